@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+)
+
+const contrastCookie = "contrast"
+
+// highContrast reports whether the viewer wants the high-contrast theme. A
+// ?contrast=high or ?contrast=normal query parameter switches modes and is
+// remembered in a cookie, so the toggle works without JavaScript.
+func highContrast(w http.ResponseWriter, r *http.Request) bool {
+	switch mode := r.URL.Query().Get("contrast"); mode {
+	case "high", "normal":
+		http.SetCookie(w, &http.Cookie{
+			Name:     contrastCookie,
+			Value:    mode,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return mode == "high"
+	}
+	cookie, err := r.Cookie(contrastCookie)
+	return err == nil && cookie.Value == "high"
+}
+
+// contrastToggle renders the link that switches contrast modes.
+func contrastToggle(high bool) string {
+	if high {
+		return `<a class="contrast-toggle" href="?contrast=normal">Standard contrast</a>`
+	}
+	return `<a class="contrast-toggle" href="?contrast=high">High contrast</a>`
+}
+
+// htmlClass returns the class attribute for the root element.
+func htmlClass(high bool) string {
+	if high {
+		return ` class="high-contrast"`
+	}
+	return ""
+}
+
+// statusClass maps a tunnel status to the CSS class of its pill. Unknown
+// values share the inactive styling.
+func statusClass(status string) string {
+	switch status {
+	case "healthy", "degraded", "down":
+		return "status-" + status
+	default:
+		return "status-inactive"
+	}
+}
+
+// statusLabel is the text read out for a status pill.
+func statusLabel(status string) string {
+	if status == "" {
+		return "unknown"
+	}
+	return status
+}
+
+// statusPill renders the status as a live region so screen readers announce
+// changes after a refresh or in-place update.
+func statusPill(status string) string {
+	return fmt.Sprintf(`<div class="status-pill %s" role="status" aria-live="polite"><span class="visually-hidden">Status: </span>%s</div>`,
+		statusClass(status), html.EscapeString(statusLabel(status)))
+}
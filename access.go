@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Route groups used for access control. Every request path belongs to
+// exactly one of them.
+const (
+	groupPublic = "public"
+	groupAPI    = "api"
+	groupAdmin  = "admin"
+)
+
+var routeGroups = []string{groupPublic, groupAPI, groupAdmin}
+
+// accessRule is the allow/deny list for a single route group. A request is
+// rejected if its client IP matches any deny prefix, or if an allow list is
+// configured and the IP matches none of it. When users are set, it must
+// also carry one of their HTTP basic auth credentials.
+type accessRule struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	// users maps each user name to the SHA-256 of its password.
+	users map[string][sha256.Size]byte
+}
+
+var (
+	accessRules = map[string]accessRule{}
+	// openPaths are left out of access control, so e.g. a badge can stay
+	// public while the page and API are protected.
+	openPaths []string
+)
+
+// routeGroup classifies a request path into its access-control group.
+func routeGroup(path string) string {
+	switch {
+	case path == "/admin" || strings.HasPrefix(path, "/admin/"):
+		return groupAdmin
+	case path == "/api" || strings.HasPrefix(path, "/api/") || path == "/metrics":
+		return groupAPI
+	default:
+		return groupPublic
+	}
+}
+
+// loadAccessRules reads <GROUP>_ALLOW_CIDRS and <GROUP>_DENY_CIDRS for each
+// route group, e.g. ADMIN_ALLOW_CIDRS=10.8.0.0/16, and PUBLIC_BASIC_AUTH and
+// API_BASIC_AUTH, comma-separated user:password pairs; admin routes already
+// require ADMIN_TOKEN. OPEN_PATHS lists paths, or prefixes ending in *,
+// that skip all of it, e.g. OPEN_PATHS=/api/status/short; /healthz and
+// /readyz always do.
+func loadAccessRules() error {
+	for _, group := range routeGroups {
+		prefix := strings.ToUpper(group)
+		allow, err := parsePrefixes(os.Getenv(prefix + "_ALLOW_CIDRS"))
+		if err != nil {
+			return fmt.Errorf("%s_ALLOW_CIDRS: %w", prefix, err)
+		}
+		deny, err := parsePrefixes(os.Getenv(prefix + "_DENY_CIDRS"))
+		if err != nil {
+			return fmt.Errorf("%s_DENY_CIDRS: %w", prefix, err)
+		}
+		users, err := parseBasicAuthUsers(os.Getenv(prefix + "_BASIC_AUTH"))
+		if err != nil {
+			return fmt.Errorf("%s_BASIC_AUTH: %w", prefix, err)
+		}
+		if len(users) > 0 && group == groupAdmin {
+			return fmt.Errorf("ADMIN_BASIC_AUTH: admin routes use ADMIN_TOKEN as the basic auth password")
+		}
+		if len(allow) > 0 || len(deny) > 0 || len(users) > 0 {
+			accessRules[group] = accessRule{allow: allow, deny: deny, users: users}
+		}
+	}
+	for _, path := range strings.Split(os.Getenv("OPEN_PATHS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("OPEN_PATHS: %q must start with /", path)
+		}
+		openPaths = append(openPaths, path)
+	}
+	return nil
+}
+
+// parseBasicAuthUsers parses a comma-separated list of user:password pairs.
+func parseBasicAuthUsers(value string) (map[string][sha256.Size]byte, error) {
+	users := map[string][sha256.Size]byte{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		user, password, ok := strings.Cut(field, ":")
+		if !ok || user == "" || password == "" {
+			return nil, fmt.Errorf("invalid credentials %q (want user:password)", user)
+		}
+		users[user] = sha256.Sum256([]byte(password))
+	}
+	return users, nil
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs. Bare addresses are
+// accepted and treated as single-host prefixes.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule accessRule) permits(addr netip.Addr) bool {
+	if containsAddr(rule.deny, addr) {
+		return false
+	}
+	return len(rule.allow) == 0 || containsAddr(rule.allow, addr)
+}
+
+// authenticates reports whether r carries valid basic auth credentials for
+// the rule, or the admin token. presented is whether it carried any.
+func (rule accessRule) authenticates(r *http.Request) (ok, presented bool) {
+	if len(rule.users) == 0 || adminAuthorized(r) {
+		return true, true
+	}
+	user, password, presented := r.BasicAuth()
+	if !presented {
+		return false, false
+	}
+	want, known := rule.users[user]
+	got := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && known, true
+}
+
+// openPath reports whether path is listed in OPEN_PATHS.
+func openPath(path string) bool {
+	for _, open := range openPaths {
+		if prefix, ok := strings.CutSuffix(open, "*"); (ok && strings.HasPrefix(path, prefix)) || path == open {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the peer that sent the request.
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// accessControl enforces the per-group CIDR rules and basic auth in front
+// of next. Signed alert links open their public page from anywhere without
+// credentials.
+func accessControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := routeGroup(r.URL.Path)
+		rule, ok := accessRules[group]
+		if !ok || openPath(r.URL.Path) || probePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if group == groupPublic && linkTokenAllows(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := clientAddr(r)
+		if !ok || !rule.permits(addr) {
+			slog.Warn("Denied access", "group", group, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeError(w, r, problemForbidden, "")
+			return
+		}
+		// Chat commands and incoming webhooks are signed by their sender,
+		// which cannot log in.
+		signed := strings.HasPrefix(r.URL.Path, "/chatops/") || strings.HasPrefix(r.URL.Path, "/webhooks/")
+		if ok, presented := rule.authenticates(r); !ok && !signed {
+			if presented {
+				slog.Warn("Denied access: invalid credentials", "group", group, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			}
+			// One realm for every group, so a browser that logged in to
+			// the page reuses the credentials for its API calls.
+			w.Header().Set("WWW-Authenticate", `Basic realm="cftunnels", charset="UTF-8"`)
+			w.Header().Set("Cache-Control", "no-store")
+			writeError(w, r, problemUnauthorized, "valid credentials are required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
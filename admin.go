@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxConfigSize bounds the body accepted by the config API.
+const maxConfigSize = 1 << 20
+
+var adminToken string
+
+// loadAdmin reads ADMIN_TOKEN. Admin endpoints are disabled without it.
+func loadAdmin() error {
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if adminToken != "" && len(adminToken) < 16 {
+		return fmt.Errorf("ADMIN_TOKEN must be at least 16 characters")
+	}
+	return nil
+}
+
+// adminAuthorized reports whether r carries the admin token, either as a
+// bearer token or as the password of HTTP basic auth.
+func adminAuthorized(r *http.Request) bool {
+	if adminToken == "" {
+		return false
+	}
+	presented := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		presented = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		presented = password
+	}
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(adminToken)) == 1
+}
+
+// sameOrigin reports whether r comes from the dashboard itself rather
+// than from a form on another site. Browsers cache basic auth and resend
+// it with cross-site form posts, so the admin pages cannot rely on the
+// token alone. Sec-Fetch-Site is trusted when present, then Origin;
+// requests with neither come from scripts such as the apply command.
+func sameOrigin(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// adminOnly guards an admin handler with the admin token and rejects
+// cross-origin mutations. Mutations honour an Idempotency-Key header, so
+// automation can retry them safely.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			writeError(w, r, problemDisabled, "admin API disabled; set ADMIN_TOKEN")
+			return
+		}
+		if !sameOrigin(r) {
+			writeError(w, r, problemCrossOrigin, "admin changes must come from this dashboard")
+			return
+		}
+		if !adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cftunnels admin"`)
+			writeError(w, r, problemUnauthorized, "a valid ADMIN_TOKEN is required")
+			return
+		}
+		idempotent(next)(w, r)
+	}
+}
+
+// configHandler serves /admin/api/config. GET returns the running config
+// with secrets redacted; PUT or POST applies a complete desired config and
+// returns the diff. Applying the same config twice changes nothing.
+// Removed tunnels and notifiers are kept for DELETED_RETENTION and can be
+// restored through /admin/api/deleted. With ?dry_run=true nothing is
+// applied; the response is the diff that would be applied and, for an
+// invalid config, every invalid section.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodGet:
+		configMu.Lock()
+		cfg := redactedConfig(currentConfig)
+		configMu.Unlock()
+		writeJSON(w, http.StatusOK, cfg)
+	case http.MethodPut, http.MethodPost:
+		var desired Config
+		decoder := json.NewDecoder(io.LimitReader(r.Body, maxConfigSize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&desired); err != nil {
+			writeProblem(w, r, problemInvalidRequest, "invalid config: "+err.Error())
+			return
+		}
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			dryRun, err := strconv.ParseBool(value)
+			if err != nil {
+				writeProblem(w, r, problemInvalidParameter, "dry_run must be true or false")
+				return
+			}
+			if dryRun {
+				writeJSON(w, http.StatusOK, previewConfig(desired))
+				return
+			}
+		}
+		diff, err := applyConfig(desired)
+		if err != nil {
+			writeProblem(w, r, problemValidation, err.Error())
+			return
+		}
+		keepDeleted(diff.removed, adminUser(r))
+		writeJSON(w, http.StatusOK, diff)
+	default:
+		methodNotAllowed(w, r, "GET, PUT, POST")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// runApply implements the apply subcommand: it sends a config file to a
+// running instance and prints the resulting diff. It exits 0 when nothing
+// changed, 2 when changes were applied and 1 on error, so pipelines can
+// tell the cases apart. With -dry-run it prints what would change, and
+// every invalid section, without applying; the exit codes are the same,
+// with 1 for an invalid config.
+func runApply(args []string) int {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := flags.String("f", "", "config file to apply, or - for stdin")
+	baseURL := flags.String("url", defaultInstanceURL(), "base URL of a running instance")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "admin token (default $ADMIN_TOKEN)")
+	timeout := flags.Duration("timeout", 30*time.Second, "request timeout")
+	dryRun := flags.Bool("dry-run", false, "show what would change without applying")
+	flags.Parse(args)
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "apply: -f is required")
+		return 1
+	}
+	var body []byte
+	var err error
+	if *file == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apply: %v\n", err)
+		return 1
+	}
+
+	endpoint := strings.TrimRight(*baseURL, "/") + "/admin/api/config"
+	if *dryRun {
+		endpoint += "?dry_run=true"
+	}
+	req, err := http.NewRequest(http.MethodPut, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apply: %v\n", err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := (&http.Client{Timeout: *timeout}).Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apply: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize))
+	if resp.StatusCode != http.StatusOK {
+		var p problem
+		if json.Unmarshal(response, &p) == nil && p.Code != "" {
+			fmt.Fprintf(os.Stderr, "apply: %s (%s): %s\n", p.Title, p.Code, p.Detail)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "apply: HTTP %d: %s\n", resp.StatusCode, strings.TrimSpace(string(response)))
+		return 1
+	}
+
+	var preview configPreview
+	if err := json.Unmarshal(response, &preview); err != nil {
+		fmt.Fprintf(os.Stderr, "apply: parsing response: %v\n", err)
+		return 1
+	}
+	if !preview.Changed {
+		fmt.Println("No changes.")
+	}
+	for _, line := range preview.lines() {
+		fmt.Println(line)
+	}
+	if *dryRun && !preview.Valid {
+		for _, problem := range preview.Errors {
+			fmt.Fprintf(os.Stderr, "invalid: %s\n", problem)
+		}
+		return 1
+	}
+	if !preview.Changed {
+		return 0
+	}
+	return 2
+}
+
+// lines renders the changes one per line: + added, - removed, ~ updated.
+func (diff configDiff) lines() []string {
+	var lines []string
+	for _, change := range diff.Changes {
+		symbol := map[string]string{"add": "+", "remove": "-", "update": "~"}[change.Action]
+		line := fmt.Sprintf("%s %s %s", symbol, change.Kind, change.ID)
+		if len(change.Fields) > 0 {
+			line += " (" + strings.Join(change.Fields, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
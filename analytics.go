@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// analyticsRetention is how many days of page views are kept.
+const analyticsRetention = 90
+
+// pageViews counts the views of one page on one UTC day. Nothing about the
+// viewer is recorded: no cookies, addresses or user agents.
+type pageViews struct {
+	Day   string `json:"day"`
+	Page  string `json:"page"`
+	Views int    `json:"views"`
+	// DuringIncident counts the views while an incident was open.
+	DuringIncident int `json:"during_incident"`
+}
+
+var (
+	analyticsEnabled bool
+	pageViewCounts   = map[[2]string]*pageViews{}
+	analyticsMu      sync.Mutex
+)
+
+// loadAnalytics reads PAGE_ANALYTICS; "true" counts views of the public
+// pages per page and day, shown on /admin/diagnostics. Counts are kept in
+// memory, and in STATE_STORE when set.
+func loadAnalytics() error {
+	analyticsEnabled = os.Getenv("PAGE_ANALYTICS") == "true"
+	return nil
+}
+
+// countViews counts GET requests for page before serving them with next.
+// The catch-all "/" only counts the status page itself. Prefetches are not
+// views.
+func countViews(page string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if analyticsEnabled && r.Method == http.MethodGet && (page != "/" || r.URL.Path == "/") &&
+			r.Header.Get("Sec-Purpose") == "" && r.Header.Get("Purpose") != "prefetch" {
+			countView(page, time.Now(), countIncidentView())
+		}
+		next(w, r)
+	}
+}
+
+func countView(page string, now time.Time, duringIncident bool) {
+	day := now.UTC().Format(time.DateOnly)
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	v := pageViewCounts[[2]string{day, page}]
+	if v == nil {
+		v = &pageViews{Day: day, Page: page}
+		pageViewCounts[[2]string{day, page}] = v
+		cutoff := now.UTC().AddDate(0, 0, -analyticsRetention).Format(time.DateOnly)
+		for key := range pageViewCounts {
+			if key[0] < cutoff {
+				delete(pageViewCounts, key)
+			}
+		}
+	}
+	v.Views++
+	if duringIncident {
+		v.DuringIncident++
+	}
+}
+
+// countIncidentView adds a view to every open incident, reporting whether
+// there was one.
+func countIncidentView() bool {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	open := false
+	for _, rec := range incidentRecords {
+		if rec.End == nil {
+			rec.Views++
+			open = true
+		}
+	}
+	return open
+}
+
+// liveViewers is how many status pages hold a live update stream, the
+// closest measure of concurrent viewers.
+func liveViewers() int {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return len(eventSubscribers)
+}
+
+// noteViewers raises the peak viewers of the open incidents to viewers.
+// The counts are saved with the incident's next change.
+func noteViewers(viewers int, now time.Time) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	for _, rec := range incidentRecords {
+		if rec.End == nil && viewers > rec.PeakViewers {
+			rec.PeakViewers, rec.PeakViewersAt = viewers, &now
+		}
+	}
+}
+
+// usualViewsPerHour is the hourly rate of views outside incidents over the
+// completed days counted, or 0 without any.
+func usualViewsPerHour(now time.Time) float64 {
+	today := now.UTC().Format(time.DateOnly)
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	days := map[string]bool{}
+	views := 0
+	for key, v := range pageViewCounts {
+		if key[0] < today {
+			days[key[0]] = true
+			views += v.Views - v.DuringIncident
+		}
+	}
+	if len(days) == 0 {
+		return 0
+	}
+	return float64(views) / float64(len(days)*24)
+}
+
+// viewerSurge describes how the views during the incident compare to the
+// usual rate, e.g. "4.2×", or "" when there is nothing to compare with.
+// Incidents shorter than 15 minutes are rated over 15 minutes, so a few
+// views of a blip do not read as a surge.
+func viewerSurge(rec incidentRecord, now time.Time) string {
+	usual := usualViewsPerHour(now)
+	if !analyticsEnabled || usual == 0 || rec.Views == 0 {
+		return ""
+	}
+	end := now
+	if rec.End != nil {
+		end = *rec.End
+	}
+	hours := max(end.Sub(rec.Start), 15*time.Minute).Hours()
+	return fmt.Sprintf("%.1f×", float64(rec.Views)/hours/usual)
+}
+
+// pageViewList returns the counts, newest day first, then by page.
+func pageViewList() []pageViews {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	list := make([]pageViews, 0, len(pageViewCounts))
+	for _, v := range pageViewCounts {
+		list = append(list, *v)
+	}
+	slices.SortFunc(list, func(a, b pageViews) int {
+		if c := strings.Compare(b.Day, a.Day); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Page, b.Page)
+	})
+	return list
+}
+
+// analyticsHandler serves GET /admin/api/analytics, the page views per
+// page and day.
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !analyticsEnabled {
+		writeProblem(w, r, problemDisabled, "page analytics disabled; set PAGE_ANALYTICS=true")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, pageViewList())
+}
+
+type diagnosticsPageData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Enabled        bool
+	Views          []pageViews
+	// Monitor is whether each of the monitor's own components is failing.
+	Monitor map[string]bool
+}
+
+var diagnosticsTemplate = template.Must(template.New("diagnostics").Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="referrer" content="no-referrer">
+	<title>Diagnostics</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Diagnostics</h1>
+		</header>
+		<section aria-labelledby="page-views">
+			<h2 id="page-views">Page views</h2>
+			{{if not .Enabled}}<p>Page analytics are disabled; set PAGE_ANALYTICS=true to count views.</p>
+			{{else if .Views}}
+			<table>
+				<thead><tr><th scope="col">Day (UTC)</th><th scope="col">Page</th><th scope="col">Views</th><th scope="col">During an incident</th></tr></thead>
+				<tbody>{{range .Views}}<tr><td>{{.Day}}</td><td><code>{{.Page}}</code></td><td>{{.Views}}</td><td>{{.DuringIncident}}</td></tr>{{end}}</tbody>
+			</table>
+			{{else}}<p>No views counted yet.</p>{{end}}
+		</section>
+		<section aria-labelledby="monitor">
+			<h2 id="monitor">Monitor components</h2>
+			{{if .Monitor}}<ul>{{range $component, $failing := .Monitor}}<li>{{$component}}: {{if $failing}}failing{{else}}OK{{end}}</li>{{end}}</ul>{{else}}<p>No components reported yet.</p>{{end}}
+		</section>
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// diagnosticsHandler serves /admin/diagnostics: page views and the health
+// of the monitor's own components.
+func diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	high := highContrast(w, r)
+	data := diagnosticsPageData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Enabled:        analyticsEnabled,
+		Views:          pageViewList(),
+		Monitor:        monitorStatus(),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := diagnosticsTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering diagnostics page", "error", err)
+	}
+}
+
+func snapshotAnalytics() ([]byte, error) {
+	return json.Marshal(pageViewList())
+}
+
+func restoreAnalytics(data []byte) error {
+	var list []pageViews
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	for _, v := range list {
+		key := [2]string{v.Day, v.Page}
+		if existing := pageViewCounts[key]; existing != nil {
+			existing.Views += v.Views
+			existing.DuringIncident += v.DuringIncident
+			continue
+		}
+		pageViewCounts[key] = &v
+	}
+	return nil
+}
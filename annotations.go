@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxAnnotationSize bounds the body accepted by the incident API.
+const maxAnnotationSize = 64 << 10
+
+// incidentNote is a free-text note an operator attached to an incident.
+// Public notes are the updates shown on the incident's page; the others
+// stay on the admin pages.
+type incidentNote struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author,omitempty"`
+	Text   string    `json:"text"`
+	Public bool      `json:"public,omitempty"`
+}
+
+// annotationRequest changes an incident's notes and tags. Public publishes
+// the note on /incidents/{id}. Hints sets region hints by country or
+// region, an empty text to word them from the tunnel's regions, and
+// RemoveHints removes them. Ticket adds a link under "ticket", next to
+// the links opened by the incident hooks.
+type annotationRequest struct {
+	Note       string   `json:"note,omitempty"`
+	Public     bool     `json:"public,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	RemoveTags []string `json:"remove_tags,omitempty"`
+	Ticket     string   `json:"ticket,omitempty"`
+	// Hints and RemoveHints are keyed by country code or region name.
+	Hints       map[string]string `json:"hints,omitempty"`
+	RemoveHints []string          `json:"remove_hints,omitempty"`
+}
+
+// normalizeTag lower-cases a tag and joins its words with hyphens, so
+// "Root cause" and "root-cause" are the same tag.
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+// validate normalizes the tags and checks the ticket is a web link.
+func (a *annotationRequest) validate() error {
+	a.Note = strings.TrimSpace(a.Note)
+	for _, tags := range []*[]string{&a.Tags, &a.RemoveTags} {
+		var normalized []string
+		for _, tag := range *tags {
+			if tag = normalizeTag(tag); tag != "" {
+				normalized = append(normalized, tag)
+			}
+		}
+		*tags = normalized
+	}
+	if a.Ticket != "" {
+		u, err := url.Parse(a.Ticket)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("ticket must be an http or https URL")
+		}
+	}
+	hints := map[string]string{}
+	for region, text := range a.Hints {
+		region = strings.ToUpper(strings.TrimSpace(region))
+		if !regionName.MatchString(region) {
+			return fmt.Errorf("hints: %q is not a country or region name", region)
+		}
+		hints[region] = strings.TrimSpace(text)
+	}
+	a.Hints = hints
+	for i, region := range a.RemoveHints {
+		a.RemoveHints[i] = strings.ToUpper(strings.TrimSpace(region))
+	}
+	if a.Note == "" && len(a.Tags) == 0 && len(a.RemoveTags) == 0 && a.Ticket == "" && len(a.Hints) == 0 && len(a.RemoveHints) == 0 {
+		return fmt.Errorf("nothing to change; set note, tags, remove_tags, ticket, hints or remove_hints")
+	}
+	return nil
+}
+
+func (a annotationRequest) apply(rec *incidentRecord, author string, now time.Time) {
+	if a.Note != "" {
+		rec.Notes = append(rec.Notes, incidentNote{Time: now, Author: author, Text: a.Note, Public: a.Public})
+	}
+	for _, tag := range a.Tags {
+		if !slices.Contains(rec.Tags, tag) {
+			rec.Tags = append(rec.Tags, tag)
+		}
+	}
+	rec.Tags = slices.DeleteFunc(rec.Tags, func(tag string) bool { return slices.Contains(a.RemoveTags, tag) })
+	sort.Strings(rec.Tags)
+	if a.Ticket != "" {
+		if rec.Links == nil {
+			rec.Links = map[string]string{}
+		}
+		rec.Links["ticket"] = a.Ticket
+	}
+	for region, text := range a.Hints {
+		if rec.RegionHints == nil {
+			rec.RegionHints = map[string]string{}
+		}
+		rec.RegionHints[region] = text
+	}
+	for _, region := range a.RemoveHints {
+		delete(rec.RegionHints, region)
+	}
+}
+
+// annotateIncident applies a to the record with the given id and returns
+// a copy of the result.
+func annotateIncident(id string, a annotationRequest, author string) (incidentRecord, bool) {
+	var updated incidentRecord
+	found := false
+	updateIncident(id, func(rec *incidentRecord) {
+		a.apply(rec, author, time.Now())
+		updated = *rec
+		found = true
+	})
+	return updated, found
+}
+
+// adminUser names the operator behind an admin request for notes: the
+// basic auth user name if there is one.
+func adminUser(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return "admin"
+}
+
+// filteredIncidents returns copies of the incident records, newest first,
+// optionally only those of one tunnel or carrying a tag.
+func filteredIncidents(tunnelID, tag string) []incidentRecord {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	records := []incidentRecord{}
+	for _, rec := range incidentRecords {
+		if (tunnelID == "" || rec.TunnelID == tunnelID) && (tag == "" || slices.Contains(rec.Tags, tag)) {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Start.After(records[j].Start) })
+	return records
+}
+
+// incidentAnnotations returns the tags and notes of records that started
+// within [start, end], like incidentLinks.
+func incidentAnnotations(tunnelID string, start, end time.Time) (tags []string, notes []incidentNote) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	for _, rec := range incidentRecords {
+		if rec.TunnelID != tunnelID || rec.Start.Before(start) || (!end.IsZero() && rec.Start.After(end)) {
+			continue
+		}
+		for _, tag := range rec.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		notes = append(notes, rec.Notes...)
+	}
+	sort.Strings(tags)
+	return tags, notes
+}
+
+// incidentTaggedDuring reports whether a record carrying tag overlaps
+// [start, end).
+func incidentTaggedDuring(tunnelID, tag string, start, end time.Time) bool {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	for _, rec := range incidentRecords {
+		if rec.TunnelID != tunnelID || !slices.Contains(rec.Tags, tag) || !rec.Start.Before(end) {
+			continue
+		}
+		if rec.End == nil || rec.End.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// incidentsHandler serves GET /admin/api/incidents: the incident records,
+// newest first, filtered by the tunnel and tag parameters and paged as in
+// listQuery.
+func incidentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	query := r.URL.Query()
+	records := filteredIncidents(query.Get("tunnel"), normalizeTag(query.Get("tag")))
+	page, ok := pageList(w, r, records, listSpec{key: "id", defaultSort: "-start"})
+	if !ok {
+		return
+	}
+	if page != nil {
+		writeJSON(w, http.StatusOK, page)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// annotateHandler serves POST /admin/api/incidents/{id}: it adds a note,
+// tags or a ticket link to the incident and returns the updated record.
+func annotateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	var a annotationRequest
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxAnnotationSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&a); err != nil {
+		writeProblem(w, r, problemInvalidRequest, "invalid annotation: "+err.Error())
+		return
+	}
+	if err := a.validate(); err != nil {
+		writeProblem(w, r, problemValidation, err.Error())
+		return
+	}
+	rec, ok := annotateIncident(r.PathValue("id"), a, adminUser(r))
+	if !ok {
+		writeProblem(w, r, problemUnknownIncident, fmt.Sprintf("no incident %q", r.PathValue("id")))
+		return
+	}
+	slog.Info("Admin annotated incident", "user", adminUser(r), "incident_id", rec.ID)
+	writeJSON(w, http.StatusOK, rec)
+}
+
+type incidentsPageData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Tag            string
+	Tags           []string
+	Incidents      []incidentRecord
+	// Exclusions holds, per incident ID, the exclusions overlapping it.
+	Exclusions map[string][]availabilityExclusion
+	Error      string
+}
+
+var incidentsTemplate = template.Must(template.New("incidents").Funcs(template.FuncMap{
+	"datetime":     func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+	"surge":        func(rec incidentRecord) string { return viewerSurge(rec, time.Now()) },
+	"incidentPath": incidentPath,
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="referrer" content="no-referrer">
+	<title>Incidents</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Incidents</h1>
+			<p>{{if .Tag}}Tagged <strong>{{.Tag}}</strong> &middot; <a href="/admin/incidents">All incidents</a>{{else}}All incidents{{end}}{{range .Tags}} &middot; <a href="?tag={{.}}">{{.}}</a>{{end}}</p>
+		</header>
+		{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+		{{range .Incidents}}
+		<section class="incident" aria-labelledby="incident-{{.ID}}">
+			<h2 id="incident-{{.ID}}"><a href="{{incidentPath .}}">{{.Tunnel}}: {{.Status}}</a></h2>
+			<p>{{datetime .Start}} &ndash; {{if .End}}{{datetime .End}}{{else}}ongoing{{end}}{{range .Tags}} &middot; <a href="?tag={{.}}">{{.}}</a>{{end}}{{range $name, $link := .Links}} &middot; <a href="{{$link}}">{{$name}}</a>{{end}}</p>
+			{{if or .PeakViewers .Views}}<p>Viewers: {{if .PeakViewers}}at most {{.PeakViewers}} live pages open at once{{with .PeakViewersAt}} ({{datetime .}}){{end}}{{end}}{{if .Views}}{{if .PeakViewers}} &middot; {{end}}{{.Views}} page views{{with surge .}}, {{.}} the usual rate{{end}}{{end}}</p>{{end}}
+			{{range $region, $text := .RegionHints}}<p>Hint for {{$region}}: {{or $text "worded from the tunnel's regions"}}</p>{{end}}
+			{{range .Notes}}<p>{{datetime .Time}}{{if .Author}}, {{.Author}}{{end}}{{if .Public}} (public){{end}}: {{.Text}}</p>{{end}}
+			{{range index $.Exclusions .ID}}<p>{{if eq .Kind "false_positive"}}False positive{{else}}Excluded from availability{{end}} {{datetime .Start}} &ndash; {{datetime .End}}{{if .Author}} by {{.Author}}{{end}}: {{.Reason}}</p>{{end}}
+			<form method="post">
+				<input type="hidden" name="id" value="{{.ID}}">
+				<p><label>Note <textarea name="note" rows="2"></textarea></label>
+				<label><input type="checkbox" name="public" value="true"> Publish as an update on the incident page</label></p>
+				<p><label>Add tags <input name="tags" placeholder="root-cause, false-positive"></label>
+				<label>Remove tags <input name="remove_tags"></label>
+				<label>Ticket <input name="ticket" type="url"></label></p>
+				<p><label>Hint for regions <input name="hint_regions" placeholder="EU, US"></label>
+				<label>Hint <input name="hint_text" placeholder="Worded from the tunnel's regions if empty"></label>
+				<label>Remove hints <input name="remove_hints"></label></p>
+				<button type="submit">Save</button>
+			</form>
+			<form method="post">
+				<input type="hidden" name="id" value="{{.ID}}">
+				<input type="hidden" name="action" value="exclude">
+				<p><label><select name="kind"><option value="false_positive">False positive</option><option value="excluded">Exclude from availability</option></select></label>
+				<label>Reason <input name="reason" required></label>
+				<button type="submit">Apply to this incident</button></p>
+			</form>
+		</section>
+		{{else}}
+		<p>No incident records{{if .Tag}} tagged {{.Tag}}{{end}}.</p>
+		{{end}}
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// adminIncidentsHandler serves /admin/incidents, where operators read and
+// annotate the incident records. ?tag= limits the list to one tag.
+func adminIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	high := highContrast(w, r)
+	data := incidentsPageData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Tag:            normalizeTag(r.URL.Query().Get("tag")),
+	}
+
+	if r.Method == http.MethodPost && r.FormValue("action") == "exclude" {
+		err := excludeIncident(r.FormValue("id"), r.FormValue("kind"), strings.TrimSpace(r.FormValue("reason")), adminUser(r))
+		if err == nil {
+			http.Redirect(w, r, r.URL.String(), http.StatusSeeOther)
+			return
+		}
+		data.Error = err.Error()
+	} else if r.Method == http.MethodPost {
+		a := annotationRequest{
+			Note:       r.FormValue("note"),
+			Public:     r.FormValue("public") == "true",
+			Tags:       splitList(r.FormValue("tags")),
+			RemoveTags: splitList(r.FormValue("remove_tags")),
+			Ticket:     strings.TrimSpace(r.FormValue("ticket")),
+		}
+		a.RemoveHints = splitList(r.FormValue("remove_hints"))
+		for _, region := range splitList(r.FormValue("hint_regions")) {
+			if a.Hints == nil {
+				a.Hints = map[string]string{}
+			}
+			a.Hints[region] = r.FormValue("hint_text")
+		}
+		err := a.validate()
+		if err == nil {
+			if rec, ok := annotateIncident(r.FormValue("id"), a, adminUser(r)); ok {
+				slog.Info("Admin annotated incident", "user", adminUser(r), "incident_id", rec.ID)
+			} else {
+				err = fmt.Errorf("unknown incident")
+			}
+		}
+		if err == nil {
+			http.Redirect(w, r, r.URL.String(), http.StatusSeeOther)
+			return
+		}
+		data.Error = err.Error()
+	}
+
+	data.Exclusions = map[string][]availabilityExclusion{}
+	for _, rec := range filteredIncidents("", "") {
+		for _, tag := range rec.Tags {
+			if !slices.Contains(data.Tags, tag) {
+				data.Tags = append(data.Tags, tag)
+			}
+		}
+		if data.Tag == "" || slices.Contains(rec.Tags, data.Tag) {
+			data.Incidents = append(data.Incidents, rec)
+			data.Exclusions[rec.ID] = tunnelExclusions(rec.TunnelID, rec.Start, incidentEnd(rec))
+		}
+	}
+	sort.Strings(data.Tags)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := incidentsTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering incidents page", "error", err)
+	}
+}
+
+// incidentEnd is when the record ended, or now while it is ongoing.
+func incidentEnd(rec incidentRecord) time.Time {
+	if rec.End == nil {
+		return time.Now()
+	}
+	return *rec.End
+}
+
+// excludeIncident covers the incident record's whole duration so far with
+// an exclusion of the given kind.
+func excludeIncident(id, kind, reason, author string) error {
+	for _, rec := range filteredIncidents("", "") {
+		if rec.ID != id {
+			continue
+		}
+		e := availabilityExclusion{TunnelID: rec.TunnelID, Start: rec.Start, End: incidentEnd(rec), Kind: kind, Reason: reason, Author: author}
+		if err := e.validate(); err != nil {
+			return err
+		}
+		addExclusion(e)
+		return nil
+	}
+	return fmt.Errorf("unknown incident")
+}
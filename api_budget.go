@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/s3ansh33p/CFTunnels/internal/cloudflare"
+)
+
+// Cloudflare allows 1200 API requests per five minutes for each user or
+// token; every account monitored with API_TOKEN shares that limit.
+const (
+	defaultAPIBudget  = "1200/5m"
+	defaultAPIReserve = 0.25
+)
+
+// apiPriority decides what happens to a Cloudflare API request when the
+// budget is tight.
+type apiPriority int
+
+const (
+	// apiCritical requests, such as tunnel status polls, wait for budget.
+	apiCritical apiPriority = iota
+	// apiBackground requests, such as audit log and config checks, are
+	// deferred to their next run rather than dip into the reserve.
+	apiBackground
+)
+
+// errAPIBudget is returned for background requests deferred because the
+// budget is tight.
+var errAPIBudget = errors.New("deferred: Cloudflare API budget is low")
+
+// apiBudget is a token bucket shared by every request made with API_TOKEN.
+// The bucket refills at limit per window; background requests leave
+// reserve tokens for critical ones.
+type apiBudget struct {
+	mu       sync.Mutex
+	capacity float64
+	perSec   float64
+	reserve  float64
+	tokens   float64
+	updated  time.Time
+	// blockedUntil is set from the Retry-After of a 429 response.
+	blockedUntil time.Time
+}
+
+var (
+	cloudflareBudget    *apiBudget
+	apiRequestsDeferred atomic.Int64
+)
+
+// loadAPIBudget reads CLOUDFLARE_API_BUDGET, <requests>/<window> the
+// instance may spend against the API token (default 1200/5m, Cloudflare's
+// limit; lower it when the token is also used elsewhere, or "none"), and
+// CLOUDFLARE_API_RESERVE, the share of it background checks leave for
+// tunnel polls (default 0.25).
+func loadAPIBudget() error {
+	spec := os.Getenv("CLOUDFLARE_API_BUDGET")
+	if spec == "none" {
+		return nil
+	}
+	if spec == "" {
+		spec = defaultAPIBudget
+	}
+	count, window, _ := strings.Cut(spec, "/")
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit < 1 {
+		return fmt.Errorf("CLOUDFLARE_API_BUDGET: %q: invalid request count %q", spec, count)
+	}
+	period, err := time.ParseDuration(window)
+	if err != nil || period < time.Second {
+		return fmt.Errorf("CLOUDFLARE_API_BUDGET: %q: invalid window %q", spec, window)
+	}
+	reserve := defaultAPIReserve
+	if value := os.Getenv("CLOUDFLARE_API_RESERVE"); value != "" {
+		reserve, err = strconv.ParseFloat(value, 64)
+		if err != nil || reserve < 0 || reserve >= 1 {
+			return fmt.Errorf("CLOUDFLARE_API_RESERVE: %q is not a number from 0 up to 1", value)
+		}
+	}
+	cloudflareBudget = &apiBudget{
+		capacity: float64(limit),
+		perSec:   float64(limit) / period.Seconds(),
+		reserve:  float64(limit) * reserve,
+		tokens:   float64(limit),
+		updated:  time.Now(),
+	}
+	return nil
+}
+
+// take spends a token for a request of the given priority. It returns how
+// long to wait before trying again, or errAPIBudget for a deferred
+// background request.
+func (b *apiBudget) take(priority apiPriority, now time.Time) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.perSec)
+	b.updated = now
+
+	floor := 1.0
+	if priority == apiBackground {
+		floor += b.reserve
+	}
+	switch {
+	case now.Before(b.blockedUntil) && priority == apiBackground:
+		return 0, errAPIBudget
+	case now.Before(b.blockedUntil):
+		return b.blockedUntil.Sub(now), nil
+	case b.tokens >= floor:
+		b.tokens--
+		return 0, nil
+	case priority == apiBackground:
+		return 0, errAPIBudget
+	default:
+		return max(time.Millisecond, time.Duration((floor-b.tokens)/b.perSec*float64(time.Second))), nil
+	}
+}
+
+// wait blocks until a critical request may be sent, or defers a
+// background one.
+func (b *apiBudget) wait(ctx context.Context, priority apiPriority) error {
+	for {
+		delay, err := b.take(priority, time.Now())
+		if err != nil || delay == 0 {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// throttled empties the bucket until the Retry-After of a 429 response,
+// or a minute without one, and returns how long that is.
+func (b *apiBudget) throttled(resp *http.Response, now time.Time) time.Duration {
+	retry := time.Minute
+	if after, ok := cloudflare.ParseRetryAfter(resp.Header.Get("Retry-After"), now); ok && after > 0 {
+		retry = after
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = 0
+	b.updated = now
+	if until := now.Add(retry); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+	return retry
+}
+
+// cloudflareDo sends a request authenticated with API_TOKEN within the
+// shared budget, with the configured User-Agent and headers. Transient
+// failures are retried up to CLOUDFLARE_RETRIES times with exponential
+// backoff, each retry spending budget like the first attempt.
+func cloudflareDo(req *http.Request, priority apiPriority) (*http.Response, error) {
+	setAPIHeaders(req)
+	retrier := cloudflare.Retrier{
+		Client:  cloudflareClient,
+		Retries: cloudflareRetries,
+		OnRetry: func(req *http.Request, reason string, attempt int, delay time.Duration) {
+			slog.Warn("Cloudflare API request failed; retrying", "method", req.Method, "path", req.URL.Path, "reason", reason, "attempt", attempt, "retries", cloudflareRetries, "retry_in", delay.Round(time.Millisecond))
+		},
+	}
+	if cloudflareBudget != nil {
+		retrier.Wait = func(ctx context.Context) error {
+			err := cloudflareBudget.wait(ctx, priority)
+			if errors.Is(err, errAPIBudget) {
+				apiRequestsDeferred.Add(1)
+			}
+			return err
+		}
+		retrier.OnResponse = func(resp *http.Response) {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				retry := cloudflareBudget.throttled(resp, time.Now())
+				slog.Warn("Cloudflare API rate limit reached; pausing requests", "retry_in", retry)
+			}
+		}
+	}
+	return retrier.Do(req)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditWindow = 30 * time.Minute
+	auditPageSize      = 100
+	// auditBackfill is how far back audit entries are fetched at startup,
+	// enough to annotate every incident in the report.
+	auditBackfill = reportDays * 24 * time.Hour
+)
+
+// auditEntry is a Cloudflare account audit log entry that concerns a
+// monitored tunnel.
+type auditEntry struct {
+	ID           string
+	When         time.Time
+	Action       string
+	Result       bool
+	Actor        string
+	Interface    string
+	ResourceType string
+	ResourceID   string
+	// TunnelID is the monitored tunnel the entry was matched to.
+	TunnelID string
+}
+
+type auditLogResponse struct {
+	Success bool `json:"success"`
+	Result  []struct {
+		ID     string    `json:"id"`
+		When   time.Time `json:"when"`
+		Action struct {
+			Type   string `json:"type"`
+			Result bool   `json:"result"`
+		} `json:"action"`
+		Actor struct {
+			Email string `json:"email"`
+			Type  string `json:"type"`
+		} `json:"actor"`
+		Interface string `json:"interface"`
+		Resource  struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		} `json:"resource"`
+		Metadata json.RawMessage `json:"metadata"`
+		NewValue json.RawMessage `json:"newValue"`
+		OldValue json.RawMessage `json:"oldValue"`
+	} `json:"result"`
+}
+
+var (
+	auditEnabled bool
+	auditWindow  = defaultAuditWindow
+	auditEntries []auditEntry
+	// auditFetchedUntil is, per account, the newest entry time fetched.
+	auditFetchedUntil = map[string]time.Time{}
+	auditMu           sync.RWMutex
+)
+
+// loadAudit reads AUDIT_LOG=true, which pulls the account audit log so
+// incidents can be annotated with what changed around them, and
+// AUDIT_LOG_WINDOW, how far before and after an incident entries are
+// shown. The API token needs the Account Audit Logs read permission.
+func loadAudit() error {
+	auditEnabled = os.Getenv("AUDIT_LOG") == "true"
+	if value := os.Getenv("AUDIT_LOG_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("AUDIT_LOG_WINDOW: invalid duration %q", value)
+		}
+		auditWindow = window
+	}
+	return nil
+}
+
+// pollAuditLog fetches new audit entries for every account with a
+// monitored tunnel on each poll interval.
+func pollAuditLog() {
+	for {
+		accounts := map[string]bool{}
+		for _, t := range snapshotTunnels() {
+			accounts[t.AccountID] = true
+		}
+		for account := range accounts {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := fetchAuditLog(ctx, account); err != nil {
+				slog.Error("Error fetching audit log", "account_id", account, "error", err)
+			}
+			cancel()
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// fetchAuditLog pages through an account's entries since the last fetch
+// and keeps those that mention a monitored tunnel.
+func fetchAuditLog(ctx context.Context, accountID string) error {
+	auditMu.RLock()
+	since, ok := auditFetchedUntil[accountID]
+	auditMu.RUnlock()
+	if !ok {
+		since = time.Now().Add(-auditBackfill)
+	}
+
+	ids := map[string]bool{}
+	for _, t := range snapshotTunnels() {
+		ids[t.ID] = true
+	}
+
+	var found []auditEntry
+	newest := since
+	for page := 1; ; page++ {
+		query := url.Values{
+			"since":     {since.UTC().Format(time.RFC3339)},
+			"direction": {"asc"},
+			"per_page":  {fmt.Sprint(auditPageSize)},
+			"page":      {fmt.Sprint(page)},
+		}
+		endpoint := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/audit_logs?%s", accountID, query.Encode())
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		resp, err := cloudflareDo(req, apiBackground)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		var parsed auditLogResponse
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return fmt.Errorf("parsing API response: %w", err)
+		}
+		if !parsed.Success {
+			return fmt.Errorf("API response indicates failure: %s", string(body))
+		}
+
+		for _, raw := range parsed.Result {
+			if raw.When.After(newest) {
+				newest = raw.When
+			}
+			// Tunnel entries name the tunnel as the resource, or in the
+			// metadata and values for related resources such as routes.
+			mentions := raw.Resource.ID + string(raw.Metadata) + string(raw.NewValue) + string(raw.OldValue)
+			for id := range ids {
+				if !strings.Contains(mentions, id) {
+					continue
+				}
+				actor := raw.Actor.Email
+				if actor == "" {
+					actor = raw.Actor.Type
+				}
+				found = append(found, auditEntry{
+					ID:           raw.ID,
+					When:         raw.When,
+					Action:       raw.Action.Type,
+					Result:       raw.Action.Result,
+					Actor:        actor,
+					Interface:    raw.Interface,
+					ResourceType: raw.Resource.Type,
+					ResourceID:   raw.Resource.ID,
+					TunnelID:     id,
+				})
+			}
+		}
+		if len(parsed.Result) < auditPageSize {
+			break
+		}
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	seen := map[string]bool{}
+	for _, e := range auditEntries {
+		seen[e.ID+e.TunnelID] = true
+	}
+	for _, e := range found {
+		if !seen[e.ID+e.TunnelID] {
+			auditEntries = append(auditEntries, e)
+		}
+	}
+	sort.Slice(auditEntries, func(i, j int) bool { return auditEntries[i].When.Before(auditEntries[j].When) })
+	cutoff := time.Now().Add(-auditBackfill)
+	drop := 0
+	for drop < len(auditEntries) && auditEntries[drop].When.Before(cutoff) {
+		drop++
+	}
+	auditEntries = auditEntries[drop:]
+	// The next fetch starts at the newest entry; entries at exactly that
+	// time are deduplicated above.
+	auditFetchedUntil[accountID] = newest
+	return nil
+}
+
+// auditEntriesAround returns a tunnel's audit entries from auditWindow
+// before start to auditWindow after end, oldest first. A zero end means
+// the incident is ongoing.
+func auditEntriesAround(tunnelID string, start, end time.Time) []auditEntry {
+	if end.IsZero() {
+		end = time.Now()
+	}
+	from, to := start.Add(-auditWindow), end.Add(auditWindow)
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	var out []auditEntry
+	for _, e := range auditEntries {
+		if e.TunnelID == tunnelID && !e.When.Before(from) && !e.When.After(to) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// recentAuditEntries returns a tunnel's audit entries, newest first.
+func recentAuditEntries(tunnelID string) []auditEntry {
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	var out []auditEntry
+	for i := len(auditEntries) - 1; i >= 0; i-- {
+		if auditEntries[i].TunnelID == tunnelID {
+			out = append(out, auditEntries[i])
+		}
+	}
+	return out
+}
+
+// Summary renders the entry for timelines, e.g.
+// "update tunnel by ops@example.com (dashboard)". It is exported for use
+// in templates.
+func (e auditEntry) Summary() string {
+	text := e.Action
+	if e.ResourceType != "" {
+		text += " " + e.ResourceType
+	}
+	if e.Actor != "" {
+		text += " by " + e.Actor
+	}
+	if e.Interface != "" {
+		text += " (" + e.Interface + ")"
+	}
+	if !e.Result {
+		text += ", failed"
+	}
+	return text
+}
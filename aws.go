@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// eventDetailTypes are the EventBridge detail-type values for each event
+// type, which rules match on.
+var eventDetailTypes = map[string]string{
+	eventStatusChanged: "Tunnel Status Changed",
+	eventConfigChanged: "Tunnel Configuration Changed",
+
+	eventServiceTokenExpiring: "Access Service Token Expiring",
+	eventServiceTokenExpired:  "Access Service Token Expired",
+
+	eventStatusPageDown: "Status Page Unreachable",
+	eventStatusPageUp:   "Status Page Reachable",
+	eventDigest:         "Tunnel Event Digest",
+
+	eventSLABudgetLow: "Tunnel Error Budget Low",
+	eventSLABreached:  "Tunnel SLA Breached",
+	eventSLABurnRate:  "Tunnel Error Budget Burn Rate High",
+
+	eventMonitorDegraded:  "Tunnel Monitor Degraded",
+	eventMonitorRecovered: "Tunnel Monitor Recovered",
+
+	eventDNSBroken: "Tunnel DNS Record Broken",
+	eventDNSFixed:  "Tunnel DNS Record Fixed",
+}
+
+// eventBridgeNotifier puts events onto an EventBridge bus.
+type eventBridgeNotifier struct {
+	client *eventbridge.Client
+	bus    string
+	source string
+}
+
+// lambdaNotifier invokes a Lambda function asynchronously with the event
+// as its payload.
+type lambdaNotifier struct {
+	client   *lambda.Client
+	function string
+}
+
+// awsFromEnv reads AWS_EVENTBRIDGE_BUS, AWS_EVENTBRIDGE_SOURCE and
+// AWS_LAMBDA_FUNCTION.
+func awsFromEnv() []NotifierConfig {
+	var configs []NotifierConfig
+	if bus := os.Getenv("AWS_EVENTBRIDGE_BUS"); bus != "" {
+		configs = append(configs, NotifierConfig{Name: "eventbridge", Type: "eventbridge", Settings: envSettings(map[string]string{
+			"bus":    "AWS_EVENTBRIDGE_BUS",
+			"source": "AWS_EVENTBRIDGE_SOURCE",
+		})})
+	}
+	if function := os.Getenv("AWS_LAMBDA_FUNCTION"); function != "" {
+		configs = append(configs, NotifierConfig{Name: "lambda", Type: "lambda", Settings: map[string]string{"function": function}})
+	}
+	return configs
+}
+
+// awsConfig loads region and credentials from the standard AWS chain:
+// environment, shared config and credentials files, then container or
+// instance roles. Requests go through the outbound proxy and CAs.
+func awsConfig() (aws.Config, error) {
+	client := awshttp.NewBuildableClient().WithTransportOptions(configureTransport)
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithHTTPClient(client))
+	if err != nil {
+		return cfg, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		return cfg, fmt.Errorf("no AWS region configured; set AWS_REGION")
+	}
+	return cfg, nil
+}
+
+// newEventBridgeIntegration takes bus and source (default cftunnels)
+// settings.
+func newEventBridgeIntegration(settings map[string]string) (integration, error) {
+	if settings["bus"] == "" {
+		return integration{}, fmt.Errorf("bus is required")
+	}
+	cfg, err := awsConfig()
+	if err != nil {
+		return integration{}, err
+	}
+	source := settings["source"]
+	if source == "" {
+		source = "cftunnels"
+	}
+	return integration{notifier: &eventBridgeNotifier{client: eventbridge.NewFromConfig(cfg), bus: settings["bus"], source: source}}, nil
+}
+
+// newLambdaIntegration takes a function setting: a name, ARN or partial
+// ARN.
+func newLambdaIntegration(settings map[string]string) (integration, error) {
+	if settings["function"] == "" {
+		return integration{}, fmt.Errorf("function is required")
+	}
+	cfg, err := awsConfig()
+	if err != nil {
+		return integration{}, err
+	}
+	return integration{notifier: &lambdaNotifier{client: lambda.NewFromConfig(cfg), function: settings["function"]}}, nil
+}
+
+func (n *eventBridgeNotifier) Name() string {
+	return "eventbridge " + n.bus
+}
+
+func (n *eventBridgeNotifier) Notify(ctx context.Context, event Event) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	detailType, ok := eventDetailTypes[event.Type]
+	if !ok {
+		detailType = event.Type
+	}
+
+	out, err := n.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{{
+			EventBusName: aws.String(n.bus),
+			Source:       aws.String(n.source),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(event.Time),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		entry := out.Entries[0]
+		return fmt.Errorf("event rejected: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
+	return nil
+}
+
+func (n *lambdaNotifier) Name() string {
+	return "lambda " + n.function
+}
+
+func (n *lambdaNotifier) Notify(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	out, err := n.client.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(n.function),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	if err != nil {
+		return err
+	}
+	if out.FunctionError != nil {
+		return fmt.Errorf("function error: %s", aws.ToString(out.FunctionError))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBurnRates page when a month's budget would be gone in about two
+// days (14.4x over an hour) or five days (6x over six hours).
+const defaultBurnRates = "14.4@1h/5m, 6@6h/30m"
+
+// burnRateAlert fires when the error budget is being spent rate times
+// faster than the target allows, over both the long window and the
+// short one. The short window stops the alert from firing long after an
+// outage has ended.
+type burnRateAlert struct {
+	rate        float64
+	long, short time.Duration
+}
+
+var (
+	burnRateAlerts []burnRateAlert
+	// burnFiring holds the alerts currently firing per tunnel, target and
+	// window, so each is sent once until it clears.
+	burnFiring   = map[string]bool{}
+	burnFiringMu sync.Mutex
+)
+
+// loadBurnRates reads SLA_BURN_RATES, a comma-separated list of
+// <rate>@<long window>/<short window> alerts on the SLA targets' error
+// budgets, or "none"; the default is 14.4@1h/5m, 6@6h/30m.
+func loadBurnRates() error {
+	spec := os.Getenv("SLA_BURN_RATES")
+	if spec == "none" {
+		return nil
+	}
+	if spec == "" {
+		spec = defaultBurnRates
+	}
+	for _, item := range splitList(spec) {
+		rate, windows, _ := strings.Cut(item, "@")
+		long, short, _ := strings.Cut(windows, "/")
+		var alert burnRateAlert
+		var err error
+		if alert.rate, err = strconv.ParseFloat(rate, 64); err != nil || alert.rate <= 0 {
+			return fmt.Errorf("SLA_BURN_RATES: %q: invalid rate %q", item, rate)
+		}
+		if alert.long, err = time.ParseDuration(long); err != nil || alert.long < time.Minute {
+			return fmt.Errorf("SLA_BURN_RATES: %q: invalid long window %q (minimum 1m)", item, long)
+		}
+		if alert.short, err = time.ParseDuration(short); err != nil || alert.short <= 0 || alert.short > alert.long {
+			return fmt.Errorf("SLA_BURN_RATES: %q: invalid short window %q (at most the long window)", item, short)
+		}
+		burnRateAlerts = append(burnRateAlerts, alert)
+	}
+	return nil
+}
+
+func (a burnRateAlert) String() string {
+	return fmt.Sprintf("%g@%s/%s", a.rate, formatWindow(a.long), formatWindow(a.short))
+}
+
+// formatWindow drops the zero units time.Duration prints, e.g. "1h"
+// rather than "1h0m0s".
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// burnRate is how many times faster than sustainable the tunnel spent
+// target's error budget over [from, to). ok is false without samples in
+// the covered time.
+func burnRate(t tunnelState, target slaTarget, from, to time.Time) (rate float64, ok bool) {
+	var ratio float64
+	if target.business {
+		ratio, ok = businessAvailability(t.ID, tunnelBusinessHours(t), from, to)
+	} else {
+		ratio, ok = availability(t.ID, from, to)
+	}
+	if !ok {
+		return 0, false
+	}
+	return (1 - ratio) / (1 - target.objective), true
+}
+
+// checkBurnRates alerts when a tunnel's SLA target is burning its budget
+// faster than an alert allows over both of its windows.
+func checkBurnRates(list []tunnelState, now time.Time) {
+	var events []Event
+	burnFiringMu.Lock()
+	for _, t := range list {
+		for _, target := range tunnelSLATargets(t) {
+			for _, alert := range burnRateAlerts {
+				key := t.ID + "|" + target.spec + "|" + alert.String()
+				long, ok := burnRate(t, target, now.Add(-alert.long), now)
+				short, shortOK := burnRate(t, target, now.Add(-alert.short), now)
+				firing := ok && shortOK && long >= alert.rate && short >= alert.rate
+				if firing && !burnFiring[key] {
+					events = append(events, burnRateEvent(t, target, alert, long, now))
+				}
+				burnFiring[key] = firing
+			}
+		}
+	}
+	burnFiringMu.Unlock()
+
+	for _, event := range events {
+		slog.Warn("SLA error budget burning fast", "tunnel_id", event.TunnelID, "sla", event.SLA, "title", event.Title)
+		notify(event)
+	}
+}
+
+func burnRateEvent(t tunnelState, target slaTarget, alert burnRateAlert, rate float64, now time.Time) Event {
+	return Event{
+		ID:         newEventID(),
+		Type:       eventSLABurnRate,
+		Time:       now,
+		TunnelID:   t.ID,
+		TunnelName: t.label(),
+		NewStatus:  t.Status,
+		SLA:        target.spec,
+		Title:      fmt.Sprintf("Tunnel %s is burning its %s error budget %.1fx too fast", t.label(), target, rate),
+		Message: fmt.Sprintf("Over the last %s tunnel %s spent its %s error budget %.1f times faster than the target allows (alert at %gx, confirmed over the last %s). At this rate a full %s budget lasts %s.",
+			formatWindow(alert.long), t.label(), target, rate, alert.rate, formatWindow(alert.short), target.period, formatElapsed(time.Duration(float64(target.periodLength())/rate))),
+	}
+}
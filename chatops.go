@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxChatRequestSize bounds slash command and interaction bodies.
+	maxChatRequestSize = 64 << 10
+	// chatRequestMaxAge rejects replayed Slack and Discord requests, as
+	// Slack recommends.
+	chatRequestMaxAge = 5 * time.Minute
+)
+
+// Discord interaction and response types.
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+	discordPong               = 1
+	discordChannelMessage     = 4
+	// discordEphemeral flags a reply only the caller sees.
+	discordEphemeral = 1 << 6
+)
+
+var (
+	// slackSigningSecret verifies slash commands sent to /chatops/slack.
+	slackSigningSecret string
+	// discordPublicKey verifies interactions sent to /chatops/discord.
+	discordPublicKey ed25519.PublicKey
+)
+
+// loadChatOps reads SLACK_SIGNING_SECRET, the signing secret of the Slack
+// app whose /tunnels slash command posts to /chatops/slack, and
+// DISCORD_PUBLIC_KEY, the hex public key of the Discord application whose
+// /tunnels command uses /chatops/discord as its interactions endpoint.
+// Each endpoint is disabled without its key.
+func loadChatOps() error {
+	slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	if value := os.Getenv("DISCORD_PUBLIC_KEY"); value != "" {
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("DISCORD_PUBLIC_KEY must be a %d-byte hex key", ed25519.PublicKeySize)
+		}
+		discordPublicKey = key
+	}
+	return nil
+}
+
+// chatReply is the answer to a command. Public replies are shown to the
+// whole channel so others see what on-call did; the rest only to the
+// caller.
+type chatReply struct {
+	text   string
+	public bool
+}
+
+// runChatCommand runs a /tunnels command from user on iface (Slack or
+// Discord): status [tunnel], ack <tunnel>, snooze <tunnel> <duration>,
+// unsnooze <tunnel> or help.
+func runChatCommand(args []string, user, iface string) chatReply {
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	usage := chatReply{text: "Usage: /tunnels status [tunnel] | ack <tunnel> | snooze <tunnel> <duration> | unsnooze <tunnel>"}
+	switch command := strings.ToLower(args[0]); {
+	case command == "help":
+		return usage
+	case command == "status" && len(args) <= 2:
+		if len(args) == 1 {
+			return chatReply{text: chatStatus(snapshotTunnels()), public: true}
+		}
+		t, ok := chatTunnel(args[1])
+		if !ok {
+			return chatReply{text: fmt.Sprintf("Unknown tunnel %q.", args[1])}
+		}
+		return chatReply{text: chatStatus([]tunnelState{t}), public: true}
+	case command == "ack" && len(args) == 2:
+		t, ok := chatTunnel(args[1])
+		if !ok {
+			return chatReply{text: fmt.Sprintf("Unknown tunnel %q.", args[1])}
+		}
+		if _, ok := acknowledgeIncident(t.ID, user, iface); !ok {
+			return chatReply{text: fmt.Sprintf("%s has no open incident.", t.label())}
+		}
+		invalidatePageCache()
+		return chatReply{text: fmt.Sprintf("%s acknowledged the incident on %s; further alerts are muted until it recovers.", user, t.label()), public: true}
+	case command == "snooze" && len(args) == 3:
+		t, ok := chatTunnel(args[1])
+		if !ok {
+			return chatReply{text: fmt.Sprintf("Unknown tunnel %q.", args[1])}
+		}
+		d, err := parseSnoozeDuration(args[2])
+		if err != nil {
+			return chatReply{text: fmt.Sprintf("Invalid duration %q; use 1m to 168h, e.g. 1h or 2h30m.", args[2])}
+		}
+		s := snoozeTunnel(t.ID, d, user, iface, "")
+		return chatReply{text: fmt.Sprintf("%s snoozed alerts for %s until %s.", user, t.label(), s.Until.UTC().Format("2006-01-02 15:04 MST")), public: true}
+	case command == "unsnooze" && len(args) == 2:
+		t, ok := chatTunnel(args[1])
+		if !ok {
+			return chatReply{text: fmt.Sprintf("Unknown tunnel %q.", args[1])}
+		}
+		if !unsnoozeTunnel(t.ID, user, iface) {
+			return chatReply{text: fmt.Sprintf("%s is not snoozed.", t.label())}
+		}
+		return chatReply{text: fmt.Sprintf("%s turned alerts for %s back on.", user, t.label()), public: true}
+	default:
+		return usage
+	}
+}
+
+// chatTunnel finds a tunnel by ID or, ignoring case, by name.
+func chatTunnel(ref string) (tunnelState, bool) {
+	if t, ok := findTunnel(ref); ok {
+		return t, true
+	}
+	for _, t := range snapshotTunnels() {
+		if strings.EqualFold(t.label(), ref) {
+			return t, true
+		}
+	}
+	return tunnelState{}, false
+}
+
+// chatStatus lists the tunnels' statuses, one per line, after the overall
+// status.
+func chatStatus(list []tunnelState) string {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Overall: %s", statusLabel(overallTunnelStatus(list)))
+	for _, t := range list {
+		fmt.Fprintf(&b, "\n%s: %s", t.label(), statusLabel(t.Status))
+		if changed := statusSince(t.ID, t.Status); !changed.IsZero() {
+			fmt.Fprintf(&b, " for %s", formatElapsed(now.Sub(changed)))
+		}
+		if until := snoozedUntil(t.ID, now); !until.IsZero() {
+			fmt.Fprintf(&b, " (snoozed until %s)", until.UTC().Format("15:04 MST"))
+		}
+		if incidentAcknowledged(t.ID) {
+			b.WriteString(" (acknowledged)")
+		}
+	}
+	return b.String()
+}
+
+// slackCommandHandler serves POST /chatops/slack, the Slack /tunnels slash
+// command.
+func slackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	if slackSigningSecret == "" {
+		writeProblem(w, r, problemDisabled, "Slack commands disabled; set SLACK_SIGNING_SECRET")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxChatRequestSize))
+	if err != nil {
+		writeProblem(w, r, problemInvalidRequest, "")
+		return
+	}
+	if !slackSignatureValid(r.Header, body, time.Now()) {
+		writeProblem(w, r, problemUnauthorized, "invalid signature")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeProblem(w, r, problemInvalidRequest, "")
+		return
+	}
+	user := form.Get("user_name")
+	if user == "" {
+		user = form.Get("user_id")
+	}
+	slog.Info("ChatOps command", "platform", "slack", "user", user, "command", form.Get("command"), "args", form.Get("text"))
+	reply := runChatCommand(strings.Fields(form.Get("text")), user, "Slack")
+	responseType := "ephemeral"
+	if reply.public {
+		responseType = "in_channel"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"response_type": responseType, "text": reply.text})
+}
+
+// slackSignatureValid checks Slack's v0 request signature, an HMAC of the
+// timestamp and body, and that the request is recent.
+func slackSignatureValid(h http.Header, body []byte, now time.Time) bool {
+	timestamp := h.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(now.Sub(time.Unix(seconds, 0)).Seconds()) > chatRequestMaxAge.Seconds() {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(h.Get("X-Slack-Signature")), []byte(expected))
+}
+
+// discordSignatureValid checks Discord's Ed25519 signature over the
+// timestamp and body, and that the timestamp is recent.
+func discordSignatureValid(h http.Header, body []byte, now time.Time) bool {
+	timestamp := h.Get("X-Signature-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(now.Sub(time.Unix(seconds, 0)).Seconds()) > chatRequestMaxAge.Seconds() {
+		return false
+	}
+	signature, err := hex.DecodeString(h.Get("X-Signature-Ed25519"))
+	return err == nil && ed25519.Verify(discordPublicKey, append([]byte(timestamp), body...), signature)
+}
+
+// discordInteraction is the part of a Discord interaction the /tunnels
+// command uses. Its subcommands carry their arguments as options.
+type discordInteraction struct {
+	Type int `json:"type"`
+	Data struct {
+		Name    string          `json:"name"`
+		Options []discordOption `json:"options"`
+	} `json:"data"`
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+}
+
+type discordOption struct {
+	Name    string          `json:"name"`
+	Value   any             `json:"value"`
+	Options []discordOption `json:"options"`
+}
+
+type discordUser struct {
+	Username string `json:"username"`
+}
+
+// discordInteractionHandler serves POST /chatops/discord, the interactions
+// endpoint of a Discord application with a /tunnels command whose
+// subcommands are status, ack, snooze and unsnooze, taking tunnel and
+// duration options.
+func discordInteractionHandler(w http.ResponseWriter, r *http.Request) {
+	if discordPublicKey == nil {
+		writeProblem(w, r, problemDisabled, "Discord commands disabled; set DISCORD_PUBLIC_KEY")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxChatRequestSize))
+	if err != nil {
+		writeProblem(w, r, problemInvalidRequest, "")
+		return
+	}
+	if !discordSignatureValid(r.Header, body, time.Now()) {
+		writeProblem(w, r, problemUnauthorized, "invalid signature")
+		return
+	}
+	var interaction discordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		writeProblem(w, r, problemInvalidRequest, "invalid interaction: "+err.Error())
+		return
+	}
+	if interaction.Type == discordPing {
+		writeJSON(w, http.StatusOK, map[string]int{"type": discordPong})
+		return
+	}
+	if interaction.Type != discordApplicationCommand {
+		writeProblem(w, r, problemValidation, fmt.Sprintf("unsupported interaction type %d", interaction.Type))
+		return
+	}
+
+	user := "unknown"
+	if interaction.Member != nil {
+		user = interaction.Member.User.Username
+	} else if interaction.User != nil {
+		user = interaction.User.Username
+	}
+	args := discordArgs(interaction.Data.Options)
+	slog.Info("ChatOps command", "platform", "discord", "user", user, "command", "/"+interaction.Data.Name, "args", strings.Join(args, " "))
+	reply := runChatCommand(args, user, "Discord")
+	data := map[string]any{"content": reply.text}
+	if !reply.public {
+		data["flags"] = discordEphemeral
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"type": discordChannelMessage, "data": data})
+}
+
+// discordArgs turns a subcommand option into the words of a slash
+// command: the subcommand, then its tunnel and duration.
+func discordArgs(options []discordOption) []string {
+	if len(options) == 0 {
+		return nil
+	}
+	args := []string{options[0].Name}
+	for _, name := range []string{"tunnel", "duration"} {
+		for _, option := range options[0].Options {
+			if option.Name == name {
+				args = append(args, fmt.Sprint(option.Value))
+			}
+		}
+	}
+	return args
+}
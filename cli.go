@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Exit codes of the check command.
+const (
+	checkHealthy   = 0
+	checkUnhealthy = 1
+	checkFailed    = 2
+)
+
+// settingFlag is a command-line flag that sets an environment variable.
+type settingFlag struct {
+	name  string
+	env   string
+	usage string
+}
+
+// settingFlagList are the settings common enough to have their own flag;
+// -set covers every other environment variable.
+var settingFlagList = []settingFlag{
+	{"config", "CONFIG_FILE", "YAML, TOML or JSON config file"},
+	{"account", "ACCOUNT_ID", "Cloudflare account ID"},
+	{"tunnels", "TUNNEL_ID", "comma-separated tunnel IDs, each optionally =<display name>"},
+	{"port", "HTTP_PORT", "HTTP port to listen on"},
+	{"poll-interval", "POLL_INTERVAL", "how often to poll the tunnels, e.g. 1m"},
+	{"poll-concurrency", "POLL_CONCURRENCY", "how many tunnels to poll at once"},
+	{"public-url", "PUBLIC_URL", "external base URL of the status page"},
+	{"history-file", "HISTORY_FILE", "file to keep the status history in"},
+	{"history-db", "HISTORY_DB", "SQLite database to keep the status history in"},
+	{"admin-token", "ADMIN_TOKEN", "token for the admin pages and API"},
+}
+
+// settingFlags are the flags shared by serve and check. They mirror the
+// environment variables and take precedence over the environment, which
+// takes precedence over the .env file and CONFIG_FILE.
+type settingFlags struct {
+	envFile  string
+	settings map[string]string
+}
+
+func addSettingFlags(flags *flag.FlagSet) *settingFlags {
+	s := &settingFlags{settings: map[string]string{}}
+	flags.StringVar(&s.envFile, "env", ".env", "environment file to read, if it exists")
+	for _, f := range settingFlagList {
+		flags.Func(f.name, f.usage+" ($"+f.env+")", func(value string) error {
+			s.settings[f.env] = value
+			return nil
+		})
+	}
+	flags.Func("set", "set any environment variable as NAME=value; repeatable", func(value string) error {
+		name, setting, ok := strings.Cut(value, "=")
+		if !ok || !settingName.MatchString(name) {
+			return fmt.Errorf("%q is not NAME=value", value)
+		}
+		s.settings[name] = setting
+		return nil
+	})
+	flags.BoolFunc("mock", "simulate the Cloudflare API instead of calling it ($MOCK)", func(value string) error {
+		s.settings["MOCK"] = value
+		return nil
+	})
+	return s
+}
+
+// apply sets the environment variables given on the command line.
+func (s *settingFlags) apply() {
+	for name, value := range s.settings {
+		os.Setenv(name, value)
+	}
+}
+
+// runServe implements the serve command, the default: it runs the status
+// page with the settings from its flags, the environment and the config.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	settings := addSettingFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "serve: unexpected argument %q\n", flags.Arg(0))
+		os.Exit(2)
+	}
+	settings.apply()
+	loadEnv(settings.envFile)
+	serve()
+}
+
+// checkResult is one tunnel's status as the check command prints it.
+type checkResult struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runCheck implements the check command: one poll of every configured
+// tunnel, printed as a line per tunnel or as JSON. It exits 0 when every
+// tunnel is healthy, 1 when any is not and 2 when a tunnel could not be
+// polled, so cron jobs and scripts can act on the result.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	settings := addSettingFlags(flags)
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	timeout := flags.Duration("timeout", 30*time.Second, "time allowed for the whole check")
+	flags.Parse(args)
+	settings.apply()
+
+	if err := godotenv.Load(settings.envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "check: %v\n", err)
+		return checkFailed
+	}
+	for _, load := range []func() error{loadConfigFile, loadMock, loadOutbound, loadUserAgent, loadCloudflareClient} {
+		if err := load(); err != nil {
+			fmt.Fprintf(os.Stderr, "check: %v\n", err)
+			return checkFailed
+		}
+	}
+	apiKey = os.Getenv("API_TOKEN")
+	cfg := configFromEnv()
+	if apiKey == "" || len(cfg.Tunnels) == 0 {
+		fmt.Fprintln(os.Stderr, "check: API_TOKEN and the tunnels (TUNNEL_ID and ACCOUNT_ID, or tunnels in CONFIG_FILE) must be set")
+		return checkFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var results []checkResult
+	var statuses []string
+	code := checkHealthy
+	for _, t := range cfg.Tunnels {
+		result := checkResult{ID: t.ID, Name: t.Name, Status: statusUnknown}
+		apiResponse, err := fetchTunnel(ctx, t.AccountID, t.ID, apiKey)
+		if err != nil {
+			result.Error = err.Error()
+			code = checkFailed
+		} else {
+			result.Status = apiResponse.Result.Status
+			if result.Name == "" {
+				result.Name = apiResponse.Result.Name
+			}
+			if result.Status != "healthy" && code == checkHealthy {
+				code = checkUnhealthy
+			}
+		}
+		if result.Name == "" {
+			result.Name = t.ID
+		}
+		results = append(results, result)
+		statuses = append(statuses, result.Status)
+	}
+
+	overall := overallStatus(statuses)
+	if *asJSON {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		out.Encode(map[string]any{"status": overall, "tunnels": results})
+		return code
+	}
+	for _, r := range results {
+		line := fmt.Sprintf("%-9s %s (%s)", statusLabel(r.Status), r.Name, r.ID)
+		if r.Error != "" {
+			line += ": " + r.Error
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("Overall: %s\n", statusLabel(overall))
+	return code
+}
+
+// runVersion implements the version command, printing the version and
+// what it was built from.
+func runVersion(args []string) int {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	flags.Parse(args)
+	fmt.Printf("cftunnels %s\n", appVersion())
+	fmt.Printf("%s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		vcs := map[string]string{}
+		for _, s := range info.Settings {
+			vcs[s.Key] = s.Value
+		}
+		if revision := vcs["vcs.revision"]; revision != "" {
+			if vcs["vcs.modified"] == "true" {
+				revision += " (modified)"
+			}
+			fmt.Printf("revision %s", revision)
+			if vcs["vcs.time"] != "" {
+				fmt.Printf(", %s", vcs["vcs.time"])
+			}
+			fmt.Println()
+		}
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `Usage: cftunnels [command] [flags]
+
+Commands:
+  serve           run the status page (the default)
+  check           poll every tunnel once and print its status; exits 0 when
+                  all are healthy, 1 when any is not, 2 when polling failed
+  version         print the version and build information
+  apply           apply a config file to a running instance; -dry-run
+                  shows what would change without applying
+  migrate-config  print the CONFIG_FILE equivalent to the environment
+  loadtest        run the server against simulated tunnels and viewers and
+                  check it against its performance budgets
+  prompt          print a short status for a shell prompt
+  check_cftunnel  Nagios plugin for a single tunnel
+
+Run "cftunnels <command> -h" for the flags of a command.
+`)
+}
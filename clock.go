@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultClockSkewThreshold = time.Minute
+
+var (
+	// clockSkewThreshold is how far the local clock may drift from
+	// Cloudflare's before the page and log warn about it.
+	clockSkewThreshold = defaultClockSkewThreshold
+	// clockSkew is the last measured offset of the local clock from
+	// Cloudflare's, positive when ahead; clockSkewed is whether it exceeds
+	// the threshold.
+	clockSkew   time.Duration
+	clockSkewed bool
+	clockMu     sync.Mutex
+)
+
+// loadClock reads CLOCK_SKEW_THRESHOLD, the largest tolerated difference
+// between the local clock and the Date header of Cloudflare API responses
+// (default 1m, minimum 5s, as the header has one-second resolution).
+func loadClock() error {
+	if value := os.Getenv("CLOCK_SKEW_THRESHOLD"); value != "" {
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 5*time.Second {
+			return fmt.Errorf("CLOCK_SKEW_THRESHOLD: invalid duration %q (minimum 5s)", value)
+		}
+		clockSkewThreshold = threshold
+	}
+	return nil
+}
+
+// checkClock compares now with the Date header of a Cloudflare API
+// response. Skew silently corrupts uptime math and certificate checks, so
+// exceeding the threshold is logged, shown on the status page and counted
+// as a monitor failure.
+func checkClock(date string, now time.Time) {
+	server, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	skew := now.Sub(server)
+	skewed := skew > clockSkewThreshold || -skew > clockSkewThreshold
+
+	clockMu.Lock()
+	changed := skewed != clockSkewed
+	clockSkew = skew
+	clockSkewed = skewed
+	clockMu.Unlock()
+
+	if changed {
+		if skewed {
+			slog.Warn("Local clock is skewed from Cloudflare's; uptimes, incidents and certificate checks will be wrong until it is corrected, e.g. by enabling NTP",
+				"skew", describeSkew(skew), "threshold", clockSkewThreshold)
+		} else {
+			slog.Info("Local clock is in step with Cloudflare's again", "threshold", clockSkewThreshold)
+		}
+		invalidatePageCache()
+	}
+	if skewed {
+		monitorFailure("clock", fmt.Errorf("local clock is %s Cloudflare's", describeSkew(skew)))
+		return
+	}
+	monitorSuccess("clock")
+}
+
+// describeSkew renders skew as e.g. "2m30s ahead of".
+func describeSkew(skew time.Duration) string {
+	if skew < 0 {
+		return (-skew).Round(time.Second).String() + " behind"
+	}
+	return skew.Round(time.Second).String() + " ahead of"
+}
+
+// clockBanner is the status page warning while the clock is skewed, ""
+// otherwise.
+func clockBanner() string {
+	clockMu.Lock()
+	skew, skewed := clockSkew, clockSkewed
+	clockMu.Unlock()
+	if !skewed {
+		return ""
+	}
+	return fmt.Sprintf(`<p class="clock-warning" role="alert">The server clock is %s Cloudflare's, so the times and uptimes shown may be wrong.</p>`,
+		html.EscapeString(describeSkew(skew)))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// cloudEventTypePrefix namespaces event types in reverse-DNS form, as the
+// CloudEvents spec recommends.
+const cloudEventTypePrefix = "io.github.s3ansh33p.cftunnels."
+
+// cloudEvent is a CloudEvents 1.0 event in the structured JSON format.
+type cloudEvent struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject,omitempty"`
+	Time            string `json:"time"`
+	DataContentType string `json:"datacontenttype"`
+	Data            Event  `json:"data"`
+}
+
+// cloudEventsNotifier POSTs events to an HTTP endpoint such as a Knative
+// broker or an EventBridge API destination. Binary mode carries the
+// attributes in ce-* headers with the event data as the body; structured
+// mode sends the whole event as application/cloudevents+json.
+type cloudEventsNotifier struct {
+	url    string
+	source string
+	binary bool
+}
+
+// cloudEventsFromEnv reads CLOUDEVENTS_URLS (comma-separated),
+// CLOUDEVENTS_MODE (structured or binary) and CLOUDEVENTS_SOURCE.
+func cloudEventsFromEnv() []NotifierConfig {
+	return numberedConfigs("cloudevents", "url", os.Getenv("CLOUDEVENTS_URLS"), map[string]string{
+		"mode":   os.Getenv("CLOUDEVENTS_MODE"),
+		"source": os.Getenv("CLOUDEVENTS_SOURCE"),
+	})
+}
+
+// newCloudEventsIntegration takes url, mode and source settings. The
+// source defaults to one naming this host.
+func newCloudEventsIntegration(settings map[string]string) (integration, error) {
+	if settings["url"] == "" {
+		return integration{}, fmt.Errorf("url is required")
+	}
+	mode := settings["mode"]
+	if mode != "" && mode != "structured" && mode != "binary" {
+		return integration{}, fmt.Errorf("mode: must be structured or binary, got %q", mode)
+	}
+	source := settings["source"]
+	if source == "" {
+		source = "/cftunnels"
+		if host, err := os.Hostname(); err == nil {
+			source = "//cftunnels/" + host
+		}
+	}
+	return integration{notifier: &cloudEventsNotifier{url: settings["url"], source: source, binary: mode == "binary"}}, nil
+}
+
+func (n *cloudEventsNotifier) Name() string {
+	return "cloudevents " + n.url
+}
+
+func toCloudEvent(event Event, source string) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              event.ID,
+		Source:          source,
+		Type:            cloudEventTypePrefix + event.Type,
+		Subject:         event.TunnelID,
+		Time:            event.Time.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
+
+func (n *cloudEventsNotifier) Notify(ctx context.Context, event Event) error {
+	ce := toCloudEvent(event, n.source)
+	if !n.binary {
+		return postJSON(ctx, n.url, map[string]string{"Content-Type": "application/cloudevents+json"}, ce)
+	}
+	headers := map[string]string{
+		"ce-specversion": ce.SpecVersion,
+		"ce-id":          ce.ID,
+		"ce-source":      ce.Source,
+		"ce-type":        ce.Type,
+		"ce-time":        ce.Time,
+	}
+	if ce.Subject != "" {
+		headers["ce-subject"] = ce.Subject
+	}
+	return postJSON(ctx, n.url, headers, ce.Data)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultCloudflareTimeout = 15 * time.Second
+	defaultCloudflareRetries = 3
+)
+
+var (
+	// cloudflareClient sends Cloudflare API requests. Its timeout covers
+	// each attempt, including reading the response body.
+	cloudflareClient  = outboundClient(defaultCloudflareTimeout)
+	cloudflareRetries = defaultCloudflareRetries
+)
+
+// loadCloudflareClient reads CLOUDFLARE_TIMEOUT, the time allowed for each
+// Cloudflare API request (default 15s), and CLOUDFLARE_RETRIES, how often
+// a request that failed transiently is retried (default 3, 0 to disable).
+func loadCloudflareClient() error {
+	if value := os.Getenv("CLOUDFLARE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < time.Second {
+			return fmt.Errorf("CLOUDFLARE_TIMEOUT: invalid duration %q (at least 1s)", value)
+		}
+		cloudflareClient.Timeout = timeout
+	}
+	if value := os.Getenv("CLOUDFLARE_RETRIES"); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 || retries > 10 {
+			return fmt.Errorf("CLOUDFLARE_RETRIES: %q is not a number from 0 to 10", value)
+		}
+		cloudflareRetries = retries
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cloudflareIPsURL = "https://api.cloudflare.com/client/v4/ips"
+
+// defaultCloudflareRanges is used until the first successful fetch of the
+// published ranges, so enforcement works even if that fetch fails at startup.
+var defaultCloudflareRanges = []string{
+	"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+	"141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+	"197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+	"104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+	"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32",
+	"2405:8100::/32", "2a06:98c0::/29", "2c0f:f248::/32",
+}
+
+var (
+	cloudflareOnly       bool
+	cloudflareIPsRefresh = 24 * time.Hour
+	trustedProxies       []netip.Prefix
+	cloudflareRanges     []netip.Prefix
+	cloudflareRangesMu   sync.RWMutex
+)
+
+type cloudflareIPsResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		IPv4CIDRs []string `json:"ipv4_cidrs"`
+		IPv6CIDRs []string `json:"ipv6_cidrs"`
+	} `json:"result"`
+}
+
+// loadCloudflareIngress reads CLOUDFLARE_ONLY, CLOUDFLARE_IPS_REFRESH and
+// CLOUDFLARE_TRUSTED_PROXIES. Trusted proxies are local hops, such as the
+// cloudflared connector publishing this dashboard, that forward Cloudflare
+// traffic from an address outside the published ranges.
+func loadCloudflareIngress() error {
+	cloudflareOnly = os.Getenv("CLOUDFLARE_ONLY") == "true"
+
+	if value := os.Getenv("CLOUDFLARE_IPS_REFRESH"); value != "" {
+		refresh, err := time.ParseDuration(value)
+		if err != nil || refresh <= 0 {
+			return fmt.Errorf("CLOUDFLARE_IPS_REFRESH: invalid duration %q", value)
+		}
+		cloudflareIPsRefresh = refresh
+	}
+
+	proxies, err := parsePrefixes(os.Getenv("CLOUDFLARE_TRUSTED_PROXIES"))
+	if err != nil {
+		return fmt.Errorf("CLOUDFLARE_TRUSTED_PROXIES: %w", err)
+	}
+	trustedProxies = proxies
+
+	ranges, err := parsePrefixes(strings.Join(defaultCloudflareRanges, ","))
+	if err != nil {
+		return err
+	}
+	setCloudflareRanges(ranges)
+	return nil
+}
+
+func setCloudflareRanges(ranges []netip.Prefix) {
+	cloudflareRangesMu.Lock()
+	cloudflareRanges = ranges
+	cloudflareRangesMu.Unlock()
+}
+
+func isCloudflareAddr(addr netip.Addr) bool {
+	cloudflareRangesMu.RLock()
+	defer cloudflareRangesMu.RUnlock()
+	return containsAddr(cloudflareRanges, addr)
+}
+
+func fetchCloudflareRanges() ([]netip.Prefix, error) {
+	req, err := http.NewRequest("GET", cloudflareIPsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setAPIHeaders(req)
+	resp, err := outboundClient(30 * time.Second).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ipsResponse cloudflareIPsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ipsResponse); err != nil {
+		return nil, err
+	}
+	if !ipsResponse.Success {
+		return nil, fmt.Errorf("API response indicates failure (HTTP %d)", resp.StatusCode)
+	}
+
+	cidrs := append(ipsResponse.Result.IPv4CIDRs, ipsResponse.Result.IPv6CIDRs...)
+	ranges, err := parsePrefixes(strings.Join(cidrs, ","))
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("API returned no ranges")
+	}
+	return ranges, nil
+}
+
+// refreshCloudflareRanges keeps the published Cloudflare ranges up to date.
+// On failure the previous ranges stay in effect.
+func refreshCloudflareRanges() {
+	for {
+		ranges, err := fetchCloudflareRanges()
+		if err != nil {
+			slog.Error("Error fetching Cloudflare IP ranges", "error", err)
+		} else {
+			setCloudflareRanges(ranges)
+		}
+		time.Sleep(cloudflareIPsRefresh)
+	}
+}
+
+// cloudflareIngress rejects requests that did not arrive through Cloudflare
+// and, for those that did, replaces RemoteAddr with the CF-Connecting-IP
+// header so later middleware and logs see the real client. Health probes
+// come from the orchestrator, not Cloudflare, and are let through.
+func cloudflareIngress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := clientAddr(r)
+		trusted := ok && (isCloudflareAddr(addr) || containsAddr(trustedProxies, addr))
+		if !trusted {
+			slog.Warn("Rejected non-Cloudflare request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeError(w, r, problemForbidden, "requests must come through Cloudflare")
+			return
+		}
+
+		if connecting, err := netip.ParseAddr(r.Header.Get("CF-Connecting-IP")); err == nil {
+			r.RemoteAddr = net.JoinHostPort(connecting.Unmap().String(), "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultConnectorLogLines = 50
+
+// connectorLogSource is a cloudflared log to follow for one tunnel.
+type connectorLogSource struct {
+	tunnelID string
+	// kind is file or journald; target is the path or the systemd unit.
+	kind   string
+	target string
+}
+
+// connectorLogLine is a log line worth showing: an error, warning or
+// connection lifecycle message.
+type connectorLogLine struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Source  string
+}
+
+// connectorLogPatterns mark info-level lines about connections coming and
+// going, which explain a degraded tunnel as well as errors do.
+var connectorLogPatterns = []string{
+	"registered tunnel connection",
+	"unregistered tunnel connection",
+	"connection terminated",
+	"retrying connection",
+	"reconnect",
+	"lost connection",
+	"initiating graceful shutdown",
+}
+
+var (
+	connectorLogSources []connectorLogSource
+	connectorLogLimit   = defaultConnectorLogLines
+	connectorLogs       = map[string][]connectorLogLine{}
+	connectorLogsMu     sync.RWMutex
+)
+
+// loadConnectorLogs reads CLOUDFLARED_LOGS, a comma-separated list of
+// tunnel-id=file:/path/to/log or tunnel-id=journald:unit entries, and
+// CLOUDFLARED_LOG_LINES, how many relevant lines to keep per tunnel.
+func loadConnectorLogs() error {
+	for _, entry := range splitList(os.Getenv("CLOUDFLARED_LOGS")) {
+		id, spec, ok := strings.Cut(entry, "=")
+		kind, target, ok2 := strings.Cut(spec, ":")
+		if !ok || !ok2 || id == "" || target == "" || (kind != "file" && kind != "journald") {
+			return fmt.Errorf("CLOUDFLARED_LOGS: %q is not tunnel-id=file:path or tunnel-id=journald:unit", entry)
+		}
+		connectorLogSources = append(connectorLogSources, connectorLogSource{tunnelID: strings.TrimSpace(id), kind: kind, target: target})
+	}
+	if value := os.Getenv("CLOUDFLARED_LOG_LINES"); value != "" {
+		lines, err := strconv.Atoi(value)
+		if err != nil || lines < 1 {
+			return fmt.Errorf("CLOUDFLARED_LOG_LINES: invalid number %q", value)
+		}
+		connectorLogLimit = lines
+	}
+	return nil
+}
+
+// tailConnectorLogs follows every configured log until the process exits.
+func tailConnectorLogs() {
+	for _, source := range connectorLogSources {
+		go func(source connectorLogSource) {
+			for {
+				var err error
+				if source.kind == "journald" {
+					err = followJournald(source)
+				} else {
+					err = followFile(source)
+				}
+				if err != nil {
+					slog.Error("Error following cloudflared log", "source", source.target, "error", err)
+				}
+				time.Sleep(10 * time.Second)
+			}
+		}(source)
+	}
+}
+
+// followFile reads new lines appended to the file, starting at its end, and
+// reopens it when it is rotated or truncated.
+func followFile(source connectorLogSource) error {
+	file, err := os.Open(source.target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	original, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			recordConnectorLog(source, partial+strings.TrimRight(line, "\r\n"))
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+
+		time.Sleep(time.Second)
+		current, err := os.Stat(source.target)
+		if err != nil || !os.SameFile(original, current) || current.Size() < offset {
+			// Rotated or truncated: start over on the new file.
+			return nil
+		}
+	}
+}
+
+// followJournald streams new entries for a systemd unit through
+// journalctl.
+func followJournald(source connectorLogSource) error {
+	cmd := exec.CommandContext(context.Background(), "journalctl", "--unit", source.target, "--follow", "--lines", "0", "--output", "cat")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		recordConnectorLog(source, scanner.Text())
+	}
+	return cmd.Wait()
+}
+
+// parseConnectorLog reads a cloudflared log line in either its default
+// console format ("2024-01-02T03:04:05Z ERR message key=value") or its JSON
+// format (--output json). ok is false for lines not worth keeping.
+func parseConnectorLog(text string) (line connectorLogLine, ok bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return line, false
+	}
+	if strings.HasPrefix(text, "{") {
+		var entry map[string]any
+		if json.Unmarshal([]byte(text), &entry) == nil {
+			line.Level, _ = entry["level"].(string)
+			line.Message, _ = entry["message"].(string)
+			if errText, _ := entry["error"].(string); errText != "" {
+				line.Message += ": " + errText
+			}
+			if stamp, _ := entry["time"].(string); stamp != "" {
+				line.Time, _ = time.Parse(time.RFC3339, stamp)
+			}
+		}
+	}
+	if line.Message == "" {
+		line.Message = text
+		fields := strings.SplitN(text, " ", 3)
+		if len(fields) == 3 {
+			if stamp, err := time.Parse(time.RFC3339, fields[0]); err == nil {
+				line.Time, line.Level, line.Message = stamp, fields[1], fields[2]
+			}
+		}
+	}
+	if line.Time.IsZero() {
+		line.Time = time.Now()
+	}
+
+	// Levels are normalised to the console format's abbreviations.
+	switch strings.ToLower(line.Level) {
+	case "err", "error", "fatal", "ftl", "panic":
+		line.Level = "ERR"
+		return line, true
+	case "wrn", "warn", "warning":
+		line.Level = "WRN"
+		return line, true
+	case "info":
+		line.Level = "INF"
+	}
+	message := strings.ToLower(line.Message)
+	for _, pattern := range connectorLogPatterns {
+		if strings.Contains(message, pattern) {
+			return line, true
+		}
+	}
+	return line, false
+}
+
+func recordConnectorLog(source connectorLogSource, text string) {
+	line, ok := parseConnectorLog(text)
+	if !ok {
+		return
+	}
+	line.Source = source.target
+	connectorLogsMu.Lock()
+	lines := append(connectorLogs[source.tunnelID], line)
+	if len(lines) > connectorLogLimit {
+		lines = lines[len(lines)-connectorLogLimit:]
+	}
+	connectorLogs[source.tunnelID] = lines
+	connectorLogsMu.Unlock()
+}
+
+// tunnelLogLines returns a tunnel's kept log lines, newest first.
+func tunnelLogLines(tunnelID string) []connectorLogLine {
+	connectorLogsMu.RLock()
+	defer connectorLogsMu.RUnlock()
+	lines := connectorLogs[tunnelID]
+	out := make([]connectorLogLine, len(lines))
+	for i, line := range lines {
+		out[len(lines)-1-i] = line
+	}
+	return out
+}
+
+// hasConnectorLogs reports whether any log is followed for the tunnel.
+func hasConnectorLogs(tunnelID string) bool {
+	for _, source := range connectorLogSources {
+		if source.tunnelID == tunnelID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConnectorScrapeInterval is how often cloudflared metrics are read.
+// They come from the local network, so this can be much shorter than the
+// API poll interval.
+const defaultConnectorScrapeInterval = 30 * time.Second
+
+// connectorTarget is a cloudflared metrics endpoint and the tunnel it
+// serves.
+type connectorTarget struct {
+	tunnelID string
+	url      string
+}
+
+// connectorMetrics is what the last scrape of one cloudflared instance
+// found. Rates are per second since the previous scrape.
+type connectorMetrics struct {
+	URL             string
+	Up              bool
+	Error           string
+	ScrapedAt       time.Time
+	Version         string
+	HAConnections   int
+	EdgeLocations   []string
+	Concurrent      float64
+	TotalRequests   float64
+	RequestErrors   float64
+	RequestRate     float64
+	ErrorRate       float64
+	ResponsesByCode map[string]float64
+}
+
+// metricSample is one line of the Prometheus text exposition format.
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var (
+	connectorTargets        []connectorTarget
+	connectorScrapeInterval = defaultConnectorScrapeInterval
+	connectors              = map[string]*connectorMetrics{}
+	connectorsMu            sync.RWMutex
+)
+
+// loadConnectorMetrics reads CLOUDFLARED_METRICS, a comma-separated list of
+// tunnel-id=url pairs naming the metrics endpoint of each cloudflared
+// instance (started with --metrics), and CLOUDFLARED_SCRAPE_INTERVAL.
+func loadConnectorMetrics() error {
+	for _, entry := range splitList(os.Getenv("CLOUDFLARED_METRICS")) {
+		id, endpoint, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || endpoint == "" {
+			return fmt.Errorf("CLOUDFLARED_METRICS: %q is not tunnel-id=url", entry)
+		}
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		endpoint = strings.TrimRight(endpoint, "/")
+		if !strings.HasSuffix(endpoint, "/metrics") {
+			endpoint += "/metrics"
+		}
+		connectorTargets = append(connectorTargets, connectorTarget{tunnelID: strings.TrimSpace(id), url: endpoint})
+	}
+	if value := os.Getenv("CLOUDFLARED_SCRAPE_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Second {
+			return fmt.Errorf("CLOUDFLARED_SCRAPE_INTERVAL: invalid duration %q", value)
+		}
+		connectorScrapeInterval = interval
+	}
+	return nil
+}
+
+// scrapeConnectors reads every configured cloudflared instance on each
+// interval.
+func scrapeConnectors() {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		var wg sync.WaitGroup
+		for _, target := range connectorTargets {
+			wg.Add(1)
+			go func(target connectorTarget) {
+				defer wg.Done()
+				scrapeConnector(client, target)
+			}(target)
+		}
+		wg.Wait()
+		time.Sleep(connectorScrapeInterval)
+	}
+}
+
+func scrapeConnector(client *http.Client, target connectorTarget) {
+	now := time.Now()
+	m := &connectorMetrics{URL: target.url, ScrapedAt: now}
+
+	samples, err := fetchMetrics(client, target.url)
+	if err != nil {
+		m.Error = err.Error()
+	} else {
+		m.Up = true
+		m.ResponsesByCode = map[string]float64{}
+		locations := map[string]bool{}
+		for _, s := range samples {
+			switch s.name {
+			case "build_info":
+				m.Version = s.labels["version"]
+			case "cloudflared_tunnel_ha_connections":
+				m.HAConnections = int(s.value)
+			case "cloudflared_tunnel_server_locations":
+				if s.value > 0 && s.labels["edge_location"] != "" {
+					locations[s.labels["edge_location"]] = true
+				}
+			case "cloudflared_tunnel_concurrent_requests_per_tunnel":
+				m.Concurrent += s.value
+			case "cloudflared_tunnel_total_requests":
+				m.TotalRequests += s.value
+			case "cloudflared_tunnel_request_errors":
+				m.RequestErrors += s.value
+			case "cloudflared_tunnel_response_by_code":
+				m.ResponsesByCode[s.labels["status_code"]] += s.value
+			}
+		}
+		for location := range locations {
+			m.EdgeLocations = append(m.EdgeLocations, location)
+		}
+		sort.Strings(m.EdgeLocations)
+	}
+
+	key := target.tunnelID + " " + target.url
+	connectorsMu.Lock()
+	if previous, ok := connectors[key]; ok && previous.Up && m.Up {
+		elapsed := now.Sub(previous.ScrapedAt).Seconds()
+		// Counters reset when cloudflared restarts.
+		if elapsed > 0 && m.TotalRequests >= previous.TotalRequests && m.RequestErrors >= previous.RequestErrors {
+			m.RequestRate = (m.TotalRequests - previous.TotalRequests) / elapsed
+			m.ErrorRate = (m.RequestErrors - previous.RequestErrors) / elapsed
+		}
+	}
+	if previous, ok := connectors[key]; ok && previous.Up && !m.Up {
+		slog.Warn("cloudflared metrics unreachable", "tunnel_id", target.tunnelID, "url", target.url, "error", m.Error)
+	}
+	connectors[key] = m
+	connectorsMu.Unlock()
+}
+
+func fetchMetrics(client *http.Client, endpoint string) ([]metricSample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return parseMetrics(io.LimitReader(resp.Body, 8<<20))
+}
+
+// parseMetrics reads the Prometheus text exposition format. Comments,
+// blank lines and timestamps are ignored.
+func parseMetrics(r io.Reader) ([]metricSample, error) {
+	var samples []metricSample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s := metricSample{labels: map[string]string{}}
+		rest := line
+		if open := strings.IndexByte(line, '{'); open >= 0 {
+			end := strings.LastIndexByte(line, '}')
+			if end < open {
+				return nil, fmt.Errorf("malformed line %q", line)
+			}
+			s.name = line[:open]
+			parseMetricLabels(line[open+1:end], s.labels)
+			rest = strings.TrimSpace(line[end+1:])
+		} else {
+			name, value, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, fmt.Errorf("malformed line %q", line)
+			}
+			s.name, rest = name, strings.TrimSpace(value)
+		}
+		value, _, _ := strings.Cut(rest, " ")
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value in %q", line)
+		}
+		s.value = v
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+func parseMetricLabels(text string, labels map[string]string) {
+	for text != "" {
+		name, rest, ok := strings.Cut(text, "=\"")
+		if !ok {
+			return
+		}
+		var value strings.Builder
+		i := 0
+		for ; i < len(rest) && rest[i] != '"'; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				switch rest[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(rest[i])
+				}
+				continue
+			}
+			value.WriteByte(rest[i])
+		}
+		labels[strings.TrimSpace(strings.TrimPrefix(name, ","))] = value.String()
+		if i >= len(rest) {
+			return
+		}
+		text = strings.TrimPrefix(rest[i+1:], ",")
+	}
+}
+
+// tunnelConnectors returns the latest metrics of a tunnel's cloudflared
+// instances, ordered by URL.
+func tunnelConnectors(tunnelID string) []connectorMetrics {
+	connectorsMu.RLock()
+	defer connectorsMu.RUnlock()
+	var out []connectorMetrics
+	for _, target := range connectorTargets {
+		if target.tunnelID != tunnelID {
+			continue
+		}
+		if m, ok := connectors[target.tunnelID+" "+target.url]; ok {
+			out = append(out, *m)
+		} else {
+			out = append(out, connectorMetrics{URL: target.url, Error: "not scraped yet"})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
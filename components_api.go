@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// componentsAPIVersion is the version of the components API contract. Fields
+// are only ever added within a version; renaming or removing one, or
+// changing what a status means, needs a new version alongside this one.
+const componentsAPIVersion = "1"
+
+// componentUptimeDays is the window of the uptime figure in the components
+// API.
+const componentUptimeDays = 90
+
+type componentsResponse struct {
+	APIVersion  string      `json:"api_version"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Status      string      `json:"status"`
+	Components  []component `json:"components"`
+}
+
+// component is one monitored tunnel as the public API describes it.
+type component struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+	// Status is one of healthy, degraded, inactive, down or unknown.
+	Status      string     `json:"status"`
+	StatusSince *time.Time `json:"status_since,omitempty"`
+	// Uptime is the percentage of the last UptimeDays during which the
+	// component was available, after exclusions; null without data.
+	Uptime     *float64 `json:"uptime"`
+	UptimeDays int      `json:"uptime_days"`
+}
+
+func componentOf(t tunnelState, now time.Time) component {
+	// Components are cached publicly, so the ID is masked for everyone.
+	c := component{
+		ID:          publicTunnelID(t.ID),
+		Name:        t.label(),
+		Group:       t.Group,
+		Status:      t.Status,
+		StatusSince: optionalTime(statusSince(t.ID, t.Status)),
+		UptimeDays:  componentUptimeDays,
+	}
+	if ratio, ok := availability(t.ID, now.AddDate(0, 0, -componentUptimeDays), now); ok {
+		uptime := math.Round(ratio*100000) / 1000
+		c.Uptime = &uptime
+	}
+	return c
+}
+
+// writeComponentsJSON sends a components API response. It may be
+// fetched from other sites' status views, so cross-origin reads are
+// allowed and responses are cacheable for a minute.
+func writeComponentsJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("API-Version", componentsAPIVersion)
+	writeJSON(w, code, v)
+}
+
+// componentsHandler serves GET /api/v1/components, the versioned contract
+// for building status views: every component, with its ID, display name,
+// group, current status and 90-day uptime, in configured order. ?group=
+// limits it to a group; the list parameters of listQuery filter, sort and
+// page the components.
+//
+//	{
+//	  "api_version": "1",
+//	  "generated_at": "2024-05-01T10:00:00Z",
+//	  "status": "healthy",
+//	  "components": [
+//	    {"id": "...", "name": "Web", "group": "Europe", "status": "healthy",
+//	     "status_since": "2024-04-28T08:12:00Z", "uptime": 99.982, "uptime_days": 90}
+//	  ]
+//	}
+func componentsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	group := r.URL.Query().Get("group")
+	var list []tunnelState
+	for _, t := range snapshotTunnels() {
+		if group == "" || t.Group == group {
+			list = append(list, t)
+		}
+	}
+	if detail, failed := upstreamFailure(list); failed {
+		writeProblem(w, r, problemCloudflare, detail)
+		return
+	}
+	response := componentsResponse{
+		APIVersion:  componentsAPIVersion,
+		GeneratedAt: now,
+		Status:      overallTunnelStatus(list),
+		Components:  []component{},
+	}
+	for _, t := range list {
+		response.Components = append(response.Components, componentOf(t, now))
+	}
+	page, ok := pageList(w, r, response.Components, listSpec{key: "id"})
+	if !ok {
+		return
+	}
+	if page != nil {
+		writeComponentsJSON(w, http.StatusOK, page.replace(response, "components"))
+		return
+	}
+	writeComponentsJSON(w, http.StatusOK, response)
+}
+
+// componentHandler serves GET /api/v1/components/{id}, a single component
+// in the same shape as in the list.
+func componentHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := findTunnel(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, problemUnknownComponent, fmt.Sprintf("no component %q", r.PathValue("id")))
+		return
+	}
+	if detail, failed := upstreamFailure([]tunnelState{t}); failed {
+		writeProblem(w, r, problemCloudflare, detail)
+		return
+	}
+	writeComponentsJSON(w, http.StatusOK, componentOf(t, time.Now()))
+}
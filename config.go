@@ -0,0 +1,658 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secret settings when a config is read back. Applying a
+// config that still contains it keeps the current value, so a config can be
+// fetched, edited and re-applied without handling secrets.
+const redacted = "(redacted)"
+
+// Config is the declarative configuration of the dashboard: what to monitor
+// and where to send events. It is built from the environment at startup
+// and can be replaced as a whole at runtime with apply.
+type Config struct {
+	Tunnels   []TunnelConfig   `json:"tunnels"`
+	Probes    []ProbeConfig    `json:"probes,omitempty"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// TunnelConfig identifies a monitored tunnel.
+type TunnelConfig struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+	// Name is the display name; the name from the API is used if empty.
+	Name string `json:"name,omitempty"`
+	// Group is the component group the tunnel is listed under in the
+	// components API, e.g. "Europe".
+	Group string `json:"group,omitempty"`
+	// BusinessHours is the schedule the business-hours availability
+	// counts, e.g. "Mon-Fri 08:00-17:00 Europe/London"; BUSINESS_HOURS
+	// is used if empty.
+	BusinessHours string `json:"business_hours,omitempty"`
+	// SLA lists the tunnel's SLA targets, e.g. "99.9% monthly";
+	// SLA_TARGETS is used if empty.
+	SLA string `json:"sla,omitempty"`
+	// Weight counts the tunnel towards the weighted overall status (1 if
+	// zero) and MaxStatus caps the status it can contribute, e.g.
+	// "degraded" for a lab tunnel.
+	Weight    float64 `json:"weight,omitempty"`
+	MaxStatus string  `json:"max_status,omitempty"`
+	// Links are quick links by name, e.g. runbook, dashboard or repo,
+	// shown on the tunnel's page and included in its alerts.
+	Links map[string]string `json:"links,omitempty"`
+	// Regions are the countries or GEOIP_REGIONS the tunnel serves, which
+	// word the region hints of its incidents, e.g. ["EU"].
+	Regions []string `json:"regions,omitempty"`
+}
+
+// NotifierConfig is one notification channel or ticketing integration.
+// Settings are specific to Type.
+type NotifierConfig struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// integration is what a notifier config builds: a Notifier for events, an
+// incidentHook for ticketing, or both, and optionally a daily summary.
+type integration struct {
+	notifier Notifier
+	hook     incidentHook
+	summary  summarySender
+}
+
+// integrationType describes a notifier type: how to build it from
+// settings and which settings are secret.
+type integrationType struct {
+	build   func(settings map[string]string) (integration, error)
+	secrets []string
+}
+
+var integrationTypes = map[string]integrationType{
+	"cloudevents":   {build: newCloudEventsIntegration},
+	"eventbridge":   {build: newEventBridgeIntegration},
+	"lambda":        {build: newLambdaIntegration},
+	"google_chat":   {build: newGoogleChatIntegration, secrets: []string{"url"}},
+	"zoom":          {build: newZoomIntegration, secrets: []string{"token"}},
+	"line":          {build: newLineIntegration, secrets: []string{"token"}},
+	"wecom":         {build: newWeComIntegration, secrets: []string{"url"}},
+	"jira":          {build: newJiraIntegration, secrets: []string{"api_token", "bearer_token"}},
+	"github_issues": {build: newGitHubIssuesIntegration, secrets: []string{"token"}},
+	"github_status": {build: newGitHubStatusIntegration, secrets: []string{"token"}},
+	"pagerduty":     {build: newPagerDutyIntegration, secrets: []string{"routing_key"}},
+	"opsgenie":      {build: newOpsgenieIntegration, secrets: []string{"api_key"}},
+	"webhook":       {build: newWebhookIntegration, secrets: []string{"secret"}},
+	"slack":         {build: newSlackIntegration, secrets: []string{"url"}},
+	"smtp":          {build: newSMTPIntegration, secrets: []string{"password"}},
+}
+
+var (
+	currentConfig Config
+	// discoveredTunnels holds tunnels found by discovery sources such as
+	// Kubernetes, keyed by source. They are monitored alongside the
+	// configured tunnels but are not part of the config.
+	discoveredTunnels = map[string][]TunnelConfig{}
+	// runningIntegrations are the integrations built from currentConfig's
+	// notifiers by name, kept across applies that leave them unchanged so
+	// digest buffers and hook state survive.
+	runningIntegrations = map[string]integration{}
+	configMu            sync.Mutex
+)
+
+// configFromEnv builds the startup configuration from environment
+// variables and CONFIG_FILE.
+func configFromEnv() Config {
+	cfg := Config{Tunnels: tunnelsFromEnv()}
+	if len(cfg.Tunnels) == 0 {
+		cfg.Tunnels = slices.Clone(fileConfig.Tunnels)
+	}
+	// Malformed entries are reported by loadExternalStatus.
+	cfg.Probes, _ = probesFromEnv()
+	if len(cfg.Probes) == 0 {
+		cfg.Probes = slices.Clone(fileConfig.Probes)
+	}
+	sources := []func() []NotifierConfig{
+		cloudEventsFromEnv,
+		awsFromEnv,
+		googleChatFromEnv,
+		zoomFromEnv,
+		lineFromEnv,
+		wecomFromEnv,
+		jiraFromEnv,
+		githubFromEnv,
+		pagerDutyFromEnv,
+		opsgenieFromEnv,
+		webhookFromEnv,
+		slackFromEnv,
+		smtpFromEnv,
+	}
+	var fromEnv []NotifierConfig
+	for _, source := range sources {
+		fromEnv = append(fromEnv, source()...)
+	}
+	cfg.Notifiers = mergeNotifiers(fileConfig.Notifiers, fromEnv)
+	digestFromEnv(cfg.Notifiers)
+	timeoutFromEnv(cfg.Notifiers)
+	return cfg
+}
+
+// tunnelsFromEnv reads TUNNEL_ID, a comma-separated list of tunnels in
+// ACCOUNT_ID, each an ID optionally followed by =<display name>, e.g.
+// "3f2a...=Web, 9c1b...=Internal API", and TUNNEL_LINKS, a comma-separated
+// list of <tunnel-id>:<name>=<URL> quick links, e.g.
+// "3f2a...:runbook=https://wiki.example.com/web", and TUNNEL_REGIONS, a
+// comma-separated list of <tunnel-id>:<region>, e.g. "3f2a...:EU".
+func tunnelsFromEnv() []TunnelConfig {
+	var tunnels []TunnelConfig
+	for _, item := range splitList(os.Getenv("TUNNEL_ID")) {
+		id, name, _ := strings.Cut(item, "=")
+		tunnels = append(tunnels, TunnelConfig{
+			ID:        strings.TrimSpace(id),
+			AccountID: os.Getenv("ACCOUNT_ID"),
+			Name:      strings.TrimSpace(name),
+		})
+	}
+	for _, item := range splitList(os.Getenv("TUNNEL_LINKS")) {
+		id, link, _ := strings.Cut(item, ":")
+		name, target, _ := strings.Cut(link, "=")
+		for i := range tunnels {
+			if tunnels[i].ID == strings.TrimSpace(id) {
+				if tunnels[i].Links == nil {
+					tunnels[i].Links = map[string]string{}
+				}
+				tunnels[i].Links[strings.TrimSpace(name)] = strings.TrimSpace(target)
+			}
+		}
+	}
+	for _, item := range splitList(os.Getenv("TUNNEL_REGIONS")) {
+		id, region, _ := strings.Cut(item, ":")
+		for i := range tunnels {
+			if tunnels[i].ID == strings.TrimSpace(id) {
+				tunnels[i].Regions = append(tunnels[i].Regions, strings.TrimSpace(region))
+			}
+		}
+	}
+	return tunnels
+}
+
+// numberedConfigs creates one notifier config per value of a
+// comma-separated environment variable, named type-1, type-2 and so on.
+func numberedConfigs(typ, key, value string, shared map[string]string) []NotifierConfig {
+	var configs []NotifierConfig
+	for i, item := range splitList(value) {
+		settings := map[string]string{key: item}
+		for k, v := range shared {
+			if v != "" {
+				settings[k] = v
+			}
+		}
+		configs = append(configs, NotifierConfig{Name: fmt.Sprintf("%s-%d", typ, i+1), Type: typ, Settings: settings})
+	}
+	return configs
+}
+
+// envSettings collects the non-empty environment variables named in keys,
+// mapping setting name to variable.
+func envSettings(keys map[string]string) map[string]string {
+	settings := map[string]string{}
+	for setting, variable := range keys {
+		if value := os.Getenv(variable); value != "" {
+			settings[setting] = value
+		}
+	}
+	return settings
+}
+
+// configErrors lists every problem found in a config, by section.
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// validate checks cfg and builds its integrations without applying
+// anything. The error is a configErrors listing every invalid section.
+func (cfg Config) validate() ([]integration, error) {
+	var errs configErrors
+	seen := map[string]bool{}
+	for i, t := range cfg.Tunnels {
+		if err := t.validate(i, seen); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	probes := map[string]bool{}
+	for i, p := range cfg.Probes {
+		if err := p.validate(i, probes); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	names := map[string]bool{}
+	var built []integration
+	for i, n := range cfg.Notifiers {
+		in, err := n.build(i, names)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		built = append(built, in)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return built, nil
+}
+
+// validate checks the ith tunnel of a config; seen holds the IDs of the
+// tunnels before it.
+func (t TunnelConfig) validate(i int, seen map[string]bool) error {
+	if t.ID == "" || t.AccountID == "" {
+		return fmt.Errorf("tunnels[%d]: id and account_id are required", i)
+	}
+	if seen[t.ID] {
+		return fmt.Errorf("tunnels[%d]: duplicate tunnel %s", i, t.ID)
+	}
+	seen[t.ID] = true
+	if t.BusinessHours != "" {
+		if _, err := parseBusinessHours(t.BusinessHours); err != nil {
+			return fmt.Errorf("tunnels[%d]: business_hours: %w", i, err)
+		}
+	}
+	if t.SLA != "" {
+		if _, err := parseSLATargets(t.SLA, t.BusinessHours != "" || defaultBusinessHours != nil); err != nil {
+			return fmt.Errorf("tunnels[%d]: sla: %w", i, err)
+		}
+	}
+	if t.Weight < 0 {
+		return fmt.Errorf("tunnels[%d]: weight must not be negative", i)
+	}
+	if t.MaxStatus != "" && !validStatus(t.MaxStatus) {
+		return fmt.Errorf("tunnels[%d]: max_status: unknown status %q (available: healthy, degraded, inactive, down)", i, t.MaxStatus)
+	}
+	for name, link := range t.Links {
+		if u, err := url.Parse(link); name == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tunnels[%d]: links: %q is not a named http or https URL", i, link)
+		}
+	}
+	for _, region := range t.Regions {
+		if !regionName.MatchString(strings.ToUpper(region)) {
+			return fmt.Errorf("tunnels[%d]: regions: %q is not a country or region name", i, region)
+		}
+	}
+	return nil
+}
+
+// build checks the ith notifier of a config and builds its integration;
+// names holds the names of the notifiers before it.
+func (n NotifierConfig) build(i int, names map[string]bool) (integration, error) {
+	if n.Name == "" {
+		return integration{}, fmt.Errorf("notifiers[%d]: name is required", i)
+	}
+	if names[n.Name] {
+		return integration{}, fmt.Errorf("notifiers[%d]: duplicate name %q", i, n.Name)
+	}
+	names[n.Name] = true
+	typ, ok := integrationTypes[n.Type]
+	if !ok {
+		return integration{}, fmt.Errorf("notifier %q: unknown type %q (available: %s)", n.Name, n.Type, strings.Join(integrationTypeNames(), ", "))
+	}
+	in, err := typ.build(n.Settings)
+	if err != nil {
+		return integration{}, fmt.Errorf("notifier %q: %w", n.Name, err)
+	}
+	if n.Settings["digest"] != "" && in.notifier == nil {
+		return integration{}, fmt.Errorf("notifier %q: digest: %s does not send events", n.Name, n.Type)
+	}
+	if in.notifier != nil {
+		if in.notifier, err = withTimeout(n.Settings, in.notifier); err != nil {
+			return integration{}, fmt.Errorf("notifier %q: %w", n.Name, err)
+		}
+		if in.notifier, err = withDigest(n.Settings, in.notifier); err != nil {
+			return integration{}, fmt.Errorf("notifier %q: %w", n.Name, err)
+		}
+	}
+	return in, nil
+}
+
+func integrationTypeNames() []string {
+	names := make([]string, 0, len(integrationTypes))
+	for name := range integrationTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configChange is one entry of the diff returned by apply.
+type configChange struct {
+	Action string   `json:"action"`
+	Kind   string   `json:"kind"`
+	ID     string   `json:"id"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// configDiff is the result of applying a configuration.
+type configDiff struct {
+	Changed bool           `json:"changed"`
+	Changes []configChange `json:"changes"`
+	// removed holds the tunnels and notifiers the change removed, with
+	// their secrets, so they can be kept for restoring.
+	removed Config
+}
+
+// diffConfigs lists what changes between current and desired.
+func diffConfigs(current, desired Config) configDiff {
+	diff := configDiff{Changes: []configChange{}}
+
+	currentTunnels := map[string]TunnelConfig{}
+	for _, t := range current.Tunnels {
+		currentTunnels[t.ID] = t
+	}
+	desiredTunnels := map[string]bool{}
+	for _, t := range desired.Tunnels {
+		desiredTunnels[t.ID] = true
+		old, ok := currentTunnels[t.ID]
+		switch {
+		case !ok:
+			diff.Changes = append(diff.Changes, configChange{Action: "add", Kind: "tunnel", ID: t.ID})
+		case !reflect.DeepEqual(old, t):
+			var fields []string
+			if old.AccountID != t.AccountID {
+				fields = append(fields, "account_id")
+			}
+			if old.Name != t.Name {
+				fields = append(fields, "name")
+			}
+			if old.Group != t.Group {
+				fields = append(fields, "group")
+			}
+			if old.BusinessHours != t.BusinessHours {
+				fields = append(fields, "business_hours")
+			}
+			if old.SLA != t.SLA {
+				fields = append(fields, "sla")
+			}
+			if old.Weight != t.Weight {
+				fields = append(fields, "weight")
+			}
+			if old.MaxStatus != t.MaxStatus {
+				fields = append(fields, "max_status")
+			}
+			if !maps.Equal(old.Links, t.Links) {
+				fields = append(fields, "links")
+			}
+			if !slices.Equal(old.Regions, t.Regions) {
+				fields = append(fields, "regions")
+			}
+			diff.Changes = append(diff.Changes, configChange{Action: "update", Kind: "tunnel", ID: t.ID, Fields: fields})
+		}
+	}
+	for _, t := range current.Tunnels {
+		if !desiredTunnels[t.ID] {
+			diff.Changes = append(diff.Changes, configChange{Action: "remove", Kind: "tunnel", ID: t.ID})
+			diff.removed.Tunnels = append(diff.removed.Tunnels, t)
+		}
+	}
+
+	currentProbes := map[string]ProbeConfig{}
+	for _, p := range current.Probes {
+		currentProbes[p.Name] = p
+	}
+	desiredProbes := map[string]bool{}
+	for _, p := range desired.Probes {
+		desiredProbes[p.Name] = true
+		old, ok := currentProbes[p.Name]
+		switch {
+		case !ok:
+			diff.Changes = append(diff.Changes, configChange{Action: "add", Kind: "probe", ID: p.Name})
+		case old != p:
+			var fields []string
+			if old.Kind != p.Kind {
+				fields = append(fields, "kind")
+			}
+			if old.URL != p.URL {
+				fields = append(fields, "url")
+			}
+			diff.Changes = append(diff.Changes, configChange{Action: "update", Kind: "probe", ID: p.Name, Fields: fields})
+		}
+	}
+	for _, p := range current.Probes {
+		if !desiredProbes[p.Name] {
+			diff.Changes = append(diff.Changes, configChange{Action: "remove", Kind: "probe", ID: p.Name})
+		}
+	}
+
+	currentNotifiers := map[string]NotifierConfig{}
+	for _, n := range current.Notifiers {
+		currentNotifiers[n.Name] = n
+	}
+	desiredNotifiers := map[string]bool{}
+	for _, n := range desired.Notifiers {
+		desiredNotifiers[n.Name] = true
+		old, ok := currentNotifiers[n.Name]
+		if !ok {
+			diff.Changes = append(diff.Changes, configChange{Action: "add", Kind: "notifier", ID: n.Name})
+			continue
+		}
+		var fields []string
+		if old.Type != n.Type {
+			fields = append(fields, "type")
+		}
+		keys := map[string]bool{}
+		for k := range old.Settings {
+			keys[k] = true
+		}
+		for k := range n.Settings {
+			keys[k] = true
+		}
+		for k := range keys {
+			if old.Settings[k] != n.Settings[k] {
+				fields = append(fields, "settings."+k)
+			}
+		}
+		if len(fields) > 0 {
+			sort.Strings(fields)
+			diff.Changes = append(diff.Changes, configChange{Action: "update", Kind: "notifier", ID: n.Name, Fields: fields})
+		}
+	}
+	for _, n := range current.Notifiers {
+		if !desiredNotifiers[n.Name] {
+			diff.Changes = append(diff.Changes, configChange{Action: "remove", Kind: "notifier", ID: n.Name})
+			diff.removed.Notifiers = append(diff.removed.Notifiers, n)
+		}
+	}
+
+	diff.Changed = len(diff.Changes) > 0
+	return diff
+}
+
+// unredact fills settings left as the redaction marker with the current
+// values of the same notifier.
+func unredact(desired Config, current Config) Config {
+	currentNotifiers := map[string]NotifierConfig{}
+	for _, n := range current.Notifiers {
+		currentNotifiers[n.Name] = n
+	}
+	out := desired
+	out.Notifiers = make([]NotifierConfig, len(desired.Notifiers))
+	for i, n := range desired.Notifiers {
+		settings := map[string]string{}
+		for k, v := range n.Settings {
+			if v == redacted {
+				v = currentNotifiers[n.Name].Settings[k]
+			}
+			settings[k] = v
+		}
+		n.Settings = settings
+		out.Notifiers[i] = n
+	}
+	return out
+}
+
+// redactedConfig returns cfg with secret settings replaced by the
+// redaction marker.
+func redactedConfig(cfg Config) Config {
+	out := cfg
+	out.Tunnels = append([]TunnelConfig{}, cfg.Tunnels...)
+	out.Notifiers = make([]NotifierConfig, len(cfg.Notifiers))
+	for i, n := range cfg.Notifiers {
+		settings := map[string]string{}
+		for k, v := range n.Settings {
+			settings[k] = v
+		}
+		for _, secret := range integrationTypes[n.Type].secrets {
+			if _, ok := settings[secret]; ok {
+				settings[secret] = redacted
+			}
+		}
+		n.Settings = settings
+		out.Notifiers[i] = n
+	}
+	return out
+}
+
+// applyConfig reconciles the running dashboard with desired and returns
+// what changed. Nothing is applied if desired is invalid. Only the
+// notifiers the diff names are rebuilt; the others keep running as they
+// are.
+func applyConfig(desired Config) (configDiff, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	desired = unredact(desired, currentConfig)
+	built, err := desired.validate()
+	if err != nil {
+		return configDiff{}, err
+	}
+	diff := diffConfigs(currentConfig, desired)
+	if !diff.Changed {
+		return diff, nil
+	}
+
+	changed := map[string]bool{}
+	for _, change := range diff.Changes {
+		if change.Kind == "notifier" {
+			changed[change.ID] = true
+		}
+	}
+	running := map[string]integration{}
+	var newNotifiers []Notifier
+	var newHooks []configuredHook
+	var newSummaries []summarySender
+	for i, in := range built {
+		name := desired.Notifiers[i].Name
+		if kept, ok := runningIntegrations[name]; ok && !changed[name] {
+			in = kept
+		} else if in.notifier != nil {
+			in.notifier = withTemplates(name, in.notifier)
+		}
+		running[name] = in
+		if in.notifier != nil {
+			newNotifiers = append(newNotifiers, in.notifier)
+		}
+		if in.hook != nil {
+			newHooks = append(newHooks, configuredHook{name: name, incidentHook: in.hook})
+		}
+		if in.summary != nil {
+			newSummaries = append(newSummaries, in.summary)
+		}
+	}
+	notifiersMu.Lock()
+	notifiers = newNotifiers
+	incidentHooks = newHooks
+	summarySenders = newSummaries
+	notifiersMu.Unlock()
+	runningIntegrations = running
+
+	currentConfig = desired
+	syncTunnels()
+	syncProbes(desired.Probes)
+
+	for _, change := range diff.Changes {
+		slog.Info("Config applied", "action", change.Action, "kind", change.Kind, "id", change.ID)
+	}
+	return diff, nil
+}
+
+// configPreview is what applying a config would do: the diff, or, when the
+// config is invalid, every section that would be rejected.
+type configPreview struct {
+	configDiff
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// previewConfig reports what applyConfig would change for desired without
+// applying anything. The diff is shown even for an invalid config.
+func previewConfig(desired Config) configPreview {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	desired = unredact(desired, currentConfig)
+	preview := configPreview{configDiff: diffConfigs(currentConfig, desired), Valid: true}
+	if _, err := desired.validate(); err != nil {
+		preview.Valid = false
+		var errs configErrors
+		if errors.As(err, &errs) {
+			preview.Errors = errs
+		} else {
+			preview.Errors = []string{err.Error()}
+		}
+	}
+	return preview
+}
+
+// setDiscoveredTunnels replaces the tunnels found by one discovery source
+// and logs what changed.
+func setDiscoveredTunnels(source string, list []TunnelConfig) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	diff := diffConfigs(Config{Tunnels: discoveredTunnels[source]}, Config{Tunnels: list})
+	if !diff.Changed {
+		return
+	}
+	discoveredTunnels[source] = list
+	syncTunnels()
+	for _, change := range diff.Changes {
+		slog.Info("Discovered tunnel change", "source", source, "action", change.Action, "tunnel_id", change.ID)
+	}
+}
+
+// syncTunnels updates the registry with the configured tunnels followed by
+// discovered ones. A tunnel that is both configured and discovered keeps
+// its configured settings. Callers hold configMu.
+func syncTunnels() {
+	seen := map[string]bool{}
+	var all []TunnelConfig
+	add := func(list []TunnelConfig) {
+		for _, t := range list {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				all = append(all, t)
+			}
+		}
+	}
+	add(currentConfig.Tunnels)
+	sources := make([]string, 0, len(discoveredTunnels))
+	for source := range discoveredTunnels {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		add(discoveredTunnels[source])
+	}
+	setTunnels(all)
+}
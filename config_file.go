@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the layout of CONFIG_FILE. Settings are environment
+// variables by name, e.g. POLL_INTERVAL: 30s, for everything outside the
+// config; tunnels, probes and notifiers take the same fields as the config
+// API, and privacy sets what is masked from the public.
+type configFile struct {
+	Settings  map[string]any   `json:"settings"`
+	Tunnels   []TunnelConfig   `json:"tunnels"`
+	Probes    []ProbeConfig    `json:"probes"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+	Privacy   privacyPolicy    `json:"privacy"`
+}
+
+var (
+	// fileConfig holds the tunnels, probes and notifiers from CONFIG_FILE,
+	// which configFromEnv merges with those from the environment.
+	fileConfig Config
+	// settingName is the form of an environment variable name.
+	settingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+// loadConfigFile reads CONFIG_FILE, a YAML (.yaml, .yml), TOML (.toml) or
+// JSON (.json) file of settings, tunnels, probes, notifiers and the privacy
+// policy. Environment variables take precedence: a setting already in the
+// environment is left alone, TUNNEL_ID replaces the file's tunnels and
+// EXTERNAL_STATUS_PAGES its probes, and a notifier from the environment replaces the file's notifier of the same
+// name.
+func loadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := map[string]string{}
+	for name, value := range file.Settings {
+		key := strings.ToUpper(name)
+		if !settingName.MatchString(key) || key == "CONFIG_FILE" {
+			return fmt.Errorf("%s: settings: %q is not an environment variable name", path, name)
+		}
+		text, err := settingText(value)
+		if err != nil {
+			return fmt.Errorf("%s: settings.%s: %w", path, name, err)
+		}
+		settings[key] = text
+	}
+	for key, value := range settings {
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+	for i := range file.Tunnels {
+		if file.Tunnels[i].AccountID == "" {
+			file.Tunnels[i].AccountID = os.Getenv("ACCOUNT_ID")
+		}
+	}
+	fileConfig = Config{Tunnels: file.Tunnels, Probes: file.Probes, Notifiers: file.Notifiers}
+	privacy = file.Privacy
+	return nil
+}
+
+// readConfigFile parses the file by its extension. Every format is decoded
+// through JSON, so the field names and checks match the config API and an
+// unknown or misspelled field is an error rather than ignored.
+func readConfigFile(path string) (configFile, error) {
+	var file configFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, err
+	}
+	var tree any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		var table map[string]any
+		_, err = toml.Decode(string(data), &table)
+		tree = table
+	case ".json":
+		err = json.Unmarshal(data, &tree)
+	default:
+		return file, fmt.Errorf("unknown format %q (available: .yaml, .yml, .toml, .json)", ext)
+	}
+	if err != nil {
+		return file, err
+	}
+	if tree == nil {
+		return file, nil
+	}
+	normalized, err := json.Marshal(tree)
+	if err != nil {
+		return file, fmt.Errorf("keys must be text: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return file, schemaError(err)
+	}
+	return file, nil
+}
+
+// schemaError rewrites a JSON decoding error in terms of the file's
+// fields, e.g. "tunnels.0.weight: expected a number, got string".
+func schemaError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%s: expected %s, got %s", typeErr.Field, schemaType(typeErr.Type), typeErr.Value)
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return fmt.Errorf("unknown field %s", field)
+	}
+	return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+}
+
+func schemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "text (quote numbers and true/false)"
+	case reflect.Float64, reflect.Int:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "a mapping"
+	}
+	return t.String()
+}
+
+// settingText renders a setting as an environment variable value. Lists
+// become the comma-separated form the variables use.
+func settingText(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, err := settingText(item)
+			if err != nil || strings.Contains(text, ",") {
+				return "", fmt.Errorf("list items must be text, numbers or true/false without commas")
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("expected text, a number, true/false or a list")
+}
+
+// mergeNotifiers combines the file's notifiers with those from the
+// environment, which replace file notifiers of the same name.
+func mergeNotifiers(file, env []NotifierConfig) []NotifierConfig {
+	fromEnv := map[string]bool{}
+	for _, n := range env {
+		fromEnv[n.Name] = true
+	}
+	var merged []NotifierConfig
+	for _, n := range file {
+		if !fromEnv[n.Name] {
+			n.Settings = maps.Clone(n.Settings)
+			merged = append(merged, n)
+		}
+	}
+	return append(merged, env...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type configPageData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Config         string
+	// Previewed is set after Preview; Applied after Apply.
+	Previewed bool
+	Applied   bool
+	Changes   []string
+	Errors    []string
+	Error     string
+}
+
+var configPageTemplate = template.Must(template.New("config").Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="referrer" content="no-referrer">
+	<title>Configuration</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Configuration</h1>
+			<p>Edit the running config and preview the changes before applying them. Secrets are shown as (redacted) and kept unless replaced. Removed tunnels and notifiers can be <a href="/admin/deleted">restored</a>.</p>
+		</header>
+		{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+		{{if or .Previewed .Applied}}
+		<section aria-labelledby="config-result">
+			<h2 id="config-result">{{if .Applied}}Applied{{else}}Preview{{end}}</h2>
+			{{range .Errors}}<p role="alert">Invalid: {{.}}</p>{{end}}
+			{{if .Changes}}<ul>{{range .Changes}}<li><code>{{.}}</code></li>{{end}}</ul>{{else}}<p>No changes.</p>{{end}}
+		</section>
+		{{end}}
+		<form method="post">
+			<p><label for="config">Config (JSON)</label></p>
+			<p><textarea id="config" name="config" rows="30" cols="100" spellcheck="false">{{.Config}}</textarea></p>
+			<button type="submit" name="action" value="preview">Preview</button>
+			<button type="submit" name="action" value="apply">Apply</button>
+		</form>
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// adminConfigHandler serves /admin/config, where operators edit the config,
+// preview what it would change and apply it.
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	high := highContrast(w, r)
+	data := configPageData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+	}
+
+	if r.Method == http.MethodPost {
+		data.Config = r.FormValue("config")
+		var desired Config
+		decoder := json.NewDecoder(strings.NewReader(data.Config))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&desired); err != nil {
+			data.Error = "Invalid config: " + err.Error()
+		} else if r.FormValue("action") == "apply" {
+			diff, err := applyConfig(desired)
+			if err != nil {
+				data.Error = err.Error()
+			} else {
+				keepDeleted(diff.removed, adminUser(r))
+				data.Applied, data.Changes = true, diff.lines()
+				data.Config = ""
+			}
+		} else {
+			preview := previewConfig(desired)
+			data.Previewed, data.Changes, data.Errors = true, preview.lines(), preview.Errors
+		}
+	}
+	if data.Config == "" {
+		configMu.Lock()
+		cfg := redactedConfig(currentConfig)
+		configMu.Unlock()
+		encoded, _ := json.MarshalIndent(cfg, "", "  ")
+		data.Config = string(encoded)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := configPageTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering config page", "error", err)
+	}
+}
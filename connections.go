@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// droppedConnectionsKept is how many dropped connections are remembered
+// per tunnel.
+const droppedConnectionsKept = 10
+
+// tunnelConnection is one connection between a connector and the
+// Cloudflare edge.
+type tunnelConnection struct {
+	ID               string    `json:"id"`
+	ConnectorID      string    `json:"connector_id"`
+	Version          string    `json:"version"`
+	Arch             string    `json:"arch"`
+	Colo             string    `json:"colo"`
+	OriginIP         string    `json:"origin_ip,omitempty"`
+	OpenedAt         time.Time `json:"opened_at"`
+	PendingReconnect bool      `json:"pending_reconnect"`
+	// DroppedAt is when the connection was first missing from a poll.
+	DroppedAt *time.Time `json:"dropped_at,omitempty"`
+}
+
+// connectionsResponse is GET .../cfd_tunnel/{id}/connections: the
+// connectors, each with its connections to the edge.
+type connectionsResponse struct {
+	Success bool `json:"success"`
+	Result  []struct {
+		ID      string `json:"id"`
+		Version string `json:"version"`
+		Arch    string `json:"arch"`
+		Conns   []struct {
+			ID                 string    `json:"id"`
+			ColoName           string    `json:"colo_name"`
+			OriginIP           string    `json:"origin_ip"`
+			OpenedAt           time.Time `json:"opened_at"`
+			IsPendingReconnect bool      `json:"is_pending_reconnect"`
+		} `json:"conns"`
+	} `json:"result"`
+}
+
+// tunnelConnectionSet is what the connections endpoint last reported for a
+// tunnel, and the connections that have gone since.
+type tunnelConnectionSet struct {
+	PolledAt time.Time
+	Active   []tunnelConnection
+	Dropped  []tunnelConnection
+}
+
+var (
+	tunnelConnections   = map[string]*tunnelConnectionSet{}
+	tunnelConnectionsMu sync.Mutex
+)
+
+func fetchConnections(ctx context.Context, t tunnelState) ([]tunnelConnection, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.url()+"/connections", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiBackground)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugAPIResponse(req, resp, body)
+	var parsed connectionsResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing API response: %w", err)
+	}
+	if !parsed.Success {
+		return nil, fmt.Errorf("API response indicates failure: %s", string(body))
+	}
+	var conns []tunnelConnection
+	for _, connector := range parsed.Result {
+		for _, c := range connector.Conns {
+			conns = append(conns, tunnelConnection{
+				ID:               c.ID,
+				ConnectorID:      connector.ID,
+				Version:          connector.Version,
+				Arch:             connector.Arch,
+				Colo:             c.ColoName,
+				OriginIP:         c.OriginIP,
+				OpenedAt:         c.OpenedAt,
+				PendingReconnect: c.IsPendingReconnect,
+			})
+		}
+	}
+	slices.SortFunc(conns, func(a, b tunnelConnection) int {
+		if c := strings.Compare(a.ConnectorID, b.ConnectorID); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Colo, b.Colo)
+	})
+	return conns, nil
+}
+
+// pollConnections records the tunnel's active connections, remembering
+// and logging the ones that dropped since the last poll. It is skipped
+// when the API budget defers it.
+func pollConnections(t tunnelState) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conns, err := fetchConnections(ctx, t)
+	if errors.Is(err, errAPIBudget) {
+		return
+	}
+	if err != nil {
+		slog.Error("Error fetching connections", "tunnel_id", t.ID, "error", err)
+		return
+	}
+	recordConnections(t, conns, time.Now())
+}
+
+func recordConnections(t tunnelState, conns []tunnelConnection, now time.Time) {
+	tunnelConnectionsMu.Lock()
+	defer tunnelConnectionsMu.Unlock()
+	set := tunnelConnections[t.ID]
+	if set == nil {
+		set = &tunnelConnectionSet{}
+		tunnelConnections[t.ID] = set
+	}
+	for _, previous := range set.Active {
+		if slices.ContainsFunc(conns, func(c tunnelConnection) bool { return c.ID == previous.ID }) {
+			continue
+		}
+		slog.Warn("Tunnel lost a connection", "tunnel_id", t.ID, "connection_id", previous.ID, "connector_id", previous.ConnectorID, "colo", previous.Colo)
+		previous.DroppedAt = &now
+		set.Dropped = append(set.Dropped, previous)
+	}
+	if len(set.Dropped) > droppedConnectionsKept {
+		set.Dropped = set.Dropped[len(set.Dropped)-droppedConnectionsKept:]
+	}
+	set.Active = conns
+	set.PolledAt = now
+}
+
+// connectionsOf returns a copy of what is known about the tunnel's
+// connections, dropped ones newest first. Origin IPs are left out unless
+// withOrigin is set, and colos unless withColo is.
+func connectionsOf(id string, withOrigin, withColo bool) tunnelConnectionSet {
+	tunnelConnectionsMu.Lock()
+	defer tunnelConnectionsMu.Unlock()
+	set := tunnelConnections[id]
+	if set == nil {
+		return tunnelConnectionSet{}
+	}
+	out := tunnelConnectionSet{
+		PolledAt: set.PolledAt,
+		Active:   slices.Clone(set.Active),
+		Dropped:  slices.Clone(set.Dropped),
+	}
+	slices.Reverse(out.Dropped)
+	for _, list := range [][]tunnelConnection{out.Active, out.Dropped} {
+		for i := range list {
+			if !withOrigin {
+				list[i].OriginIP = ""
+			}
+			if !withColo {
+				list[i].Colo = ""
+			}
+		}
+	}
+	return out
+}
+
+// connectionsHandler serves GET /api/tunnels/{id}/connections, the
+// tunnel's active and recently dropped connections. Origin IPs are only
+// included for admins, and colos unless the privacy policy masks them.
+func connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := findTunnel(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", r.PathValue("id")))
+		return
+	}
+	set := connectionsOf(t.ID, adminAuthorized(r), showColos(r))
+	response := struct {
+		PolledAt *time.Time         `json:"polled_at,omitempty"`
+		Active   []tunnelConnection `json:"active"`
+		Dropped  []tunnelConnection `json:"dropped"`
+	}{Active: set.Active, Dropped: set.Dropped}
+	if !set.PolledAt.IsZero() {
+		response.PolledAt = &set.PolledAt
+	}
+	if response.Active == nil {
+		response.Active = []tunnelConnection{}
+	}
+	if response.Dropped == nil {
+		response.Dropped = []tunnelConnection{}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
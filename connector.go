@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+type tokenResponse struct {
+	Success bool   `json:"success"`
+	Result  string `json:"result"`
+}
+
+type connectorCommand struct {
+	Label   string
+	Command string
+}
+
+type connectorData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Tunnel         tunnelState
+	Label          string
+	Revealed       bool
+	Error          string
+	Token          string
+	QRCode         template.URL
+	Commands       []connectorCommand
+	SnoozedUntil   string
+	SnoozePresets  []string
+}
+
+var connectorTemplate = template.Must(template.New("connector").Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="referrer" content="no-referrer">
+	<title>Add a connector - {{.Label}}</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Add a connector to {{.Label}}</h1>
+			<p><code>{{.Tunnel.ID}}</code> &middot; <a href="/tunnels/{{.Tunnel.ID}}">Tunnel page</a></p>
+		</header>
+		<section aria-labelledby="install-heading">
+			<h2 id="install-heading">Install command</h2>
+			{{if .Error}}<p role="alert">Could not fetch the tunnel token: {{.Error}}</p>{{end}}
+			{{range .Commands}}
+			<h3>{{.Label}}</h3>
+			<pre class="connector-command"><code>{{.Command}}</code></pre>
+			{{end}}
+			{{if .Revealed}}
+			<h3>Token</h3>
+			<pre class="connector-command"><code>{{.Token}}</code></pre>
+			<p><img class="connector-qr" src="{{.QRCode}}" alt="QR code of the tunnel token" width="256" height="256"></p>
+			<p><a href="">Hide token</a></p>
+			{{else}}
+			<form method="post">
+				<button type="submit">Reveal token</button>
+			</form>
+			<p>The token is fetched from Cloudflare when revealed and lets anyone run a connector for this tunnel.</p>
+			{{end}}
+		</section>
+		<section aria-labelledby="snooze-heading">
+			<h2 id="snooze-heading">Alerts</h2>
+			{{if .SnoozedUntil}}
+			<p>Alerts are snoozed until {{.SnoozedUntil}}.</p>
+			<form method="post" action="/admin/tunnels/{{.Tunnel.ID}}/snooze">
+				<button type="submit" name="action" value="unsnooze">Turn alerts back on</button>
+			</form>
+			{{else}}
+			<p>Alerts are on. Snoozing mutes them for a while; the tunnel stays on the status page, marked as snoozed.</p>
+			{{end}}
+			<form method="post" action="/admin/tunnels/{{.Tunnel.ID}}/snooze">
+				<p><label>Reason <input name="reason"></label></p>
+				<p>{{range .SnoozePresets}}<button type="submit" name="duration" value="{{.}}">Snooze {{.}}</button> {{end}}</p>
+			</form>
+			<form method="post" action="/admin/tunnels/{{.Tunnel.ID}}/snooze">
+				<p><label>Custom duration <input name="duration" placeholder="2h30m" required></label> <button type="submit">Snooze</button></p>
+			</form>
+		</section>
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// fetchTunnelToken retrieves the token a connector needs to run the
+// tunnel.
+func fetchTunnelToken(ctx context.Context, t tunnelState) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.url()+"/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiCritical)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("parsing API response: %w", err)
+	}
+	if !token.Success || token.Result == "" {
+		return "", fmt.Errorf("API response indicates failure (HTTP %d)", resp.StatusCode)
+	}
+	return token.Result, nil
+}
+
+// connectorCommands are the ways to start a connector with token.
+func connectorCommands(token string) []connectorCommand {
+	return []connectorCommand{
+		{Label: "Linux, macOS or Windows service", Command: "cloudflared service install " + token},
+		{Label: "Docker", Command: "docker run -d --restart unless-stopped cloudflare/cloudflared:latest tunnel --no-autoupdate run --token " + token},
+	}
+}
+
+// adminTunnelHandler serves /admin/tunnels/{id}, which shows how to start
+// another connector for the tunnel and snoozes its alerts. The token is masked until requested
+// with a POST, and is fetched from the API each time rather than stored.
+func adminTunnelHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := findTunnel(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	high := highContrast(w, r)
+	data := connectorData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Tunnel:         t,
+		Label:          t.label(),
+		Commands:       connectorCommands("<token>"),
+		SnoozePresets:  snoozePresets,
+	}
+	if until := snoozedUntil(t.ID, time.Now()); !until.IsZero() {
+		data.SnoozedUntil = until.UTC().Format("2006-01-02 15:04 MST")
+	}
+
+	if r.Method == http.MethodPost {
+		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		defer cancel()
+		token, err := fetchTunnelToken(ctx, t)
+		if err == nil {
+			var png []byte
+			png, err = qrcode.Encode(token, qrcode.Medium, 256)
+			if err == nil {
+				data.Revealed = true
+				data.Token = token
+				data.Commands = connectorCommands(token)
+				data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+				slog.Info("Admin revealed connector token", "tunnel_id", t.ID, "remote_addr", r.RemoteAddr)
+			}
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := connectorTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering connector page", "error", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLinkTokenTTL = time.Hour
+
+var (
+	// publicURL is the status page's base URL as viewers reach it, used
+	// for links in alerts.
+	publicURL string
+	// linkSigningKey, when set, signs a short-lived token into alert links
+	// that lets them through the public access rules.
+	linkSigningKey []byte
+	linkTokenTTL   = defaultLinkTokenTTL
+)
+
+// loadDeepLinks reads PUBLIC_URL, the status page's external base URL,
+// e.g. https://status.example.com; LINK_SIGNING_KEY, at least 16
+// characters, which adds a signed token to alert links so on-call can open
+// them from outside PUBLIC_ALLOW_CIDRS; and LINK_TOKEN_TTL, how long such
+// a link works (default 1h, at most 7 days).
+func loadDeepLinks() error {
+	if value := os.Getenv("PUBLIC_URL"); value != "" {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("PUBLIC_URL: %q is not an http or https URL", value)
+		}
+		publicURL = strings.TrimRight(value, "/")
+	}
+	if key := os.Getenv("LINK_SIGNING_KEY"); key != "" {
+		if len(key) < 16 {
+			return fmt.Errorf("LINK_SIGNING_KEY must be at least 16 characters")
+		}
+		if publicURL == "" {
+			return fmt.Errorf("LINK_SIGNING_KEY needs PUBLIC_URL")
+		}
+		linkSigningKey = []byte(key)
+	}
+	if value := os.Getenv("LINK_TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < time.Minute || ttl > 7*24*time.Hour {
+			return fmt.Errorf("LINK_TOKEN_TTL: invalid duration %q (1m to 168h)", value)
+		}
+		linkTokenTTL = ttl
+	}
+	return nil
+}
+
+// addTunnelContext adds what on-call needs to an event about a tunnel: a
+// link to the tunnel's page at the time of the event and the tunnel's
+// quick links, in Links and after the message.
+func addTunnelContext(event Event) Event {
+	t, ok := findTunnel(event.TunnelID)
+	if !ok {
+		return event
+	}
+	if publicURL != "" && event.URL == "" {
+		event.URL = eventLink(event, time.Now())
+		event.Message += "\n\nDetails: " + event.URL
+	}
+	if len(t.Links) > 0 && event.Links == nil {
+		event.Links = t.Links
+		event.Message += "\n"
+		for _, name := range slices.Sorted(maps.Keys(t.Links)) {
+			event.Message += fmt.Sprintf("\n%s: %s", name, t.Links[name])
+		}
+	}
+	return event
+}
+
+// eventLink is the tunnel page showing the status at the event's time,
+// signed when LINK_SIGNING_KEY is set.
+func eventLink(event Event, now time.Time) string {
+	path := tunnelPath(event.TunnelID)
+	query := url.Values{"event": {event.Type}, "at": {event.Time.UTC().Format(time.RFC3339)}}
+	if linkSigningKey != nil {
+		expires := now.Add(linkTokenTTL).Unix()
+		query.Set("token", strconv.FormatInt(expires, 10)+"."+linkSignature(path, expires))
+	}
+	return publicURL + path + "?" + query.Encode()
+}
+
+func linkSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, linkSigningKey)
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// linkTokenAllows reports whether r may bypass the public access rules:
+// it carries an unexpired token signed for its path, or it is for the
+// stylesheet such a page loads.
+func linkTokenAllows(r *http.Request) bool {
+	if linkSigningKey == nil {
+		return false
+	}
+	if r.URL.Path == "/theme.css" {
+		return true
+	}
+	expiresValue, signature, ok := strings.Cut(r.URL.Query().Get("token"), ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresValue, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(linkSignature(r.URL.EscapedPath(), expires)))
+}
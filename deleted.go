@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultDeletedRetention = 30 * 24 * time.Hour
+
+// deletedObject is a tunnel or notifier removed from the config, kept until
+// Expires so it can be restored. Exactly one of Tunnel and Notifier is set.
+type deletedObject struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Name      string          `json:"name"`
+	DeletedAt time.Time       `json:"deleted_at"`
+	DeletedBy string          `json:"deleted_by,omitempty"`
+	Expires   time.Time       `json:"expires"`
+	Tunnel    *TunnelConfig   `json:"tunnel,omitempty"`
+	Notifier  *NotifierConfig `json:"notifier,omitempty"`
+}
+
+var (
+	deletedRetention = defaultDeletedRetention
+	deletedFile      string
+	deletedObjects   []deletedObject
+	deletedMu        sync.Mutex
+)
+
+// loadDeleted reads DELETED_RETENTION, how long removed tunnels and
+// notifiers can be restored (default 720h), and DELETED_FILE, where they
+// are kept between restarts. Without it they are kept in memory only.
+func loadDeleted() error {
+	if value := os.Getenv("DELETED_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention < time.Hour {
+			return fmt.Errorf("DELETED_RETENTION: invalid duration %q (at least 1h)", value)
+		}
+		deletedRetention = retention
+	}
+	deletedFile = os.Getenv("DELETED_FILE")
+	if deletedFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(deletedFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &deletedObjects)
+}
+
+// saveDeleted writes the deleted objects to DELETED_FILE. Callers hold
+// deletedMu.
+func saveDeleted() {
+	if deletedFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(deletedObjects, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding deleted objects", "error", err)
+		monitorFailure("deleted objects file", err)
+		return
+	}
+	tmp := deletedFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		slog.Error("Error writing deleted objects file", "error", err)
+		monitorFailure("deleted objects file", err)
+		return
+	}
+	err = os.Rename(tmp, deletedFile)
+	if err != nil {
+		slog.Error("Error writing deleted objects file", "error", err)
+	}
+	monitorResult("deleted objects file", err)
+}
+
+// pruneDeleted drops the objects past their retention. Callers hold
+// deletedMu.
+func pruneDeleted(now time.Time) {
+	deletedObjects = slices.DeleteFunc(deletedObjects, func(d deletedObject) bool { return !now.Before(d.Expires) })
+}
+
+// keepDeleted moves what a config change removed into the deleted objects.
+func keepDeleted(removed Config, actor string) {
+	if len(removed.Tunnels) == 0 && len(removed.Notifiers) == 0 {
+		return
+	}
+	now := time.Now()
+	deletedMu.Lock()
+	pruneDeleted(now)
+	for _, t := range removed.Tunnels {
+		deletedObjects = append(deletedObjects, deletedObject{ID: newEventID(), Kind: "tunnel", Name: t.ID,
+			DeletedAt: now, DeletedBy: actor, Expires: now.Add(deletedRetention), Tunnel: &t})
+	}
+	for _, n := range removed.Notifiers {
+		deletedObjects = append(deletedObjects, deletedObject{ID: newEventID(), Kind: "notifier", Name: n.Name,
+			DeletedAt: now, DeletedBy: actor, Expires: now.Add(deletedRetention), Notifier: &n})
+	}
+	saveDeleted()
+	deletedMu.Unlock()
+
+	for _, t := range removed.Tunnels {
+		recordAdminAction(t.ID, actor, fmt.Sprintf("deleted the tunnel (restorable until %s)", now.Add(deletedRetention).UTC().Format(time.RFC3339)))
+	}
+	for _, n := range removed.Notifiers {
+		slog.Info("Admin deleted notifier", "user", actor, "notifier", n.Name, "restorable_until", now.Add(deletedRetention).UTC())
+	}
+}
+
+// listDeleted returns the restorable objects, newest first, with notifier
+// secrets redacted.
+func listDeleted(now time.Time) []deletedObject {
+	deletedMu.Lock()
+	defer deletedMu.Unlock()
+	pruneDeleted(now)
+	list := []deletedObject{}
+	for _, d := range slices.Backward(deletedObjects) {
+		if d.Notifier != nil {
+			n := redactedConfig(Config{Notifiers: []NotifierConfig{*d.Notifier}}).Notifiers[0]
+			d.Notifier = &n
+		}
+		list = append(list, d)
+	}
+	return list
+}
+
+// errDeletedConflict is returned when restoring an object whose ID or name
+// has been reused since it was deleted.
+var errDeletedConflict = errors.New("already in the config")
+
+// restoreDeleted adds the deleted object back to the config. found is false
+// when there is no such object.
+func restoreDeleted(id, actor string) (diff configDiff, found bool, err error) {
+	deletedMu.Lock()
+	pruneDeleted(time.Now())
+	i := slices.IndexFunc(deletedObjects, func(d deletedObject) bool { return d.ID == id })
+	if i < 0 {
+		deletedMu.Unlock()
+		return configDiff{}, false, nil
+	}
+	d := deletedObjects[i]
+	deletedMu.Unlock()
+
+	configMu.Lock()
+	desired := Config{
+		Tunnels:   slices.Clone(currentConfig.Tunnels),
+		Notifiers: slices.Clone(currentConfig.Notifiers),
+	}
+	configMu.Unlock()
+	switch {
+	case d.Tunnel != nil:
+		if slices.ContainsFunc(desired.Tunnels, func(t TunnelConfig) bool { return t.ID == d.Tunnel.ID }) {
+			return configDiff{}, true, fmt.Errorf("tunnel %s: %w", d.Name, errDeletedConflict)
+		}
+		desired.Tunnels = append(desired.Tunnels, *d.Tunnel)
+	case d.Notifier != nil:
+		if slices.ContainsFunc(desired.Notifiers, func(n NotifierConfig) bool { return n.Name == d.Notifier.Name }) {
+			return configDiff{}, true, fmt.Errorf("notifier %s: %w", d.Name, errDeletedConflict)
+		}
+		desired.Notifiers = append(desired.Notifiers, *d.Notifier)
+	}
+	diff, err = applyConfig(desired)
+	if err != nil {
+		return configDiff{}, true, err
+	}
+
+	deletedMu.Lock()
+	deletedObjects = slices.DeleteFunc(deletedObjects, func(o deletedObject) bool { return o.ID == id })
+	saveDeleted()
+	deletedMu.Unlock()
+	if d.Tunnel != nil {
+		recordAdminAction(d.Name, actor, "restored the deleted tunnel")
+	} else {
+		slog.Info("Admin restored deleted notifier", "user", actor, "notifier", d.Name)
+	}
+	return diff, true, nil
+}
+
+// deletedHandler serves GET /admin/api/deleted, the tunnels and notifiers
+// that can be restored.
+func deletedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, listDeleted(time.Now()))
+}
+
+// restoreDeletedHandler serves POST /admin/api/deleted/{id}/restore,
+// putting the object back into the config and returning the diff.
+func restoreDeletedHandler(w http.ResponseWriter, r *http.Request) {
+	diff, found, err := restoreDeleted(r.PathValue("id"), adminUser(r))
+	switch {
+	case !found:
+		writeProblem(w, r, problemUnknownDeleted, fmt.Sprintf("no deleted object %q; it may have expired", r.PathValue("id")))
+	case errors.Is(err, errDeletedConflict):
+		writeProblem(w, r, problemConflict, err.Error())
+	case err != nil:
+		writeProblem(w, r, problemValidation, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, diff)
+	}
+}
+
+type deletedPageData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Objects        []deletedObject
+	Error          string
+}
+
+var deletedTemplate = template.Must(template.New("deleted").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="referrer" content="no-referrer">
+	<title>Deleted objects</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Deleted objects</h1>
+			<p>Tunnels and notifiers removed from the config can be restored until they expire.</p>
+		</header>
+		{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+		{{range .Objects}}
+		<section aria-labelledby="deleted-{{.ID}}">
+			<h2 id="deleted-{{.ID}}">{{if eq .Kind "tunnel"}}Tunnel {{if .Tunnel.Name}}{{.Tunnel.Name}} ({{.Name}}){{else}}{{.Name}}{{end}}{{else}}Notifier {{.Name}} ({{.Notifier.Type}}){{end}}</h2>
+			<p>Deleted {{datetime .DeletedAt}}{{if .DeletedBy}} by {{.DeletedBy}}{{end}} &middot; restorable until {{datetime .Expires}}</p>
+			<form method="post">
+				<input type="hidden" name="id" value="{{.ID}}">
+				<button type="submit">Restore</button>
+			</form>
+		</section>
+		{{else}}
+		<p>Nothing has been deleted.</p>
+		{{end}}
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// adminDeletedHandler serves /admin/deleted, where operators restore
+// deleted tunnels and notifiers.
+func adminDeletedHandler(w http.ResponseWriter, r *http.Request) {
+	high := highContrast(w, r)
+	data := deletedPageData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+	}
+	if r.Method == http.MethodPost {
+		_, found, err := restoreDeleted(r.FormValue("id"), adminUser(r))
+		if !found {
+			err = fmt.Errorf("unknown deleted object; it may have expired")
+		}
+		if err == nil {
+			http.Redirect(w, r, r.URL.String(), http.StatusSeeOther)
+			return
+		}
+		data.Error = err.Error()
+	}
+	data.Objects = listDeleted(time.Now())
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := deletedTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering deleted objects page", "error", err)
+	}
+}
+
+func snapshotDeleted() ([]byte, error) {
+	deletedMu.Lock()
+	defer deletedMu.Unlock()
+	return json.Marshal(deletedObjects)
+}
+
+func restoreDeletedObjects(data []byte) error {
+	deletedMu.Lock()
+	defer deletedMu.Unlock()
+	if len(deletedObjects) > 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &deletedObjects); err != nil {
+		return err
+	}
+	if len(deletedObjects) > 0 {
+		slog.Info("State restored deleted objects", "count", len(deletedObjects), "backend", stateBackend.Name())
+		saveDeleted()
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type detailData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Tunnel         tunnelState
+	ID             string
+	Label          string
+	Status         string
+	StatusClass    string
+	ActiveLabel    string
+	Elapsed        string
+	Since          time.Time
+	// StatusSince is when the current status began; zero if unknown.
+	StatusSince time.Time
+	InStatus    string
+	Connections tunnelConnectionSet
+	// ShowOrigin is whether origin IPs are shown; only to admins, and
+	// ShowColos whether edge locations are, as the privacy policy allows.
+	ShowOrigin     bool
+	ShowColos      bool
+	Connectors     []connectorMetrics
+	HasConnectors  bool
+	Logs           []connectorLogLine
+	HasLogs        bool
+	AdminEnabled   bool
+	ConfigVersions []tunnelConfigVersion
+	AuditEnabled   bool
+	Audit          []auditEntry
+	AuditDays      int
+	// Alert is set when the page is opened from an alert link with
+	// ?event= and ?at=.
+	Alert *alertContext
+}
+
+// alertContext is the tunnel's status at the time of an alert.
+type alertContext struct {
+	Event    string
+	At       time.Time
+	Status   string
+	Interval *statusInterval
+}
+
+var detailTemplate = template.Must(template.New("detail").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 MST")
+	},
+	"rate": func(v float64) string { return fmt.Sprintf("%.2f/s", v) },
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Label}} - Tunnel Status</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>{{.Label}}</h1>
+			<p><code>{{.ID}}</code> &middot; <a href="/">Back to status page</a>{{if .AdminEnabled}} &middot; <a href="/admin/tunnels/{{.ID}}">Add a connector</a>{{end}}</p>
+			{{if .Tunnel.Links}}<nav class="tunnel-links" aria-label="Quick links">{{range $name, $link := .Tunnel.Links}}<a href="{{$link}}">{{$name}}</a> {{end}}</nav>{{end}}
+		</header>
+
+		{{with .Alert}}
+		<section aria-labelledby="alert-heading">
+			<h2 id="alert-heading">Alert context</h2>
+			<p>{{.Event}} alert at {{datetime .At}}: the tunnel was {{.Status}}{{with .Interval}} from {{datetime .Start}} to {{datetime .End}}{{end}}.</p>
+		</section>
+		{{end}}
+
+		<section aria-labelledby="status-heading">
+			<h2 id="status-heading">Status</h2>
+			<p><span class="status-pill {{.StatusClass}}" role="status">{{.Status}}</span></p>
+			<p>{{.ActiveLabel}}: {{.Elapsed}}{{if not .Since.IsZero}} (since {{datetime .Since}}){{end}}</p>
+			{{if not .StatusSince.IsZero}}<p>{{.Status}} for {{.InStatus}} (since {{datetime .StatusSince}})</p>{{end}}
+			<p>Active connections reported by Cloudflare: {{.Tunnel.Connections}}</p>
+			<p>Last polled: {{datetime .Tunnel.LastPollAt}}</p>
+		</section>
+
+		{{if not .Connections.PolledAt.IsZero}}
+		<section aria-labelledby="connections-heading">
+			<h2 id="connections-heading">Connections</h2>
+			<p>Each connection from a connector to the Cloudflare edge, as of {{datetime .Connections.PolledAt}}. <a href="/api/tunnels/{{.ID}}/connections">JSON</a></p>
+			{{if .Connections.Active}}
+			<table>
+				<thead><tr>
+					<th scope="col">Connector</th><th scope="col">Version</th>{{if .ShowColos}}<th scope="col">Edge location</th>{{end}}
+					{{if .ShowOrigin}}<th scope="col">Origin IP</th>{{end}}<th scope="col">Opened</th><th scope="col">State</th>
+				</tr></thead>
+				<tbody>
+				{{range .Connections.Active}}<tr>
+					<td><code>{{.ConnectorID}}</code></td>
+					<td>{{.Version}}{{if .Arch}} ({{.Arch}}){{end}}</td>
+					{{if $.ShowColos}}<td>{{.Colo}}</td>{{end}}
+					{{if $.ShowOrigin}}<td><code>{{.OriginIP}}</code></td>{{end}}
+					<td>{{datetime .OpenedAt}}</td>
+					<td>{{if .PendingReconnect}}reconnecting{{else}}connected{{end}}</td>
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{else}}
+			<p>No active connections.</p>
+			{{end}}
+			{{if .Connections.Dropped}}
+			<h3>Recently dropped</h3>
+			<table>
+				<thead><tr>
+					<th scope="col">Connector</th><th scope="col">Version</th>{{if .ShowColos}}<th scope="col">Edge location</th>{{end}}
+					{{if .ShowOrigin}}<th scope="col">Origin IP</th>{{end}}<th scope="col">Opened</th><th scope="col">Dropped</th>
+				</tr></thead>
+				<tbody>
+				{{range .Connections.Dropped}}<tr>
+					<td><code>{{.ConnectorID}}</code></td>
+					<td>{{.Version}}{{if .Arch}} ({{.Arch}}){{end}}</td>
+					{{if $.ShowColos}}<td>{{.Colo}}</td>{{end}}
+					{{if $.ShowOrigin}}<td><code>{{.OriginIP}}</code></td>{{end}}
+					<td>{{datetime .OpenedAt}}</td>
+					<td>{{datetime .DroppedAt}}</td>
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{end}}
+		</section>
+		{{end}}
+
+		{{if .HasConnectors}}
+		<section aria-labelledby="connectors-heading">
+			<h2 id="connectors-heading">Connectors</h2>
+			<table>
+				<thead><tr>
+					<th scope="col">Metrics endpoint</th><th scope="col">State</th><th scope="col">Version</th>
+					<th scope="col">HA connections</th>{{if .ShowColos}}<th scope="col">Edge locations</th>{{end}}
+					<th scope="col">Requests</th><th scope="col">Errors</th><th scope="col">In flight</th><th scope="col">Scraped</th>
+				</tr></thead>
+				<tbody>
+				{{range .Connectors}}<tr>
+					<td><code>{{.URL}}</code></td>
+					<td>{{if .Up}}up{{else}}unreachable: {{.Error}}{{end}}</td>
+					<td>{{.Version}}</td>
+					<td>{{if .Up}}{{.HAConnections}}{{end}}</td>
+					{{if $.ShowColos}}<td>{{join .EdgeLocations ", "}}</td>{{end}}
+					<td>{{if .Up}}{{rate .RequestRate}}{{end}}</td>
+					<td>{{if .Up}}{{rate .ErrorRate}}{{end}}</td>
+					<td>{{if .Up}}{{.Concurrent}}{{end}}</td>
+					<td>{{datetime .ScrapedAt}}</td>
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+		</section>
+		{{end}}
+
+		{{if .HasLogs}}
+		<section aria-labelledby="logs-heading">
+			<h2 id="logs-heading">Recent cloudflared log events</h2>
+			{{if .Logs}}
+			<table class="log-lines">
+				<thead><tr><th scope="col">Time</th><th scope="col">Level</th><th scope="col">Message</th></tr></thead>
+				<tbody>
+				{{range .Logs}}<tr class="log-{{.Level}}"><td>{{datetime .Time}}</td><td>{{.Level}}</td><td><code>{{.Message}}</code></td></tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{else}}
+			<p>No errors or connection events since this instance started.</p>
+			{{end}}
+		</section>
+		{{end}}
+
+		{{if .ConfigVersions}}
+		<section aria-labelledby="config-heading">
+			<h2 id="config-heading">Configuration history</h2>
+			{{range .ConfigVersions}}
+			<h3>Version {{.Version}} &middot; {{.Source}} &middot; seen {{datetime .SeenAt}}</h3>
+			{{if .Diff}}<pre class="config-diff"><code>{{.Diff}}</code></pre>{{else}}<p>First version seen.</p>{{end}}
+			{{end}}
+		</section>
+		{{end}}
+
+		{{if .AuditEnabled}}
+		<section aria-labelledby="audit-heading">
+			<h2 id="audit-heading">Audit log</h2>
+			{{if .Audit}}
+			<table>
+				<thead><tr><th scope="col">Time</th><th scope="col">Change</th><th scope="col">Resource</th></tr></thead>
+				<tbody>
+				{{range .Audit}}<tr><td>{{datetime .When}}</td><td>{{.Summary}}</td><td><code>{{.ResourceID}}</code></td></tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{else}}
+			<p>No audit log entries mention this tunnel in the last {{.AuditDays}} days.</p>
+			{{end}}
+		</section>
+		{{end}}
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// tunnelPath is the detail page of a tunnel, named by its slug when it
+// has one.
+func tunnelPath(id string) string {
+	tunnelSlugsMu.RLock()
+	slug := tunnelSlugs[id]
+	tunnelSlugsMu.RUnlock()
+	if slug != "" {
+		return "/tunnels/" + url.PathEscape(slug)
+	}
+	return "/tunnels/" + url.PathEscape(publicTunnelID(id))
+}
+
+// tunnelHandler serves /tunnels/{id}: the API view of one tunnel merged
+// with what its cloudflared instances report locally. The tunnel is named
+// by its ID or its slug.
+func tunnelHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := findTunnel(r.PathValue("id"))
+	if !ok {
+		if id, found := tunnelBySlug(r.PathValue("id")); found {
+			t, ok = findTunnel(id)
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now()
+	high := highContrast(w, r)
+	since, _ := t.since()
+	data := detailData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Tunnel:         t,
+		ID:             visibleTunnelID(r, t.ID),
+		Label:          t.label(),
+		Status:         statusLabel(t.Status),
+		StatusClass:    statusClass(t.Status),
+		ActiveLabel:    t.periodLabel(),
+		Since:          since,
+		Elapsed:        elapsedSince(since, now),
+		StatusSince:    statusSince(t.ID, t.Status),
+		Connectors:     tunnelConnectors(t.ID),
+		ShowOrigin:     adminAuthorized(r),
+		ShowColos:      showColos(r),
+	}
+	data.Connections = connectionsOf(t.ID, data.ShowOrigin, data.ShowColos)
+	data.InStatus = elapsedSince(data.StatusSince, now)
+	data.HasConnectors = len(data.Connectors) > 0
+	data.Logs = tunnelLogLines(t.ID)
+	if !showOriginIPs(r) {
+		for i := range data.Connectors {
+			data.Connectors[i].URL = maskIPs(data.Connectors[i].URL)
+			data.Connectors[i].Error = maskIPs(data.Connectors[i].Error)
+		}
+		for i := range data.Logs {
+			data.Logs[i].Message = maskIPs(data.Logs[i].Message)
+		}
+	}
+	data.HasLogs = hasConnectorLogs(t.ID)
+	data.AdminEnabled = adminToken != ""
+	data.ConfigVersions = tunnelConfigHistory(t.ID)
+	data.AuditEnabled = auditEnabled
+	data.Audit = recentAuditEntries(t.ID)
+	data.AuditDays = reportDays
+	if at, err := parseTimeParam(r, "at", time.Time{}); err == nil && !at.IsZero() {
+		alert := &alertContext{Event: "Tunnel", At: at, Status: statusUnknown}
+		if event := r.URL.Query().Get("event"); event != "" {
+			alert.Event = strings.ToUpper(event[:1]) + strings.ReplaceAll(event[1:], "_", " ")
+		}
+		if in, ok := statusAt(t.ID, at); ok {
+			alert.Status, alert.Interval = statusLabel(in.Status), &in
+		}
+		data.Alert = alert
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := detailTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering tunnel page", "error", err)
+	}
+}
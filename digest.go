@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestNotifier sends critical events straight away and batches the rest
+// into one summary per interval.
+type digestNotifier struct {
+	Notifier
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []Event
+	timer   *time.Timer
+}
+
+// digestFromEnv reads DIGEST, a comma-separated list of
+// notifier-name=interval entries (e.g. slack-1=1h), into the digest
+// setting of the named notifiers.
+func digestFromEnv(notifiers []NotifierConfig) {
+	for _, entry := range splitList(os.Getenv("DIGEST")) {
+		name, interval, _ := strings.Cut(entry, "=")
+		for i := range notifiers {
+			if notifiers[i].Name == strings.TrimSpace(name) {
+				if notifiers[i].Settings == nil {
+					notifiers[i].Settings = map[string]string{}
+				}
+				notifiers[i].Settings["digest"] = strings.TrimSpace(interval)
+			}
+		}
+	}
+}
+
+// withDigest wraps n when settings has a digest interval, accepted by
+// every notifier type.
+func withDigest(settings map[string]string, n Notifier) (Notifier, error) {
+	value := settings["digest"]
+	if value == "" {
+		return n, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Minute {
+		return nil, fmt.Errorf("digest: invalid duration %q (minimum 1m)", value)
+	}
+	return &digestNotifier{Notifier: n, interval: interval}, nil
+}
+
+// isCritical reports whether an event should bypass digests: outages,
+// expired tokens, an unreachable status page, SLA breaches, fast error
+// budget burn, a failing monitor and broken DNS records.
+func isCritical(event Event) bool {
+	switch event.Type {
+	case eventStatusChanged:
+		return event.NewStatus == "down" || event.NewStatus == "inactive"
+	case eventServiceTokenExpired, eventStatusPageDown, eventSLABreached, eventSLABurnRate, eventMonitorDegraded, eventDNSBroken:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *digestNotifier) Notify(ctx context.Context, event Event) error {
+	if isCritical(event) {
+		return n.Notifier.Notify(ctx, event)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, event)
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.interval, n.flush)
+	}
+	return nil
+}
+
+// flush sends the batched events as one digest event.
+func (n *digestNotifier) flush() {
+	n.mu.Lock()
+	events := n.pending
+	n.pending = nil
+	n.timer = nil
+	n.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := n.Notifier.Notify(ctx, digestEvent(events)); err != nil {
+		slog.Error("Error sending digest", "events", len(events), "notifier", n.Name(), "error", err)
+	}
+}
+
+// digestEvent summarises events, oldest first, one line each.
+func digestEvent(events []Event) Event {
+	var lines []string
+	for _, e := range events {
+		lines = append(lines, fmt.Sprintf("%s  %s", e.Time.UTC().Format("2006-01-02 15:04 MST"), e.Title))
+	}
+	noun := "updates"
+	if len(events) == 1 {
+		noun = "update"
+	}
+	return Event{
+		ID:      newEventID(),
+		Type:    eventDigest,
+		Time:    time.Now(),
+		Title:   fmt.Sprintf("Digest: %d tunnel %s", len(events), noun),
+		Message: strings.Join(lines, "\n"),
+	}
+}
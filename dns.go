@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDNSCheckInterval = 15 * time.Minute
+
+// dnsProblem is what is wrong with one of a tunnel's DNS records, worded
+// for the status page: "app.example.com has no DNS record".
+type dnsProblem struct {
+	Hostname string `json:"hostname"`
+	Problem  string `json:"problem"`
+}
+
+func (p dnsProblem) String() string {
+	return p.Hostname + " " + p.Problem
+}
+
+// dnsRecord is a record as the Cloudflare DNS API reports it.
+type dnsRecord struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Proxied bool   `json:"proxied"`
+}
+
+var (
+	dnsCheckInterval time.Duration
+	// dnsHostnames are the hostnames from DNS_HOSTNAMES by tunnel ID.
+	dnsHostnames = map[string][]string{}
+	dnsResolver  = net.DefaultResolver
+	// dnsZones caches the zone ID of each hostname checked.
+	dnsZones = map[string]string{}
+	// dnsProblems are the problems the last check found, by tunnel ID.
+	dnsProblems = map[string][]dnsProblem{}
+	dnsMu       sync.RWMutex
+)
+
+// loadDNSCheck reads DNS_CHECK_INTERVAL (e.g. 15m) or DNS_CHECK=true for
+// the default interval, which checks the DNS records of each tunnel's
+// hostnames: those of its remotely managed ingress rules and those in
+// DNS_HOSTNAMES, e.g. "<tunnel-id>=app.example.com,api.example.com",
+// separated by ";". Each must be a proxied CNAME to
+// <tunnel-id>.cfargotunnel.com and resolve, or the status page flags it:
+// a healthy tunnel is no use behind a broken record. Wildcard hostnames
+// are skipped. DNS_RESOLVER, e.g. 1.1.1.1:53, resolves them instead of the
+// system resolver. The API token needs the Zone: DNS read permission.
+func loadDNSCheck() error {
+	if value := os.Getenv("DNS_CHECK_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Minute {
+			return fmt.Errorf("DNS_CHECK_INTERVAL: invalid duration %q (minimum 1m)", value)
+		}
+		dnsCheckInterval = interval
+	} else if os.Getenv("DNS_CHECK") == "true" {
+		dnsCheckInterval = defaultDNSCheckInterval
+	}
+	if dnsCheckInterval == 0 {
+		return nil
+	}
+
+	for _, item := range strings.Split(os.Getenv("DNS_HOSTNAMES"), ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		id, hostnames, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("DNS_HOSTNAMES: %q is not <tunnel-id>=<hostname>,...", item)
+		}
+		id = strings.TrimSpace(id)
+		for _, hostname := range splitList(hostnames) {
+			dnsHostnames[id] = append(dnsHostnames[id], strings.ToLower(hostname))
+		}
+	}
+
+	if server := os.Getenv("DNS_RESOLVER"); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return fmt.Errorf("DNS_RESOLVER: %q is not host:port", server)
+		}
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		dnsResolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return nil
+}
+
+// watchDNS checks every tunnel's DNS records on each interval.
+func watchDNS() {
+	for {
+		for _, t := range snapshotTunnels() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := checkTunnelDNS(ctx, t, time.Now()); err != nil {
+				slog.Error("Error checking DNS records", "tunnel_id", t.ID, "error", err)
+			}
+			cancel()
+		}
+		time.Sleep(dnsCheckInterval)
+	}
+}
+
+// tunnelHostnames are the hostnames whose records should point at the
+// tunnel: its ingress rules' and DNS_HOSTNAMES'.
+func tunnelHostnames(ctx context.Context, t tunnelState) []string {
+	hostnames := slices.Clone(dnsHostnames[t.ID])
+	// Locally managed tunnels have no remote configuration to read.
+	if remote, err := fetchTunnelConfig(ctx, t); err == nil {
+		var config struct {
+			Ingress []struct {
+				Hostname string `json:"hostname"`
+			} `json:"ingress"`
+		}
+		if json.Unmarshal(remote.Result.Config, &config) == nil {
+			for _, rule := range config.Ingress {
+				hostnames = append(hostnames, strings.ToLower(rule.Hostname))
+			}
+		}
+	}
+	slices.Sort(hostnames)
+	hostnames = slices.Compact(hostnames)
+	return slices.DeleteFunc(hostnames, func(hostname string) bool {
+		return hostname == "" || strings.HasPrefix(hostname, "*.")
+	})
+}
+
+// checkTunnelDNS checks the tunnel's hostnames and sends a
+// dns_record_broken event for each newly broken record and a
+// dns_record_fixed event once it is fixed. An API error leaves the last
+// result as it was.
+func checkTunnelDNS(ctx context.Context, t tunnelState, now time.Time) error {
+	var problems []dnsProblem
+	for _, hostname := range tunnelHostnames(ctx, t) {
+		problem, err := checkHostnameDNS(ctx, t, hostname)
+		if err != nil {
+			return fmt.Errorf("%s: %w", hostname, err)
+		}
+		if problem != "" {
+			problems = append(problems, dnsProblem{Hostname: hostname, Problem: problem})
+		}
+	}
+
+	dnsMu.Lock()
+	previous := dnsProblems[t.ID]
+	if len(problems) > 0 {
+		dnsProblems[t.ID] = problems
+	} else {
+		delete(dnsProblems, t.ID)
+	}
+	dnsMu.Unlock()
+
+	var events []Event
+	for _, p := range problems {
+		if !slices.Contains(previous, p) {
+			slog.Warn("DNS record is broken", "tunnel_id", t.ID, "tunnel", t.label(), "hostname", p.Hostname, "problem", p.Problem)
+			events = append(events, dnsEvent(eventDNSBroken, t, p, now))
+		}
+	}
+	for _, p := range previous {
+		if !slices.ContainsFunc(problems, func(q dnsProblem) bool { return q.Hostname == p.Hostname }) {
+			slog.Info("DNS record is fixed", "tunnel_id", t.ID, "tunnel", t.label(), "hostname", p.Hostname)
+			events = append(events, dnsEvent(eventDNSFixed, t, p, now))
+		}
+	}
+	if len(events) > 0 {
+		invalidatePageCache()
+	}
+	for _, event := range events {
+		notify(event)
+	}
+	return nil
+}
+
+// checkHostnameDNS returns what is wrong with the hostname's record, ""
+// if nothing is.
+func checkHostnameDNS(ctx context.Context, t tunnelState, hostname string) (string, error) {
+	zone, err := dnsZoneOf(ctx, hostname)
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		return "is not in a Cloudflare zone the API token can read", nil
+	}
+	records, err := fetchDNSRecords(ctx, zone, hostname)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "has no DNS record", nil
+	}
+	i := slices.IndexFunc(records, func(r dnsRecord) bool { return r.Type == "CNAME" })
+	if i < 0 {
+		return fmt.Sprintf("is a %s record, not a CNAME to the tunnel", records[0].Type), nil
+	}
+	target := strings.TrimSuffix(strings.ToLower(records[i].Content), ".")
+	switch {
+	case target == strings.ToLower(t.ID)+".cfargotunnel.com":
+	case strings.HasSuffix(target, ".cfargotunnel.com"):
+		return "points at another tunnel", nil
+	default:
+		return fmt.Sprintf("points at %s, not the tunnel", target), nil
+	}
+	if !records[i].Proxied {
+		return "is not proxied, so it does not reach the tunnel", nil
+	}
+
+	addrs, err := dnsResolver.LookupNetIP(ctx, "ip", hostname)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && (dnsErr.IsNotFound || !dnsErr.IsTemporary) {
+			return "does not resolve: " + dnsErr.Err, nil
+		}
+		return "", err
+	}
+	cloudflareRangesMu.RLock()
+	rangesKnown := len(cloudflareRanges) > 0
+	cloudflareRangesMu.RUnlock()
+	if rangesKnown && !slices.ContainsFunc(addrs, func(addr netip.Addr) bool { return isCloudflareAddr(addr.Unmap()) }) {
+		return "resolves to addresses outside Cloudflare", nil
+	}
+	return "", nil
+}
+
+// dnsZoneOf finds the ID of the zone the hostname is in, trying its
+// parent domains from the longest, or "" if none is in the account.
+func dnsZoneOf(ctx context.Context, hostname string) (string, error) {
+	dnsMu.RLock()
+	zone, ok := dnsZones[hostname]
+	dnsMu.RUnlock()
+	if ok {
+		return zone, nil
+	}
+	labels := strings.Split(hostname, ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		query := url.Values{"name": {strings.Join(labels[i:], ".")}}
+		if err := cloudflareGet(ctx, "https://api.cloudflare.com/client/v4/zones?"+query.Encode(), &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			dnsMu.Lock()
+			dnsZones[hostname] = zones[0].ID
+			dnsMu.Unlock()
+			return zones[0].ID, nil
+		}
+	}
+	return "", nil
+}
+
+func fetchDNSRecords(ctx context.Context, zone, hostname string) ([]dnsRecord, error) {
+	var records []dnsRecord
+	query := url.Values{"name": {hostname}}
+	err := cloudflareGet(ctx, fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?%s", zone, query.Encode()), &records)
+	return records, err
+}
+
+// cloudflareGet fetches a Cloudflare API URL and decodes its result.
+func cloudflareGet(ctx context.Context, endpoint string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiBackground)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	debugAPIResponse(req, resp, body)
+	var parsed struct {
+		Success bool            `json:"success"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return fmt.Errorf("parsing API response: %w", err)
+	}
+	if !parsed.Success {
+		return fmt.Errorf("API response indicates failure: %s", string(body))
+	}
+	if err := json.Unmarshal(parsed.Result, result); err != nil {
+		return fmt.Errorf("parsing API response: %w", err)
+	}
+	return nil
+}
+
+func dnsEvent(typ string, t tunnelState, p dnsProblem, now time.Time) Event {
+	event := Event{
+		ID:         newEventID(),
+		Type:       typ,
+		Time:       now,
+		TunnelID:   t.ID,
+		TunnelName: t.label(),
+		Hostname:   p.Hostname,
+	}
+	if typ == eventDNSFixed {
+		event.Title = fmt.Sprintf("DNS record %s of tunnel %s is fixed", p.Hostname, t.label())
+		event.Message = fmt.Sprintf("%s points at tunnel %s again.", p.Hostname, t.label())
+		return event
+	}
+	event.Title = fmt.Sprintf("DNS record %s of tunnel %s is broken", p.Hostname, t.label())
+	event.Message = fmt.Sprintf("%s. Users cannot reach %s through tunnel %s, whatever the tunnel's status.", p, p.Hostname, t.label())
+	return event
+}
+
+// tunnelDNSProblems returns the problems found with the tunnel's records.
+func tunnelDNSProblems(tunnelID string) []dnsProblem {
+	dnsMu.RLock()
+	defer dnsMu.RUnlock()
+	return slices.Clone(dnsProblems[tunnelID])
+}
+
+// dnsBadges mark a tunnel row with its broken DNS records.
+func dnsBadges(tunnelID string) string {
+	var b strings.Builder
+	for _, p := range tunnelDNSProblems(tunnelID) {
+		fmt.Fprintf(&b, ` <span class="tunnel-dns">DNS: %s</span>`, html.EscapeString(p.String()))
+	}
+	return b.String()
+}
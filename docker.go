@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	// Container labels read by discovery.
+	dockerTunnelLabel  = "cftunnels.tunnel-id"
+	dockerAccountLabel = "cftunnels.account-id"
+	dockerNameLabel    = "cftunnels.name"
+)
+
+// dockerContainer is the subset of the container list response that
+// discovery reads.
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// dockerClient talks to the Docker Engine API over a unix socket or plain
+// TCP.
+type dockerClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+var dockerDiscovery *dockerClient
+
+// loadDocker reads DOCKER_DISCOVERY=true and DOCKER_HOST (default
+// unix:///var/run/docker.sock; tcp://host:port is also accepted).
+// Containers labelled cftunnels.tunnel-id are monitored for as long as the
+// container exists, running or not, so a stopped connector shows up as a
+// down tunnel rather than disappearing.
+func loadDocker() error {
+	if os.Getenv("DOCKER_DISCOVERY") != "true" {
+		return nil
+	}
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+	parsed, err := url.Parse(host)
+	if err != nil {
+		return fmt.Errorf("DOCKER_HOST: %w", err)
+	}
+	switch parsed.Scheme {
+	case "unix":
+		socket := parsed.Path
+		dockerDiscovery = &dockerClient{
+			baseURL: "http://docker",
+			client: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			}},
+		}
+	case "tcp":
+		dockerDiscovery = &dockerClient{baseURL: "http://" + parsed.Host, client: &http.Client{}}
+	default:
+		return fmt.Errorf("DOCKER_HOST: unsupported scheme %q (use unix:// or tcp://)", parsed.Scheme)
+	}
+	return nil
+}
+
+func (c *dockerClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: HTTP %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func dockerLabelFilter(event ...string) string {
+	filters := map[string][]string{"label": {dockerTunnelLabel}}
+	if len(event) > 0 {
+		filters["type"] = []string{"container"}
+		filters["event"] = event
+	}
+	encoded, _ := json.Marshal(filters)
+	return string(encoded)
+}
+
+// containerTunnels lists the tunnels referenced by labelled containers.
+func (c *dockerClient) containerTunnels(ctx context.Context) ([]TunnelConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	resp, err := c.get(ctx, "/containers/json", url.Values{"all": {"1"}, "filters": {dockerLabelFilter()}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("parsing container list: %w", err)
+	}
+
+	var found []TunnelConfig
+	for _, container := range containers {
+		id := container.Labels[dockerTunnelLabel]
+		if id == "" {
+			continue
+		}
+		t := TunnelConfig{ID: id, AccountID: container.Labels[dockerAccountLabel], Name: container.Labels[dockerNameLabel]}
+		if t.AccountID == "" {
+			t.AccountID = os.Getenv("ACCOUNT_ID")
+		}
+		if t.Name == "" && len(container.Names) > 0 {
+			t.Name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		found = append(found, t)
+	}
+	sortTunnelConfigs(found)
+	return found, nil
+}
+
+// watchDocker keeps the discovered tunnels in sync with labelled containers
+// until the process exits. The container list is re-read whenever a
+// labelled container is created or destroyed, and after reconnecting.
+func watchDocker() {
+	for {
+		if err := dockerDiscovery.sync(context.Background()); err != nil {
+			slog.Error("Error watching Docker", "error", err)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (c *dockerClient) sync(ctx context.Context) error {
+	// Subscribe before listing so no change falls between the two.
+	resp, err := c.get(ctx, "/events", url.Values{"filters": {dockerLabelFilter("create", "destroy")}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	refresh := func() error {
+		found, err := c.containerTunnels(ctx)
+		if err != nil {
+			return err
+		}
+		setDiscoveredTunnels("docker", found)
+		return nil
+	}
+	if err := refresh(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if err := refresh(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
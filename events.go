@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxEventStreams = 256
+	// eventHeartbeat keeps idle streams open through proxies.
+	eventHeartbeat = 30 * time.Second
+)
+
+// pageEvent is one Server-Sent Event pushed to status page viewers.
+type pageEvent struct {
+	name string
+	data []byte
+}
+
+// tunnelUpdate is the data of a "tunnel" event: what the status page shows
+// for one tunnel. Since and StatusSince are Unix seconds, 0 when unknown.
+type tunnelUpdate struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	StatusLabel string `json:"status_label"`
+	StatusClass string `json:"status_class"`
+	PeriodLabel string `json:"period_label"`
+	Since       int64  `json:"since"`
+	StatusSince int64  `json:"status_since"`
+}
+
+// overallUpdate is the data of an "overall" event.
+type overallUpdate struct {
+	Status      string `json:"status"`
+	StatusLabel string `json:"status_label"`
+	StatusClass string `json:"status_class"`
+}
+
+var (
+	maxEventStreams  = defaultMaxEventStreams
+	eventSubscribers = map[chan pageEvent]struct{}{}
+	eventsMu         sync.Mutex
+	// publishedUpdates are the tunnel events last sent, so polls that
+	// change nothing a viewer sees send nothing.
+	publishedUpdates   = map[string]tunnelUpdate{}
+	publishedUpdatesMu sync.Mutex
+)
+
+// loadEventStreams reads MAX_EVENT_STREAMS, how many viewers may hold an
+// /events or /ws stream open at once. Viewers beyond it fall back to reloading
+// the page.
+func loadEventStreams() error {
+	if value := os.Getenv("MAX_EVENT_STREAMS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("MAX_EVENT_STREAMS: invalid number %q", value)
+		}
+		maxEventStreams = n
+	}
+	return nil
+}
+
+// publishPageEvent sends an event to every open stream. Streams too slow
+// to keep up miss it rather than hold up polling.
+func publishPageEvent(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for ch := range eventSubscribers {
+		select {
+		case ch <- pageEvent{name: name, data: data}:
+		default:
+		}
+	}
+}
+
+// publishTunnelUpdate pushes a polled tunnel to status page viewers if
+// what they see of it changed, dropping the cached pages first so viewers
+// reloading on the event get the change.
+func publishTunnelUpdate(t tunnelState) {
+	update := tunnelUpdate{
+		ID:          publicTunnelID(t.ID),
+		Status:      t.Status,
+		StatusLabel: statusLabel(t.Status),
+		StatusClass: statusClass(t.Status),
+		PeriodLabel: t.periodLabel(),
+	}
+	if since, _ := t.since(); !since.IsZero() {
+		update.Since = since.Unix()
+	}
+	if changed := statusSince(t.ID, t.Status); !changed.IsZero() {
+		update.StatusSince = changed.Unix()
+	}
+	publishedUpdatesMu.Lock()
+	unchanged := publishedUpdates[t.ID] == update
+	publishedUpdates[t.ID] = update
+	publishedUpdatesMu.Unlock()
+	if !unchanged {
+		invalidatePageCache()
+		publishPageEvent("tunnel", update)
+	}
+}
+
+// publishOverallUpdate pushes the overall status to status page viewers,
+// once per round of polls rather than per tunnel. The cached pages are
+// dropped too, for the availability figures the round updated.
+func publishOverallUpdate() {
+	invalidatePageCache()
+	overall := overallTunnelStatus(snapshotTunnels())
+	if len(federationPeers) > 0 {
+		overall = overallStatus(append([]string{overall}, peerOverallStatuses()...))
+	}
+	publishPageEvent("overall", overallUpdate{Status: overall, StatusLabel: statusLabel(overall), StatusClass: statusClass(overall)})
+}
+
+// subscribePageEvents registers a stream for page events, or responds 503
+// and returns nil when MAX_EVENT_STREAMS are already open.
+func subscribePageEvents(w http.ResponseWriter) (chan pageEvent, func()) {
+	ch := make(chan pageEvent, 16)
+	eventsMu.Lock()
+	if len(eventSubscribers) >= maxEventStreams {
+		eventsMu.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(shedRetryAfter))
+		http.Error(w, "too many event streams", http.StatusServiceUnavailable)
+		return nil, nil
+	}
+	eventSubscribers[ch] = struct{}{}
+	viewers := len(eventSubscribers)
+	eventsMu.Unlock()
+	noteViewers(viewers, time.Now())
+	return ch, func() {
+		eventsMu.Lock()
+		delete(eventSubscribers, ch)
+		eventsMu.Unlock()
+	}
+}
+
+// eventsHandler serves /events, a Server-Sent Events stream of "tunnel"
+// events when a poll changes a tunnel's row and "overall" events after
+// every round of polls, so the status page updates in place instead of
+// reloading.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch, unsubscribe := subscribePageEvents(w)
+	if ch == nil {
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 10000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case event := <-ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// liveScript subscribes to /events and updates the pills and times in
+// place. While the stream is open it marks the page live, which pauses
+// refreshScript's reloads; a tunnel the page does not list yet reloads it.
+const liveScript = `
+(function () {
+	if (!window.EventSource) {
+		return;
+	}
+	const root = document.documentElement;
+	const source = new EventSource("/events");
+
+	function setPill(pill, update) {
+		if (!pill) {
+			return;
+		}
+		pill.className = "status-pill " + update.status_class;
+		pill.lastChild.textContent = update.status_label;
+	}
+
+	function setElapsed(el, since) {
+		if (!el) {
+			return;
+		}
+		if (!since) {
+			el.textContent = "unknown";
+			return;
+		}
+		let time = el.querySelector("time");
+		if (!time) {
+			time = document.createElement("time");
+			el.replaceChildren(time);
+		}
+		time.dataset.since = String(since);
+		time.dateTime = new Date(since * 1000).toISOString();
+	}
+
+	source.addEventListener("open", function () { root.dataset.live = "true"; });
+	source.addEventListener("error", function () { root.dataset.live = "false"; });
+	source.addEventListener("overall", function (e) {
+		setPill(document.querySelector("#overall-status .status-pill"), JSON.parse(e.data));
+	});
+	source.addEventListener("tunnel", function (e) {
+		const update = JSON.parse(e.data);
+		const row = document.querySelector('[data-tunnel-id="' + CSS.escape(update.id) + '"]');
+		if (!row) {
+			location.reload();
+			return;
+		}
+		setPill(row.querySelector(".status-pill"), update);
+		row.querySelector(".tunnel-period").textContent = update.period_label;
+		setElapsed(row.querySelector(".tunnel-elapsed"), update.since);
+		const inStatus = row.querySelector(".tunnel-in-status");
+		if (inStatus) {
+			inStatus.hidden = !update.status_since;
+			inStatus.querySelector(".tunnel-status-label").textContent = update.status_label;
+			setElapsed(inStatus.querySelector(".tunnel-status-elapsed"), update.status_since);
+		}
+	});
+})();
+`
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	exclusionFalsePositive = "false_positive"
+	exclusionExcluded      = "excluded"
+)
+
+// availabilityExclusion takes a tunnel's downtime in [Start, End) out of
+// the published availability. A false positive counts as healthy, as the
+// tunnel was up and only the monitoring failed; excluded time is left out
+// of the calculation altogether.
+type availabilityExclusion struct {
+	ID       string    `json:"id"`
+	TunnelID string    `json:"tunnel_id"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Kind     string    `json:"kind"`
+	Reason   string    `json:"reason"`
+	Author   string    `json:"author,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+var (
+	exclusionsFile string
+	exclusions     []availabilityExclusion
+	exclusionsMu   sync.Mutex
+)
+
+// loadExclusions reads EXCLUSIONS_FILE, where availability exclusions are
+// kept between restarts. Without it they are kept in memory only.
+func loadExclusions() error {
+	exclusionsFile = os.Getenv("EXCLUSIONS_FILE")
+	if exclusionsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(exclusionsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &exclusions)
+}
+
+// saveExclusions writes the exclusions to EXCLUSIONS_FILE. Callers hold
+// exclusionsMu.
+func saveExclusions() {
+	if exclusionsFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(exclusions, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding exclusions", "error", err)
+		monitorFailure("exclusions file", err)
+		return
+	}
+	tmp := exclusionsFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		slog.Error("Error writing exclusions file", "error", err)
+		monitorFailure("exclusions file", err)
+		return
+	}
+	err = os.Rename(tmp, exclusionsFile)
+	if err != nil {
+		slog.Error("Error writing exclusions file", "error", err)
+	}
+	monitorResult("exclusions file", err)
+}
+
+func (e availabilityExclusion) validate() error {
+	switch {
+	case e.TunnelID == "":
+		return fmt.Errorf("tunnel_id is required")
+	case !knownTunnel(e.TunnelID):
+		return fmt.Errorf("unknown tunnel %q", e.TunnelID)
+	case e.Start.IsZero() || e.End.IsZero() || !e.Start.Before(e.End):
+		return fmt.Errorf("start and end are required and start must be before end")
+	case e.Kind != exclusionFalsePositive && e.Kind != exclusionExcluded:
+		return fmt.Errorf("unknown kind %q (available: %s, %s)", e.Kind, exclusionFalsePositive, exclusionExcluded)
+	case e.Reason == "":
+		return fmt.Errorf("a reason is required")
+	}
+	return nil
+}
+
+// describe renders the exclusion for the audit log, e.g. "marked
+// 2024-05-01 10:00-10:20 UTC as a false positive: probe outage".
+func (e availabilityExclusion) describe() string {
+	what := "excluded"
+	if e.Kind == exclusionFalsePositive {
+		what = "as a false positive"
+	}
+	return fmt.Sprintf("marked %s-%s UTC %s: %s", e.Start.UTC().Format("2006-01-02 15:04"), e.End.UTC().Format("15:04"), what, e.Reason)
+}
+
+// addExclusion records e and notes it in the audit log.
+func addExclusion(e availabilityExclusion) availabilityExclusion {
+	e.ID = newEventID()
+	e.Created = time.Now()
+	exclusionsMu.Lock()
+	cutoff := e.Created.Add(-historyRetention)
+	kept := exclusions[:0]
+	for _, old := range exclusions {
+		if old.End.After(cutoff) {
+			kept = append(kept, old)
+		}
+	}
+	exclusions = append(kept, e)
+	saveExclusions()
+	exclusionsMu.Unlock()
+
+	recordAdminAction(e.TunnelID, e.Author, e.describe())
+	invalidatePageCache()
+	return e
+}
+
+// removeExclusion deletes the exclusion with the given id, noting why in
+// the audit log.
+func removeExclusion(id, author, reason string) bool {
+	exclusionsMu.Lock()
+	var removed *availabilityExclusion
+	for i, e := range exclusions {
+		if e.ID == id {
+			removed = &e
+			exclusions = append(exclusions[:i], exclusions[i+1:]...)
+			saveExclusions()
+			break
+		}
+	}
+	exclusionsMu.Unlock()
+	if removed == nil {
+		return false
+	}
+	recordAdminAction(removed.TunnelID, author, fmt.Sprintf("removed exclusion %s (%s): %s", removed.ID, removed.describe(), reason))
+	invalidatePageCache()
+	return true
+}
+
+// recordAdminAction logs an admin change to a tunnel's availability and
+// adds it to the audit entries shown next to incidents.
+func recordAdminAction(tunnelID, actor, action string) {
+	recordAction(tunnelID, actor, "status page", action)
+}
+
+// recordAction is recordAdminAction for a change made through iface, such
+// as a chat command.
+func recordAction(tunnelID, actor, iface, action string) {
+	slog.Info("Audit", "user", actor, "action", action, "tunnel_id", tunnelID)
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditEntries = append(auditEntries, auditEntry{
+		ID:        newEventID(),
+		When:      time.Now(),
+		Action:    action,
+		Result:    true,
+		Actor:     actor,
+		Interface: iface,
+		TunnelID:  tunnelID,
+	})
+}
+
+// tunnelExclusions returns the tunnel's exclusions overlapping [from, to),
+// by start time; an empty tunnelID returns every tunnel's.
+func tunnelExclusions(tunnelID string, from, to time.Time) []availabilityExclusion {
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+	out := []availabilityExclusion{}
+	for _, e := range exclusions {
+		if (tunnelID == "" || e.TunnelID == tunnelID) && e.Start.Before(to) && e.End.After(from) {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// availabilitySpans is historySpans as published: downtime inside a false
+// positive is reported as healthy and downtime inside an excluded period
+// is skipped. Availability and error budgets are computed from it.
+func availabilitySpans(tunnelID string, from, to time.Time, fn func(status string, start, end time.Time)) {
+	list := tunnelExclusions(tunnelID, from, to)
+	historySpans(tunnelID, from, to, func(status string, start, end time.Time) {
+		if isAvailable(status) || len(list) == 0 {
+			fn(status, start, end)
+			return
+		}
+		for start.Before(end) {
+			var cover *availabilityExclusion
+			for i := range list {
+				if list[i].End.After(start) && list[i].Start.Before(end) {
+					cover = &list[i]
+					break
+				}
+			}
+			if cover == nil {
+				fn(status, start, end)
+				return
+			}
+			if cover.Start.After(start) {
+				fn(status, start, cover.Start)
+				start = cover.Start
+			}
+			stop := end
+			if cover.End.Before(stop) {
+				stop = cover.End
+			}
+			if cover.Kind == exclusionFalsePositive {
+				fn("healthy", start, stop)
+			}
+			start = stop
+		}
+	})
+}
+
+// exclusionsHandler serves /admin/api/exclusions. GET lists the
+// exclusions, optionally of one tunnel; POST adds one, which needs a
+// reason.
+func exclusionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, tunnelExclusions(r.URL.Query().Get("tunnel"), time.Time{}, time.Now().Add(historyRetention)))
+	case http.MethodPost:
+		var e availabilityExclusion
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAnnotationSize)).Decode(&e); err != nil {
+			writeProblem(w, r, problemInvalidRequest, "invalid exclusion: "+err.Error())
+			return
+		}
+		if e.Kind == "" {
+			e.Kind = exclusionFalsePositive
+		}
+		if err := e.validate(); err != nil {
+			writeProblem(w, r, problemValidation, err.Error())
+			return
+		}
+		e.Author = adminUser(r)
+		writeJSON(w, http.StatusCreated, addExclusion(e))
+	default:
+		methodNotAllowed(w, r, "GET, POST")
+	}
+}
+
+// deleteExclusionHandler serves DELETE /admin/api/exclusions/{id}?reason=,
+// putting the downtime back into the availability.
+func deleteExclusionHandler(w http.ResponseWriter, r *http.Request) {
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		writeProblem(w, r, problemValidation, "reason: a reason is required")
+		return
+	}
+	if !removeExclusion(r.PathValue("id"), adminUser(r), reason) {
+		writeProblem(w, r, problemUnknownExclusion, fmt.Sprintf("no exclusion %q", r.PathValue("id")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeConfig is a dependency whose status comes from its own public
+// status page, shown alongside the tunnels.
+type ProbeConfig struct {
+	Name string `json:"name"`
+	// Kind is statuspage (Atlassian Statuspage) or instatus.
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+// externalComponent is a configured probe as it is fetched.
+type externalComponent struct {
+	name string
+	kind string
+	url  string
+}
+
+// externalStatus is the last fetched status of an external component.
+// Description is the page's own summary, e.g. "Partial System Outage".
+type externalStatus struct {
+	Status      string
+	Description string
+	FetchedAt   time.Time
+	Err         string
+}
+
+var (
+	externalComponents []externalComponent
+	externalInterval   = pollInterval
+	externalStatuses   = map[string]externalStatus{}
+	externalMu         sync.RWMutex
+)
+
+// statuspageIndicators maps Statuspage's status indicator to a status.
+var statuspageIndicators = map[string]string{
+	"none":        "healthy",
+	"minor":       "degraded",
+	"maintenance": "degraded",
+	"major":       "down",
+	"critical":    "down",
+}
+
+// instatusStatuses maps an Instatus page status to a status.
+var instatusStatuses = map[string]string{
+	"UP":               "healthy",
+	"HASISSUES":        "degraded",
+	"UNDERMAINTENANCE": "degraded",
+}
+
+// loadExternalStatus reads EXTERNAL_STATUS_PAGES, a comma-separated list
+// of name=kind:URL entries for third-party status pages to show as
+// dependencies, e.g. "Payments=statuspage:https://status.example.com",
+// where kind is statuspage or instatus; and EXTERNAL_STATUS_INTERVAL. The
+// pages become the config's probes.
+func loadExternalStatus() error {
+	probes, err := probesFromEnv()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i, p := range probes {
+		if err := p.validate(i, seen); err != nil {
+			return fmt.Errorf("EXTERNAL_STATUS_PAGES: %v", err)
+		}
+	}
+	if value := os.Getenv("EXTERNAL_STATUS_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 30*time.Second {
+			return fmt.Errorf("EXTERNAL_STATUS_INTERVAL: invalid duration %q (minimum 30s)", value)
+		}
+		externalInterval = interval
+	}
+	return nil
+}
+
+// probesFromEnv reads the probes in EXTERNAL_STATUS_PAGES.
+func probesFromEnv() ([]ProbeConfig, error) {
+	var probes []ProbeConfig
+	for _, entry := range splitList(os.Getenv("EXTERNAL_STATUS_PAGES")) {
+		name, source, ok := strings.Cut(entry, "=")
+		kind, base, hasKind := strings.Cut(strings.TrimSpace(source), ":")
+		if !ok || strings.TrimSpace(name) == "" || !hasKind {
+			return nil, fmt.Errorf("EXTERNAL_STATUS_PAGES: %q is not name=kind:URL", entry)
+		}
+		probes = append(probes, ProbeConfig{Name: strings.TrimSpace(name), Kind: kind, URL: strings.TrimRight(base, "/")})
+	}
+	return probes, nil
+}
+
+// validate checks the ith probe of a config; seen holds the names of the
+// probes before it.
+func (p ProbeConfig) validate(i int, seen map[string]bool) error {
+	if p.Name == "" {
+		return fmt.Errorf("probes[%d]: name is required", i)
+	}
+	if seen[p.Name] {
+		return fmt.Errorf("probes[%d]: duplicate name %q", i, p.Name)
+	}
+	seen[p.Name] = true
+	if p.Kind != "statuspage" && p.Kind != "instatus" {
+		return fmt.Errorf("probe %q: unknown kind %q (available: statuspage, instatus)", p.Name, p.Kind)
+	}
+	if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("probe %q: %q is not an http or https URL", p.Name, p.URL)
+	}
+	return nil
+}
+
+// syncProbes makes the configured probes the external components, fetching
+// new and changed ones right away and forgetting removed ones.
+func syncProbes(probes []ProbeConfig) {
+	components := make([]externalComponent, 0, len(probes))
+	for _, p := range probes {
+		components = append(components, externalComponent{name: p.Name, kind: p.Kind, url: strings.TrimRight(p.URL, "/")})
+	}
+	externalMu.Lock()
+	previous := map[string]externalComponent{}
+	for _, c := range externalComponents {
+		previous[c.name] = c
+	}
+	externalComponents = components
+	for name := range externalStatuses {
+		if c, ok := previous[name]; !ok || !slices.Contains(components, c) {
+			delete(externalStatuses, name)
+		}
+	}
+	externalMu.Unlock()
+	for _, c := range components {
+		if previous[c.name] != c {
+			go func() {
+				fetchExternalStatus(c)
+				invalidatePageCache()
+			}()
+		}
+	}
+	invalidatePageCache()
+}
+
+// watchExternalStatus fetches every external component each interval,
+// after syncProbes has fetched them first.
+func watchExternalStatus() {
+	for {
+		time.Sleep(externalInterval)
+		externalMu.RLock()
+		components := externalComponents
+		externalMu.RUnlock()
+		for _, c := range components {
+			fetchExternalStatus(c)
+		}
+		if len(components) > 0 {
+			invalidatePageCache()
+		}
+	}
+}
+
+func fetchExternalStatus(c externalComponent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	status, description, err := readExternalStatus(ctx, c)
+	monitorResult("status page of "+c.name, err)
+
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	if !slices.Contains(externalComponents, c) {
+		// Removed or changed by a config apply meanwhile.
+		return
+	}
+	if err != nil {
+		slog.Error("Error fetching external status page", "component", c.name, "error", err)
+		last := externalStatuses[c.name]
+		last.Err = err.Error()
+		externalStatuses[c.name] = last
+		return
+	}
+	externalStatuses[c.name] = externalStatus{Status: status, Description: description, FetchedAt: time.Now()}
+}
+
+// readExternalStatus fetches a component's status from its page's JSON
+// API.
+func readExternalStatus(ctx context.Context, c externalComponent) (status, description string, err error) {
+	if c.kind == "instatus" {
+		var summary struct {
+			Page struct {
+				Status string `json:"status"`
+			} `json:"page"`
+		}
+		if err := doJSON(ctx, "GET", c.url+"/summary.json", nil, nil, &summary); err != nil {
+			return "", "", err
+		}
+		status, ok := instatusStatuses[summary.Page.Status]
+		if !ok {
+			return "", "", fmt.Errorf("unknown page status %q", summary.Page.Status)
+		}
+		return status, "", nil
+	}
+
+	var summary struct {
+		Status struct {
+			Indicator   string `json:"indicator"`
+			Description string `json:"description"`
+		} `json:"status"`
+	}
+	if err := doJSON(ctx, "GET", c.url+"/api/v2/status.json", nil, nil, &summary); err != nil {
+		return "", "", err
+	}
+	status, ok := statuspageIndicators[summary.Status.Indicator]
+	if !ok {
+		return "", "", fmt.Errorf("unknown status indicator %q", summary.Status.Indicator)
+	}
+	return status, summary.Status.Description, nil
+}
+
+// externalSection renders the external components on the status page.
+// They are informational and do not count towards the overall status.
+func externalSection() string {
+	externalMu.RLock()
+	defer externalMu.RUnlock()
+	if len(externalComponents) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<section class="external-components"><h2>Dependencies</h2><ul class="tunnel-list">`)
+	for _, c := range externalComponents {
+		s, ok := externalStatuses[c.name]
+		status, note := s.Status, s.Description
+		switch {
+		case s.Err != "":
+			status, note = statusUnknown, "Status page unreachable"
+		case !ok:
+			status, note = statusUnknown, "Waiting for the first update"
+		}
+		if note != "" {
+			note = ` <span class="tunnel-since">` + html.EscapeString(note) + `</span>`
+		}
+		fmt.Fprintf(&b, `<li><a class="tunnel-name" href="%s">%s</a> %s%s</li>`,
+			html.EscapeString(c.url), html.EscapeString(c.name), statusPill(status), note)
+	}
+	b.WriteString(`</ul></section>`)
+	return b.String()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultFederationInterval = time.Minute
+
+// federationResponse is what /api/federation serves to aggregating peers:
+// this instance's own tunnels, never those it federates, so peers can
+// federate each other without loops.
+type federationResponse struct {
+	Instance string `json:"instance"`
+	Version  string `json:"version"`
+	statusResponse
+}
+
+// federationPeer is another instance whose tunnels are shown here.
+type federationPeer struct {
+	name  string
+	url   string
+	token string
+}
+
+// peerStatus is the last view of a peer. Instance, Status and Tunnels are
+// kept from the last successful fetch; Err is set while fetching fails.
+type peerStatus struct {
+	Instance  string
+	Status    string
+	Tunnels   []tunnelStatusResult
+	FetchedAt time.Time
+	Err       string
+}
+
+var (
+	federationPeers    []federationPeer
+	federationInterval = defaultFederationInterval
+	// federationToken, when set, is required to read /api/federation.
+	federationToken string
+	peerStatuses    = map[string]peerStatus{}
+	peersMu         sync.RWMutex
+)
+
+// loadFederation reads FEDERATION_PEERS, a comma-separated list of
+// name=base-URL entries for the instances whose tunnels are aggregated
+// onto this page, e.g. "EU=https://status-eu.example.com";
+// FEDERATION_PEER_TOKENS, name=token entries sent to those peers;
+// FEDERATION_INTERVAL; and FEDERATION_TOKEN, the token peers must present
+// to read this instance's /api/federation.
+func loadFederation() error {
+	federationToken = os.Getenv("FEDERATION_TOKEN")
+	tokens := map[string]string{}
+	for _, entry := range splitList(os.Getenv("FEDERATION_PEER_TOKENS")) {
+		name, token, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("FEDERATION_PEER_TOKENS: %q is not name=token", entry)
+		}
+		tokens[strings.TrimSpace(name)] = strings.TrimSpace(token)
+	}
+	seen := map[string]bool{}
+	for _, entry := range splitList(os.Getenv("FEDERATION_PEERS")) {
+		name, base, ok := strings.Cut(entry, "=")
+		name, base = strings.TrimSpace(name), strings.TrimRight(strings.TrimSpace(base), "/")
+		if !ok || name == "" {
+			return fmt.Errorf("FEDERATION_PEERS: %q is not name=URL", entry)
+		}
+		if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("FEDERATION_PEERS: %s: %q is not an http or https URL", name, base)
+		}
+		if seen[name] {
+			return fmt.Errorf("FEDERATION_PEERS: duplicate peer %q", name)
+		}
+		seen[name] = true
+		federationPeers = append(federationPeers, federationPeer{name: name, url: base, token: tokens[name]})
+	}
+	for name := range tokens {
+		if !seen[name] {
+			return fmt.Errorf("FEDERATION_PEER_TOKENS: unknown peer %q", name)
+		}
+	}
+	if value := os.Getenv("FEDERATION_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 10*time.Second {
+			return fmt.Errorf("FEDERATION_INTERVAL: invalid duration %q (minimum 10s)", value)
+		}
+		federationInterval = interval
+	}
+	return nil
+}
+
+// federationHandler serves /api/federation.
+func federationHandler(w http.ResponseWriter, r *http.Request) {
+	if federationToken != "" {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(federationToken)) != 1 {
+			writeProblem(w, r, problemUnauthorized, "a valid FEDERATION_TOKEN bearer token is required")
+			return
+		}
+	}
+	response := federationResponse{
+		Instance:       instanceID,
+		Version:        appVersion(),
+		statusResponse: statusSnapshot(snapshotTunnels(), time.Now()),
+	}
+	if federationToken == "" {
+		response.maskTunnelIDs()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
+
+// federate fetches every peer each interval.
+func federate() {
+	for {
+		var wg sync.WaitGroup
+		for _, peer := range federationPeers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fetchPeer(peer)
+			}()
+		}
+		wg.Wait()
+		invalidatePageCache()
+		time.Sleep(federationInterval)
+	}
+}
+
+func fetchPeer(peer federationPeer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	headers := map[string]string{}
+	if peer.token != "" {
+		headers["Authorization"] = "Bearer " + peer.token
+	}
+	var response federationResponse
+	err := doJSON(ctx, "GET", peer.url+"/api/federation", headers, nil, &response)
+	monitorResult("federation peer "+peer.name, err)
+
+	peersMu.Lock()
+	defer peersMu.Unlock()
+	if err != nil {
+		slog.Error("Error fetching federation peer", "peer", peer.name, "error", err)
+		status := peerStatuses[peer.name]
+		status.Err = err.Error()
+		peerStatuses[peer.name] = status
+		return
+	}
+	// The peer's statuses reach the page and the overall status, so only
+	// the ones this instance knows are taken from it.
+	if !validStatus(response.Status) {
+		response.Status = statusUnknown
+	}
+	for i := range response.Tunnels {
+		if !validStatus(response.Tunnels[i].Status) {
+			response.Tunnels[i].Status = statusUnknown
+		}
+	}
+	peerStatuses[peer.name] = peerStatus{
+		Instance:  response.Instance,
+		Status:    response.Status,
+		Tunnels:   response.Tunnels,
+		FetchedAt: time.Now(),
+	}
+}
+
+// peerOverallStatuses are the statuses peers contribute to the overall
+// status: unknown while a peer is unreachable or has not answered yet.
+func peerOverallStatuses() []string {
+	peersMu.RLock()
+	defer peersMu.RUnlock()
+	var statuses []string
+	for _, peer := range federationPeers {
+		status, ok := peerStatuses[peer.name]
+		if !ok || status.Err != "" {
+			statuses = append(statuses, statusUnknown)
+			continue
+		}
+		statuses = append(statuses, status.Status)
+	}
+	return statuses
+}
+
+// federatedSections renders each peer's tunnels under its name on the
+// status page.
+func federatedSections(now time.Time) string {
+	peersMu.RLock()
+	defer peersMu.RUnlock()
+	var b strings.Builder
+	for i, peer := range federationPeers {
+		status, ok := peerStatuses[peer.name]
+		instance := ""
+		if status.Instance != "" {
+			instance = fmt.Sprintf(` <span class="tunnel-since">(%s)</span>`, html.EscapeString(status.Instance))
+		}
+		fmt.Fprintf(&b, `<section class="federated-peer"><h2>%s%s</h2>`, html.EscapeString(peer.name), instance)
+		switch {
+		case !ok:
+			b.WriteString(`<p class="tunnel-since">Waiting for the first update.</p>`)
+		case status.Err != "" && status.FetchedAt.IsZero():
+			b.WriteString(`<p class="tunnel-since">Unreachable.</p>`)
+		case status.Err != "":
+			fmt.Fprintf(&b, `<p class="tunnel-since">Unreachable; last updated %s ago.</p>`, relTime(fmt.Sprintf("peer-%d", i), status.FetchedAt, now))
+		}
+		b.WriteString(`<ul class="tunnel-list">`)
+		for j, t := range status.Tunnels {
+			since, label := t.ConnsInactiveAt, "Downtime"
+			if t.Up {
+				since, label = t.ConnsActiveAt, "Uptime"
+			}
+			elapsed := statusUnknown
+			if since != nil {
+				elapsed = relTime(fmt.Sprintf("peer-%d-%d", i, j), *since, now)
+			}
+			fmt.Fprintf(&b, `<li><span class="tunnel-name">%s</span> %s <span class="tunnel-since">%s: %s</span></li>`,
+				html.EscapeString(t.Name), statusPill(t.Status), label, elapsed)
+		}
+		b.WriteString(`</ul></section>`)
+	}
+	return b.String()
+}
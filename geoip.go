@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// viewerHintsMarker stands in the cached status page for the region hints,
+// which depend on the viewer.
+const viewerHintsMarker = "<!--viewer-hints-->"
+
+// geoRange is a range of addresses in one country.
+type geoRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+var (
+	// geoRanges is GEOIP_FILE, sorted by start.
+	geoRanges []geoRange
+	// geoRegions maps region names from GEOIP_REGIONS to their countries.
+	geoRegions = map[string][]string{}
+	regionName = regexp.MustCompile(`^[A-Z0-9-]+$`)
+)
+
+// loadGeoIP reads GEOIP_FILE, a CSV file of address ranges and their
+// countries, one "start,end,country" range per line as in the DB-IP and
+// IP2Location Lite country databases, and GEOIP_REGIONS, named groups of
+// countries, e.g. "EU=DE,FR,NL; NA=US,CA". Behind CLOUDFLARE_ONLY the
+// CF-IPCountry header gives the viewer's country instead. Both let
+// incidents carry region hints, which tell viewers in a region or country
+// whether they are affected.
+func loadGeoIP() error {
+	for _, item := range strings.Split(os.Getenv("GEOIP_REGIONS"), ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, countries, ok := strings.Cut(item, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !ok || !regionName.MatchString(name) {
+			return fmt.Errorf("GEOIP_REGIONS: %q is not <region>=<country>,...", item)
+		}
+		for _, country := range splitList(countries) {
+			geoRegions[name] = append(geoRegions[name], strings.ToUpper(country))
+		}
+	}
+
+	path := os.Getenv("GEOIP_FILE")
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("GEOIP_FILE: %w", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("GEOIP_FILE: %w", err)
+		}
+		if len(record) < 3 {
+			return fmt.Errorf("GEOIP_FILE: line %d: expected start,end,country", line)
+		}
+		start, err1 := netip.ParseAddr(record[0])
+		end, err2 := netip.ParseAddr(record[1])
+		if err1 != nil || err2 != nil {
+			if line == 1 {
+				// A header.
+				continue
+			}
+			return fmt.Errorf("GEOIP_FILE: line %d: invalid address range", line)
+		}
+		geoRanges = append(geoRanges, geoRange{start: start.Unmap(), end: end.Unmap(), country: strings.ToUpper(record[2])})
+	}
+	sort.Slice(geoRanges, func(i, j int) bool { return geoRanges[i].start.Less(geoRanges[j].start) })
+	slog.Info("GeoIP address ranges loaded", "count", len(geoRanges), "file", path)
+	return nil
+}
+
+// lookupCountry returns the country of addr in GEOIP_FILE.
+func lookupCountry(addr netip.Addr) (string, bool) {
+	i := sort.Search(len(geoRanges), func(i int) bool { return addr.Less(geoRanges[i].start) })
+	if i == 0 || geoRanges[i-1].end.Less(addr) {
+		return "", false
+	}
+	return geoRanges[i-1].country, true
+}
+
+// viewerCountry is the country of the viewer of r, "" if unknown.
+func viewerCountry(r *http.Request) string {
+	if cloudflareOnly {
+		// XX is unknown and T1 Tor.
+		if country := strings.ToUpper(r.Header.Get("CF-IPCountry")); country != "" && country != "XX" && country != "T1" {
+			return country
+		}
+	}
+	if addr, ok := clientAddr(r); ok {
+		if country, ok := lookupCountry(addr); ok {
+			return country
+		}
+	}
+	return ""
+}
+
+// viewerRegions are the names a viewer in country may be addressed by:
+// the country, then the GEOIP_REGIONS containing it in name order.
+func viewerRegions(country string) []string {
+	if country == "" {
+		return nil
+	}
+	regions := []string{country}
+	var names []string
+	for name, countries := range geoRegions {
+		if slices.Contains(countries, country) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append(regions, names...)
+}
+
+// regionHint is what an open incident tells the viewers of one region.
+type regionHint struct {
+	Tunnel string
+	Text   string
+}
+
+// hintFor returns the record's hint for a viewer in regions, the most
+// specific one first. An empty hint text is worded from the tunnel's
+// configured regions: affected if the viewer is in one of them.
+func hintFor(rec incidentRecord, regions []string) (regionHint, bool) {
+	for _, region := range regions {
+		text, ok := rec.RegionHints[region]
+		if !ok {
+			continue
+		}
+		hint := regionHint{Tunnel: rec.Tunnel, Text: text}
+		t, known := findTunnel(rec.TunnelID)
+		if known {
+			hint.Tunnel = t.label()
+		}
+		if text == "" {
+			if !known || len(t.Regions) == 0 {
+				continue
+			}
+			affected := slices.ContainsFunc(t.Regions, func(served string) bool { return slices.Contains(regions, served) })
+			if affected {
+				hint.Text = region + " users are affected."
+			} else {
+				hint.Text = region + " users are not affected."
+			}
+		}
+		return hint, true
+	}
+	return regionHint{}, false
+}
+
+// viewerHints returns the hints of the open incidents for the viewer of r.
+func viewerHints(r *http.Request) []regionHint {
+	var open []incidentRecord
+	incidentsMu.Lock()
+	for _, rec := range incidentRecords {
+		if rec.End == nil && len(rec.RegionHints) > 0 {
+			open = append(open, *rec)
+		}
+	}
+	incidentsMu.Unlock()
+	if len(open) == 0 {
+		return nil
+	}
+	regions := viewerRegions(viewerCountry(r))
+	var hints []regionHint
+	for _, rec := range open {
+		if hint, ok := hintFor(rec, regions); ok {
+			hints = append(hints, hint)
+		}
+	}
+	return hints
+}
+
+// withViewerHints fills the status page's hints marker for the viewer of r.
+func withViewerHints(body []byte, r *http.Request) []byte {
+	hints := viewerHints(r)
+	if len(hints) == 0 {
+		return body
+	}
+	var b strings.Builder
+	for _, hint := range hints {
+		fmt.Fprintf(&b, `<p class="viewer-hint" role="status">%s: %s</p>`, html.EscapeString(hint.Tunnel), html.EscapeString(hint.Text))
+	}
+	return bytes.Replace(body, []byte(viewerHintsMarker), []byte(b.String()), 1)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+// githubClient holds what every GitHub call needs.
+type githubClient struct {
+	apiURL string
+	token  string
+	repo   string
+}
+
+// githubIssueHook opens an issue per incident and closes it on recovery.
+type githubIssueHook struct {
+	githubClient
+	labels    []string
+	threshold time.Duration
+}
+
+// githubStatusNotifier mirrors tunnel status onto a commit status and/or a
+// deployment status, so it shows up next to the code that is deployed.
+type githubStatusNotifier struct {
+	githubClient
+	sha          string
+	deploymentID string
+}
+
+type githubIssue struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// commitStates and deploymentStates map tunnel statuses to the states the
+// two GitHub APIs accept.
+var (
+	commitStates     = map[string]string{"healthy": "success", "degraded": "pending", "down": "failure", "inactive": "error"}
+	deploymentStates = map[string]string{"healthy": "success", "degraded": "in_progress", "down": "failure", "inactive": "inactive"}
+)
+
+// githubFromEnv reads GITHUB_TOKEN, GITHUB_REPO (owner/name) and
+// GITHUB_API_URL for GitHub Enterprise Server. GITHUB_ISSUES=true enables
+// incident issues, labelled with GITHUB_ISSUE_LABELS and opened once an
+// outage reaches GITHUB_OUTAGE_THRESHOLD. GITHUB_STATUS_SHA and
+// GITHUB_DEPLOYMENT_ID enable commit and deployment statuses.
+func githubFromEnv() []NotifierConfig {
+	if os.Getenv("GITHUB_REPO") == "" {
+		return nil
+	}
+	client := map[string]string{
+		"repo":    "GITHUB_REPO",
+		"token":   "GITHUB_TOKEN",
+		"api_url": "GITHUB_API_URL",
+	}
+	var configs []NotifierConfig
+	if os.Getenv("GITHUB_ISSUES") == "true" {
+		settings := envSettings(client)
+		for k, v := range envSettings(map[string]string{"labels": "GITHUB_ISSUE_LABELS", "outage_threshold": "GITHUB_OUTAGE_THRESHOLD"}) {
+			settings[k] = v
+		}
+		configs = append(configs, NotifierConfig{Name: "github-issues", Type: "github_issues", Settings: settings})
+	}
+	if os.Getenv("GITHUB_STATUS_SHA") != "" || os.Getenv("GITHUB_DEPLOYMENT_ID") != "" {
+		settings := envSettings(client)
+		for k, v := range envSettings(map[string]string{"sha": "GITHUB_STATUS_SHA", "deployment_id": "GITHUB_DEPLOYMENT_ID"}) {
+			settings[k] = v
+		}
+		configs = append(configs, NotifierConfig{Name: "github-status", Type: "github_status", Settings: settings})
+	}
+	return configs
+}
+
+// newGitHubClient takes repo (owner/name), token and api_url settings.
+func newGitHubClient(settings map[string]string) (githubClient, error) {
+	client := githubClient{
+		apiURL: strings.TrimRight(settings["api_url"], "/"),
+		token:  settings["token"],
+		repo:   settings["repo"],
+	}
+	if client.apiURL == "" {
+		client.apiURL = defaultGitHubAPIURL
+	}
+	if client.token == "" {
+		return client, fmt.Errorf("token is required")
+	}
+	if strings.Count(client.repo, "/") != 1 {
+		return client, fmt.Errorf("repo must be owner/name, got %q", client.repo)
+	}
+	return client, nil
+}
+
+// newGitHubIssuesIntegration adds labels and outage_threshold (default
+// immediately) to the client settings.
+func newGitHubIssuesIntegration(settings map[string]string) (integration, error) {
+	client, err := newGitHubClient(settings)
+	if err != nil {
+		return integration{}, err
+	}
+	hook := &githubIssueHook{githubClient: client, labels: splitList(settings["labels"])}
+	if value := settings["outage_threshold"]; value != "" {
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 0 {
+			return integration{}, fmt.Errorf("outage_threshold: invalid duration %q", value)
+		}
+		hook.threshold = threshold
+	}
+	return integration{hook: hook}, nil
+}
+
+// newGitHubStatusIntegration adds sha and/or deployment_id to the client
+// settings.
+func newGitHubStatusIntegration(settings map[string]string) (integration, error) {
+	client, err := newGitHubClient(settings)
+	if err != nil {
+		return integration{}, err
+	}
+	if settings["sha"] == "" && settings["deployment_id"] == "" {
+		return integration{}, fmt.Errorf("sha or deployment_id is required")
+	}
+	return integration{notifier: &githubStatusNotifier{githubClient: client, sha: settings["sha"], deploymentID: settings["deployment_id"]}}, nil
+}
+
+func (c githubClient) headers() map[string]string {
+	return map[string]string{
+		"Authorization":        "Bearer " + c.token,
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+}
+
+func (c githubClient) url(format string, args ...any) string {
+	return c.apiURL + "/repos/" + c.repo + fmt.Sprintf(format, args...)
+}
+
+func (h *githubIssueHook) Name() string {
+	return "github"
+}
+
+func (h *githubIssueHook) Threshold() time.Duration {
+	return h.threshold
+}
+
+func (h *githubIssueHook) Open(ctx context.Context, rec incidentRecord) (string, error) {
+	issue := map[string]any{
+		"title": fmt.Sprintf("Tunnel %s outage: %s", rec.Tunnel, statusLabel(rec.Status)),
+		"body": fmt.Sprintf("Tunnel **%s** (`%s`) has not been healthy since %s.\n\nWorst status so far: **%s**.\n\nIncident ID: `%s`",
+			rec.Tunnel, rec.TunnelID, rec.Start.UTC().Format(time.RFC1123), statusLabel(rec.Status), rec.ID),
+	}
+	if len(h.labels) > 0 {
+		issue["labels"] = h.labels
+	}
+	var created githubIssue
+	if err := doJSON(ctx, "POST", h.url("/issues"), h.headers(), issue, &created); err != nil {
+		return "", err
+	}
+	if created.HTMLURL == "" {
+		return "", fmt.Errorf("response did not include an issue URL")
+	}
+	return created.HTMLURL, nil
+}
+
+func (h *githubIssueHook) Resolve(ctx context.Context, rec incidentRecord, link string) error {
+	number := path.Base(link)
+	comment := fmt.Sprintf("Tunnel **%s** recovered at %s after %s. Worst status: **%s**.",
+		rec.Tunnel, rec.End.UTC().Format(time.RFC1123), formatElapsed(rec.End.Sub(rec.Start)), statusLabel(rec.Status))
+	if err := postJSON(ctx, h.url("/issues/%s/comments", number), h.headers(), map[string]string{"body": comment}); err != nil {
+		return err
+	}
+	return doJSON(ctx, "PATCH", h.url("/issues/%s", number), h.headers(),
+		map[string]string{"state": "closed", "state_reason": "completed"}, nil)
+}
+
+func (n *githubStatusNotifier) Name() string {
+	return "github status " + n.repo
+}
+
+func (n *githubStatusNotifier) Notify(ctx context.Context, event Event) error {
+	if event.Type != eventStatusChanged {
+		return nil
+	}
+	description := event.Title
+	if len(description) > 140 {
+		description = description[:140]
+	}
+
+	if n.sha != "" {
+		state, ok := commitStates[event.NewStatus]
+		if !ok {
+			state = "error"
+		}
+		status := map[string]string{
+			"state":       state,
+			"context":     "cftunnels/" + event.TunnelID,
+			"description": description,
+		}
+		if err := postJSON(ctx, n.url("/statuses/%s", n.sha), n.headers(), status); err != nil {
+			return fmt.Errorf("commit status: %w", err)
+		}
+	}
+	if n.deploymentID != "" {
+		state, ok := deploymentStates[event.NewStatus]
+		if !ok {
+			state = "error"
+		}
+		status := map[string]string{"state": state, "description": description}
+		if err := postJSON(ctx, n.url("/deployments/%s/statuses", n.deploymentID), n.headers(), status); err != nil {
+			return fmt.Errorf("deployment status: %w", err)
+		}
+	}
+	return nil
+}
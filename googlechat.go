@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// googleChatNotifier posts a card to a Google Chat space incoming webhook.
+type googleChatNotifier struct {
+	url string
+}
+
+// googleChatFromEnv reads GOOGLE_CHAT_WEBHOOK_URLS, a comma-separated list
+// of space webhook URLs.
+func googleChatFromEnv() []NotifierConfig {
+	return numberedConfigs("google_chat", "url", os.Getenv("GOOGLE_CHAT_WEBHOOK_URLS"), nil)
+}
+
+// newGoogleChatIntegration takes the webhook url setting.
+func newGoogleChatIntegration(settings map[string]string) (integration, error) {
+	if settings["url"] == "" {
+		return integration{}, fmt.Errorf("url is required")
+	}
+	return integration{notifier: &googleChatNotifier{url: settings["url"]}}, nil
+}
+
+func (n *googleChatNotifier) Name() string {
+	return "google chat"
+}
+
+func (n *googleChatNotifier) Notify(ctx context.Context, event Event) error {
+	widgets := []map[string]any{}
+	if transition := statusTransition(event); transition != "" {
+		widgets = append(widgets, map[string]any{
+			"decoratedText": map[string]any{"topLabel": "Status", "text": transition},
+		})
+	}
+	widgets = append(widgets, map[string]any{
+		"textParagraph": map[string]any{"text": event.Message},
+	})
+
+	payload := map[string]any{
+		"text": event.Title,
+		"cardsV2": []map[string]any{{
+			"cardId": event.ID,
+			"card": map[string]any{
+				"header":   map[string]any{"title": event.Title, "subtitle": event.TunnelID},
+				"sections": []map[string]any{{"widgets": widgets}},
+			},
+		}},
+	}
+	return postJSON(ctx, n.url, nil, payload)
+}
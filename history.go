@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/s3ansh33p/CFTunnels/internal/store"
+)
+
+// historyRetention is how far back samples are kept.
+const historyRetention = 90 * 24 * time.Hour
+
+// maxSampleSpan caps how long a sample is assumed to hold. Beyond it, for
+// example while the service was stopped, the status is treated as unknown
+// rather than extended indefinitely. It follows the poll interval.
+var maxSampleSpan = 3 * pollInterval
+
+// sample is one observation of a tunnel's status, recorded after every
+// successful poll.
+type sample = store.Sample
+
+// historyInterval is a run of samples with the same status, no more than
+// maxSampleSpan apart. Start is the first sample's time and End the last
+// one's; the status is taken to hold until the next interval starts or
+// maxSampleSpan after End, whichever is first.
+type historyInterval = store.Interval
+
+// incident is a contiguous period during which the tunnel was not healthy.
+// Status is the worst status observed during the period. End is zero while
+// the incident is still ongoing.
+type incident struct {
+	Start  time.Time
+	End    time.Time
+	Status string
+}
+
+// historyCompactAfter is how many interval updates are appended to
+// HISTORY_FILE before it is rewritten with one line per interval.
+const historyCompactAfter = 1000
+
+var (
+	historyFile string
+	// history holds every tunnel's intervals ordered by start.
+	history []historyInterval
+	// historyIndex maps each tunnel to the positions of its intervals in
+	// history, so looking one tunnel up does not scan every tunnel's.
+	historyIndex = map[string][]int{}
+	historyMu    sync.RWMutex
+	// historyWriteMu serialises writes to HISTORY_FILE and HISTORY_DB,
+	// which happen outside historyMu so readers do not wait on the disk.
+	// It is taken before historyMu, never while holding it.
+	historyWriteMu sync.Mutex
+	// historyAppended counts the lines appended since the file was last
+	// rewritten.
+	historyAppended int
+)
+
+// loadHistory reads HISTORY_FILE, a JSON-lines file with one interval per
+// line, and rewrites it without intervals older than historyRetention.
+// Later lines for the same tunnel and start update the interval's end.
+// Files of one sample per line, as written by earlier versions, are
+// converted. When HISTORY_FILE is unset, history is kept in memory only.
+// Samples written before multiple tunnels were supported belong to the
+// first configured tunnel. HISTORY_DB, an SQLite database path, replaces
+// HISTORY_FILE.
+func loadHistory() error {
+	historyFile = os.Getenv("HISTORY_FILE")
+	if path := os.Getenv("HISTORY_DB"); path != "" {
+		return loadHistoryDB(path)
+	}
+	if historyFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(historyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded, samples, err := readHistory(file)
+	if err != nil {
+		return fmt.Errorf("%s:%w", historyFile, err)
+	}
+	if samples > 0 {
+		slog.Info("History samples converted into intervals", "samples", samples, "file", historyFile, "intervals", len(loaded))
+	}
+
+	historyMu.Lock()
+	setHistory(loaded)
+	historyMu.Unlock()
+	historyWriteMu.Lock()
+	defer historyWriteMu.Unlock()
+	return rewriteHistory(loaded)
+}
+
+// legacyTunnelID is the tunnel of history lines without a tunnel ID,
+// written when only one tunnel was supported: the first in TUNNEL_ID or,
+// without it, in CONFIG_FILE.
+func legacyTunnelID() string {
+	tunnels := tunnelsFromEnv()
+	if len(tunnels) == 0 {
+		tunnels = fileConfig.Tunnels
+	}
+	if len(tunnels) == 0 {
+		return ""
+	}
+	return tunnels[0].ID
+}
+
+// readHistory parses history lines, folding samples into intervals, and
+// drops intervals older than historyRetention. It returns how many of the
+// lines were samples.
+func readHistory(r io.Reader) ([]historyInterval, int, error) {
+	intervals, samples, err := store.ReadLines(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	legacyID := legacyTunnelID()
+	for i := range intervals {
+		if intervals[i].TunnelID == "" {
+			intervals[i].TunnelID = legacyID
+		}
+	}
+	for i := range samples {
+		if samples[i].TunnelID == "" {
+			samples[i].TunnelID = legacyID
+		}
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	for _, s := range samples {
+		intervals, _ = appendSample(intervals, s)
+	}
+	sort.SliceStable(intervals, func(i, j int) bool { return intervals[i].Start.Before(intervals[j].Start) })
+
+	cutoff := time.Now().Add(-historyRetention)
+	kept := intervals[:0]
+	for _, in := range intervals {
+		if in.End.After(cutoff) {
+			kept = append(kept, in)
+		}
+	}
+	return kept, len(samples), nil
+}
+
+// appendSample extends the tunnel's latest interval with s, or starts a
+// new one when the status changed or the previous sample is too long ago.
+// It returns the interval s ended up in.
+func appendSample(intervals []historyInterval, s sample) ([]historyInterval, historyInterval) {
+	for i := len(intervals) - 1; i >= 0; i-- {
+		last := &intervals[i]
+		if last.TunnelID != s.TunnelID {
+			continue
+		}
+		if extendedBy(*last, s) {
+			last.End = s.Time
+			return intervals, *last
+		}
+		break
+	}
+	in := historyInterval{TunnelID: s.TunnelID, Status: s.Status, Start: s.Time, End: s.Time}
+	return append(intervals, in), in
+}
+
+// extendedBy reports whether s, the tunnel's next sample, continues the
+// interval rather than starting a new one.
+func extendedBy(in historyInterval, s sample) bool {
+	return in.Status == s.Status && !s.Time.Before(in.End) && s.Time.Sub(in.End) <= maxSampleSpan
+}
+
+// setHistory replaces the in-memory history and its index. historyMu must
+// be held.
+func setHistory(intervals []historyInterval) {
+	history = intervals
+	historyIndex = map[string][]int{}
+	for i, in := range history {
+		historyIndex[in.TunnelID] = append(historyIndex[in.TunnelID], i)
+	}
+}
+
+// rewriteHistory replaces HISTORY_FILE with intervals, one line each.
+// historyWriteMu must be held.
+func rewriteHistory(intervals []historyInterval) error {
+	if err := store.WriteFile(historyFile, intervals); err != nil {
+		return err
+	}
+	historyAppended = 0
+	return nil
+}
+
+// recordSample adds s to the in-memory history and, if configured, appends
+// the updated interval to HISTORY_FILE or saves it to HISTORY_DB. The file
+// is compacted, and old database rows pruned, every historyCompactAfter
+// appends.
+func recordSample(s sample) {
+	historyMu.Lock()
+	var in historyInterval
+	positions := historyIndex[s.TunnelID]
+	if n := len(positions); n > 0 && extendedBy(history[positions[n-1]], s) {
+		last := &history[positions[n-1]]
+		last.End = s.Time
+		in = *last
+	} else {
+		in = historyInterval{TunnelID: s.TunnelID, Status: s.Status, Start: s.Time, End: s.Time}
+		historyIndex[s.TunnelID] = append(positions, len(history))
+		history = append(history, in)
+	}
+	cutoff := s.Time.Add(-historyRetention)
+	drop := 0
+	for drop < len(history) && history[drop].End.Before(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		setHistory(history[drop:])
+	}
+	historyMu.Unlock()
+	persistInterval(in, s.Time)
+}
+
+// persistInterval writes in, a tunnel's updated interval, to HISTORY_DB or
+// HISTORY_FILE.
+func persistInterval(in historyInterval, now time.Time) {
+	historyWriteMu.Lock()
+	defer historyWriteMu.Unlock()
+	if historyDB != nil {
+		err := historyDB.SaveIntervals([]historyInterval{in})
+		if err == nil && historyAppended >= historyCompactAfter {
+			historyAppended = 0
+			err = pruneHistoryDB(now)
+		}
+		if err != nil {
+			slog.Error("Error writing history database", "error", err)
+		}
+		monitorResult("history database", err)
+		historyAppended++
+		return
+	}
+	if historyFile == "" {
+		return
+	}
+	if historyAppended >= historyCompactAfter {
+		err := rewriteHistory(historySnapshot())
+		if err != nil {
+			slog.Error("Error compacting history file", "error", err)
+		}
+		monitorResult("history file", err)
+		return
+	}
+	err := store.AppendFile(historyFile, in)
+	if err != nil {
+		slog.Error("Error writing history file", "error", err)
+	}
+	monitorResult("history file", err)
+	historyAppended++
+}
+
+// historySnapshot returns a copy of every tunnel's intervals.
+func historySnapshot() []historyInterval {
+	historyMu.RLock()
+	defer historyMu.RUnlock()
+	return slices.Clone(history)
+}
+
+// lastRecordedStatus returns the status of a tunnel's newest interval,
+// which lets status changes be detected across restarts.
+func lastRecordedStatus(tunnelID string) string {
+	historyMu.RLock()
+	defer historyMu.RUnlock()
+	if positions := historyIndex[tunnelID]; len(positions) > 0 {
+		return history[positions[len(positions)-1]].Status
+	}
+	return ""
+}
+
+// tunnelHistory returns a tunnel's intervals, oldest first.
+func tunnelHistory(tunnelID string) []historyInterval {
+	historyMu.RLock()
+	defer historyMu.RUnlock()
+	var intervals []historyInterval
+	for _, i := range historyIndex[tunnelID] {
+		intervals = append(intervals, history[i])
+	}
+	return intervals
+}
+
+// statusSince returns when the tunnel's current run of status began, as
+// far as the history goes, or the zero time if its newest interval has
+// another status. Unlike since(), which follows the connections, this
+// changes whenever the status does, e.g. from degraded to healthy.
+func statusSince(tunnelID, status string) time.Time {
+	historyMu.RLock()
+	defer historyMu.RUnlock()
+	positions := historyIndex[tunnelID]
+	if len(positions) > 0 {
+		if in := history[positions[len(positions)-1]]; in.Status == status {
+			return in.Start
+		}
+	}
+	return time.Time{}
+}
+
+// historySpans calls fn for each of a tunnel's intervals overlapping
+// [from, to) with the portion of the window it covers.
+func historySpans(tunnelID string, from, to time.Time, fn func(status string, start, end time.Time)) {
+	intervals := tunnelHistory(tunnelID)
+	for i, in := range intervals {
+		end := in.End.Add(maxSampleSpan)
+		if i+1 < len(intervals) && intervals[i+1].Start.Before(end) {
+			end = intervals[i+1].Start
+		}
+		start := in.Start
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			fn(in.Status, start, end)
+		}
+	}
+}
+
+// isAvailable reports whether a status counts as up for availability. A
+// degraded tunnel still serves traffic.
+func isAvailable(status string) bool {
+	return status == "healthy" || status == "degraded"
+}
+
+// availability returns the fraction of observed time in [from, to) during
+// which the tunnel was available, after exclusions. ok is false when there
+// is no data.
+func availability(tunnelID string, from, to time.Time) (ratio float64, ok bool) {
+	var up, observed time.Duration
+	availabilitySpans(tunnelID, from, to, func(status string, start, end time.Time) {
+		observed += end.Sub(start)
+		if isAvailable(status) {
+			up += end.Sub(start)
+		}
+	})
+	if observed == 0 {
+		return 0, false
+	}
+	return float64(up) / float64(observed), true
+}
+
+// availabilityWindows are the standard windows availability is shown for
+// on the status page and in the status API.
+var availabilityWindows = []struct {
+	Label  string
+	Length time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"90d", 90 * 24 * time.Hour},
+}
+
+// windowAvailability is a tunnel's availability over one of the
+// availabilityWindows ending now.
+type windowAvailability struct {
+	Label string
+	Ratio float64
+	OK    bool
+}
+
+func windowAvailabilities(tunnelID string, now time.Time) []windowAvailability {
+	var windows []windowAvailability
+	for _, w := range availabilityWindows {
+		ratio, ok := availability(tunnelID, now.Add(-w.Length), now)
+		windows = append(windows, windowAvailability{Label: w.Label, Ratio: ratio, OK: ok})
+	}
+	return windows
+}
+
+// statusSeverity orders statuses from best to worst.
+func statusSeverity(status string) int {
+	switch status {
+	case "healthy":
+		return 0
+	case "degraded":
+		return 1
+	case "inactive":
+		return 2
+	case "down":
+		return 3
+	default:
+		return 1
+	}
+}
+
+// incidentsBetween returns a tunnel's incidents overlapping [from, to),
+// newest first. Gaps in the data end an incident.
+func incidentsBetween(tunnelID string, from, to time.Time) []incident {
+	var incidents []incident
+	var current *incident
+	historySpans(tunnelID, from, to, func(status string, start, end time.Time) {
+		if status == "healthy" {
+			current = nil
+			return
+		}
+		if current != nil && !current.End.Before(start) {
+			current.End = end
+			if statusSeverity(status) > statusSeverity(current.Status) {
+				current.Status = status
+			}
+			return
+		}
+		incidents = append(incidents, incident{Start: start, End: end, Status: status})
+		current = &incidents[len(incidents)-1]
+	})
+
+	if n := len(incidents); n > 0 {
+		t, _ := findTunnel(tunnelID)
+		ongoing := t.Status != "healthy"
+		if last := &incidents[n-1]; ongoing && !last.End.Before(to) {
+			last.End = time.Time{}
+		}
+	}
+
+	for i, j := 0, len(incidents)-1; i < j; i, j = i+1, j-1 {
+		incidents[i], incidents[j] = incidents[j], incidents[i]
+	}
+	return incidents
+}
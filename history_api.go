@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// statusInterval is a period during which a tunnel held one status. Time
+// between intervals was not observed.
+type statusInterval struct {
+	Status string    `json:"status"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+type statusAtResponse struct {
+	TunnelID string          `json:"tunnel_id"`
+	At       time.Time       `json:"at"`
+	Status   string          `json:"status"`
+	Interval *statusInterval `json:"interval,omitempty"`
+}
+
+type intervalsResponse struct {
+	TunnelID  string           `json:"tunnel_id"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Intervals []statusInterval `json:"intervals"`
+}
+
+// statusIntervals returns the tunnel's status intervals overlapping
+// [from, to), clipped to it, with adjacent spans of the same status
+// merged.
+func statusIntervals(tunnelID string, from, to time.Time) []statusInterval {
+	intervals := []statusInterval{}
+	historySpans(tunnelID, from, to, func(status string, start, end time.Time) {
+		if n := len(intervals); n > 0 && intervals[n-1].Status == status && intervals[n-1].End.Equal(start) {
+			intervals[n-1].End = end
+			return
+		}
+		intervals = append(intervals, statusInterval{Status: status, Start: start, End: end})
+	})
+	return intervals
+}
+
+// statusAt returns the whole interval containing at, if the tunnel was
+// observed then.
+func statusAt(tunnelID string, at time.Time) (statusInterval, bool) {
+	for _, in := range statusIntervals(tunnelID, at.Add(-historyRetention), time.Now()) {
+		if !at.Before(in.Start) && at.Before(in.End) {
+			return in, true
+		}
+	}
+	return statusInterval{}, false
+}
+
+// knownTunnel reports whether id is monitored now or has history, so
+// removed tunnels can still be audited.
+func knownTunnel(id string) bool {
+	if _, ok := findTunnel(id); ok {
+		return true
+	}
+	return len(tunnelHistory(id)) > 0
+}
+
+// resolveTunnelID returns the real ID of a configured tunnel named by its
+// pseudonym, and any other ID unchanged.
+func resolveTunnelID(id string) string {
+	if t, ok := findTunnel(id); ok {
+		return t.ID
+	}
+	return id
+}
+
+// parseTimeParam reads an RFC 3339 query parameter, returning def when it
+// is absent.
+func parseTimeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+// statusAtHandler serves GET /api/tunnels/{id}/status?at=<time>: the
+// tunnel's status at that moment and the interval it belongs to, or
+// "unknown" if it was not observed. at defaults to now.
+func statusAtHandler(w http.ResponseWriter, r *http.Request) {
+	id := resolveTunnelID(r.PathValue("id"))
+	if !knownTunnel(id) {
+		writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", r.PathValue("id")))
+		return
+	}
+	at, err := parseTimeParam(r, "at", time.Now())
+	if err != nil {
+		writeProblem(w, r, problemInvalidParameter, err.Error())
+		return
+	}
+
+	response := statusAtResponse{TunnelID: visibleTunnelID(r, id), At: at, Status: "unknown"}
+	if in, ok := statusAt(id, at); ok {
+		response.Status = in.Status
+		response.Interval = &in
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// intervalsHandler serves GET /api/tunnels/{id}/intervals: the status
+// intervals between from and to (default the retained history up to now),
+// optionally only those whose status is in the comma-separated status
+// parameter, or that overlap an incident carrying the tag parameter. Long
+// histories can be paged with limit and cursor, as in listQuery.
+func intervalsHandler(w http.ResponseWriter, r *http.Request) {
+	id := resolveTunnelID(r.PathValue("id"))
+	if !knownTunnel(id) {
+		writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", r.PathValue("id")))
+		return
+	}
+	now := time.Now()
+	from, err := parseTimeParam(r, "from", now.Add(-historyRetention))
+	to := now
+	if err == nil {
+		to, err = parseTimeParam(r, "to", now)
+	}
+	if err != nil {
+		writeProblem(w, r, problemInvalidParameter, err.Error())
+		return
+	}
+	if !from.Before(to) {
+		writeProblem(w, r, problemInvalidParameter, "from must be before to")
+		return
+	}
+
+	wanted := map[string]bool{}
+	for _, status := range splitList(r.URL.Query().Get("status")) {
+		if !validStatus(status) {
+			writeProblem(w, r, problemInvalidParameter, fmt.Sprintf("status: unknown status %q (available: healthy, degraded, inactive, down)", status))
+			return
+		}
+		wanted[status] = true
+	}
+
+	tag := normalizeTag(r.URL.Query().Get("tag"))
+
+	response := intervalsResponse{TunnelID: visibleTunnelID(r, id), From: from, To: to, Intervals: []statusInterval{}}
+	for _, in := range statusIntervals(id, from, to) {
+		if (len(wanted) == 0 || wanted[in.Status]) && (tag == "" || incidentTaggedDuring(id, tag, in.Start, in.End)) {
+			response.Intervals = append(response.Intervals, in)
+		}
+	}
+	page, ok := pageList(w, r, response.Intervals, listSpec{key: "start", defaultSort: "start"})
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if page != nil {
+		json.NewEncoder(w).Encode(page.replace(response, "intervals"))
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
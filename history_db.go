@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/s3ansh33p/CFTunnels/internal/store"
+)
+
+// historyDB, when HISTORY_DB is set, stores history intervals and status
+// transitions instead of HISTORY_FILE, and the responses to admin
+// requests with an Idempotency-Key.
+var historyDB *store.DB
+
+// loadHistoryDB opens the database at path, drops rows older than
+// historyRetention and loads the intervals. An empty database imports
+// HISTORY_FILE, if there is one, which is then no longer written.
+func loadHistoryDB(path string) error {
+	db, err := store.OpenDB(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	historyDB = db
+	if err := pruneHistoryDB(time.Now()); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	loaded, err := historyDB.Intervals()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(loaded) == 0 && historyFile != "" {
+		loaded, err = importHistoryFile()
+		if err != nil {
+			return err
+		}
+	}
+	historyFile = ""
+
+	historyMu.Lock()
+	setHistory(loaded)
+	historyMu.Unlock()
+	return nil
+}
+
+func importHistoryFile() ([]historyInterval, error) {
+	file, err := os.Open(historyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	loaded, _, err := readHistory(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", historyFile, err)
+	}
+	if err := historyDB.SaveIntervals(loaded); err != nil {
+		return nil, err
+	}
+	slog.Info("History imported into the history database", "intervals", len(loaded), "file", historyFile)
+	return loaded, nil
+}
+
+// pruneHistoryDB deletes intervals and transitions older than
+// historyRetention.
+func pruneHistoryDB(now time.Time) error {
+	return historyDB.Prune(now.Add(-historyRetention))
+}
+
+// recordTransition stores a tunnel's status change in HISTORY_DB, if it
+// is set.
+func recordTransition(tunnelID, oldStatus, newStatus string, at time.Time) {
+	historyWriteMu.Lock()
+	defer historyWriteMu.Unlock()
+	if historyDB == nil {
+		return
+	}
+	if err := historyDB.RecordTransition(tunnelID, oldStatus, newStatus, at); err != nil {
+		slog.Error("Error writing status transition to the history database", "tunnel_id", tunnelID, "error", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/s3ansh33p/CFTunnels/internal/store"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed for its key.
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKey bounds the length of an Idempotency-Key.
+	maxIdempotencyKey = 255
+)
+
+// idempotentResponse is the response first given to a request with an
+// Idempotency-Key, replayed when the request is retried.
+type idempotentResponse struct {
+	// fingerprint identifies the request, so a key reused for another
+	// request is rejected rather than answered with the wrong response.
+	fingerprint string
+	status      int
+	contentType string
+	location    string
+	body        []byte
+	created     time.Time
+}
+
+var (
+	// idempotencyResponses holds the responses when there is no
+	// HISTORY_DB; they are then forgotten on restart.
+	idempotencyResponses = map[string]idempotentResponse{}
+	// idempotencyPending holds the keys whose first request is running.
+	idempotencyPending = map[string]bool{}
+	idempotencyMu      sync.Mutex
+)
+
+// idempotent makes a mutating request with an Idempotency-Key header run
+// once: a retry with the same key and request gets the stored response,
+// with Idempotent-Replayed: true, for idempotencyTTL. Server errors are
+// not stored, so those requests can be retried. Requests without the
+// header, and reads, are passed through.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeProblem(w, r, problemInvalidRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKey))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigSize+1))
+		if err != nil {
+			writeProblem(w, r, problemInvalidRequest, "reading body: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + string(body)))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		idempotencyMu.Lock()
+		if idempotencyPending[key] {
+			idempotencyMu.Unlock()
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, r, problemIdempotencyPending, "a request with this Idempotency-Key is still being processed")
+			return
+		}
+		stored, found, err := loadIdempotentResponse(key, time.Now())
+		if err == nil && !found {
+			idempotencyPending[key] = true
+		}
+		idempotencyMu.Unlock()
+		switch {
+		case err != nil:
+			writeProblem(w, r, problemInternal, "reading idempotency key: "+err.Error())
+			return
+		case found && stored.fingerprint != fingerprint:
+			writeProblem(w, r, problemIdempotencyMismatch, "this Idempotency-Key was used for a different request; use a new key for each request")
+			return
+		case found:
+			stored.replay(w)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			idempotencyMu.Lock()
+			defer idempotencyMu.Unlock()
+			delete(idempotencyPending, key)
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			if recorder.status >= 500 || recorder.status == http.StatusTooManyRequests {
+				return
+			}
+			response := idempotentResponse{
+				fingerprint: fingerprint,
+				status:      recorder.status,
+				contentType: w.Header().Get("Content-Type"),
+				location:    w.Header().Get("Location"),
+				body:        recorder.body.Bytes(),
+				created:     time.Now(),
+			}
+			if err := saveIdempotentResponse(key, response); err != nil {
+				slog.Error("Error saving idempotency key", "error", err)
+			}
+		}()
+		next(recorder, r)
+	}
+}
+
+func (resp idempotentResponse) replay(w http.ResponseWriter) {
+	if resp.contentType != "" {
+		w.Header().Set("Content-Type", resp.contentType)
+	}
+	if resp.location != "" {
+		w.Header().Set("Location", resp.location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// loadIdempotentResponse returns the unexpired response stored for key.
+// The caller holds idempotencyMu.
+func loadIdempotentResponse(key string, now time.Time) (idempotentResponse, bool, error) {
+	if historyDB == nil {
+		resp, ok := idempotencyResponses[key]
+		return resp, ok && now.Sub(resp.created) < idempotencyTTL, nil
+	}
+	stored, ok, err := historyDB.Response(key, now.Add(-idempotencyTTL))
+	return idempotentResponse{
+		fingerprint: stored.Fingerprint,
+		status:      stored.Status,
+		contentType: stored.ContentType,
+		location:    stored.Location,
+		body:        stored.Body,
+		created:     stored.Created,
+	}, ok, err
+}
+
+// saveIdempotentResponse stores the response for key and drops expired
+// ones. The caller holds idempotencyMu.
+func saveIdempotentResponse(key string, resp idempotentResponse) error {
+	cutoff := resp.created.Add(-idempotencyTTL)
+	if historyDB == nil {
+		for k, stored := range idempotencyResponses {
+			if stored.created.Before(cutoff) {
+				delete(idempotencyResponses, k)
+			}
+		}
+		idempotencyResponses[key] = resp
+		return nil
+	}
+	return historyDB.SaveResponse(key, store.Response{
+		Fingerprint: resp.fingerprint,
+		Status:      resp.status,
+		ContentType: resp.contentType,
+		Location:    resp.location,
+		Body:        resp.body,
+		Created:     resp.created,
+	}, cutoff)
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// incidentEntry is one line of an incident's public timeline.
+type incidentEntry struct {
+	Time time.Time
+	// Kind is status, acknowledged, update or resolved.
+	Kind string
+	Text string
+}
+
+type incidentPageData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Incident       incidentRecord
+	// Tunnel is the affected tunnel's current display name, and Group
+	// its component group; TunnelKnown is false once it was removed.
+	Tunnel      string
+	Group       string
+	TunnelKnown bool
+	Duration    string
+	Timeline    []incidentEntry
+	// Hint is the region hint for the viewer while the incident is open.
+	Hint string
+}
+
+var incidentPageTemplate = template.Must(template.New("incident").Funcs(template.FuncMap{
+	"datetime":   func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+	"tunnelPath": tunnelPath,
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Tunnel}} {{.Incident.Status}} since {{datetime .Incident.Start}} - Incident</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>{{.Tunnel}}: {{.Incident.Status}}</h1>
+			<p>{{if .Incident.End}}Resolved{{else}}Ongoing{{end}} &middot; <a href="/incidents">All incidents</a> &middot; <a href="/">Back to status page</a></p>
+		</header>
+		{{with .Hint}}<p class="viewer-hint" role="status">{{.}}</p>{{end}}
+		<section aria-labelledby="summary-heading">
+			<h2 id="summary-heading">Summary</h2>
+			<p>Started {{datetime .Incident.Start}}{{with .Incident.End}}, resolved {{datetime .}}{{end}}, {{if .Incident.End}}lasting{{else}}so far{{end}} {{.Duration}}.</p>
+			<p>Worst status: {{.Incident.Status}}</p>
+			<p>Affected: {{if .TunnelKnown}}<a href="{{tunnelPath .Incident.TunnelID}}">{{.Tunnel}}</a>{{else}}{{.Tunnel}}{{end}}{{if .Group}} ({{.Group}}){{end}}</p>
+		</section>
+		<section aria-labelledby="timeline-heading">
+			<h2 id="timeline-heading">Timeline</h2>
+			<ol class="incident-timeline">
+				{{range .Timeline}}<li class="incident-{{.Kind}}"><time datetime="{{.Time.UTC.Format "2006-01-02T15:04:05Z07:00"}}">{{datetime .Time}}</time>: {{.Text}}</li>
+				{{end}}
+			</ol>
+		</section>
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// incidentPath is the permalink page of an incident record: a readable
+// slug of its date, tunnel and status, then its ID, which alone finds it.
+func incidentPath(rec incidentRecord) string {
+	slug := slugify(rec.Start.UTC().Format(time.DateOnly) + " " + rec.Tunnel + " " + rec.Status)
+	return "/incidents/" + url.PathEscape(slug+"-"+rec.ID)
+}
+
+// findIncidentRecord returns a copy of the incident record with the
+// given ID.
+func findIncidentRecord(id string) (incidentRecord, bool) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	for _, rec := range incidentRecords {
+		if rec.ID == id {
+			return *rec, true
+		}
+	}
+	return incidentRecord{}, false
+}
+
+// incidentRecordFor returns the tunnel's incident record that overlaps
+// [start, end), linking an outage derived from the history to its
+// permalink. end is zero for an ongoing outage.
+func incidentRecordFor(tunnelID string, start, end time.Time) (incidentRecord, bool) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	for _, rec := range incidentRecords {
+		if rec.TunnelID != tunnelID || (!end.IsZero() && !rec.Start.Before(end)) {
+			continue
+		}
+		if rec.End == nil || !rec.End.Before(start) {
+			return *rec, true
+		}
+	}
+	return incidentRecord{}, false
+}
+
+// incidentTimeline is the record's public story: its status changes from
+// the history, its acknowledgement, the public notes and the recovery.
+// Who acknowledged it and the private notes are left out.
+func incidentTimeline(rec incidentRecord) []incidentEntry {
+	var entries []incidentEntry
+	previous := ""
+	for _, in := range statusIntervals(rec.TunnelID, rec.Start, incidentEnd(rec)) {
+		if in.Status == previous || in.Status == "healthy" {
+			continue
+		}
+		text := "Status changed to " + statusLabel(in.Status)
+		if previous == "" {
+			text = "Incident opened: " + statusLabel(in.Status)
+		}
+		entries = append(entries, incidentEntry{Time: in.Start, Kind: "status", Text: text})
+		previous = in.Status
+	}
+	if len(entries) == 0 {
+		entries = append(entries, incidentEntry{Time: rec.Start, Kind: "status", Text: "Incident opened: " + statusLabel(rec.Status)})
+	}
+	if rec.AckedAt != nil {
+		entries = append(entries, incidentEntry{Time: *rec.AckedAt, Kind: "acknowledged", Text: "Acknowledged; the team is looking into it"})
+	}
+	for _, note := range rec.Notes {
+		if note.Public {
+			entries = append(entries, incidentEntry{Time: note.Time, Kind: "update", Text: note.Text})
+		}
+	}
+	if rec.End != nil {
+		entries = append(entries, incidentEntry{Time: *rec.End, Kind: "resolved", Text: "Resolved"})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries
+}
+
+// incidentPageHandler serves /incidents/{id}, the permalink of one
+// incident record for postmortems and customer updates. Any slug before
+// the ID redirects to the current one.
+func incidentPageHandler(w http.ResponseWriter, r *http.Request) {
+	value := r.PathValue("id")
+	rec, ok := findIncidentRecord(value[strings.LastIndex(value, "-")+1:])
+	if !ok {
+		http.Error(w, "unknown incident; records are kept for "+formatElapsed(incidentRecordRetention), http.StatusNotFound)
+		return
+	}
+	if path := incidentPath(rec); r.URL.EscapedPath() != path {
+		http.Redirect(w, r, path, http.StatusMovedPermanently)
+		return
+	}
+	high := highContrast(w, r)
+	data := incidentPageData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Incident:       rec,
+		Tunnel:         rec.Tunnel,
+		Duration:       formatElapsed(incidentEnd(rec).Sub(rec.Start)),
+		Timeline:       incidentTimeline(rec),
+	}
+	if t, ok := findTunnel(rec.TunnelID); ok {
+		data.Tunnel, data.Group, data.TunnelKnown = t.label(), t.Group, true
+	}
+	if rec.End == nil {
+		if hint, ok := hintFor(rec, viewerRegions(viewerCountry(r))); ok {
+			data.Hint = hint.Text
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := incidentPageTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering incident page", "error", err)
+	}
+}
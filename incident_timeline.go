@@ -0,0 +1,123 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type timelineIncident struct {
+	// Permalink is the incident record's page; empty once the record has
+	// expired.
+	Permalink string
+	TunnelID  string
+	Tunnel    string
+	Start     time.Time
+	End       time.Time
+	Duration  string
+	Status    string
+}
+
+type timelineData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	Days           int
+	// Tunnel is set when the page is limited to one tunnel.
+	Tunnel    string
+	Incidents []timelineIncident
+}
+
+var timelineTemplate = template.Must(template.New("incidents").Funcs(template.FuncMap{
+	"datetime":   func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+	"tunnelPath": tunnelPath,
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Incidents</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Incidents</h1>
+			<p>Outages of {{if .Tunnel}}{{.Tunnel}}{{else}}every tunnel{{end}} over the last {{.Days}} days, newest first. <a href="/">Back to status page</a>{{if .Tunnel}} &middot; <a href="/incidents">All tunnels</a>{{end}}</p>
+		</header>
+		{{if .Incidents}}
+		<table>
+			<thead><tr><th scope="col">Tunnel</th><th scope="col">Start</th><th scope="col">End</th><th scope="col">Duration</th><th scope="col">Worst status</th></tr></thead>
+			<tbody>
+			{{range .Incidents}}<tr>
+				<td><a href="{{tunnelPath .TunnelID}}">{{.Tunnel}}</a></td>
+				<td>{{if .Permalink}}<a href="{{.Permalink}}">{{datetime .Start}}</a>{{else}}{{datetime .Start}}{{end}}</td>
+				<td>{{if .End.IsZero}}Ongoing{{else}}{{datetime .End}}{{end}}</td>
+				<td>{{.Duration}}</td>
+				<td>{{.Status}}</td>
+			</tr>
+			{{end}}
+			</tbody>
+		</table>
+		{{else}}
+		<p>No outages recorded in the last {{.Days}} days.</p>
+		{{end}}
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// incidentTimelineHandler serves /incidents: every outage in the history,
+// derived from the recorded statuses, with when it started and ended and
+// how long it lasted. ?tunnel=<id> limits it to one tunnel.
+func incidentTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	from := now.Add(-historyRetention)
+	high := highContrast(w, r)
+	list := snapshotTunnels()
+	data := timelineData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Days:           int(historyRetention / (24 * time.Hour)),
+	}
+	if id := r.URL.Query().Get("tunnel"); id != "" {
+		t, ok := findTunnel(id)
+		if !ok {
+			http.Error(w, "unknown tunnel", http.StatusNotFound)
+			return
+		}
+		list = []tunnelState{t}
+		data.Tunnel = t.label()
+	}
+
+	for _, t := range list {
+		for _, inc := range incidentsBetween(t.ID, from, now) {
+			end := inc.End
+			if end.IsZero() {
+				end = now
+			}
+			permalink := ""
+			if rec, ok := incidentRecordFor(t.ID, inc.Start, inc.End); ok {
+				permalink = incidentPath(rec)
+			}
+			data.Incidents = append(data.Incidents, timelineIncident{
+				Permalink: permalink,
+				TunnelID:  t.ID,
+				Tunnel:    t.label(),
+				Start:     inc.Start,
+				End:       inc.End,
+				Duration:  formatElapsed(end.Sub(inc.Start)),
+				Status:    statusLabel(inc.Status),
+			})
+		}
+	}
+	sort.Slice(data.Incidents, func(i, j int) bool { return data.Incidents[i].Start.After(data.Incidents[j].Start) })
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := timelineTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering incidents", "error", err)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incidentRecordRetention is how long closed incident records are kept.
+const incidentRecordRetention = historyRetention
+
+// incidentRecord is an outage tracked as it happens. Unlike the incidents
+// derived from history, records carry links to tickets opened in external
+// systems, keyed by the hook that opened them, and the notes and tags
+// operators attach to them.
+type incidentRecord struct {
+	ID       string            `json:"id"`
+	TunnelID string            `json:"tunnel_id"`
+	Tunnel   string            `json:"tunnel"`
+	Start    time.Time         `json:"start"`
+	End      *time.Time        `json:"end,omitempty"`
+	Status   string            `json:"status"`
+	Links    map[string]string `json:"links,omitempty"`
+	// Resolved lists hooks that have been told about the recovery.
+	Resolved map[string]bool `json:"resolved,omitempty"`
+	Tags     []string        `json:"tags,omitempty"`
+	Notes    []incidentNote  `json:"notes,omitempty"`
+	// AckedBy is whoever acknowledged the incident, which mutes its
+	// further alerts until the tunnel recovers.
+	AckedBy string     `json:"acked_by,omitempty"`
+	AckedAt *time.Time `json:"acked_at,omitempty"`
+	// PeakViewers is the most live status pages open at once during the
+	// incident, a rough measure of who noticed; Views counts the page
+	// views with PAGE_ANALYTICS.
+	PeakViewers   int        `json:"peak_viewers,omitempty"`
+	PeakViewersAt *time.Time `json:"peak_viewers_at,omitempty"`
+	Views         int        `json:"views,omitempty"`
+	// RegionHints are what viewers in a country or GEOIP_REGIONS region
+	// are told while the incident is open; an empty text says whether the
+	// tunnel's regions include theirs.
+	RegionHints map[string]string `json:"region_hints,omitempty"`
+}
+
+// incidentHook opens a ticket in an external system once an outage has
+// lasted longer than its threshold, and updates it when the outage ends.
+type incidentHook interface {
+	Name() string
+	Threshold() time.Duration
+	// Open creates the ticket and returns a URL linking to it.
+	Open(ctx context.Context, rec incidentRecord) (string, error)
+	// Resolve records the recovery on the ticket Open returned.
+	Resolve(ctx context.Context, rec incidentRecord, link string) error
+}
+
+// configuredHook is an incident hook under the name of the notifier that
+// configured it. The name keys its links and resolutions on incident
+// records, so two hooks of one type keep separate tickets; Name is the
+// hook's type.
+type configuredHook struct {
+	name string
+	incidentHook
+}
+
+var (
+	incidentsFile   string
+	incidentRecords []*incidentRecord
+	incidentsMu     sync.Mutex
+	incidentHooks   []configuredHook
+	incidentHooksMu sync.Mutex
+)
+
+// loadIncidents reads INCIDENTS_FILE, where incident records are kept
+// between restarts. Without it records are kept in memory only.
+func loadIncidents() error {
+	incidentsFile = os.Getenv("INCIDENTS_FILE")
+	if incidentsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(incidentsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &incidentRecords)
+}
+
+// saveIncidents writes the records to INCIDENTS_FILE. Callers hold
+// incidentsMu.
+func saveIncidents() {
+	if incidentsFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(incidentRecords, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding incidents", "error", err)
+		monitorFailure("incidents file", err)
+		return
+	}
+	tmp := incidentsFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		slog.Error("Error writing incidents file", "error", err)
+		monitorFailure("incidents file", err)
+		return
+	}
+	err = os.Rename(tmp, incidentsFile)
+	if err != nil {
+		slog.Error("Error writing incidents file", "error", err)
+	}
+	monitorResult("incidents file", err)
+}
+
+func openIncident(tunnelID string) *incidentRecord {
+	for _, rec := range incidentRecords {
+		if rec.TunnelID == tunnelID && rec.End == nil {
+			return rec
+		}
+	}
+	return nil
+}
+
+// trackIncident opens, updates or closes the incident record for a tunnel
+// after a poll, then gives the hooks a chance to act on it.
+func trackIncident(tunnelID, tunnel, tunnelStatus string, at time.Time) {
+	incidentsMu.Lock()
+	rec := openIncident(tunnelID)
+	changed := false
+	switch {
+	case tunnelStatus == statusUnknown:
+		// Nothing is known either way, e.g. the poll failed; the record
+		// stays as it is until a poll succeeds.
+	case tunnelStatus != "healthy" && rec == nil:
+		rec = &incidentRecord{
+			ID:       newEventID(),
+			TunnelID: tunnelID,
+			Tunnel:   tunnel,
+			Start:    at,
+			Status:   tunnelStatus,
+		}
+		if viewers := liveViewers(); viewers > 0 {
+			rec.PeakViewers, rec.PeakViewersAt = viewers, &at
+		}
+		incidentRecords = append(incidentRecords, rec)
+		changed = true
+	case tunnelStatus != "healthy":
+		if statusSeverity(tunnelStatus) > statusSeverity(rec.Status) {
+			rec.Status = tunnelStatus
+			changed = true
+		}
+	case rec != nil:
+		end := at
+		rec.End = &end
+		changed = true
+	}
+
+	cutoff := at.Add(-incidentRecordRetention)
+	kept := incidentRecords[:0]
+	for _, r := range incidentRecords {
+		if r.End == nil || r.End.After(cutoff) {
+			kept = append(kept, r)
+		} else {
+			changed = true
+		}
+	}
+	incidentRecords = kept
+	if changed {
+		saveIncidents()
+	}
+	incidentsMu.Unlock()
+
+	notifiersMu.RLock()
+	hooks := len(incidentHooks)
+	notifiersMu.RUnlock()
+	if hooks > 0 {
+		go runIncidentHooks(at)
+	}
+}
+
+// runIncidentHooks opens tickets for incidents past each hook's threshold
+// and resolves tickets for incidents that have ended. Failed calls are
+// retried on the next poll. Only one run is active at a time.
+func runIncidentHooks(now time.Time) {
+	if !incidentHooksMu.TryLock() {
+		return
+	}
+	defer incidentHooksMu.Unlock()
+
+	notifiersMu.RLock()
+	hooks := incidentHooks
+	notifiersMu.RUnlock()
+
+	incidentsMu.Lock()
+	var pending []incidentRecord
+	for _, rec := range incidentRecords {
+		pending = append(pending, *rec)
+	}
+	incidentsMu.Unlock()
+
+	for _, rec := range pending {
+		for _, hook := range hooks {
+			name := hook.name
+			link, opened := rec.Links[name]
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			switch {
+			case !opened && rec.End == nil && now.Sub(rec.Start) >= hook.Threshold():
+				link, err := hook.Open(ctx, rec)
+				if err != nil {
+					slog.Error("Error opening incident ticket", "hook", name, "incident_id", rec.ID, "tunnel_id", rec.TunnelID, "error", err)
+				} else {
+					updateIncident(rec.ID, func(r *incidentRecord) {
+						if r.Links == nil {
+							r.Links = map[string]string{}
+						}
+						r.Links[name] = link
+					})
+				}
+			case opened && rec.End != nil && !rec.Resolved[name]:
+				if err := hook.Resolve(ctx, rec, link); err != nil {
+					slog.Error("Error resolving incident ticket", "hook", name, "incident_id", rec.ID, "tunnel_id", rec.TunnelID, "error", err)
+				} else {
+					updateIncident(rec.ID, func(r *incidentRecord) {
+						if r.Resolved == nil {
+							r.Resolved = map[string]bool{}
+						}
+						r.Resolved[name] = true
+					})
+				}
+			}
+			cancel()
+		}
+	}
+}
+
+func updateIncident(id string, fn func(*incidentRecord)) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	for _, rec := range incidentRecords {
+		if rec.ID == id {
+			fn(rec)
+			saveIncidents()
+			return
+		}
+	}
+}
+
+// incidentAcknowledger is an incident hook whose tickets can be
+// acknowledged, such as a pager alert, so an acknowledgement made here
+// stops the paging too.
+type incidentAcknowledger interface {
+	Acknowledge(ctx context.Context, rec incidentRecord, link string) error
+}
+
+// acknowledgeIncident marks the tunnel's open incident as acknowledged by
+// actor, returning it, or false when the tunnel has no open incident.
+func acknowledgeIncident(tunnelID, actor, iface string) (incidentRecord, bool) {
+	incidentsMu.Lock()
+	rec := openIncident(tunnelID)
+	incidentsMu.Unlock()
+	if rec == nil {
+		return incidentRecord{}, false
+	}
+	return acknowledgeRecord(rec.ID, actor, iface)
+}
+
+// acknowledgeRecord marks the open record with the given id as
+// acknowledged by actor through iface. The first acknowledgement is passed
+// on to the other hooks' tickets; later ones change nothing, so a pager
+// echoing it back does not loop.
+func acknowledgeRecord(id, actor, iface string) (incidentRecord, bool) {
+	var acked incidentRecord
+	found, changed := false, false
+	updateIncident(id, func(rec *incidentRecord) {
+		found = true
+		if rec.End == nil && rec.AckedBy == "" {
+			now := time.Now()
+			rec.AckedBy, rec.AckedAt = actor, &now
+			changed = true
+		}
+		acked = *rec
+	})
+	if changed {
+		recordAction(acked.TunnelID, actor, iface, "acknowledged incident "+acked.ID)
+		invalidatePageCache()
+		go syncAcknowledgement(acked, iface)
+	}
+	return acked, found
+}
+
+// syncAcknowledgement acknowledges the tickets opened for rec, except in
+// the system the acknowledgement came from.
+func syncAcknowledgement(rec incidentRecord, from string) {
+	notifiersMu.RLock()
+	hooks := incidentHooks
+	notifiersMu.RUnlock()
+	for _, hook := range hooks {
+		acknowledger, ok := hook.incidentHook.(incidentAcknowledger)
+		link, opened := rec.Links[hook.name]
+		if !ok || !opened || strings.EqualFold(hook.Name(), from) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := acknowledger.Acknowledge(ctx, rec, link); err != nil {
+			slog.Error("Error acknowledging incident ticket", "hook", hook.name, "incident_id", rec.ID, "tunnel_id", rec.TunnelID, "error", err)
+		}
+		cancel()
+	}
+}
+
+// hookNames returns the configured names of the incident hooks of type
+// kind, e.g. pagerduty.
+func hookNames(kind string) []string {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	var names []string
+	for _, hook := range incidentHooks {
+		if hook.Name() == kind {
+			names = append(names, hook.name)
+		}
+	}
+	return names
+}
+
+// resolveRecordInPager records that the alert for the record with the given
+// id was resolved by actor in the system of hooks of type kind. The record
+// stays open until the tunnel recovers, acknowledged, and the hooks that
+// opened the alert are not asked to resolve it again.
+func resolveRecordInPager(id, kind, actor, iface string) bool {
+	names := hookNames(kind)
+	found := false
+	updateIncident(id, func(rec *incidentRecord) {
+		found = true
+		if rec.Resolved == nil {
+			rec.Resolved = map[string]bool{}
+		}
+		resolved := false
+		for _, name := range names {
+			if _, opened := rec.Links[name]; opened && !rec.Resolved[name] {
+				rec.Resolved[name] = true
+				resolved = true
+			}
+		}
+		if resolved {
+			rec.Notes = append(rec.Notes, incidentNote{Time: time.Now(), Author: actor, Text: "Resolved in " + iface})
+		}
+	})
+	if found {
+		acknowledgeRecord(id, actor, iface)
+	}
+	return found
+}
+
+// setIncidentLink replaces the links of the tickets opened by hooks of type
+// kind, e.g. once a pager reports the alert's own page.
+func setIncidentLink(id, kind, link string) {
+	names := hookNames(kind)
+	updateIncident(id, func(rec *incidentRecord) {
+		for _, name := range names {
+			if _, opened := rec.Links[name]; opened {
+				rec.Links[name] = link
+			}
+		}
+	})
+}
+
+// incidentAcknowledged reports whether the tunnel's open incident has been
+// acknowledged.
+func incidentAcknowledged(tunnelID string) bool {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	rec := openIncident(tunnelID)
+	return rec != nil && rec.AckedBy != ""
+}
+
+// incidentLinks returns the ticket links of records that started within
+// [start, end]; a zero end means the incident is ongoing.
+func incidentLinks(tunnelID string, start, end time.Time) map[string]string {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	links := map[string]string{}
+	for _, rec := range incidentRecords {
+		if rec.TunnelID != tunnelID || rec.Start.Before(start) || (!end.IsZero() && rec.Start.After(end)) {
+			continue
+		}
+		for name, link := range rec.Links {
+			links[name] = link
+		}
+	}
+	return links
+}
@@ -0,0 +1,166 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Backoff is the wait before the first retry, doubled for each further
+	// one up to MaxBackoff.
+	Backoff    = time.Second
+	MaxBackoff = 30 * time.Second
+	// MaxRetryAfter is the longest Retry-After a request waits out itself;
+	// past it the response is returned and the caller holds back later
+	// requests instead.
+	MaxRetryAfter = time.Minute
+)
+
+// Retrier sends requests through Client, retrying those that failed
+// transiently: a network error or timeout, a 429, or a 5xx that signals a
+// passing problem. Retries wait out an exponential backoff with full
+// jitter, or the response's Retry-After when that is longer.
+type Retrier struct {
+	Client *http.Client
+	// Retries is how often a request is retried; 0 disables retries.
+	Retries int
+	// Wait, if set, is called before every attempt, e.g. to keep within a
+	// request budget. Its error ends the request.
+	Wait func(context.Context) error
+	// OnResponse, if set, sees every response before it is retried or
+	// returned.
+	OnResponse func(*http.Response)
+	// OnRetry, if set, is called before waiting delay for retry attempt
+	// (counting from 1), with why the previous attempt failed.
+	OnRetry func(req *http.Request, reason string, attempt int, delay time.Duration)
+}
+
+// Do sends req, retrying it as long as it can be replayed.
+func (r *Retrier) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		if r.Wait != nil {
+			if err := r.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := r.Client.Do(req)
+		if err == nil && r.OnResponse != nil {
+			r.OnResponse(resp)
+		}
+		if attempt > r.Retries || !retryable(ctx, resp, err) {
+			return resp, err
+		}
+		delay, ok := retryDelay(resp, attempt, time.Now())
+		if !ok || !replayable(req) {
+			return resp, err
+		}
+		reason := fmt.Sprint(err)
+		if err == nil {
+			reason = resp.Status
+		}
+		discard(resp)
+		if err := rewind(req); err != nil {
+			return nil, err
+		}
+		if r.OnRetry != nil {
+			r.OnRetry(req, reason, attempt, delay)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether a failed attempt is worth repeating.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay is the wait before retry attempt (counting from 1). ok is
+// false when Retry-After exceeds MaxRetryAfter.
+func retryDelay(resp *http.Response, attempt int, now time.Time) (delay time.Duration, ok bool) {
+	backoff := min(MaxBackoff, Backoff<<(attempt-1))
+	delay = rand.N(backoff) + time.Millisecond
+	if resp == nil {
+		return delay, true
+	}
+	retryAfter, found := ParseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !found {
+		return delay, true
+	}
+	if retryAfter > MaxRetryAfter {
+		return 0, false
+	}
+	return max(delay, retryAfter), true
+}
+
+// ParseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(0, at.Sub(now)), true
+	}
+	return 0, false
+}
+
+// replayable reports whether req can be sent again: it has no body or can
+// recreate it.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind resets the body of a replayable request before it is resent.
+func rewind(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// discard drains and closes a response that is being retried, so its
+// connection can be reused.
+func discard(resp *http.Response) {
+	if resp != nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
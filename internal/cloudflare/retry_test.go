@@ -0,0 +1,79 @@
+package cloudflare
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetrierRetriesTransientFailures(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var retries []int
+	r := Retrier{
+		Client:  server.Client(),
+		Retries: 3,
+		OnRetry: func(_ *http.Request, _ string, attempt int, _ time.Duration) { retries = append(retries, attempt) },
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || attempts != 3 || len(retries) != 2 {
+		t.Errorf("status %d after %d attempts and retries %v, want 200 after 3 attempts and 2 retries", resp.StatusCode, attempts, retries)
+	}
+}
+
+func TestRetrierReturnsLongRetryAfter(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	r := Retrier{Client: server.Client(), Retries: 3}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || attempts != 1 {
+		t.Errorf("status %d after %d attempts, want 429 after 1", resp.StatusCode, attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"30", 30 * time.Second, true},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseRetryAfter(test.value, now)
+		if got != test.want || ok != test.ok {
+			t.Errorf("ParseRetryAfter(%q) = %s, %t; want %s, %t", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
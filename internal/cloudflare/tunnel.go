@@ -0,0 +1,83 @@
+// Package cloudflare is the Cloudflare API client CFTunnels polls tunnels
+// with: the tunnel types, a client that fetches them, and a Retrier that
+// repeats requests that failed transiently.
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Tunnel is a tunnel as the Cloudflare API reports it. Status is healthy,
+// degraded, inactive or down.
+type Tunnel struct {
+	Name            string       `json:"name"`
+	Status          string       `json:"status"`
+	ConnsActiveAt   time.Time    `json:"conns_active_at"`
+	ConnsInactiveAt time.Time    `json:"conns_inactive_at"`
+	Connections     []Connection `json:"connections"`
+}
+
+// Connection is one of a tunnel's connections to the Cloudflare edge.
+type Connection struct {
+	ID string `json:"id"`
+}
+
+// URL is the Cloudflare API endpoint of a tunnel.
+func URL(accountID, tunnelID string) string {
+	return fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/cfd_tunnel/%s", accountID, tunnelID)
+}
+
+// Decode parses a Cloudflare API response body for a tunnel, returning an
+// error if the API reports failure.
+func Decode(body []byte) (Tunnel, error) {
+	var response struct {
+		Success bool   `json:"success"`
+		Result  Tunnel `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Tunnel{}, fmt.Errorf("parsing API response: %w", err)
+	}
+	if !response.Success {
+		return Tunnel{}, fmt.Errorf("API response indicates failure: %s", string(body))
+	}
+	return response.Result, nil
+}
+
+// Client fetches tunnels from the Cloudflare API with an API token that
+// can read them.
+type Client struct {
+	Token string
+	// HTTPClient defaults to one with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Tunnel fetches a tunnel.
+func (c *Client) Tunnel(ctx context.Context, accountID, tunnelID string) (Tunnel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", URL(accountID, tunnelID), nil)
+	if err != nil {
+		return Tunnel{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	client := c.HTTPClient
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Tunnel{}, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return Tunnel{}, fmt.Errorf("reading API response: %w", err)
+	}
+	return Decode(body)
+}
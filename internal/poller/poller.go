@@ -0,0 +1,185 @@
+// Package poller polls tunnels through the Cloudflare API client, a round
+// of polls at a time, and reports each result and status change.
+package poller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/s3ansh33p/CFTunnels/internal/cloudflare"
+)
+
+// StatusUnknown is the status of a tunnel not polled successfully yet.
+const StatusUnknown = "unknown"
+
+// Ref names a tunnel to monitor.
+type Ref struct {
+	AccountID string
+	ID        string
+}
+
+// State is what a Monitor last saw of a tunnel.
+type State struct {
+	cloudflare.Tunnel
+	// PolledAt is the last successful poll, and Err the last poll's error.
+	PolledAt time.Time
+	Err      error
+}
+
+// Change is a tunnel's status changing from one poll to the next.
+type Change struct {
+	Tunnel Ref
+	Name   string
+	From   string
+	To     string
+	At     time.Time
+}
+
+// ErrNoClient is returned by a Monitor without a Client.
+var ErrNoClient = errors.New("poller: Monitor has no Client")
+
+// Monitor polls its tunnels every Interval and calls OnChange when one's
+// status changes. The first successful poll of a tunnel sets its status
+// without a change.
+type Monitor struct {
+	Client  *cloudflare.Client
+	Tunnels []Ref
+	// List, if set, is called before each round for the tunnels to poll
+	// instead of Tunnels, for a set of tunnels that changes.
+	List func() []Ref
+	// Interval, the wait between rounds, defaults to a minute.
+	Interval time.Duration
+	// Concurrency is how many tunnels are polled at once; 0 or 1 polls
+	// them in turn. Burst, if set, replaces it for the first round, so a
+	// fresh start learns every status quickly.
+	Concurrency int
+	Burst       int
+	// Wake, if set, starts the next round early when it receives.
+	Wake     <-chan struct{}
+	OnChange func(Change)
+	// OnError, if set, is called when polling a tunnel fails.
+	OnError func(Ref, error)
+	// OnPoll, if set, is called after every poll with its result and how
+	// long the request took.
+	OnPoll func(ref Ref, t cloudflare.Tunnel, err error, latency time.Duration)
+	// OnRound, if set, is called after each round of polls.
+	OnRound func()
+
+	mu     sync.Mutex
+	states map[string]State
+}
+
+// Run polls until ctx is done and returns its error. A round in progress
+// when ctx is done is finished first.
+func (m *Monitor) Run(ctx context.Context) error {
+	if m.Client == nil {
+		return ErrNoClient
+	}
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for first := true; ; first = false {
+		// Every tunnel is about to be polled anyway.
+		select {
+		case <-m.Wake:
+		default:
+		}
+		limit := m.Concurrency
+		if first && m.Burst > 0 {
+			limit = m.Burst
+		}
+		m.round(context.WithoutCancel(ctx), limit)
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-m.Wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// PollOnce polls every tunnel once, Concurrency at a time, stopping early
+// if ctx is done.
+func (m *Monitor) PollOnce(ctx context.Context) error {
+	if m.Client == nil {
+		return ErrNoClient
+	}
+	m.round(ctx, m.Concurrency)
+	return ctx.Err()
+}
+
+func (m *Monitor) round(ctx context.Context, limit int) {
+	refs := m.Tunnels
+	if m.List != nil {
+		refs = m.List()
+	}
+	slots := make(chan struct{}, min(max(limit, 1), max(len(refs), 1)))
+	var wg sync.WaitGroup
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots }()
+			defer wg.Done()
+			m.poll(ctx, ref)
+		}()
+	}
+	wg.Wait()
+	if m.OnRound != nil {
+		m.OnRound()
+	}
+}
+
+func (m *Monitor) poll(ctx context.Context, ref Ref) {
+	started := time.Now()
+	t, err := m.Client.Tunnel(ctx, ref.AccountID, ref.ID)
+	now := time.Now()
+	if m.OnPoll != nil {
+		m.OnPoll(ref, t, err, now.Sub(started))
+	}
+	if t.Status == "" {
+		t.Status = StatusUnknown
+	}
+
+	m.mu.Lock()
+	if m.states == nil {
+		m.states = map[string]State{}
+	}
+	state := m.states[ref.ID]
+	previous := state.Status
+	if err != nil {
+		state.Err = err
+	} else {
+		state = State{Tunnel: t, PolledAt: now}
+	}
+	m.states[ref.ID] = state
+	m.mu.Unlock()
+
+	if err != nil {
+		if m.OnError != nil {
+			m.OnError(ref, err)
+		}
+		return
+	}
+	if previous != "" && previous != StatusUnknown && previous != t.Status && m.OnChange != nil {
+		m.OnChange(Change{Tunnel: ref, Name: t.Name, From: previous, To: t.Status, At: now})
+	}
+}
+
+// State returns what was last seen of the tunnel with the given ID.
+func (m *Monitor) State(id string) (State, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[id]
+	return state, ok
+}
@@ -0,0 +1,55 @@
+package poller
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/s3ansh33p/CFTunnels/internal/cloudflare"
+)
+
+// scriptedTransport answers every request with the next status of a
+// script.
+type scriptedTransport struct {
+	statuses []string
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := s.statuses[0]
+	s.statuses = s.statuses[1:]
+	body := `{"success":true,"result":{"name":"web","status":"` + status + `"}}`
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func TestMonitorReportsChanges(t *testing.T) {
+	transport := &scriptedTransport{statuses: []string{"healthy", "healthy", "down"}}
+	var changes []Change
+	m := &Monitor{
+		Client:   &cloudflare.Client{HTTPClient: &http.Client{Transport: transport}},
+		Tunnels:  []Ref{{AccountID: "account", ID: "tunnel"}},
+		OnChange: func(c Change) { changes = append(changes, c) },
+	}
+	for range 3 {
+		if err := m.PollOnce(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(changes) != 1 || changes[0].From != "healthy" || changes[0].To != "down" || changes[0].Name != "web" {
+		t.Errorf("changes = %+v, want one from healthy to down", changes)
+	}
+	if state, ok := m.State("tunnel"); !ok || state.Status != "down" {
+		t.Errorf("State = %+v, %t; want down", state, ok)
+	}
+}
+
+func TestMonitorWithoutClient(t *testing.T) {
+	m := &Monitor{}
+	if err := m.PollOnce(context.Background()); err != ErrNoClient {
+		t.Errorf("PollOnce = %v, want ErrNoClient", err)
+	}
+	if err := m.Run(context.Background()); err != ErrNoClient {
+		t.Errorf("Run = %v, want ErrNoClient", err)
+	}
+}
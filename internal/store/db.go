@@ -0,0 +1,155 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// DB is an embedded SQLite database of history intervals, status
+// transitions and idempotent responses. Transitions keep the old and new
+// status even when a gap or an unknown status lies between two intervals.
+// Times are stored as Unix nanoseconds.
+type DB struct {
+	db *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS intervals (
+	tunnel_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	start INTEGER NOT NULL,
+	end INTEGER NOT NULL,
+	PRIMARY KEY (tunnel_id, start)
+);
+CREATE INDEX IF NOT EXISTS intervals_end ON intervals (end);
+CREATE TABLE IF NOT EXISTS transitions (
+	time INTEGER NOT NULL,
+	tunnel_id TEXT NOT NULL,
+	old_status TEXT NOT NULL,
+	new_status TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS transitions_tunnel_time ON transitions (tunnel_id, time);
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	content_type TEXT NOT NULL,
+	location TEXT NOT NULL,
+	body BLOB NOT NULL,
+	created INTEGER NOT NULL
+);
+`
+
+// OpenDB opens, and if need be creates, the database at path.
+func OpenDB(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection avoids busy errors.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database, checkpointing its write-ahead log.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Intervals returns every interval, ordered by start.
+func (d *DB) Intervals() ([]Interval, error) {
+	rows, err := d.db.Query(`SELECT tunnel_id, status, start, end FROM intervals ORDER BY start`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var intervals []Interval
+	for rows.Next() {
+		var in Interval
+		var start, end int64
+		if err := rows.Scan(&in.TunnelID, &in.Status, &start, &end); err != nil {
+			return nil, err
+		}
+		in.Start, in.End = time.Unix(0, start), time.Unix(0, end)
+		intervals = append(intervals, in)
+	}
+	return intervals, rows.Err()
+}
+
+// SaveIntervals inserts or updates intervals, keyed by tunnel and start,
+// in one transaction.
+func (d *DB) SaveIntervals(intervals []Interval) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, in := range intervals {
+		if _, err := tx.Exec(`INSERT INTO intervals (tunnel_id, status, start, end) VALUES (?, ?, ?, ?)
+			ON CONFLICT (tunnel_id, start) DO UPDATE SET status = excluded.status, end = excluded.end`,
+			in.TunnelID, in.Status, in.Start.UnixNano(), in.End.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Prune deletes intervals that ended, and transitions that happened,
+// before cutoff.
+func (d *DB) Prune(cutoff time.Time) error {
+	if _, err := d.db.Exec(`DELETE FROM intervals WHERE end < ?`, cutoff.UnixNano()); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(`DELETE FROM transitions WHERE time < ?`, cutoff.UnixNano())
+	return err
+}
+
+// RecordTransition stores a tunnel's status change.
+func (d *DB) RecordTransition(tunnelID, oldStatus, newStatus string, at time.Time) error {
+	_, err := d.db.Exec(`INSERT INTO transitions (time, tunnel_id, old_status, new_status) VALUES (?, ?, ?, ?)`,
+		at.UnixNano(), tunnelID, oldStatus, newStatus)
+	return err
+}
+
+// Response is the response first given to a request with an
+// Idempotency-Key. Fingerprint identifies the request.
+type Response struct {
+	Fingerprint string
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	Created     time.Time
+}
+
+// Response returns the response stored for key if it was created at or
+// after since.
+func (d *DB) Response(key string, since time.Time) (Response, bool, error) {
+	var resp Response
+	var created int64
+	err := d.db.QueryRow(`SELECT fingerprint, status, content_type, location, body, created FROM idempotency_keys WHERE key = ? AND created >= ?`,
+		key, since.UnixNano()).Scan(&resp.Fingerprint, &resp.Status, &resp.ContentType, &resp.Location, &resp.Body, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resp, false, nil
+	}
+	resp.Created = time.Unix(0, created)
+	return resp, err == nil, err
+}
+
+// SaveResponse stores the response for key and deletes those created
+// before cutoff.
+func (d *DB) SaveResponse(key string, resp Response, cutoff time.Time) error {
+	if _, err := d.db.Exec(`DELETE FROM idempotency_keys WHERE created < ?`, cutoff.UnixNano()); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO idempotency_keys (key, fingerprint, status, content_type, location, body, created) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key, resp.Fingerprint, resp.Status, resp.ContentType, resp.Location, resp.Body, resp.Created.UnixNano())
+	return err
+}
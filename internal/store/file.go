@@ -0,0 +1,109 @@
+// Package store keeps the status history of CFTunnels: intervals in a
+// JSON-lines file or an SQLite database, the status transitions between
+// them, and the responses to admin requests with an Idempotency-Key.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Sample is one observation of a tunnel's status.
+type Sample struct {
+	Time     time.Time `json:"time"`
+	TunnelID string    `json:"tunnel_id,omitempty"`
+	Status   string    `json:"status"`
+}
+
+// Interval is a run of samples of a tunnel with the same status. Start is
+// the first sample's time and End the last one's.
+type Interval struct {
+	TunnelID string    `json:"tunnel_id"`
+	Status   string    `json:"status"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// record is one line of a history file: an interval, or a sample written
+// before history was stored as intervals.
+type record struct {
+	Interval
+	Time time.Time `json:"time"`
+}
+
+// ReadLines parses a history file, one interval per line. A later line for
+// the same tunnel and start updates the interval. Lines that are samples,
+// as earlier versions wrote, are returned separately, in file order, for
+// the caller to fold into intervals.
+func ReadLines(r io.Reader) ([]Interval, []Sample, error) {
+	var intervals []Interval
+	var samples []Sample
+	// latest maps tunnel and start to the interval's index, so update
+	// lines replace the end.
+	latest := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, nil, fmt.Errorf("%d: %w", line, err)
+		}
+		if rec.Start.IsZero() {
+			samples = append(samples, Sample{Time: rec.Time, TunnelID: rec.TunnelID, Status: rec.Status})
+			continue
+		}
+		key := rec.TunnelID + "@" + rec.Start.Format(time.RFC3339Nano)
+		if i, ok := latest[key]; ok {
+			intervals[i] = rec.Interval
+			continue
+		}
+		latest[key] = len(intervals)
+		intervals = append(intervals, rec.Interval)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return intervals, samples, nil
+}
+
+// WriteFile replaces the file at path with intervals, one line each,
+// through a temporary file so readers never see it half written.
+func WriteFile(path string, intervals []Interval) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, in := range intervals {
+		if err := encoder.Encode(in); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// AppendFile adds in, a new or updated interval, to the file at path.
+func AppendFile(path string, in Interval) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(in); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
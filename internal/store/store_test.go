@@ -0,0 +1,88 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLines(t *testing.T) {
+	lines := `{"tunnel_id":"a","status":"healthy","start":"2026-01-01T00:00:00Z","end":"2026-01-01T00:05:00Z"}
+{"time":"2025-12-31T23:00:00Z","status":"down"}
+{"tunnel_id":"a","status":"healthy","start":"2026-01-01T00:00:00Z","end":"2026-01-01T00:10:00Z"}
+`
+	intervals, samples, err := ReadLines(strings.NewReader(lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := []Interval{{TunnelID: "a", Status: "healthy", Start: start, End: start.Add(10 * time.Minute)}}
+	if !reflect.DeepEqual(intervals, want) {
+		t.Errorf("intervals = %+v, want %+v", intervals, want)
+	}
+	if len(samples) != 1 || samples[0].Status != "down" || samples[0].TunnelID != "" {
+		t.Errorf("samples = %+v, want the one down sample without a tunnel", samples)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	in := Interval{TunnelID: "a", Status: "healthy", Start: start, End: start}
+	if err := WriteFile(path, []Interval{in}); err != nil {
+		t.Fatal(err)
+	}
+	in.End = start.Add(time.Minute)
+	if err := AppendFile(path, in); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got, _, err := ReadLines(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].End.Equal(in.End) {
+		t.Errorf("ReadLines = %+v, want the appended update of %+v", got, in)
+	}
+}
+
+func TestDBPruneAndResponses(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := Interval{TunnelID: "a", Status: "down", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)}
+	current := Interval{TunnelID: "a", Status: "healthy", Start: now, End: now}
+	if err := db.SaveIntervals([]Interval{old, current}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordTransition("a", "down", "healthy", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Prune(now.Add(-30 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.Intervals(); len(got) != 1 || got[0].Status != "healthy" {
+		t.Errorf("Intervals after Prune = %+v, want only the healthy one", got)
+	}
+
+	resp := Response{Fingerprint: "f", Status: 201, ContentType: "application/json", Body: []byte("{}"), Created: now}
+	if err := db.SaveResponse("key", resp, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := db.Response("key", now.Add(-time.Minute)); err != nil || !ok || got.Status != 201 || !got.Created.Equal(now) {
+		t.Errorf("Response = %+v, %t, %v; want the saved response", got, ok, err)
+	}
+	if _, ok, _ := db.Response("key", now.Add(time.Minute)); ok {
+		t.Error("Response returned a response created before since")
+	}
+}
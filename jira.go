@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// jiraHook opens a Jira issue for outages that last longer than threshold
+// and comments on it when the tunnel recovers.
+type jiraHook struct {
+	baseURL   string
+	auth      string
+	project   string
+	issueType string
+	labels    []string
+	threshold time.Duration
+}
+
+type jiraCreated struct {
+	Key string `json:"key"`
+}
+
+// jiraFromEnv reads JIRA_URL, JIRA_PROJECT, JIRA_ISSUE_TYPE, JIRA_LABELS,
+// JIRA_OUTAGE_THRESHOLD and either JIRA_USER with JIRA_API_TOKEN (Jira
+// Cloud) or JIRA_BEARER_TOKEN (Data Center personal access token).
+func jiraFromEnv() []NotifierConfig {
+	if os.Getenv("JIRA_URL") == "" {
+		return nil
+	}
+	return []NotifierConfig{{Name: "jira", Type: "jira", Settings: envSettings(map[string]string{
+		"url":              "JIRA_URL",
+		"project":          "JIRA_PROJECT",
+		"issue_type":       "JIRA_ISSUE_TYPE",
+		"labels":           "JIRA_LABELS",
+		"outage_threshold": "JIRA_OUTAGE_THRESHOLD",
+		"user":             "JIRA_USER",
+		"api_token":        "JIRA_API_TOKEN",
+		"bearer_token":     "JIRA_BEARER_TOKEN",
+	})}}
+}
+
+// newJiraIntegration takes url, project, issue_type (default Bug), labels,
+// outage_threshold (default 15m) and either user with api_token or
+// bearer_token.
+func newJiraIntegration(settings map[string]string) (integration, error) {
+	hook := &jiraHook{
+		baseURL:   strings.TrimRight(settings["url"], "/"),
+		project:   settings["project"],
+		issueType: settings["issue_type"],
+		labels:    splitList(settings["labels"]),
+		threshold: 15 * time.Minute,
+	}
+	if hook.baseURL == "" || hook.project == "" {
+		return integration{}, fmt.Errorf("url and project are required")
+	}
+	if hook.issueType == "" {
+		hook.issueType = "Bug"
+	}
+	if value := settings["outage_threshold"]; value != "" {
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 0 {
+			return integration{}, fmt.Errorf("outage_threshold: invalid duration %q", value)
+		}
+		hook.threshold = threshold
+	}
+
+	user, token, bearer := settings["user"], settings["api_token"], settings["bearer_token"]
+	switch {
+	case bearer != "":
+		hook.auth = "Bearer " + bearer
+	case user != "" && token != "":
+		hook.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+token))
+	default:
+		return integration{}, fmt.Errorf("set user and api_token, or bearer_token")
+	}
+	return integration{hook: hook}, nil
+}
+
+func (h *jiraHook) Name() string {
+	return "jira"
+}
+
+func (h *jiraHook) Threshold() time.Duration {
+	return h.threshold
+}
+
+func (h *jiraHook) headers() map[string]string {
+	return map[string]string{"Authorization": h.auth}
+}
+
+func (h *jiraHook) Open(ctx context.Context, rec incidentRecord) (string, error) {
+	fields := map[string]any{
+		"project":   map[string]string{"key": h.project},
+		"issuetype": map[string]string{"name": h.issueType},
+		"summary":   fmt.Sprintf("Tunnel %s outage: %s", rec.Tunnel, statusLabel(rec.Status)),
+		"description": fmt.Sprintf("Tunnel %s (%s) has not been healthy since %s.\nWorst status so far: %s.\nIncident ID: %s",
+			rec.Tunnel, rec.TunnelID, rec.Start.UTC().Format(time.RFC1123), statusLabel(rec.Status), rec.ID),
+	}
+	if len(h.labels) > 0 {
+		fields["labels"] = h.labels
+	}
+
+	var created jiraCreated
+	if err := doJSON(ctx, "POST", h.baseURL+"/rest/api/2/issue", h.headers(), map[string]any{"fields": fields}, &created); err != nil {
+		return "", err
+	}
+	if created.Key == "" {
+		return "", fmt.Errorf("response did not include an issue key")
+	}
+	return h.baseURL + "/browse/" + created.Key, nil
+}
+
+func (h *jiraHook) Resolve(ctx context.Context, rec incidentRecord, link string) error {
+	key := path.Base(link)
+	comment := fmt.Sprintf("Tunnel %s recovered at %s after %s. Worst status: %s.",
+		rec.Tunnel, rec.End.UTC().Format(time.RFC1123), formatElapsed(rec.End.Sub(rec.Start)), statusLabel(rec.Status))
+	return postJSON(ctx, h.baseURL+"/rest/api/2/issue/"+key+"/comment", h.headers(), map[string]string{"body": comment})
+}
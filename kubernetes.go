@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	k8sRetryDelay        = 10 * time.Second
+	// Annotations read from Services and Ingresses.
+	k8sTunnelAnnotation  = "cftunnels.io/tunnel-id"
+	k8sAccountAnnotation = "cftunnels.io/account-id"
+	k8sNameAnnotation    = "cftunnels.io/name"
+)
+
+// k8sObject is the subset of a Service, Ingress or CloudflareTunnel that
+// discovery reads.
+type k8sObject struct {
+	Metadata struct {
+		UID         string            `json:"uid"`
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		TunnelID  string `json:"tunnelID"`
+		AccountID string `json:"accountID"`
+		Name      string `json:"name"`
+	} `json:"spec"`
+}
+
+type k8sList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []k8sObject `json:"items"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// k8sResource is a kind of cluster object that can reference a tunnel.
+type k8sResource struct {
+	name string
+	// group is the API path prefix, e.g. /api/v1.
+	group  string
+	plural string
+	tunnel func(obj k8sObject) (TunnelConfig, bool)
+}
+
+// k8sClient talks to the API server with the pod's service account.
+type k8sClient struct {
+	baseURL   string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+var (
+	k8sDiscovery      *k8sClient
+	k8sResources      []k8sResource
+	k8sDefaultAccount string
+)
+
+// k8sResourceTypes are the kinds K8S_DISCOVERY can name. Services and
+// Ingresses opt in with the cftunnels.io/tunnel-id annotation;
+// CloudflareTunnel objects (see deploy/kubernetes/crd.yaml) always do.
+var k8sResourceTypes = map[string]k8sResource{
+	"services":  {name: "services", group: "/api/v1", plural: "services", tunnel: annotatedTunnel},
+	"ingresses": {name: "ingresses", group: "/apis/networking.k8s.io/v1", plural: "ingresses", tunnel: annotatedTunnel},
+	"crd":       {name: "cloudflaretunnels", group: "/apis/cftunnels.io/v1alpha1", plural: "cloudflaretunnels", tunnel: crdTunnel},
+}
+
+// loadKubernetes reads K8S_DISCOVERY, a comma-separated list of services,
+// ingresses and crd, and K8S_NAMESPACE to watch one namespace instead of
+// the whole cluster. The API server and credentials are those of the pod's
+// service account. Tunnels without an account annotation use ACCOUNT_ID.
+func loadKubernetes() error {
+	kinds := splitList(os.Getenv("K8S_DISCOVERY"))
+	if len(kinds) == 0 {
+		return nil
+	}
+	for _, kind := range kinds {
+		resource, ok := k8sResourceTypes[kind]
+		if !ok {
+			return fmt.Errorf("K8S_DISCOVERY: unknown kind %q (use services, ingresses or crd)", kind)
+		}
+		k8sResources = append(k8sResources, resource)
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are unset")
+	}
+	token, err := os.ReadFile(k8sServiceAccountDir + "/token")
+	if err != nil {
+		return fmt.Errorf("reading service account token: %w", err)
+	}
+	ca, err := os.ReadFile(k8sServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("service account CA contains no certificates")
+	}
+
+	k8sDiscovery = &k8sClient{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: os.Getenv("K8S_NAMESPACE"),
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}
+	k8sDefaultAccount = os.Getenv("ACCOUNT_ID")
+	return nil
+}
+
+func annotatedTunnel(obj k8sObject) (TunnelConfig, bool) {
+	id := obj.Metadata.Annotations[k8sTunnelAnnotation]
+	if id == "" {
+		return TunnelConfig{}, false
+	}
+	account := obj.Metadata.Annotations[k8sAccountAnnotation]
+	if account == "" {
+		account = k8sDefaultAccount
+	}
+	return TunnelConfig{ID: id, AccountID: account, Name: obj.Metadata.Annotations[k8sNameAnnotation]}, true
+}
+
+func crdTunnel(obj k8sObject) (TunnelConfig, bool) {
+	if obj.Spec.TunnelID == "" {
+		return TunnelConfig{}, false
+	}
+	t := TunnelConfig{ID: obj.Spec.TunnelID, AccountID: obj.Spec.AccountID, Name: obj.Spec.Name}
+	if t.AccountID == "" {
+		t.AccountID = k8sDefaultAccount
+	}
+	if t.Name == "" {
+		t.Name = obj.Metadata.Name
+	}
+	return t, true
+}
+
+// watchKubernetes keeps the discovered tunnels in sync with every
+// configured resource until the process exits.
+func watchKubernetes() {
+	for _, resource := range k8sResources {
+		go func(resource k8sResource) {
+			for {
+				if err := k8sDiscovery.sync(context.Background(), resource); err != nil {
+					slog.Error("Error watching Kubernetes", "resource", resource.name, "error", err)
+				}
+				time.Sleep(k8sRetryDelay)
+			}
+		}(resource)
+	}
+}
+
+func (c *k8sClient) path(resource k8sResource) string {
+	if c.namespace != "" {
+		return fmt.Sprintf("%s/namespaces/%s/%s", resource.group, url.PathEscape(c.namespace), resource.plural)
+	}
+	return resource.group + "/" + resource.plural
+}
+
+func (c *k8sClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: HTTP %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// sync lists resource, publishes the tunnels it references, then follows
+// the watch stream until it ends. The caller lists again afterwards, which
+// also recovers from expired resource versions.
+func (c *k8sClient) sync(ctx context.Context, resource k8sResource) error {
+	listCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	resp, err := c.get(listCtx, c.path(resource), nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	var list k8sList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	cancel()
+	if err != nil {
+		return fmt.Errorf("parsing list: %w", err)
+	}
+
+	found := map[string]TunnelConfig{}
+	for _, obj := range list.Items {
+		if t, ok := resource.tunnel(obj); ok {
+			found[obj.Metadata.UID] = t
+		}
+	}
+	source := "kubernetes/" + resource.name
+	publish := func() {
+		var discovered []TunnelConfig
+		for _, t := range found {
+			discovered = append(discovered, t)
+		}
+		sortTunnelConfigs(discovered)
+		setDiscoveredTunnels(source, discovered)
+	}
+	publish()
+
+	resp, err = c.get(ctx, c.path(resource), url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {list.Metadata.ResourceVersion},
+		"timeoutSeconds":  {"600"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var event k8sWatchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("parsing watch event: %w", err)
+		}
+		if event.Type == "ERROR" {
+			// Usually 410 Gone: the resource version is too old.
+			return nil
+		}
+		var obj k8sObject
+		if err := json.Unmarshal(event.Object, &obj); err != nil {
+			return fmt.Errorf("parsing watch object: %w", err)
+		}
+		t, ok := resource.tunnel(obj)
+		switch {
+		case event.Type == "DELETED" || (event.Type == "MODIFIED" && !ok):
+			delete(found, obj.Metadata.UID)
+		case ok && (event.Type == "ADDED" || event.Type == "MODIFIED"):
+			found[obj.Metadata.UID] = t
+		default:
+			continue
+		}
+		publish()
+	}
+	return scanner.Err()
+}
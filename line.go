@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+const defaultLineNotifyURL = "https://notify-api.line.me/api/notify"
+
+// lineNotifier sends a message with a LINE Notify personal or group token.
+type lineNotifier struct {
+	url   string
+	token string
+}
+
+// lineFromEnv reads LINE_NOTIFY_TOKENS, a comma-separated list of tokens,
+// and LINE_NOTIFY_URL for compatible gateways.
+func lineFromEnv() []NotifierConfig {
+	return numberedConfigs("line", "token", os.Getenv("LINE_NOTIFY_TOKENS"), map[string]string{
+		"url": os.Getenv("LINE_NOTIFY_URL"),
+	})
+}
+
+// newLineIntegration takes token and optional url settings.
+func newLineIntegration(settings map[string]string) (integration, error) {
+	if settings["token"] == "" {
+		return integration{}, fmt.Errorf("token is required")
+	}
+	endpoint := settings["url"]
+	if endpoint == "" {
+		endpoint = defaultLineNotifyURL
+	}
+	return integration{notifier: &lineNotifier{url: endpoint, token: settings["token"]}}, nil
+}
+
+func (n *lineNotifier) Name() string {
+	return "line notify"
+}
+
+func (n *lineNotifier) Notify(ctx context.Context, event Event) error {
+	// LINE Notify prefixes the token's name, so the message starts on a new
+	// line to keep the title readable.
+	message := "\n" + event.Title + "\n" + event.Message
+	return postForm(ctx, n.url, map[string]string{"Authorization": "Bearer " + n.token}, url.Values{"message": {message}})
+}
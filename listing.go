@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxListLimit caps how many items one page of a list endpoint returns.
+const maxListLimit = 1000
+
+// listParams are the reserved query parameters of list endpoints. Any
+// other parameter named after a field of the items filters on it.
+var listParams = []string{"limit", "cursor", "sort", "fields"}
+
+// listSpec describes the items of a list endpoint: the field identifying
+// an item, used to break ties and resume after a cursor, and the default
+// sort, "" for the endpoint's own order.
+type listSpec struct {
+	key         string
+	defaultSort string
+}
+
+// listQuery is a request's pagination, filters, sort and fields:
+//
+//	?status=down,degraded&sort=-status_since&limit=50&fields=id,name
+//
+// Filters take comma-separated values and match any of them, or any
+// element of a list field. sort names a field, descending with a leading
+// "-". fields lists the fields to return. cursor is the opaque next_cursor
+// of the previous page, passed back with the same filters and sort.
+type listQuery struct {
+	filters map[string][]string
+	sort    string
+	desc    bool
+	limit   int
+	cursor  *listCursor
+	fields  []string
+}
+
+// listCursor marks the last item of a page, by its sort value and key.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v,omitempty"`
+	Key   any    `json:"k"`
+}
+
+// listPage is a page of items as generic JSON values, ready to encode.
+type listPage []map[string]any
+
+// pageList applies the request's list parameters to items, a slice of
+// structs, and sets the X-Total-Count header to the number of items
+// matching the filters and, when there are more, a Link header to the next
+// page. The page is nil when the request has no list parameters, so the
+// response can be written unchanged. On an invalid parameter it responds
+// with a problem and returns false.
+func pageList(w http.ResponseWriter, r *http.Request, items any, spec listSpec) (listPage, bool) {
+	fields := jsonFieldNames(reflect.TypeOf(items).Elem())
+	q, err := parseListQuery(r, fields, spec)
+	if err != nil {
+		writeProblem(w, r, problemInvalidParameter, err.Error())
+		return nil, false
+	}
+	if q == nil {
+		w.Header().Set("X-Total-Count", strconv.Itoa(reflect.ValueOf(items).Len()))
+		return nil, true
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		writeProblem(w, r, problemInternal, err.Error())
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var all listPage
+	if err := decoder.Decode(&all); err != nil {
+		writeProblem(w, r, problemInternal, err.Error())
+		return nil, false
+	}
+
+	page := listPage{}
+	for _, item := range all {
+		if q.matches(item) {
+			page = append(page, item)
+		}
+	}
+	if q.sort != "" {
+		slices.SortStableFunc(page, func(a, b map[string]any) int { return q.compare(a, b, spec.key) })
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(page)))
+
+	if q.cursor != nil {
+		start := -1
+		for i, item := range page {
+			if q.sort == "" && compareJSON(item[spec.key], q.cursor.Key) == 0 {
+				start = i + 1
+				break
+			}
+			if q.sort != "" && q.compare(item, map[string]any{q.sort: q.cursor.Value, spec.key: q.cursor.Key}, spec.key) > 0 {
+				start = i
+				break
+			}
+		}
+		if start < 0 && q.sort == "" {
+			writeProblem(w, r, problemInvalidParameter, "cursor: the item it points after no longer exists; start again without it")
+			return nil, false
+		}
+		if start < 0 {
+			start = len(page)
+		}
+		page = page[start:]
+	}
+
+	if q.limit > 0 && len(page) > q.limit {
+		page = page[:q.limit]
+		last := page[len(page)-1]
+		next := listCursor{Sort: q.sortParam(), Value: last[q.sort], Key: last[spec.key]}
+		if q.sort == "" {
+			next.Value = nil
+		}
+		query := r.URL.Query()
+		query.Set("cursor", next.encode())
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+	}
+
+	if len(q.fields) > 0 {
+		for i, item := range page {
+			projected := make(map[string]any, len(q.fields))
+			for _, field := range q.fields {
+				if value, ok := item[field]; ok {
+					projected[field] = value
+				}
+			}
+			page[i] = projected
+		}
+	}
+	return page, true
+}
+
+// replace returns envelope, a response struct, with its field holding the
+// page instead of the full list.
+func (p listPage) replace(envelope any, field string) any {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return envelope
+	}
+	var out map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if decoder.Decode(&out) != nil {
+		return envelope
+	}
+	out[field] = p
+	return out
+}
+
+// parseListQuery reads the list parameters, or returns nil when there are
+// none.
+func parseListQuery(r *http.Request, fields []string, spec listSpec) (*listQuery, error) {
+	query := r.URL.Query()
+	q := &listQuery{filters: map[string][]string{}}
+	present := false
+	for name, values := range query {
+		if slices.Contains(fields, name) && !slices.Contains(listParams, name) {
+			q.filters[name] = splitList(strings.Join(values, ","))
+			present = true
+		}
+	}
+
+	sort := query.Get("sort")
+	if sort != "" {
+		present = true
+	} else {
+		sort = spec.defaultSort
+	}
+	q.sort, q.desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	if q.sort != "" && !slices.Contains(fields, q.sort) {
+		return nil, fmt.Errorf("sort: unknown field %q (available: %s)", q.sort, strings.Join(fields, ", "))
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be a number from 1 to %d", maxListLimit)
+		}
+		q.limit, present = limit, true
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeListCursor(value)
+		if err != nil {
+			return nil, fmt.Errorf("cursor: invalid cursor")
+		}
+		if cursor.Sort != q.sortParam() {
+			return nil, fmt.Errorf("cursor: the cursor is for sort=%s; pass the same sort as the first page", cursor.Sort)
+		}
+		q.cursor, present = &cursor, true
+	}
+	if value := query.Get("fields"); value != "" {
+		q.fields = splitList(value)
+		for _, field := range q.fields {
+			if !slices.Contains(fields, field) {
+				return nil, fmt.Errorf("fields: unknown field %q (available: %s)", field, strings.Join(fields, ", "))
+			}
+		}
+		present = true
+	}
+	if !present {
+		return nil, nil
+	}
+	return q, nil
+}
+
+func (q *listQuery) sortParam() string {
+	if q.desc {
+		return "-" + q.sort
+	}
+	return q.sort
+}
+
+// matches reports whether item passes every filter.
+func (q *listQuery) matches(item map[string]any) bool {
+	for field, wanted := range q.filters {
+		values := []any{item[field]}
+		if list, ok := item[field].([]any); ok {
+			values = list
+		}
+		if !slices.ContainsFunc(values, func(v any) bool { return v != nil && slices.Contains(wanted, fmt.Sprint(v)) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders items by the sort field, then by key.
+func (q *listQuery) compare(a, b map[string]any, key string) int {
+	c := compareJSON(a[q.sort], b[q.sort])
+	if q.desc {
+		c = -c
+	}
+	if c == 0 {
+		c = compareJSON(a[key], b[key])
+	}
+	return c
+}
+
+// compareJSON orders decoded JSON values: missing values first, numbers by
+// value, times chronologically and other text alphabetically.
+func compareJSON(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			fx, _ := x.Float64()
+			fy, _ := y.Float64()
+			return cmpFloat(fx, fy)
+		}
+	}
+	if x, ok := a.(bool); ok {
+		if y, ok := b.(bool); ok {
+			return cmpBool(x, y)
+		}
+	}
+	x, y := fmt.Sprint(a), fmt.Sprint(b)
+	if tx, err := time.Parse(time.RFC3339Nano, x); err == nil {
+		if ty, err := time.Parse(time.RFC3339Nano, y); err == nil {
+			return tx.Compare(ty)
+		}
+	}
+	return strings.Compare(x, y)
+}
+
+func cmpFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func cmpBool(x, y bool) int {
+	switch {
+	case x == y:
+		return 0
+	case !x:
+		return -1
+	}
+	return 1
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(value string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&c)
+	return c, err
+}
+
+// jsonFieldNames lists the JSON names of a struct type's fields, including
+// those of embedded structs.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			names = append(names, jsonFieldNames(f.Type)...)
+			continue
+		}
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
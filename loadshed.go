@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxRequests  = 64
+	defaultRequestQueue = 256
+	defaultQueueTimeout = 2 * time.Second
+	// shedRetryAfter is the Retry-After, in seconds, sent with shed
+	// requests.
+	shedRetryAfter = 10
+)
+
+// shedPage is deliberately tiny and static so shedding costs next to
+// nothing.
+const shedPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta http-equiv="refresh" content="10"><title>Server Status</title></head>
+<body><p>The status page is busy. It will reload in a few seconds.</p></body>
+</html>`
+
+var (
+	maxRequests  = defaultMaxRequests
+	requestQueue = defaultRequestQueue
+	queueTimeout = defaultQueueTimeout
+	// requestSlots holds a token per request being served.
+	requestSlots chan struct{}
+	// queuedRequests counts requests waiting for a slot.
+	queuedRequests atomic.Int64
+	shedRequests   atomic.Int64
+	shedLoggedAt   time.Time
+	shedLogMu      sync.Mutex
+)
+
+// loadLoadShedding reads MAX_CONCURRENT_REQUESTS, how many requests are
+// served at once (0 disables the limit); MAX_QUEUED_REQUESTS, how many more
+// may wait for a slot; and REQUEST_QUEUE_TIMEOUT, how long they wait. Any
+// request beyond that gets a 503 with Retry-After, so a traffic spike
+// during an outage cannot starve the poller and notifiers.
+func loadLoadShedding() error {
+	for _, setting := range []struct {
+		key   string
+		value *int
+	}{{"MAX_CONCURRENT_REQUESTS", &maxRequests}, {"MAX_QUEUED_REQUESTS", &requestQueue}} {
+		value := os.Getenv(setting.key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s: invalid number %q", setting.key, value)
+		}
+		*setting.value = n
+	}
+	if value := os.Getenv("REQUEST_QUEUE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("REQUEST_QUEUE_TIMEOUT: invalid duration %q", value)
+		}
+		queueTimeout = timeout
+	}
+	if maxRequests > 0 {
+		requestSlots = make(chan struct{}, maxRequests)
+	}
+	return nil
+}
+
+// loadShedding limits next to maxRequests concurrent requests, queueing up
+// to requestQueue more for queueTimeout and shedding the rest. /events and
+// /ws streams stay open indefinitely, and /api/watch for minutes, so they
+// are limited by MAX_EVENT_STREAMS instead. Health probes are never shed.
+func loadShedding(next http.Handler) http.Handler {
+	if requestSlots == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" || r.URL.Path == "/ws" || r.URL.Path == "/api/watch" || probePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case requestSlots <- struct{}{}:
+		default:
+			if !waitForSlot(r) {
+				shed(w, r)
+				return
+			}
+		}
+		defer func() { <-requestSlots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitForSlot queues the request if there is room, and reports whether it
+// got a slot in time.
+func waitForSlot(r *http.Request) bool {
+	if queuedRequests.Add(1) > int64(requestQueue) {
+		queuedRequests.Add(-1)
+		return false
+	}
+	defer queuedRequests.Add(-1)
+	timer := time.NewTimer(queueTimeout)
+	defer timer.Stop()
+	select {
+	case requestSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func shed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(shedRetryAfter))
+	if isAPIPath(r.URL.Path) {
+		writeProblem(w, r, problemOverloaded, fmt.Sprintf("try again in %d seconds", shedRetryAfter))
+	} else {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(shedPage))
+	}
+
+	// Log at most once a minute rather than once per shed request.
+	shedRequests.Add(1)
+	shedLogMu.Lock()
+	defer shedLogMu.Unlock()
+	if now := time.Now(); now.Sub(shedLoggedAt) >= time.Minute {
+		slog.Warn("Overloaded: shed requests", "shed", shedRequests.Swap(0), "max_requests", maxRequests, "max_queued", requestQueue)
+		shedLoggedAt = now
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exit codes of the loadtest command.
+const (
+	loadtestPassed = 0
+	loadtestOver   = 1
+	loadtestFailed = 2
+)
+
+// Performance budgets the loadtest command enforces by default. With its
+// default load, 2000 tunnels all changing status every few seconds, 200
+// viewers and 8 requests at a time, a single CPU core meets them with room
+// to spare: the status page and the APIs answer within their budgets at
+// the 99th percentile, a poll of every tunnel takes less than the poll
+// budget, an event reaches the /events streams within the fan-out budget
+// at the 99th percentile, and at most defaultMissedBudget of the events
+// are missed by streams too slow to keep up.
+const (
+	defaultPageLatencyBudget = time.Second
+	defaultAPILatencyBudget  = 500 * time.Millisecond
+	defaultPollBudget        = 5 * time.Second
+	defaultFanoutBudget      = 250 * time.Millisecond
+	defaultMissedBudget      = 0.01
+)
+
+// loadtestPaths are the pages requested under load, in turn.
+var loadtestPaths = []string{"/", "/api/status", "/api/v1/components"}
+
+// loadtestResult is what the loadtest command measured.
+type loadtestResult struct {
+	Tunnels  int                      `json:"tunnels"`
+	Viewers  int                      `json:"viewers"`
+	Requests map[string]latencyResult `json:"requests"`
+	Errors   int64                    `json:"errors"`
+	Shed     int64                    `json:"shed"`
+	Poll     time.Duration            `json:"poll_ns"`
+	Fanout   latencyResult            `json:"fanout"`
+	// Missed counts events that did not reach a viewer.
+	Missed int64    `json:"missed_events"`
+	Over   []string `json:"over_budget,omitempty"`
+}
+
+// latencyResult summarises a set of latencies.
+type latencyResult struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+func summarise(latencies []time.Duration) latencyResult {
+	if len(latencies) == 0 {
+		return latencyResult{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(q float64) time.Duration {
+		return latencies[max(int(q*float64(len(latencies)))-1, 0)]
+	}
+	return latencyResult{Count: len(latencies), P50: at(0.50), P95: at(0.95), P99: at(0.99), Max: latencies[len(latencies)-1]}
+}
+
+// loadScenario is the load a run applies to a server in MOCK mode.
+type loadScenario struct {
+	// Viewers is how many /events streams are held open.
+	Viewers int
+	// Requests is how many page and API requests are made, Concurrency
+	// at a time.
+	Requests    int
+	Concurrency int
+	// Events is how many events are sent to the streams.
+	Events int
+}
+
+// loadBudgets are the limits a run is held to.
+type loadBudgets struct {
+	// Page and API bound the 99th percentile latency of the status page
+	// and of the APIs.
+	Page, API time.Duration
+	// Poll bounds a poll of every tunnel, and Fanout the 99th percentile
+	// time for an event to reach a stream.
+	Poll, Fanout time.Duration
+	// Missed is the fraction of events streams may miss.
+	Missed float64
+}
+
+// loadtestEnv returns the environment of a loadtest server with tunnels
+// simulated tunnels and room for viewers event streams.
+func loadtestEnv(tunnels, viewers int) map[string]string {
+	ids := make([]string, tunnels)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%08x-0000-4000-8000-%012x=Load test %d", i, i, i+1)
+	}
+	return map[string]string{
+		"MOCK":                  "true",
+		"MOCK_SCRIPT":           "healthy,healthy,degraded,healthy,down",
+		"MOCK_STEP":             "5s",
+		"TUNNEL_ID":             strings.Join(ids, ","),
+		"POLL_INTERVAL":         "5s",
+		"CLOUDFLARE_API_BUDGET": "none",
+		"MAX_EVENT_STREAMS":     strconv.Itoa(viewers + 16),
+		"LOG_LEVEL":             "error",
+	}
+}
+
+// runLoadtest implements the loadtest command: it runs the server in MOCK
+// mode with thousands of simulated tunnels, holds hundreds of /events
+// streams open, requests the status page and APIs concurrently, times a
+// poll of every tunnel and the fan-out of events to the streams, and
+// compares the results with the budgets. It exits 0 within budget, 1 over
+// it and 2 when the run itself failed, so CI can catch regressions.
+//
+// The environment, .env and CONFIG_FILE are ignored so a run never calls
+// Cloudflare or the configured notifiers; -set adds settings to test with.
+func runLoadtest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	tunnels := flags.Int("tunnels", 2000, "how many tunnels to simulate")
+	var scenario loadScenario
+	flags.IntVar(&scenario.Viewers, "viewers", 200, "how many /events streams to hold open")
+	flags.IntVar(&scenario.Requests, "requests", 3000, "how many page and API requests to make")
+	flags.IntVar(&scenario.Concurrency, "concurrency", 8, "how many requests to make at once")
+	flags.IntVar(&scenario.Events, "events", 20, "how many events to send to the streams")
+	var budgets loadBudgets
+	flags.DurationVar(&budgets.Page, "page-budget", defaultPageLatencyBudget, "99th percentile budget for the status page")
+	flags.DurationVar(&budgets.API, "api-budget", defaultAPILatencyBudget, "99th percentile budget for the APIs")
+	flags.DurationVar(&budgets.Poll, "poll-budget", defaultPollBudget, "budget for polling every tunnel once")
+	flags.DurationVar(&budgets.Fanout, "fanout-budget", defaultFanoutBudget, "99th percentile budget for an event to reach a stream")
+	flags.Float64Var(&budgets.Missed, "missed-budget", defaultMissedBudget, "fraction of events streams may miss")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	settings := map[string]string{}
+	flags.Func("set", "set any environment variable as NAME=value; repeatable", func(value string) error {
+		name, setting, ok := strings.Cut(value, "=")
+		if !ok || !settingName.MatchString(name) {
+			return fmt.Errorf("%q is not NAME=value", value)
+		}
+		settings[name] = setting
+		return nil
+	})
+	flags.Parse(args)
+	if *tunnels < 1 || scenario.Viewers < 0 || scenario.Requests < 0 || scenario.Concurrency < 1 || scenario.Events < 1 {
+		fmt.Fprintln(os.Stderr, "loadtest: -tunnels, -concurrency and -events must be at least 1")
+		return loadtestFailed
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		return loadtestFailed
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	os.Clearenv()
+	for name, value := range loadtestEnv(*tunnels, scenario.Viewers) {
+		os.Setenv(name, value)
+	}
+	os.Setenv("HTTP_PORT", port)
+	for name, value := range settings {
+		os.Setenv(name, value)
+	}
+	loadEnv(os.DevNull)
+	go serve()
+
+	base := "http://127.0.0.1:" + port
+	if err := waitForReady(http.DefaultClient, base+"/readyz", time.Minute); err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		return loadtestFailed
+	}
+	result, err := runLoadScenario(base, scenario)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		return loadtestFailed
+	}
+	budgets.check(&result, scenario)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		printLoadtest(os.Stdout, result)
+	}
+	if len(result.Over) > 0 {
+		return loadtestOver
+	}
+	return loadtestPassed
+}
+
+// runLoadScenario applies s to the MOCK mode server at base, which runs in
+// this process: the events are published and the poll timed directly.
+func runLoadScenario(base string, s loadScenario) (loadtestResult, error) {
+	result := loadtestResult{Tunnels: len(snapshotTunnels()), Viewers: s.Viewers, Requests: map[string]latencyResult{}}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: s.Concurrency},
+	}
+	defer client.CloseIdleConnections()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan time.Duration, s.Viewers*s.Events)
+	var open sync.WaitGroup
+	for range s.Viewers {
+		open.Add(1)
+		go loadtestViewer(ctx, base+"/events", &open, received)
+	}
+	open.Wait()
+	eventsMu.Lock()
+	streams := len(eventSubscribers)
+	eventsMu.Unlock()
+	if streams < s.Viewers {
+		return result, fmt.Errorf("only %d of %d event streams opened", streams, s.Viewers)
+	}
+
+	start := time.Now()
+	tunnelPoller.PollOnce(context.Background())
+	result.Poll = time.Since(start)
+
+	// The requests run while events are sent, as they would during an
+	// outage.
+	latencies := map[string][]time.Duration{}
+	var latenciesMu sync.Mutex
+	var errorCount, shedCount atomic.Int64
+	var next atomic.Int64
+	var workers sync.WaitGroup
+	for range s.Concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				n := next.Add(1)
+				if n > int64(s.Requests) {
+					return
+				}
+				path := loadtestPaths[n%int64(len(loadtestPaths))]
+				began := time.Now()
+				resp, err := client.Get(base + path)
+				if err != nil {
+					errorCount.Add(1)
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				took := time.Since(began)
+				switch {
+				case resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "":
+					shedCount.Add(1)
+				case resp.StatusCode >= 500:
+					errorCount.Add(1)
+				default:
+					latenciesMu.Lock()
+					latencies[path] = append(latencies[path], took)
+					latenciesMu.Unlock()
+				}
+			}
+		}()
+	}
+	for range s.Events {
+		publishPageEvent("loadtest", map[string]int64{"sent": time.Now().UnixNano()})
+		time.Sleep(50 * time.Millisecond)
+	}
+	workers.Wait()
+
+	var fanout []time.Duration
+	want := s.Viewers * s.Events
+	deadline := time.After(5 * time.Second)
+collect:
+	for len(fanout) < want {
+		select {
+		case latency := <-received:
+			fanout = append(fanout, latency)
+		case <-deadline:
+			break collect
+		}
+	}
+
+	for path, list := range latencies {
+		result.Requests[path] = summarise(list)
+	}
+	result.Errors = errorCount.Load()
+	result.Shed = shedCount.Load()
+	result.Fanout = summarise(fanout)
+	result.Missed = int64(want - len(fanout))
+	return result, nil
+}
+
+// check notes in result.Over every budget the run of s exceeded.
+func (b loadBudgets) check(result *loadtestResult, s loadScenario) {
+	over := func(format string, args ...any) { result.Over = append(result.Over, fmt.Sprintf(format, args...)) }
+	for _, path := range loadtestPaths {
+		budget := b.API
+		if path == "/" {
+			budget = b.Page
+		}
+		if p99 := result.Requests[path].P99; p99 > budget {
+			over("%s p99 %s over %s", path, p99.Round(time.Millisecond), budget)
+		}
+	}
+	if result.Poll > b.Poll {
+		over("poll of %d tunnels took %s, over %s", result.Tunnels, result.Poll.Round(time.Millisecond), b.Poll)
+	}
+	if result.Fanout.P99 > b.Fanout {
+		over("event fan-out p99 %s over %s", result.Fanout.P99.Round(time.Millisecond), b.Fanout)
+	}
+	if result.Errors > 0 {
+		over("%d requests failed", result.Errors)
+	}
+	if result.Shed > 0 {
+		over("%d requests were shed", result.Shed)
+	}
+	want := s.Viewers * s.Events
+	if float64(result.Missed) > b.Missed*float64(want) {
+		over("%d of %d events did not reach their stream", result.Missed, want)
+	}
+}
+
+// waitForReady waits for url to answer 200.
+func waitForReady(client *http.Client, url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("server not ready after %s", timeout)
+}
+
+// loadtestViewer holds an /events stream open, marking open done once it
+// is, and reports how long each loadtest event took to arrive.
+func loadtestViewer(ctx context.Context, url string, open *sync.WaitGroup, received chan<- time.Duration) {
+	var once sync.Once
+	defer once.Do(open.Done)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	reader := bufio.NewReader(resp.Body)
+	var event string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "retry:"):
+			once.Do(open.Done)
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "loadtest":
+			var probe struct {
+				Sent int64 `json:"sent"`
+			}
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &probe) == nil {
+				received <- time.Since(time.Unix(0, probe.Sent))
+			}
+		case line == "":
+			event = ""
+		}
+	}
+}
+
+func printLoadtest(w io.Writer, result loadtestResult) {
+	fmt.Fprintf(w, "%d tunnels, %d viewers\n", result.Tunnels, result.Viewers)
+	for _, path := range loadtestPaths {
+		r := result.Requests[path]
+		fmt.Fprintf(w, "  %-20s %5d requests  p50 %-8s p95 %-8s p99 %-8s max %s\n", path, r.Count,
+			r.P50.Round(time.Microsecond), r.P95.Round(time.Microsecond), r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "  %-20s %d failed, %d shed\n", "errors", result.Errors, result.Shed)
+	fmt.Fprintf(w, "  %-20s %s\n", "poll", result.Poll.Round(time.Millisecond))
+	fmt.Fprintf(w, "  %-20s %5d events    p50 %-8s p95 %-8s p99 %-8s max %s (%d missed)\n", "event fan-out", result.Fanout.Count,
+		result.Fanout.P50.Round(time.Microsecond), result.Fanout.P95.Round(time.Microsecond), result.Fanout.P99.Round(time.Microsecond), result.Fanout.Max.Round(time.Microsecond), result.Missed)
+	if len(result.Over) == 0 {
+		fmt.Fprintln(w, "Within budget")
+		return
+	}
+	fmt.Fprintln(w, "Over budget:")
+	for _, line := range result.Over {
+		fmt.Fprintln(w, "  "+line)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Budgets of TestLoadScenario. They are looser than the loadtest command's
+// because the test server shares the machine with the rest of the tests.
+const (
+	testTunnels         = 500
+	testViewers         = 50
+	testPageP95Budget   = 250 * time.Millisecond
+	testAPIP95Budget    = 150 * time.Millisecond
+	testFanoutP95Budget = 150 * time.Millisecond
+)
+
+// testLatency enables the latency budgets, which depend on the machine and
+// fail under the race detector, e.g. LOADTEST_LATENCY=1 go test. It is
+// read before setupLoadtest clears the environment.
+var testLatency = os.Getenv("LOADTEST_LATENCY") != ""
+
+// testAllocBudgets bound the allocations of one request for each of
+// loadtestPaths with testTunnels tunnels. The status page is served from
+// its cache, so its budget allows for an occasional render, not one per
+// request.
+var testAllocBudgets = map[string]float64{
+	"/":                  400,
+	"/api/status":        26000,
+	"/api/v1/components": 4000,
+}
+
+var loadtestSetup sync.Once
+
+// setupLoadtest configures the process as the loadtest command does, with
+// testTunnels simulated tunnels, and polls them once. The configuration is
+// global, so it is done once for every test and benchmark.
+func setupLoadtest(tb testing.TB) {
+	tb.Helper()
+	loadtestSetup.Do(func() {
+		keep := map[string]string{}
+		for _, name := range []string{"PATH", "HOME", "TMPDIR", "GOCOVERDIR"} {
+			if value, ok := os.LookupEnv(name); ok {
+				keep[name] = value
+			}
+		}
+		os.Clearenv()
+		for name, value := range keep {
+			os.Setenv(name, value)
+		}
+		for name, value := range loadtestEnv(testTunnels, testViewers) {
+			os.Setenv(name, value)
+		}
+		loadEnv(os.DevNull)
+		tunnelPoller.PollOnce(context.Background())
+	})
+}
+
+func TestLoadScenario(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the load scenario is slow")
+	}
+	setupLoadtest(t)
+	server := httptest.NewServer(routes())
+	defer server.Close()
+
+	result, err := runLoadScenario(server.URL, loadScenario{Viewers: testViewers, Requests: 600, Concurrency: 4, Events: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Errors > 0 || result.Shed > 0 {
+		t.Errorf("%d requests failed and %d were shed", result.Errors, result.Shed)
+	}
+	if testLatency {
+		checkLatencyBudgets(t, result)
+	}
+	if result.Missed > 0 {
+		t.Errorf("%d events did not reach their stream", result.Missed)
+	}
+
+	handler := routes()
+	for _, path := range loadtestPaths {
+		allocs := testing.AllocsPerRun(20, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		})
+		if budget := testAllocBudgets[path]; allocs > budget {
+			t.Errorf("%s made %.0f allocations, over %.0f", path, allocs, budget)
+		}
+	}
+}
+
+// checkLatencyBudgets fails t when the p95 latencies of result exceed the
+// test budgets.
+func checkLatencyBudgets(t *testing.T, result loadtestResult) {
+	t.Helper()
+	for _, path := range loadtestPaths {
+		budget := testAPIP95Budget
+		if path == "/" {
+			budget = testPageP95Budget
+		}
+		if p95 := result.Requests[path].P95; p95 > budget {
+			t.Errorf("%s p95 %s over %s", path, p95, budget)
+		}
+	}
+	if result.Fanout.P95 > testFanoutP95Budget {
+		t.Errorf("event fan-out p95 %s over %s", result.Fanout.P95, testFanoutP95Budget)
+	}
+}
+
+func BenchmarkStatusPage(b *testing.B) {
+	setupLoadtest(b)
+	handler := routes()
+	b.ReportAllocs()
+	for b.Loop() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+}
+
+func BenchmarkEventsFanout(b *testing.B) {
+	setupLoadtest(b)
+	done := make(chan struct{})
+	var drained sync.WaitGroup
+	var unsubscribes []func()
+	for range testViewers {
+		ch, unsubscribe := subscribePageEvents(httptest.NewRecorder())
+		if ch == nil {
+			b.Fatal("event stream was shed")
+		}
+		unsubscribes = append(unsubscribes, unsubscribe)
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			for {
+				select {
+				case <-ch:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		publishPageEvent("benchmark", map[string]int64{"sent": time.Now().UnixNano()})
+	}
+	b.StopTimer()
+	for _, unsubscribe := range unsubscribes {
+		unsubscribe()
+	}
+	close(done)
+	drained.Wait()
+}
+
+func BenchmarkPoll(b *testing.B) {
+	setupLoadtest(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := tunnelPoller.PollOnce(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// loadLogging reads LOG_FORMAT, text (the default) or json, and LOG_LEVEL,
+// debug, info (the default), warn or error. Every message carries its
+// details as attributes rather than in the text. Polls and status changes carry tunnel_id, status and latency fields, and
+// debug also logs the raw Cloudflare API responses. The privacy policy may
+// mask tunnel IDs, IPs and colos in them.
+func loadLogging() error {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("LOG_LEVEL: unknown level %q (available: debug, info, warn, error)", value)
+		}
+	}
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: maskLogAttr}
+	var handler slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("LOG_FORMAT: unknown format %q (available: text, json)", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs msg at error level with the given attributes and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// debugAPIResponse logs a Cloudflare API response body at debug level.
+func debugAPIResponse(req *http.Request, resp *http.Response, body []byte) {
+	slog.Debug("Cloudflare API response", "method", req.Method, "url", req.URL.String(), "http_status", resp.StatusCode, "body", string(body))
+}
@@ -1,202 +1,636 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"html"
+	"html/template"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/s3ansh33p/CFTunnels/internal/cloudflare"
+	"github.com/s3ansh33p/CFTunnels/internal/poller"
 )
 
-const pollInterval = 5 * time.Minute
+const defaultPollInterval = 5 * time.Minute
+
+// pollInterval is how often every tunnel is polled, set by POLL_INTERVAL.
+var pollInterval = defaultPollInterval
 
 var (
-	apiURL      string
-	apiKey      string
-	status      string
-	activeAt    time.Time
-	inactiveAt  time.Time
+	apiKey string
+	// lastPollAt is when the last poll of every tunnel finished.
+	lastPollAt  time.Time
 	statusMutex sync.RWMutex
 )
 
 type ApiResponse struct {
-	Success bool `json:"success"`
-	Result  struct {
-		Status          string    `json:"status"`
-		ConnsActiveAt   time.Time `json:"conns_active_at"`
-		ConnsInActiveAt time.Time `json:"conns_inactive_at"`
-	} `json:"result"`
+	Success bool              `json:"success"`
+	Result  cloudflare.Tunnel `json:"result"`
 }
 
-func loadEnv() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+// loadEnv reads the configuration from the environment, envFile if it
+// exists, and CONFIG_FILE, exiting on any invalid setting.
+func loadEnv(envFile string) {
+	if err := godotenv.Load(envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal("Error loading environment file", "file", envFile, "error", err)
+	}
+	if err := loadLogging(); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+
+	if err := loadConfigFile(); err != nil {
+		fatal("Invalid config file", "error", err)
+	}
+	if err := loadMock(); err != nil {
+		fatal("Invalid mock configuration", "error", err)
 	}
 
-	accountID := os.Getenv("ACCOUNT_ID")
-	tunnelID := os.Getenv("TUNNEL_ID")
 	apiKey = os.Getenv("API_TOKEN")
-	if accountID == "" || tunnelID == "" || apiKey == "" {
-		log.Fatal("ACCOUNT_ID, TUNNEL_ID, and API_TOKEN must be set in the environment variables")
+	if apiKey == "" || len(configFromEnv().Tunnels) == 0 {
+		fatal("API_TOKEN and the tunnels (TUNNEL_ID and ACCOUNT_ID, or tunnels in CONFIG_FILE) must be set")
 	}
 
-	apiURL = fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/cfd_tunnel/%s", accountID, tunnelID)
+	if err := loadOutbound(); err != nil {
+		fatal("Invalid outbound network configuration", "error", err)
+	}
+	if err := loadAccessRules(); err != nil {
+		fatal("Invalid access control configuration", "error", err)
+	}
+	if err := loadSearchIndexing(); err != nil {
+		fatal("Invalid search indexing configuration", "error", err)
+	}
+	if err := loadAdmin(); err != nil {
+		fatal("Invalid admin configuration", "error", err)
+	}
+	if err := loadCloudflareIngress(); err != nil {
+		fatal("Invalid Cloudflare ingress configuration", "error", err)
+	}
+	if err := loadGeoIP(); err != nil {
+		fatal("Invalid GeoIP configuration", "error", err)
+	}
+	if err := loadTLS(); err != nil {
+		fatal("Invalid TLS configuration", "error", err)
+	}
+	if err := loadPollInterval(); err != nil {
+		fatal("Invalid polling configuration", "error", err)
+	}
+	if err := loadStaleAfter(); err != nil {
+		fatal("Invalid polling configuration", "error", err)
+	}
+	if err := loadPollConcurrency(); err != nil {
+		fatal("Invalid polling configuration", "error", err)
+	}
+	if err := loadStartup(); err != nil {
+		fatal("Invalid startup configuration", "error", err)
+	}
+	if err := loadLoadShedding(); err != nil {
+		fatal("Invalid load shedding configuration", "error", err)
+	}
+	if err := loadEventStreams(); err != nil {
+		fatal("Invalid event stream configuration", "error", err)
+	}
+	if err := loadRefreshInterval(); err != nil {
+		fatal("Invalid refresh configuration", "error", err)
+	}
+	if err := loadTheme(); err != nil {
+		fatal("Invalid theme configuration", "error", err)
+	}
+	if err := loadPageTemplates(); err != nil {
+		fatal("Invalid template configuration", "error", err)
+	}
+	if err := loadAnalytics(); err != nil {
+		fatal("Invalid analytics configuration", "error", err)
+	}
+	if err := loadZabbix(); err != nil {
+		fatal("Invalid Zabbix configuration", "error", err)
+	}
+	if err := loadSNMP(); err != nil {
+		fatal("Invalid SNMP configuration", "error", err)
+	}
+	if err := loadKubernetes(); err != nil {
+		fatal("Invalid Kubernetes configuration", "error", err)
+	}
+	if err := loadDocker(); err != nil {
+		fatal("Invalid Docker configuration", "error", err)
+	}
+	if err := loadUserAgent(); err != nil {
+		fatal("Invalid Cloudflare API configuration", "error", err)
+	}
+	if err := loadNotifyDispatch(); err != nil {
+		fatal("Invalid notification configuration", "error", err)
+	}
+	if err := loadMonitor(); err != nil {
+		fatal("Invalid monitor configuration", "error", err)
+	}
+	if err := loadClock(); err != nil {
+		fatal("Invalid clock configuration", "error", err)
+	}
+	if err := loadDeepLinks(); err != nil {
+		fatal("Invalid link configuration", "error", err)
+	}
+	if err := loadFederation(); err != nil {
+		fatal("Invalid federation configuration", "error", err)
+	}
+	if err := loadExternalStatus(); err != nil {
+		fatal("Invalid external status page configuration", "error", err)
+	}
+	if err := loadChatOps(); err != nil {
+		fatal("Invalid chat command configuration", "error", err)
+	}
+	if err := loadPagerDutyWebhook(); err != nil {
+		fatal("Invalid PagerDuty webhook configuration", "error", err)
+	}
+	if err := loadOpsgenieWebhook(); err != nil {
+		fatal("Invalid Opsgenie webhook configuration", "error", err)
+	}
+	if err := loadAPIBudget(); err != nil {
+		fatal("Invalid Cloudflare API budget configuration", "error", err)
+	}
+	if err := loadCloudflareClient(); err != nil {
+		fatal("Invalid Cloudflare API client configuration", "error", err)
+	}
+	if err := loadConnectorMetrics(); err != nil {
+		fatal("Invalid cloudflared metrics configuration", "error", err)
+	}
+	if err := loadConnectorLogs(); err != nil {
+		fatal("Invalid cloudflared log configuration", "error", err)
+	}
+	if err := loadConfigWatch(); err != nil {
+		fatal("Invalid configuration watch settings", "error", err)
+	}
+	if err := loadDNSCheck(); err != nil {
+		fatal("Invalid DNS check configuration", "error", err)
+	}
+	if err := loadAudit(); err != nil {
+		fatal("Invalid audit log configuration", "error", err)
+	}
+	if err := loadServiceTokens(); err != nil {
+		fatal("Invalid service token configuration", "error", err)
+	}
+	if err := loadZeroTrust(); err != nil {
+		fatal("Invalid Zero Trust configuration", "error", err)
+	}
+	if err := loadStateStore(); err != nil {
+		fatal("Invalid state store configuration", "error", err)
+	}
+	if err := loadPublish(); err != nil {
+		fatal("Invalid publish configuration", "error", err)
+	}
+	if err := loadSelfCheck(); err != nil {
+		fatal("Invalid self-check configuration", "error", err)
+	}
+	if err := loadMessageTemplates(); err != nil {
+		fatal("Invalid message templates", "error", err)
+	}
+	if err := loadSLA(); err != nil {
+		fatal("Invalid SLA configuration", "error", err)
+	}
+	if err := loadRollup(); err != nil {
+		fatal("Invalid overall status configuration", "error", err)
+	}
+	if err := loadBurnRates(); err != nil {
+		fatal("Invalid SLA configuration", "error", err)
+	}
+	if err := loadHistory(); err != nil {
+		fatal("Error loading history", "error", err)
+	}
+	if err := loadIncidents(); err != nil {
+		fatal("Error loading incidents", "error", err)
+	}
+	if err := loadExclusions(); err != nil {
+		fatal("Error loading exclusions", "error", err)
+	}
+	if err := loadSnoozes(); err != nil {
+		fatal("Error loading snoozes", "error", err)
+	}
+	if err := loadDeleted(); err != nil {
+		fatal("Error loading deleted objects", "error", err)
+	}
+	configurePoller()
+	if _, err := applyConfig(configFromEnv()); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if stateBackend != nil {
+		if err := restoreState(); err != nil {
+			fatal("Error restoring state", "backend", stateBackend.Name(), "error", err)
+		}
+	}
 }
 
-func pollAPI() {
-	for {
-		req, err := http.NewRequest("GET", apiURL, nil)
-		if err != nil {
-			log.Printf("Error creating request: %v", err)
-			time.Sleep(pollInterval)
-			continue
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Error polling API: %v", err)
-			time.Sleep(pollInterval)
-			continue
-		}
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Error reading API response: %v", err)
-			time.Sleep(pollInterval)
-			continue
-		}
+func tunnelURL(accountID, tunnelID string) string {
+	return cloudflare.URL(accountID, tunnelID)
+}
+
+// tunnelPoller polls the registered tunnels through cloudflareTransport;
+// configurePoller sets it up once the environment is loaded.
+var tunnelPoller = &poller.Monitor{
+	Client: &cloudflare.Client{HTTPClient: &http.Client{Transport: cloudflareTransport{}}},
+	List:   tunnelRefs,
+	// The first round polls every tunnel at once, as nothing is known yet.
+	Burst:   math.MaxInt,
+	Wake:    pollNow,
+	OnPoll:  recordPoll,
+	OnRound: finishPollRound,
+}
+
+func configurePoller() {
+	tunnelPoller.Client.Token = apiKey
+	tunnelPoller.Interval = pollInterval
+	tunnelPoller.Concurrency = pollConcurrency
+}
 
-		var apiResponse ApiResponse
-		if err := json.Unmarshal(body, &apiResponse); err != nil {
-			log.Printf("Error parsing API response: %v", err)
-			time.Sleep(pollInterval)
-			continue
+// cloudflareTransport sends the poller's requests through cloudflareDo,
+// within the API budget and with its retries, and checks the clock and
+// logs the response on the way back.
+type cloudflareTransport struct{}
+
+func (cloudflareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := cloudflareDo(req, apiCritical)
+	if err != nil {
+		// The client wraps the error with the request URL again.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
 		}
+		return nil, err
+	}
+	checkClock(resp.Header.Get("Date"), time.Now())
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading API response: %w", err)
+	}
+	debugAPIResponse(req, resp, body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
 
-		if apiResponse.Success {
-			statusMutex.Lock()
-			status = apiResponse.Result.Status
-			activeAt = apiResponse.Result.ConnsActiveAt
-			inactiveAt = apiResponse.Result.ConnsInActiveAt
-			statusMutex.Unlock()
-		} else {
-			log.Printf("API response indicates failure: %s", string(body))
+// fetchTunnel retrieves a tunnel from the Cloudflare API with token,
+// returning an error if the request fails or the API reports failure.
+func fetchTunnel(ctx context.Context, accountID, tunnelID, token string) (*ApiResponse, error) {
+	client := cloudflare.Client{Token: token, HTTPClient: tunnelPoller.Client.HTTPClient}
+	tunnel, err := client.Tunnel(ctx, accountID, tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	return &ApiResponse{Success: true, Result: tunnel}, nil
+}
+
+// pollAPI runs the poller until ctx is done, finishing a round in
+// progress first.
+func pollAPI(ctx context.Context) {
+	defer close(pollerDone)
+	tunnelPoller.Run(ctx)
+}
+
+// finishPollRound runs after every round of polls.
+func finishPollRound() {
+	publishOverallUpdate()
+	statusMutex.Lock()
+	lastPollAt = time.Now()
+	statusMutex.Unlock()
+	markFirstPoll()
+	if len(publishTargets) > 0 {
+		requestPublish()
+	}
+	list := snapshotTunnels()
+	checkSLAs(list, time.Now())
+	checkBurnRates(list, time.Now())
+	checkStaleness(list, time.Now())
+}
+
+// recordPoll applies the result of polling a tunnel.
+func recordPoll(ref poller.Ref, result cloudflare.Tunnel, err error, latency time.Duration) {
+	now := time.Now()
+	statusMutex.Lock()
+	var live *tunnelState
+	for _, candidate := range tunnels {
+		if candidate.ID == ref.ID {
+			live = candidate
 		}
+	}
+	if live == nil {
+		// Removed while the request was in flight.
+		statusMutex.Unlock()
+		return
+	}
+	if err != nil {
+		label := live.label()
+		statusMutex.Unlock()
+		countPoll(ref.ID, err)
+		monitorResult("polling of tunnel "+label, err)
+		slog.Error("Error polling API", "tunnel_id", ref.ID, "error", err, "latency", latency)
+		return
+	}
+	current := result.Status
+	if current == "" {
+		current = statusUnknown
+	}
+	previous := live.Status
+	live.LastPollAt = now
+	live.Status = current
+	live.APIName = result.Name
+	live.ActiveAt = result.ConnsActiveAt
+	live.InactiveAt = result.ConnsInactiveAt
+	live.Connections = len(result.Connections)
+	t := *live
+	statusMutex.Unlock()
+	countPoll(t.ID, nil)
+	monitorResult("polling of tunnel "+t.label(), nil)
 
-		time.Sleep(pollInterval)
+	if previous == statusUnknown {
+		previous = lastRecordedStatus(t.ID)
+	}
+	slog.Debug("Polled tunnel", "tunnel_id", t.ID, "status", current, "connections", t.Connections, "latency", latency)
+	if previous != current && current != statusUnknown {
+		slog.Info("Tunnel status changed", "tunnel_id", t.ID, "tunnel", t.label(), "previous_status", previous, "status", current)
+		recordStatusChange(t.ID, t.label(), previous, current, now)
+	}
+	if previous != "" && previous != statusUnknown && previous != current {
+		notify(statusChangeEvent(t.ID, t.label(), previous, current, now))
+	}
+	recordSample(sample{Time: now, TunnelID: t.ID, Status: current})
+	trackIncident(t.ID, t.label(), current, now)
+	publishTunnelUpdate(t)
+	pollConnections(t)
+	if zabbixServer != "" {
+		go pushZabbix(t.ID, current, t.ActiveAt, now)
 	}
 }
 
+// tunnelRow renders one tunnel on the status page.
+func tunnelRow(i int, t tunnelState, now time.Time) string {
+	activeString := t.periodLabel()
+	since, _ := t.since()
+	var budgets strings.Builder
+	for _, b := range tunnelBudgets(t, now) {
+		fmt.Fprintf(&budgets, ` <span class="tunnel-budget">Error budget (%s): %s</span>`, html.EscapeString(b.Target.String()), b.Remaining())
+	}
+	var windows []string
+	for _, w := range windowAvailabilities(t.ID, now) {
+		if w.OK {
+			windows = append(windows, fmt.Sprintf("%s %s", w.Label, formatAvailability(w.Ratio, w.OK)))
+		}
+	}
+	availabilityLine := ""
+	if len(windows) > 0 {
+		availabilityLine = ` <span class="tunnel-availability">Availability: ` + strings.Join(windows, " &middot; ") + `</span>`
+	}
+	// The time in the current status is rendered even when unknown, hidden,
+	// so liveScript can fill it in.
+	changed := statusSince(t.ID, t.Status)
+	hidden := ""
+	if changed.IsZero() {
+		hidden = " hidden"
+	}
+	inStatus := fmt.Sprintf(`<span class="tunnel-in-status"%s> &middot; <span class="tunnel-status-label">%s</span> for <span class="tunnel-status-elapsed">%s</span></span>`,
+		hidden, statusLabel(t.Status), relTime(fmt.Sprintf("status-%d", i), changed, now))
+	return fmt.Sprintf(`<li data-tunnel-id="%s"><a class="tunnel-name" href="%s">%s</a> %s%s <span class="tunnel-since"><span class="tunnel-period">%s</span>: <span class="tunnel-elapsed">%s</span>%s</span>%s%s</li>`,
+		html.EscapeString(publicTunnelID(t.ID)), html.EscapeString(tunnelPath(t.ID)), html.EscapeString(t.label()), statusPill(t.Status), snoozedBadge(t.ID, now)+staleBadge(i, t, now)+dnsBadges(t.ID), activeString, relTime(fmt.Sprintf("uptime-%d", i), since, now), inStatus, availabilityLine, budgets.String())
+}
+
 func handler(w http.ResponseWriter, r *http.Request) {
-	statusMutex.RLock()
-	defer statusMutex.RUnlock()
+	page := cachedStatusPage(highContrast(w, r), viewerRefreshSeconds(r))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(page.code)
+	w.Write(withViewerHints(page.body, r))
+}
 
-  activeString := "Uptime"
-	uptime := time.Since(activeAt).Truncate(time.Second)
-	if activeAt.IsZero() {
-		activeString = "Downtime"
-		uptime = time.Since(inactiveAt).Truncate(time.Second)
+// renderStatusPage renders the status page for one contrast mode and
+// no-script refresh interval.
+func renderStatusPage(high bool, refreshSeconds int) renderedPage {
+	now := time.Now()
+	list := snapshotTunnels()
+	overall := overallTunnelStatus(list)
+	if len(federationPeers) > 0 {
+		overall = overallStatus(append([]string{overall}, peerOverallStatuses()...))
 	}
 
-	var statusColor string
 	var responseCode int
-	switch status {
+	switch overall {
 	case "healthy":
-		statusColor = "green"
 		responseCode = http.StatusOK // 200
-	case "inactive":
-		statusColor = "darkslategray"
-		responseCode = http.StatusCreated // 201
-	case "degraded":
-		statusColor = "orangered"
-		responseCode = http.StatusCreated // 201
-	case "down":
-		statusColor = "red"
+	case "inactive", "degraded", "down":
 		responseCode = http.StatusCreated // 201
 	default:
-		statusColor = "darkslategray"
 		responseCode = http.StatusServiceUnavailable // 503
 	}
 
-	response := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-	<title>Server Status</title>
-	<style>
-			body {
-					font-family: Arial, sans-serif;
-					text-align: center;
-					display: flex;
-					flex-direction: column;
-					justify-content: center;
-					align-items: center;
-					height: 100dvh;
-					height: 100vh;
-					margin: 0;
-					background-color: #121212;
-					color: white;
-			}
-			.status-pill {
-					display: inline-block;
-					padding: 10px 20px;
-					color: white;
-					background-color: %s;
-					border-radius: 25px;
-					font-size: 1.2em;
-					text-transform: uppercase;
-			}
-	</style>
-	<script>
-		let uptimeSeconds = %d;
-
-		function updateUptime() {
-			uptimeSeconds++;
-			const uptimeElement = document.getElementById("uptime");
-			const hours = Math.floor(uptimeSeconds / 3600);
-			const minutes = Math.floor((uptimeSeconds %% 3600) / 60);
-			const seconds = uptimeSeconds %% 60;
-			uptimeElement.textContent = hours + "h" + minutes + "m" + seconds + "s";
-		}
-
-		function refreshPage() { location.reload(); };
-
-		setInterval(updateUptime, 1000);
-		setTimeout(refreshPage, 300000);
-	</script>
-</head>
-<body>
-	<h1>Server Status</h1>
-	<div class="status-pill">%s</div>
-	<p>%s: <span id="uptime">%s</span></p>
-</body>
-</html>`, statusColor, int(uptime.Seconds()), status, activeString, uptime.String())
+	var rows strings.Builder
+	for i, t := range list {
+		rows.WriteString(tunnelRow(i, t, now))
+	}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(responseCode)
-	w.Write([]byte(response))
+	body := executePage("status.html", statusPageData{
+		HTMLClass:       template.HTMLAttr(htmlClass(high)),
+		Status:          overall,
+		Refresh:         template.HTML(noscriptRefresh(refreshSeconds)),
+		Stylesheets:     template.HTML(stylesheetLinks()),
+		Banners:         template.HTML(clockBanner() + staleBanner(list, now) + viewerHintsMarker),
+		OverallStatus:   template.HTML(statusPill(overall)),
+		Tunnels:         template.HTML(rows.String()),
+		Federated:       template.HTML(federatedSections(now)),
+		External:        template.HTML(externalSection()),
+		RefreshControls: template.HTML(refreshControls()),
+		ZeroTrustLink:   template.HTML(zeroTrustLink()),
+		ContrastToggle:  template.HTML(contrastToggle(high)),
+		Scripts:         []template.JS{relTimeScript, refreshScript, liveScript},
+	})
+	return renderedPage{code: responseCode, body: body}
 }
 
 func main() {
-	loadEnv()
+	if filepath.Base(os.Args[0]) == "check_cftunnel" {
+		os.Exit(runNagiosCheck(os.Args[1:]))
+	}
+	// Without a command, or with only flags, the server runs as before.
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve":
+		runServe(args)
+	case "check":
+		os.Exit(runCheck(args))
+	case "version":
+		os.Exit(runVersion(args))
+	case "prompt":
+		os.Exit(runPrompt(args))
+	case "check_cftunnel":
+		os.Exit(runNagiosCheck(args))
+	case "apply":
+		os.Exit(runApply(args))
+	case "migrate-config":
+		os.Exit(runMigrateConfig(args))
+	case "loadtest":
+		os.Exit(runLoadtest(args))
+	case "help":
+		printUsage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
 
-	go pollAPI()
+// serve runs the status page and the background pollers until the
+// process is stopped.
+func serve() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go pollAPI(ctx)
+	go runDailySummaries()
+	if snmpListen != "" {
+		go serveSNMP()
+	}
+	if k8sDiscovery != nil {
+		go watchKubernetes()
+	}
+	if dockerDiscovery != nil {
+		go watchDocker()
+	}
+	if len(connectorTargets) > 0 {
+		go scrapeConnectors()
+	}
+	if len(connectorLogSources) > 0 {
+		go tailConnectorLogs()
+	}
+	if configWatchInterval > 0 {
+		go watchTunnelConfigs()
+	}
+	if dnsCheckInterval > 0 {
+		go watchDNS()
+	}
+	if auditEnabled {
+		go pollAuditLog()
+	}
+	if serviceTokenMonitor {
+		go watchServiceTokens()
+	}
+	if zeroTrustEnabled {
+		go pollZeroTrust()
+	}
+	if stateBackend != nil {
+		go syncState()
+	}
+	if len(publishTargets) > 0 {
+		go publishStatus()
+	}
+	if selfCheckURL != "" {
+		go runSelfCheck()
+	}
+	if len(federationPeers) > 0 {
+		go federate()
+	}
+	go watchExternalStatus()
+	if cloudflareOnly {
+		go refreshCloudflareRanges()
+	}
+	root := routes()
 
-	http.HandleFunc("/", handler)
 	port := os.Getenv("HTTP_PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Println("Server started on :" + port)
-	log.Println("Polling API every", pollInterval)
-	log.Println("Press Ctrl+C to stop the server")
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	if startupWait > 0 {
+		waitForFirstPoll()
+	}
+	server := &http.Server{Addr: ":" + port, Handler: root, TLSConfig: serverTLS}
+	serveErr := make(chan error, 1)
+	if serverTLS != nil {
+		slog.Info("Server started", "addr", ":"+port, "tls", true)
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
+		if tlsRedirectPort != "" {
+			go serveTLSRedirects(port)
+		}
+	} else {
+		slog.Info("Server started", "addr", ":"+port, "tls", false)
+		go func() { serveErr <- server.ListenAndServe() }()
+	}
+	slog.Info("Polling API", "interval", pollInterval)
+	slog.Info("Press Ctrl+C to stop the server")
+	select {
+	case err := <-serveErr:
+		fatal("Error serving HTTP", "error", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process as before.
+	stop()
+	shutdown(server)
+}
+
+// routes returns the server's handler: every page and API behind the
+// access, ingress and load-shedding middleware.
+func routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", countViews("/", handler))
+	mux.HandleFunc("/theme.css", themeHandler)
+	mux.HandleFunc("GET /robots.txt", robotsHandler)
+	mux.HandleFunc("GET /sitemap.xml", sitemapHandler)
+	mux.HandleFunc("GET /events", eventsHandler)
+	mux.HandleFunc("GET /ws", websocketHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readyHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/report", countViews("/report", reportHandler))
+	mux.HandleFunc("/incidents", countViews("/incidents", incidentTimelineHandler))
+	mux.HandleFunc("GET /incidents/{id}", countViews("/incidents/{id}", incidentPageHandler))
+	mux.HandleFunc("GET /tunnels/{id}", countViews("/tunnels/{id}", tunnelHandler))
+	mux.HandleFunc("/zero-trust", countViews("/zero-trust", zeroTrustHandler))
+	mux.HandleFunc("/api/refresh", refreshHandler)
+	mux.HandleFunc("/api/status", statusAPIHandler)
+	mux.HandleFunc("GET /api/problems", problemsHandler)
+	mux.HandleFunc("GET /api/watch", watchHandler)
+	mux.HandleFunc("GET /api/federation", federationHandler)
+	mux.HandleFunc("/api/status/short", shortStatusHandler)
+	mux.HandleFunc("/api/zabbix/discovery", zabbixDiscoveryHandler)
+	mux.HandleFunc("GET /api/v1/components", componentsHandler)
+	mux.HandleFunc("GET /api/v1/components/{id}", componentHandler)
+	mux.HandleFunc("GET /api/tunnels/{id}/status", statusAtHandler)
+	mux.HandleFunc("GET /api/tunnels/{id}/intervals", intervalsHandler)
+	mux.HandleFunc("GET /api/tunnels/{id}/connections", connectionsHandler)
+	mux.HandleFunc("POST /chatops/slack", slackCommandHandler)
+	mux.HandleFunc("POST /chatops/discord", discordInteractionHandler)
+	mux.HandleFunc("POST /webhooks/pagerduty", pagerDutyWebhookHandler)
+	mux.HandleFunc("POST /webhooks/opsgenie", opsgenieWebhookHandler)
+	mux.HandleFunc("/admin/api/config", adminOnly(configHandler))
+	mux.HandleFunc("/admin/config", adminOnly(adminConfigHandler))
+	mux.HandleFunc("/admin/tunnels/{id}", adminOnly(adminTunnelHandler))
+	mux.HandleFunc("POST /admin/tunnels/{id}/snooze", adminOnly(snoozeFormHandler))
+	mux.HandleFunc("GET /admin/api/snoozes", adminOnly(snoozesHandler))
+	mux.HandleFunc("/admin/api/tunnels/{id}/snooze", adminOnly(snoozeHandler))
+	mux.HandleFunc("/admin/incidents", adminOnly(adminIncidentsHandler))
+	mux.HandleFunc("GET /admin/api/incidents", adminOnly(incidentsHandler))
+	mux.HandleFunc("POST /admin/api/incidents/{id}", adminOnly(annotateHandler))
+	mux.HandleFunc("/admin/api/exclusions", adminOnly(exclusionsHandler))
+	mux.HandleFunc("DELETE /admin/api/exclusions/{id}", adminOnly(deleteExclusionHandler))
+	mux.HandleFunc("GET /admin/api/deleted", adminOnly(deletedHandler))
+	mux.HandleFunc("POST /admin/api/deleted/{id}/restore", adminOnly(restoreDeletedHandler))
+	mux.HandleFunc("/admin/deleted", adminOnly(adminDeletedHandler))
+	mux.HandleFunc("GET /admin/api/analytics", adminOnly(analyticsHandler))
+	mux.HandleFunc("/admin/diagnostics", adminOnly(diagnosticsHandler))
+	if mockMode {
+		mux.HandleFunc("/admin/api/mock/tunnels/{id}", adminOnly(mockHandler))
+	}
+
+	var root http.Handler = noindexHeader(accessControl(mux))
+	if cloudflareOnly {
+		root = cloudflareIngress(root)
+	}
+	return loadShedding(root)
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricStatuses are the values of the status label of cftunnel_status.
+var metricStatuses = []string{"healthy", "degraded", "inactive", "down", statusUnknown}
+
+var (
+	// pollsTotal and pollErrorsTotal count API polls per tunnel since
+	// startup.
+	pollsTotal      = map[string]uint64{}
+	pollErrorsTotal = map[string]uint64{}
+	// pollErrors holds the error of each tunnel's last poll, if it failed.
+	pollErrors   = map[string]string{}
+	pollCountsMu sync.Mutex
+)
+
+// countPoll records the outcome of one API poll of a tunnel.
+func countPoll(tunnelID string, err error) {
+	pollCountsMu.Lock()
+	defer pollCountsMu.Unlock()
+	pollsTotal[tunnelID]++
+	if err != nil {
+		pollErrorsTotal[tunnelID]++
+		// The request URL names the account; the status API need not.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		pollErrors[tunnelID] = err.Error()
+	} else {
+		delete(pollErrors, tunnelID)
+	}
+}
+
+// lastPollError is the error of the tunnel's last poll, or "" if it
+// succeeded.
+func lastPollError(tunnelID string) string {
+	pollCountsMu.Lock()
+	defer pollCountsMu.Unlock()
+	return pollErrors[tunnelID]
+}
+
+// escapeLabel escapes a Prometheus label value.
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// metricsWriter writes the Prometheus text exposition format, adding the
+// HELP and TYPE lines before a metric's first sample.
+type metricsWriter struct {
+	b    strings.Builder
+	seen map[string]bool
+}
+
+func (m *metricsWriter) sample(name, typ, help, labels string, value float64) {
+	if !m.seen[name] {
+		m.seen[name] = true
+		fmt.Fprintf(&m.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(&m.b, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// metricsHandler serves /metrics in the Prometheus text format: each
+// tunnel's status, uptime, connections and last poll, and poll counters,
+// so alerts can be written in Prometheus and Alertmanager. Tunnel IDs are
+// masked for scrapers without the admin token.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	m := &metricsWriter{seen: map[string]bool{}}
+
+	list := snapshotTunnels()
+	for _, t := range list {
+		tunnel := fmt.Sprintf(`tunnel_id="%s",name="%s"`, escapeLabel(visibleTunnelID(r, t.ID)), escapeLabel(t.label()))
+		for _, status := range metricStatuses {
+			value := 0.0
+			if t.Status == status {
+				value = 1
+			}
+			m.sample("cftunnel_status", "gauge", "Whether the tunnel has the status in the status label.", fmt.Sprintf(`%s,status="%s"`, tunnel, status), value)
+		}
+	}
+	for _, t := range list {
+		since, up := t.since()
+		uptime := 0.0
+		if up && !since.IsZero() {
+			uptime = now.Sub(since).Seconds()
+		}
+		m.sample("cftunnel_uptime_seconds", "gauge", "Seconds since the tunnel's connections became active, 0 while it is not up.", fmt.Sprintf(`tunnel_id="%s"`, escapeLabel(visibleTunnelID(r, t.ID))), uptime)
+	}
+	for _, t := range list {
+		m.sample("cftunnel_connections", "gauge", "Active connections reported for the tunnel.", fmt.Sprintf(`tunnel_id="%s"`, escapeLabel(visibleTunnelID(r, t.ID))), float64(t.Connections))
+	}
+	for _, t := range list {
+		if !t.LastPollAt.IsZero() {
+			m.sample("cftunnel_last_poll_timestamp_seconds", "gauge", "Unix time of the tunnel's last successful poll.", fmt.Sprintf(`tunnel_id="%s"`, escapeLabel(visibleTunnelID(r, t.ID))), float64(t.LastPollAt.Unix()))
+		}
+	}
+
+	statusMutex.RLock()
+	last := lastPollAt
+	statusMutex.RUnlock()
+	if !last.IsZero() {
+		m.sample("cftunnel_last_poll_timestamp", "gauge", "Unix time the last poll cycle of every tunnel finished.", "", float64(last.Unix()))
+	}
+
+	pollCountsMu.Lock()
+	for _, t := range list {
+		m.sample("cftunnel_polls_total", "counter", "API polls of the tunnel since startup.", fmt.Sprintf(`tunnel_id="%s"`, escapeLabel(visibleTunnelID(r, t.ID))), float64(pollsTotal[t.ID]))
+	}
+	for _, t := range list {
+		m.sample("cftunnel_poll_errors_total", "counter", "API polls of the tunnel that failed since startup.", fmt.Sprintf(`tunnel_id="%s"`, escapeLabel(visibleTunnelID(r, t.ID))), float64(pollErrorsTotal[t.ID]))
+	}
+	pollCountsMu.Unlock()
+
+	status := monitorStatus()
+	components := make([]string, 0, len(status))
+	for component := range status {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		value := 0.0
+		if status[component] {
+			value = 1
+		}
+		m.sample("cftunnel_monitor_failing", "gauge", "Whether a part of the monitor itself, such as polling or storage, keeps failing.", fmt.Sprintf(`component="%s"`, escapeLabel(component)), value)
+	}
+
+	m.sample("cftunnel_api_requests_deferred_total", "counter", "Background Cloudflare API requests deferred to stay within the API budget.", "", float64(apiRequestsDeferred.Load()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(m.b.String()))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// migratedConfig is the CONFIG_FILE runMigrateConfig writes, a subset of
+// configFile in the order a reader expects.
+type migratedConfig struct {
+	Settings  map[string]string `json:"settings,omitempty"`
+	Tunnels   []TunnelConfig    `json:"tunnels"`
+	Notifiers []NotifierConfig  `json:"notifiers,omitempty"`
+	Privacy   *privacyPolicy    `json:"privacy,omitempty"`
+}
+
+// runMigrateConfig implements the migrate-config subcommand: it reads the
+// same environment variables as the server, from an .env file if one
+// exists, and prints the equivalent YAML CONFIG_FILE. Variables that make
+// up tunnels and notifiers become those sections; the rest of the .env
+// file, such as polling and storage, becomes settings. Per-tunnel defaults
+// that otherwise come from the environment (BUSINESS_HOURS, SLA_TARGETS,
+// weight) are written out explicitly.
+func runMigrateConfig(args []string) int {
+	flags := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	envFile := flags.String("env", ".env", "environment file to read")
+	output := flags.String("o", "-", "file to write, or - for stdout")
+	redact := flags.Bool("redact", false, "replace secrets with "+redacted+", e.g. to share the file")
+	flags.Parse(args)
+
+	env, err := godotenv.Read(*envFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "migrate-config: %v\n", err)
+		return 1
+	}
+	if err := godotenv.Load(*envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "migrate-config: %v\n", err)
+		return 1
+	}
+	if err := loadConfigFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-config: %v\n", err)
+		return 1
+	}
+	cfg := configFromEnv()
+	if len(cfg.Tunnels) == 0 {
+		fmt.Fprintln(os.Stderr, "migrate-config: no tunnels found; set TUNNEL_ID and ACCOUNT_ID")
+		return 1
+	}
+	settings := migratedSettings(env, cfg)
+	for i := range cfg.Tunnels {
+		t := &cfg.Tunnels[i]
+		if t.BusinessHours == "" {
+			t.BusinessHours = os.Getenv("BUSINESS_HOURS")
+		}
+		if t.SLA == "" {
+			t.SLA = os.Getenv("SLA_TARGETS")
+		}
+		if t.Weight == 0 {
+			t.Weight = 1
+		}
+	}
+	if _, err := cfg.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-config: the environment does not make a valid config: %v\n", err)
+		return 1
+	}
+	if *redact {
+		cfg = redactedConfig(cfg)
+		for name := range settings {
+			if secretSetting(name) {
+				settings[name] = redacted
+			}
+		}
+	}
+
+	file := migratedConfig{Settings: settings, Tunnels: cfg.Tunnels, Notifiers: cfg.Notifiers}
+	if privacy != (privacyPolicy{}) {
+		file.Privacy = &privacy
+	}
+	data, err := marshalYAML(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-config: %v\n", err)
+		return 1
+	}
+	if *output == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-config: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d settings, %d tunnels and %d notifiers to %s; use it with CONFIG_FILE=%s\n", len(settings), len(cfg.Tunnels), len(cfg.Notifiers), *output, *output)
+	return 0
+}
+
+// migratedSettings returns the variables of the .env file that are not
+// part of cfg: those whose removal leaves configFromEnv unchanged. The
+// per-tunnel defaults written into the tunnels are left out too.
+func migratedSettings(env map[string]string, cfg Config) map[string]string {
+	settings := map[string]string{}
+	for name := range env {
+		switch name {
+		case "CONFIG_FILE", "BUSINESS_HOURS", "SLA_TARGETS":
+			continue
+		}
+		if !settingName.MatchString(name) {
+			continue
+		}
+		value := os.Getenv(name)
+		os.Unsetenv(name)
+		inConfig := !reflect.DeepEqual(configFromEnv(), cfg)
+		os.Setenv(name, value)
+		if !inConfig {
+			settings[name] = value
+		}
+	}
+	return settings
+}
+
+// secretSetting reports whether the setting name looks like it holds a
+// credential, e.g. API_TOKEN or PAGERDUTY_WEBHOOK_SECRET.
+func secretSetting(name string) bool {
+	for _, word := range []string{"TOKEN", "SECRET", "PASSWORD", "KEY"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalYAML renders v as block-style YAML with the field names and order
+// of its JSON encoding, which is what readConfigFile decodes.
+func marshalYAML(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var block func(*yaml.Node)
+	block = func(n *yaml.Node) {
+		n.Style = 0
+		for _, child := range n.Content {
+			block(child)
+		}
+	}
+	block(&node)
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMockStep = time.Minute
+	// mockTunnels are the tunnels simulated when MOCK is set without any.
+	mockTunnels = "00000000-0000-4000-8000-000000000001=Mock web,00000000-0000-4000-8000-000000000002=Mock API"
+)
+
+// mockScript is a sequence of statuses a simulated tunnel steps through,
+// starting at start and repeating.
+type mockScript struct {
+	statuses []string
+	start    time.Time
+}
+
+var (
+	mockMode bool
+	mockStep = defaultMockStep
+	// mockScripts are the scripts from MOCK_SCRIPT by tunnel ID, "*" for
+	// the rest; mockOverrides are those set at runtime.
+	mockScripts   = map[string]mockScript{}
+	mockOverrides = map[string]mockScript{}
+	mockScriptsMu sync.Mutex
+)
+
+// loadMock reads MOCK; true answers every Cloudflare API request from a
+// simulation instead of the real API, for local development and for
+// testing notifiers and the pages without an account. API_TOKEN and
+// ACCOUNT_ID are then not needed, and two tunnels are simulated unless
+// TUNNEL_ID or CONFIG_FILE names others. MOCK_SCRIPT gives each tunnel a
+// sequence of statuses, e.g. "healthy,degraded,down" for every tunnel or
+// "<tunnel-id>=healthy,down; *=healthy", each held for MOCK_STEP (default
+// 1m) and repeated. Admins can change a tunnel's script while running at
+// /admin/api/mock/tunnels/{id}.
+func loadMock() error {
+	if os.Getenv("MOCK") != "true" {
+		return nil
+	}
+	mockMode = true
+	if value := os.Getenv("MOCK_STEP"); value != "" {
+		step, err := time.ParseDuration(value)
+		if err != nil || step < time.Second {
+			return fmt.Errorf("MOCK_STEP: invalid duration %q (at least 1s)", value)
+		}
+		mockStep = step
+	}
+	now := time.Now()
+	mockScripts["*"] = mockScript{statuses: []string{"healthy"}, start: now}
+	for _, item := range strings.Split(os.Getenv("MOCK_SCRIPT"), ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		id, statuses, ok := strings.Cut(item, "=")
+		if !ok {
+			id, statuses = "*", item
+		}
+		script, err := parseMockScript(statuses, now)
+		if err != nil {
+			return fmt.Errorf("MOCK_SCRIPT: %w", err)
+		}
+		mockScripts[strings.TrimSpace(id)] = script
+	}
+
+	for name, value := range map[string]string{"API_TOKEN": "mock", "ACCOUNT_ID": "mock"} {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+	if len(configFromEnv().Tunnels) == 0 {
+		os.Setenv("TUNNEL_ID", mockTunnels)
+	}
+	cloudflareClient.Transport = mockTransport{}
+	slog.Warn("MOCK is set; tunnel statuses are simulated, not read from Cloudflare")
+	return nil
+}
+
+// parseMockScript reads a comma-separated list of statuses.
+func parseMockScript(value string, start time.Time) (mockScript, error) {
+	script := mockScript{start: start}
+	for _, status := range splitList(value) {
+		if !validStatus(status) {
+			return script, fmt.Errorf("unknown status %q (available: healthy, degraded, inactive, down)", status)
+		}
+		script.statuses = append(script.statuses, status)
+	}
+	if len(script.statuses) == 0 {
+		return script, fmt.Errorf("no statuses in %q", value)
+	}
+	return script, nil
+}
+
+// mockStatus is the simulated tunnel's status at now and when it began.
+func mockStatus(id string, now time.Time) (string, time.Time) {
+	mockScriptsMu.Lock()
+	script, ok := mockOverrides[id]
+	if !ok {
+		script, ok = mockScripts[id]
+	}
+	if !ok {
+		script = mockScripts["*"]
+	}
+	mockScriptsMu.Unlock()
+	statuses := script.statuses
+	step := int(now.Sub(script.start) / mockStep)
+	status := statuses[step%len(statuses)]
+	// A status repeated from earlier steps has held since the first.
+	first := step
+	for first > 0 && first > step-len(statuses) && statuses[(first-1)%len(statuses)] == status {
+		first--
+	}
+	if first == step-len(statuses) {
+		first = 0
+	}
+	return status, script.start.Add(time.Duration(first) * mockStep)
+}
+
+// mockTransport answers Cloudflare API requests with simulated tunnels:
+// the tunnel, its connections and its configuration. Anything else gets
+// an API error.
+type mockTransport struct{}
+
+func (mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	now := time.Now()
+	path := strings.TrimPrefix(req.URL.Path, "/client/v4/accounts/")
+	parts := strings.Split(path, "/")
+	if req.Method != http.MethodGet || len(parts) < 3 || parts[1] != "cfd_tunnel" {
+		return mockResponse(req, http.StatusNotFound, map[string]any{
+			"success": false,
+			"errors":  []map[string]any{{"code": 7003, "message": "not simulated in MOCK mode"}},
+		}), nil
+	}
+	id := parts[2]
+	status, since := mockStatus(id, now)
+	connections := map[string]int{"healthy": 4, "degraded": 1}[status]
+
+	var result any
+	switch strings.Join(parts[3:], "/") {
+	case "":
+		tunnel := map[string]any{"id": id, "name": "mock-" + id[:min(8, len(id))], "status": status}
+		var conns []map[string]string
+		for i := range connections {
+			conns = append(conns, map[string]string{"id": fmt.Sprintf("%s-conn-%d", id, i)})
+		}
+		tunnel["connections"] = conns
+		if connections > 0 {
+			tunnel["conns_active_at"] = since
+		} else {
+			tunnel["conns_inactive_at"] = since
+		}
+		result = tunnel
+	case "connections":
+		colos := []string{"ams01", "lhr01"}
+		var conns []map[string]any
+		for i := range connections {
+			conns = append(conns, map[string]any{
+				"id":        fmt.Sprintf("%s-conn-%d", id, i),
+				"colo_name": colos[i%len(colos)],
+				"origin_ip": fmt.Sprintf("192.0.2.%d", 10+i/2),
+				"opened_at": since,
+			})
+		}
+		connectors := []map[string]any{}
+		if len(conns) > 0 {
+			connectors = append(connectors, map[string]any{"id": id + "-connector", "version": "mock", "arch": "linux_amd64", "conns": conns})
+		}
+		result = connectors
+	case "configurations":
+		result = map[string]any{
+			"version":    1,
+			"source":     "cloudflare",
+			"created_at": since,
+			"config":     map[string]any{"ingress": []map[string]string{{"hostname": "mock.example.com", "service": "http://localhost:8080"}, {"service": "http_status:404"}}},
+		}
+	default:
+		return mockResponse(req, http.StatusNotFound, map[string]any{
+			"success": false,
+			"errors":  []map[string]any{{"code": 7003, "message": "not simulated in MOCK mode"}},
+		}), nil
+	}
+	return mockResponse(req, http.StatusOK, map[string]any{"success": true, "result": result}), nil
+}
+
+func mockResponse(req *http.Request, code int, body any) *http.Response {
+	data, _ := json.Marshal(body)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
+
+// mockHandler serves /admin/api/mock/tunnels/{id} in MOCK mode. PUT
+// replaces the tunnel's script from now with {"script":
+// "healthy,degraded,down"} or a fixed {"status": "down"}; DELETE returns it
+// to MOCK_SCRIPT. The next poll picks the change up.
+func mockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	t, ok := findTunnel(r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", r.PathValue("id")))
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var request struct {
+			Script string `json:"script"`
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAnnotationSize)).Decode(&request); err != nil {
+			writeProblem(w, r, problemInvalidRequest, "invalid script: "+err.Error())
+			return
+		}
+		if request.Script == "" {
+			request.Script = request.Status
+		}
+		script, err := parseMockScript(request.Script, time.Now())
+		if err != nil {
+			writeProblem(w, r, problemValidation, err.Error())
+			return
+		}
+		mockScriptsMu.Lock()
+		mockOverrides[t.ID] = script
+		mockScriptsMu.Unlock()
+	case http.MethodDelete:
+		mockScriptsMu.Lock()
+		delete(mockOverrides, t.ID)
+		mockScriptsMu.Unlock()
+	default:
+		methodNotAllowed(w, r, "PUT, DELETE")
+		return
+	}
+	slog.Info("Admin changed the simulated status", "user", adminUser(r), "tunnel_id", t.ID)
+	select {
+	case pollNow <- struct{}{}:
+	default:
+	}
+	status, since := mockStatus(t.ID, time.Now())
+	writeJSON(w, http.StatusOK, map[string]any{"tunnel_id": t.ID, "status": status, "since": since})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMonitorFailures = 3
+
+// monitorComponent tracks consecutive failures of one part of the monitor
+// itself, such as polling a tunnel or writing a file.
+type monitorComponent struct {
+	failures int
+	alerted  bool
+}
+
+var (
+	// monitorFailures is how many failures in a row of a component are
+	// worth a monitor_degraded event.
+	monitorFailures   = defaultMonitorFailures
+	monitorComponents = map[string]*monitorComponent{}
+	monitorMu         sync.Mutex
+)
+
+// loadMonitor reads MONITOR_FAILURES, how many consecutive failures of a
+// poll, notifier, storage write or clock check raise a monitor alert.
+func loadMonitor() error {
+	if value := os.Getenv("MONITOR_FAILURES"); value != "" {
+		failures, err := strconv.Atoi(value)
+		if err != nil || failures < 1 {
+			return fmt.Errorf("MONITOR_FAILURES: invalid number %q", value)
+		}
+		monitorFailures = failures
+	}
+	return nil
+}
+
+// monitorResult records the outcome of an operation of component, e.g.
+// "incidents file".
+func monitorResult(component string, err error) {
+	if err != nil {
+		monitorFailure(component, err)
+	} else {
+		monitorSuccess(component)
+	}
+}
+
+// monitorFailure counts a failure of component and alerts once it has
+// failed monitorFailures times in a row.
+func monitorFailure(component string, err error) {
+	monitorMu.Lock()
+	c := monitorComponents[component]
+	if c == nil {
+		c = &monitorComponent{}
+		monitorComponents[component] = c
+	}
+	c.failures++
+	alert := c.failures >= monitorFailures && !c.alerted
+	if alert {
+		c.alerted = true
+	}
+	failures := c.failures
+	monitorMu.Unlock()
+
+	if alert {
+		slog.Error("Monitor component failing", "component", component, "failures", failures, "error", err)
+		notify(monitorEvent(eventMonitorDegraded, component, failures, err))
+	}
+}
+
+// monitorSuccess resets component's failures and reports its recovery if
+// it had alerted.
+func monitorSuccess(component string) {
+	monitorMu.Lock()
+	c := monitorComponents[component]
+	if c == nil {
+		monitorMu.Unlock()
+		return
+	}
+	recovered := c.alerted
+	c.failures = 0
+	c.alerted = false
+	monitorMu.Unlock()
+
+	if recovered {
+		slog.Info("Monitor component recovered", "component", component)
+		notify(monitorEvent(eventMonitorRecovered, component, 0, nil))
+	}
+}
+
+func monitorEvent(typ, component string, failures int, cause error) Event {
+	event := Event{ID: newEventID(), Type: typ, Time: time.Now()}
+	if typ == eventMonitorDegraded {
+		event.Title = "Tunnel monitor needs attention: " + component
+		event.Message = fmt.Sprintf("The tunnel monitor's %s failed %d times in a row: %v. Tunnel statuses and alerts may be stale or missing until it recovers.",
+			component, failures, cause)
+		return event
+	}
+	event.Title = "Tunnel monitor recovered: " + component
+	event.Message = fmt.Sprintf("The tunnel monitor's %s is working again.", component)
+	return event
+}
+
+// monitorStatus reports, for every component that has run, whether it is
+// failing: it has alerted and not yet recovered.
+func monitorStatus() map[string]bool {
+	monitorMu.Lock()
+	defer monitorMu.Unlock()
+	status := map[string]bool{}
+	for component, c := range monitorComponents {
+		status[component] = c.alerted
+	}
+	return status
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosLabels = map[int]string{
+	nagiosOK:       "OK",
+	nagiosWarning:  "WARNING",
+	nagiosCritical: "CRITICAL",
+	nagiosUnknown:  "UNKNOWN",
+}
+
+// nagiosState maps a tunnel status to a plugin exit code. Whether an
+// inactive tunnel (no connectors configured to run) is a problem depends on
+// the deployment, so it is configurable.
+func nagiosState(status string, inactive int) int {
+	switch status {
+	case "healthy":
+		return nagiosOK
+	case "degraded":
+		return nagiosWarning
+	case "down":
+		return nagiosCritical
+	case "inactive":
+		return inactive
+	default:
+		return nagiosUnknown
+	}
+}
+
+// runNagiosCheck implements check_cftunnel: a one-shot poll of a single
+// tunnel that prints a Nagios plugin status line with perfdata and exits
+// with the matching plugin exit code. Credentials default to the same
+// environment variables as the server, read from .env if one exists.
+func runNagiosCheck(args []string) int {
+	godotenv.Load()
+
+	flags := flag.NewFlagSet("check_cftunnel", flag.ExitOnError)
+	account := flags.String("a", os.Getenv("ACCOUNT_ID"), "Cloudflare account ID")
+	tunnel := flags.String("t", os.Getenv("TUNNEL_ID"), "tunnel ID")
+	token := flags.String("k", os.Getenv("API_TOKEN"), "Cloudflare API token")
+	inactive := flags.String("inactive", "critical", "state for inactive tunnels: ok, warning or critical")
+	timeout := flags.Duration("timeout", 10*time.Second, "API request timeout")
+	flags.Parse(args)
+
+	inactiveState := map[string]int{"ok": nagiosOK, "warning": nagiosWarning, "critical": nagiosCritical}
+	inactiveCode, ok := inactiveState[*inactive]
+	if !ok {
+		fmt.Printf("CFTUNNEL UNKNOWN - invalid -inactive value %q\n", *inactive)
+		return nagiosUnknown
+	}
+	if *account == "" || *tunnel == "" || *token == "" {
+		fmt.Println("CFTUNNEL UNKNOWN - account (-a), tunnel (-t) and token (-k) are required")
+		return nagiosUnknown
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	apiResponse, err := fetchTunnel(ctx, *account, *tunnel, *token)
+	if err != nil {
+		fmt.Printf("CFTUNNEL UNKNOWN - %v\n", err)
+		return nagiosUnknown
+	}
+
+	tunnelStatus := apiResponse.Result.Status
+	code := nagiosState(tunnelStatus, inactiveCode)
+	name := apiResponse.Result.Name
+	if name == "" {
+		name = *tunnel
+	}
+
+	label, since := "up", apiResponse.Result.ConnsActiveAt
+	if since.IsZero() {
+		label, since = "down", apiResponse.Result.ConnsInactiveAt
+	}
+	elapsed := time.Duration(0)
+	if !since.IsZero() {
+		elapsed = time.Since(since).Truncate(time.Second)
+	}
+	uptime := 0
+	if label == "up" {
+		uptime = int(elapsed.Seconds())
+	}
+
+	fmt.Printf("CFTUNNEL %s - tunnel %s is %s, %s for %s | uptime=%ds;;;0 connections=%d;;;0\n",
+		nagiosLabels[code], name, statusLabel(tunnelStatus), label, formatElapsed(elapsed),
+		uptime, len(apiResponse.Result.Connections))
+	return code
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyTimeout bounds a single delivery attempt to one notifier, unless
+// its timeout setting is shorter.
+const notifyTimeout = 30 * time.Second
+
+// Event types.
+const (
+	eventStatusChanged = "status_changed"
+	eventConfigChanged = "config_changed"
+	// Access service tokens have no tunnel; TokenName and ExpiresAt
+	// identify them.
+	eventServiceTokenExpiring = "service_token_expiring"
+	eventServiceTokenExpired  = "service_token_expired"
+	// Sent by the self-check about the status page itself.
+	eventStatusPageDown = "status_page_down"
+	eventStatusPageUp   = "status_page_up"
+	// eventDigest batches non-critical events for channels with a digest.
+	eventDigest = "digest"
+	// SLA events name the target in SLA.
+	eventSLABudgetLow = "sla_budget_low"
+	eventSLABreached  = "sla_breached"
+	eventSLABurnRate  = "sla_burn_rate"
+	// Monitor events are about this service's own polling, notifiers,
+	// storage and clock rather than a tunnel.
+	eventMonitorDegraded  = "monitor_degraded"
+	eventMonitorRecovered = "monitor_recovered"
+	// DNS events name the tunnel's record in Hostname.
+	eventDNSBroken = "dns_record_broken"
+	eventDNSFixed  = "dns_record_fixed"
+)
+
+// Event is something worth telling operators about, such as a tunnel
+// changing status. Title and Message are ready-to-send text for chat and
+// email style notifiers; the other fields are for structured consumers.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	TunnelID   string    `json:"tunnel_id,omitempty"`
+	TunnelName string    `json:"tunnel_name,omitempty"`
+	OldStatus  string    `json:"old_status,omitempty"`
+	NewStatus  string    `json:"new_status,omitempty"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	// Diff is the full configuration diff of config_changed events.
+	Diff      string     `json:"diff,omitempty"`
+	TokenName string     `json:"token_name,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	SLA       string     `json:"sla,omitempty"`
+	Hostname  string     `json:"hostname,omitempty"`
+	// URL links to the tunnel's page at the time of the event, when
+	// PUBLIC_URL is set; Links are the tunnel's configured quick links.
+	URL   string            `json:"url,omitempty"`
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// Notifier delivers events to an external channel.
+type Notifier interface {
+	// Name identifies the notifier in logs.
+	Name() string
+	Notify(ctx context.Context, event Event) error
+}
+
+var (
+	// notifiers and incidentHooks are replaced when a config is applied,
+	// under notifiersMu, keeping the notifiers the config left unchanged.
+	notifiers   []Notifier
+	notifiersMu sync.RWMutex
+	httpClient  = outboundClient(notifyTimeout)
+)
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusChangeEvent describes a tunnel moving from one status to another.
+func statusChangeEvent(id, name, oldStatus, newStatus string, at time.Time) Event {
+	label := name
+	if label == "" {
+		label = id
+	}
+	return Event{
+		ID:         newEventID(),
+		Type:       eventStatusChanged,
+		Time:       at,
+		TunnelID:   id,
+		TunnelName: name,
+		OldStatus:  oldStatus,
+		NewStatus:  newStatus,
+		Title:      fmt.Sprintf("Tunnel %s is %s", label, statusLabel(newStatus)),
+		Message: fmt.Sprintf("Tunnel %s changed from %s to %s at %s.",
+			label, statusLabel(oldStatus), statusLabel(newStatus), at.UTC().Format(time.RFC1123)),
+	}
+}
+
+// statusTransition renders "old → new" for status change events and ""
+// for anything else.
+func statusTransition(event Event) string {
+	if event.NewStatus == "" {
+		return ""
+	}
+	return statusLabel(event.OldStatus) + " → " + statusLabel(event.NewStatus)
+}
+
+// notify sends event to every configured notifier in the background,
+// through the bounded worker pool. Events about a tunnel carry a link to it
+// and its quick links.
+func notify(event Event) {
+	if until := snoozedUntil(event.TunnelID, time.Now()); !until.IsZero() {
+		slog.Info("Not sending event: tunnel snoozed", "event_type", event.Type, "tunnel_id", event.TunnelID, "snoozed_until", until)
+		return
+	}
+	// Once an outage is acknowledged only its recovery is announced.
+	if event.Type == eventStatusChanged && event.NewStatus != "healthy" && incidentAcknowledged(event.TunnelID) {
+		slog.Info("Not sending event: incident acknowledged", "event_type", event.Type, "tunnel_id", event.TunnelID)
+		return
+	}
+	event = addTunnelContext(event)
+	notifiersMu.RLock()
+	current := notifiers
+	notifiersMu.RUnlock()
+	for _, n := range current {
+		dispatch(notifyJob{notifier: n, event: event})
+	}
+}
+
+// postJSON POSTs body, JSON-encoded unless it is already a []byte, and
+// treats any non-2xx response as an error.
+func postJSON(ctx context.Context, url string, headers map[string]string, body any) error {
+	return doJSON(ctx, "POST", url, headers, body, nil)
+}
+
+// doJSON sends body as JSON with the given method and, when out is not
+// nil, decodes the JSON response into it. A nil body sends no content.
+func doJSON(ctx context.Context, method, url string, headers map[string]string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, ok := body.([]byte)
+		if !ok {
+			var err error
+			if payload, err = json.Marshal(body); err != nil {
+				return err
+			}
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return sendRequest(req, out)
+}
+
+// postForm POSTs form as application/x-www-form-urlencoded.
+func postForm(ctx context.Context, endpoint string, headers map[string]string, form url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return sendRequest(req, nil)
+}
+
+func sendRequest(req *http.Request, out any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("parsing response: %w", err)
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// splitList splits a comma-separated environment value, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
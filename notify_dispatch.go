@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNotifyWorkers = 8
+	// notifyQueueSize bounds the deliveries waiting for a worker.
+	notifyQueueSize = 1024
+)
+
+// notifyJob is one event to deliver to one notifier.
+type notifyJob struct {
+	notifier Notifier
+	event    Event
+}
+
+var (
+	notifyWorkers = defaultNotifyWorkers
+	// Critical deliveries have their own queue, which workers drain first,
+	// so pages are not stuck behind a backlog of routine events.
+	criticalJobs      = make(chan notifyJob, notifyQueueSize)
+	routineJobs       = make(chan notifyJob, notifyQueueSize)
+	notifyWorkersOnce sync.Once
+)
+
+// loadNotifyDispatch reads NOTIFY_WORKERS, how many deliveries run at once
+// (default 8).
+func loadNotifyDispatch() error {
+	value := os.Getenv("NOTIFY_WORKERS")
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("NOTIFY_WORKERS: invalid number %q", value)
+	}
+	notifyWorkers = n
+	return nil
+}
+
+// dispatch queues a delivery for the worker pool, starting it on first
+// use. A critical delivery that finds its queue full is sent right away
+// rather than dropped.
+func dispatch(job notifyJob) {
+	notifyWorkersOnce.Do(func() {
+		for range notifyWorkers {
+			go notifyWorker()
+		}
+	})
+	queue := routineJobs
+	if isCritical(job.event) {
+		queue = criticalJobs
+	}
+	select {
+	case queue <- job:
+	default:
+		if queue == criticalJobs {
+			go deliver(job)
+			return
+		}
+		slog.Warn("Notification queue full; dropped event", "event_type", job.event.Type, "notifier", job.notifier.Name(), "tunnel_id", job.event.TunnelID)
+	}
+}
+
+func notifyWorker() {
+	for {
+		select {
+		case job := <-criticalJobs:
+			deliver(job)
+			continue
+		default:
+		}
+		select {
+		case job := <-criticalJobs:
+			deliver(job)
+		case job := <-routineJobs:
+			deliver(job)
+		}
+	}
+}
+
+// deliver sends one event. Each notifier applies its own timeout.
+func deliver(job notifyJob) {
+	err := job.notifier.Notify(context.Background(), job.event)
+	if err != nil {
+		slog.Error("Error sending event", "event_type", job.event.Type, "notifier", job.notifier.Name(), "tunnel_id", job.event.TunnelID, "error", err)
+	}
+	monitorResult("notifier "+job.notifier.Name(), err)
+}
+
+// timeoutNotifier bounds every delivery to a notifier.
+type timeoutNotifier struct {
+	Notifier
+	timeout time.Duration
+}
+
+// timeoutFromEnv reads NOTIFY_TIMEOUTS, a comma-separated list of
+// notifier-name=duration entries (e.g. email-1=10s), into the timeout
+// setting of the named notifiers.
+func timeoutFromEnv(notifiers []NotifierConfig) {
+	for _, entry := range splitList(os.Getenv("NOTIFY_TIMEOUTS")) {
+		name, timeout, _ := strings.Cut(entry, "=")
+		for i := range notifiers {
+			if notifiers[i].Name == strings.TrimSpace(name) {
+				if notifiers[i].Settings == nil {
+					notifiers[i].Settings = map[string]string{}
+				}
+				notifiers[i].Settings["timeout"] = strings.TrimSpace(timeout)
+			}
+		}
+	}
+}
+
+// withTimeout wraps n with its timeout setting, accepted by every notifier
+// type, or notifyTimeout without one. HTTP requests are also bounded by
+// notifyTimeout, so the setting can only shorten it.
+func withTimeout(settings map[string]string, n Notifier) (Notifier, error) {
+	timeout := notifyTimeout
+	if value := settings["timeout"]; value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < time.Second || timeout > notifyTimeout {
+			return nil, fmt.Errorf("timeout: invalid duration %q (1s to %s)", value, notifyTimeout)
+		}
+	}
+	return &timeoutNotifier{Notifier: n, timeout: timeout}, nil
+}
+
+func (n *timeoutNotifier) Notify(ctx context.Context, event Event) error {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	return n.Notifier.Notify(ctx, event)
+}
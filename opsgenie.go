@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// opsgenieAppURL is linked for the alerts this service creates; alerts
+// are found by their alias, the incident ID.
+const opsgenieAppURL = "https://app.opsgenie.com/alert/list"
+
+// opsgenieHook creates an Opsgenie alert for outages that last longer than
+// threshold, aliased by the incident ID, and closes it when the tunnel
+// recovers.
+type opsgenieHook struct {
+	baseURL   string
+	apiKey    string
+	priority  string
+	threshold time.Duration
+}
+
+// opsgenieWebhookToken authenticates the webhooks sent to
+// /webhooks/opsgenie.
+var opsgenieWebhookToken string
+
+// opsgenieFromEnv reads OPSGENIE_API_KEY, the key of an API integration,
+// OPSGENIE_REGION, OPSGENIE_PRIORITY and OPSGENIE_OUTAGE_THRESHOLD.
+func opsgenieFromEnv() []NotifierConfig {
+	if os.Getenv("OPSGENIE_API_KEY") == "" {
+		return nil
+	}
+	return []NotifierConfig{{Name: "opsgenie", Type: "opsgenie", Settings: envSettings(map[string]string{
+		"api_key":          "OPSGENIE_API_KEY",
+		"region":           "OPSGENIE_REGION",
+		"priority":         "OPSGENIE_PRIORITY",
+		"outage_threshold": "OPSGENIE_OUTAGE_THRESHOLD",
+	})}}
+}
+
+// newOpsgenieIntegration takes api_key, region (us or eu; default us),
+// priority (P1 to P5; default P1) and outage_threshold (default 0).
+func newOpsgenieIntegration(settings map[string]string) (integration, error) {
+	hook := &opsgenieHook{apiKey: settings["api_key"], priority: strings.ToUpper(settings["priority"])}
+	if hook.apiKey == "" {
+		return integration{}, fmt.Errorf("api_key is required")
+	}
+	switch region := strings.ToLower(settings["region"]); region {
+	case "", "us":
+		hook.baseURL = "https://api.opsgenie.com"
+	case "eu":
+		hook.baseURL = "https://api.eu.opsgenie.com"
+	default:
+		return integration{}, fmt.Errorf("region: unknown region %q (available: us, eu)", region)
+	}
+	switch hook.priority {
+	case "":
+		hook.priority = "P1"
+	case "P1", "P2", "P3", "P4", "P5":
+	default:
+		return integration{}, fmt.Errorf("priority: unknown priority %q (available: P1 to P5)", settings["priority"])
+	}
+	if value := settings["outage_threshold"]; value != "" {
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 0 {
+			return integration{}, fmt.Errorf("outage_threshold: invalid duration %q", value)
+		}
+		hook.threshold = threshold
+	}
+	return integration{hook: hook}, nil
+}
+
+func (h *opsgenieHook) Name() string {
+	return "opsgenie"
+}
+
+func (h *opsgenieHook) Threshold() time.Duration {
+	return h.threshold
+}
+
+func (h *opsgenieHook) headers() map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + h.apiKey}
+}
+
+// alertAction runs an action such as close on the incident's alert.
+func (h *opsgenieHook) alertAction(ctx context.Context, rec incidentRecord, action, note string) error {
+	endpoint := fmt.Sprintf("%s/v2/alerts/%s/%s?identifierType=alias", h.baseURL, url.PathEscape(rec.ID), action)
+	return postJSON(ctx, endpoint, h.headers(), map[string]string{"source": "cftunnels", "note": note})
+}
+
+func (h *opsgenieHook) Open(ctx context.Context, rec incidentRecord) (string, error) {
+	err := postJSON(ctx, h.baseURL+"/v2/alerts", h.headers(), map[string]any{
+		"message":     fmt.Sprintf("Tunnel %s outage: %s", rec.Tunnel, statusLabel(rec.Status)),
+		"alias":       rec.ID,
+		"description": fmt.Sprintf("Tunnel %s (%s) has been %s since %s.", rec.Tunnel, rec.TunnelID, statusLabel(rec.Status), rec.Start.UTC().Format(time.RFC1123)),
+		"entity":      rec.TunnelID,
+		"source":      "cftunnels",
+		"priority":    h.priority,
+		"tags":        []string{"cftunnels"},
+	})
+	if err != nil {
+		return "", err
+	}
+	return opsgenieAppURL, nil
+}
+
+func (h *opsgenieHook) Acknowledge(ctx context.Context, rec incidentRecord, link string) error {
+	return h.alertAction(ctx, rec, "acknowledge", "Acknowledged by "+rec.AckedBy)
+}
+
+func (h *opsgenieHook) Resolve(ctx context.Context, rec incidentRecord, link string) error {
+	return h.alertAction(ctx, rec, "close", "Tunnel recovered")
+}
+
+// loadOpsgenieWebhook reads OPSGENIE_WEBHOOK_TOKEN, sent as a bearer token
+// by an Opsgenie webhook integration that forwards Acknowledge and Close
+// actions to /webhooks/opsgenie. The endpoint is disabled without it.
+func loadOpsgenieWebhook() error {
+	opsgenieWebhookToken = os.Getenv("OPSGENIE_WEBHOOK_TOKEN")
+	return nil
+}
+
+// opsgenieWebhook is the part of an Opsgenie webhook the sync uses.
+type opsgenieWebhook struct {
+	Action string `json:"action"`
+	Alert  struct {
+		Alias    string `json:"alias"`
+		Username string `json:"username"`
+	} `json:"alert"`
+}
+
+// opsgenieWebhookHandler serves POST /webhooks/opsgenie: acknowledging or
+// closing an alert this service created acknowledges the incident here
+// too. Other actions are accepted and ignored.
+func opsgenieWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if opsgenieWebhookToken == "" {
+		writeProblem(w, r, problemDisabled, "Opsgenie webhooks disabled; set OPSGENIE_WEBHOOK_TOKEN")
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(opsgenieWebhookToken)) != 1 {
+		writeProblem(w, r, problemUnauthorized, "invalid token")
+		return
+	}
+	var webhook opsgenieWebhook
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxChatRequestSize)).Decode(&webhook); err != nil {
+		writeProblem(w, r, problemInvalidRequest, "invalid webhook: "+err.Error())
+		return
+	}
+	actor := webhook.Alert.Username
+	if actor == "" {
+		actor = "Opsgenie"
+	}
+	id := webhook.Alert.Alias
+	switch webhook.Action {
+	case "Acknowledge":
+		acknowledgeRecord(id, actor, "Opsgenie")
+	case "Close":
+		resolveRecordInPager(id, "opsgenie", actor, "Opsgenie")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	slog.Info("Opsgenie webhook", "action", webhook.Action, "user", actor, "incident_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
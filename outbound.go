@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// outboundProxy, when set, carries every outbound HTTP request except
+	// those to hosts in outboundNoProxy.
+	outboundProxy   *url.URL
+	outboundNoProxy []string
+	// outboundCAs is the system pool plus EXTRA_CA_CERTS, nil without
+	// extra certificates.
+	outboundCAs *x509.CertPool
+
+	// outboundTransport carries every outbound HTTP request; loadOutbound
+	// applies the proxy and CA settings to it.
+	outboundTransport = http.DefaultTransport.(*http.Transport).Clone()
+)
+
+// loadOutbound reads OUTBOUND_PROXY, an http, https or socks5 proxy URL for
+// outbound calls (Cloudflare API, notifiers, publishing), with
+// OUTBOUND_NO_PROXY, a comma-separated list of hosts and .domains that
+// bypass it, and EXTRA_CA_CERTS, a comma-separated list of PEM files
+// trusted in addition to the system roots, e.g. a TLS-intercepting
+// proxy's CA. Without OUTBOUND_PROXY the standard HTTPS_PROXY and NO_PROXY
+// variables still apply. SMTP is not proxied: an email notifier's host
+// must be in OUTBOUND_NO_PROXY.
+func loadOutbound() error {
+	if value := os.Getenv("OUTBOUND_PROXY"); value != "" {
+		proxy, err := url.Parse(value)
+		if err != nil || proxy.Host == "" {
+			return fmt.Errorf("OUTBOUND_PROXY: invalid URL %q", value)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("OUTBOUND_PROXY: unsupported scheme %q (available: http, https, socks5)", proxy.Scheme)
+		}
+		outboundProxy = proxy
+	}
+	for _, host := range splitList(os.Getenv("OUTBOUND_NO_PROXY")) {
+		outboundNoProxy = append(outboundNoProxy, strings.ToLower(host))
+	}
+
+	if files := splitList(os.Getenv("EXTRA_CA_CERTS")); len(files) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range files {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("EXTRA_CA_CERTS: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("EXTRA_CA_CERTS: no certificates found in %s", file)
+			}
+		}
+		outboundCAs = pool
+	}
+
+	configureTransport(outboundTransport)
+	return nil
+}
+
+// outboundClient returns a client for outbound calls through
+// outboundTransport that gives up after timeout.
+func outboundClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: outboundTransport}
+}
+
+// configureTransport applies the proxy and CA settings to t, for clients
+// such as the AWS SDK's that build their own transport.
+func configureTransport(t *http.Transport) {
+	if outboundProxy != nil {
+		t.Proxy = outboundProxyFor
+	}
+	if outboundCAs != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.RootCAs = outboundCAs
+	}
+}
+
+// outboundProxyFor is the transport's Proxy function under OUTBOUND_PROXY.
+func outboundProxyFor(req *http.Request) (*url.URL, error) {
+	if outboundBypassed(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return outboundProxy, nil
+}
+
+// outboundBypassed reports whether host is in OUTBOUND_NO_PROXY.
+func outboundBypassed(host string) bool {
+	host = strings.ToLower(host)
+	for _, bypass := range outboundNoProxy {
+		if host == strings.TrimPrefix(bypass, ".") || (strings.HasPrefix(bypass, ".") && strings.HasSuffix(host, bypass)) {
+			return true
+		}
+	}
+	return false
+}
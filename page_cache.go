@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// renderedPage is a rendered status page and the HTTP status it is served
+// with.
+type renderedPage struct {
+	code int
+	body []byte
+}
+
+// pageKey identifies a variant of the status page.
+type pageKey struct {
+	high           bool
+	refreshSeconds int
+}
+
+var (
+	// pageCache holds the rendered status page variants until the
+	// tunnels change. The page is requested far more often than it
+	// changes, above all during an outage when everyone is watching it.
+	pageCache   = map[pageKey]renderedPage{}
+	pageCacheMu sync.Mutex
+)
+
+// cachedStatusPage returns the status page variant, rendering it only if
+// the tunnels changed since it was last rendered. Elapsed times are
+// therefore as of the last change; relTimeScript keeps them current in
+// the browser. Refresh intervals other than the offered ones are rendered
+// every time so odd query strings cannot grow the cache.
+func cachedStatusPage(high bool, refreshSeconds int) renderedPage {
+	key := pageKey{high: high, refreshSeconds: refreshSeconds}
+	if refreshSeconds != int(refreshInterval.Seconds()) && !slices.Contains(refreshOptions, refreshSeconds) {
+		return renderStatusPage(high, refreshSeconds)
+	}
+
+	pageCacheMu.Lock()
+	defer pageCacheMu.Unlock()
+	page, ok := pageCache[key]
+	if !ok {
+		page = renderStatusPage(high, refreshSeconds)
+		pageCache[key] = page
+	}
+	return page
+}
+
+// invalidatePageCache drops the rendered pages after the tunnels or their
+// status have changed.
+func invalidatePageCache() {
+	pageCacheMu.Lock()
+	clear(pageCache)
+	pageCacheMu.Unlock()
+}
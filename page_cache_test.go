@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func BenchmarkCachedStatusPage(b *testing.B) {
+	setupLoadtest(b)
+	refreshSeconds := int(refreshInterval.Seconds())
+	cachedStatusPage(false, refreshSeconds)
+	b.ReportAllocs()
+	for b.Loop() {
+		cachedStatusPage(false, refreshSeconds)
+	}
+}
+
+func BenchmarkRenderStatusPage(b *testing.B) {
+	setupLoadtest(b)
+	refreshSeconds := int(refreshInterval.Seconds())
+	b.ReportAllocs()
+	for b.Loop() {
+		renderStatusPage(false, refreshSeconds)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// defaultTemplates are the built-in page templates.
+//
+//go:embed templates/*.html
+var defaultTemplates embed.FS
+
+var (
+	builtinPageTemplates = template.Must(template.ParseFS(defaultTemplates, "templates/*.html"))
+	// pageTemplates are the built-in templates with TEMPLATE_DIR's
+	// overrides.
+	pageTemplates = builtinPageTemplates
+)
+
+// statusPageData is what the status page template renders. The HTML
+// fields are rendered by the server so the live updates keep working
+// with any template.
+type statusPageData struct {
+	HTMLClass template.HTMLAttr
+	// Status is the overall status, e.g. "degraded".
+	Status          string
+	Refresh         template.HTML
+	Stylesheets     template.HTML
+	Banners         template.HTML
+	OverallStatus   template.HTML
+	Tunnels         template.HTML
+	Federated       template.HTML
+	External        template.HTML
+	RefreshControls template.HTML
+	ZeroTrustLink   template.HTML
+	ContrastToggle  template.HTML
+	Scripts         []template.JS
+}
+
+// loadPageTemplates reads TEMPLATE_DIR, a directory of html/template files
+// parsed over the built-in ones in templates/. A file can replace the whole
+// status page by being named status.html, or brand it by redefining just
+// its blocks, e.g. {{define "title"}}Acme Status{{end}} or a "header" with
+// a logo; "head" and "footer" are empty by default. Colours are set with
+// THEME or CUSTOM_CSS_URL.
+func loadPageTemplates() error {
+	dir := os.Getenv("TEMPLATE_DIR")
+	if dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("TEMPLATE_DIR: no .html files in %s", dir)
+	}
+	templates, err := template.Must(builtinPageTemplates.Clone()).ParseFiles(files...)
+	if err != nil {
+		return fmt.Errorf("TEMPLATE_DIR: %w", err)
+	}
+	// Render once so errors that only show when executing fail at startup.
+	if err := templates.ExecuteTemplate(&bytes.Buffer{}, "status.html", statusPageData{Status: statusUnknown}); err != nil {
+		return fmt.Errorf("TEMPLATE_DIR: %w", err)
+	}
+	pageTemplates = templates
+	slog.Info("Templates loaded", "count", len(files), "dir", dir)
+	return nil
+}
+
+// executePage renders the named page template, falling back to the
+// built-in one if a custom template fails.
+func executePage(name string, data any) []byte {
+	var b bytes.Buffer
+	err := pageTemplates.ExecuteTemplate(&b, name, data)
+	if err == nil {
+		return b.Bytes()
+	}
+	slog.Error("Error rendering template", "template", name, "error", err)
+	b.Reset()
+	if err := builtinPageTemplates.ExecuteTemplate(&b, name, data); err != nil {
+		slog.Error("Error rendering built-in template", "template", name, "error", err)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	// pagerDutyAppURL is linked until a webhook reports the incident's
+	// own page.
+	pagerDutyAppURL = "https://app.pagerduty.com/incidents"
+)
+
+// pagerDutyHook triggers a PagerDuty alert for outages that last longer
+// than threshold, keyed by the incident ID, and resolves it when the
+// tunnel recovers.
+type pagerDutyHook struct {
+	routingKey string
+	severity   string
+	threshold  time.Duration
+}
+
+// pagerDutyWebhookSecret verifies the V3 webhooks sent to
+// /webhooks/pagerduty.
+var pagerDutyWebhookSecret string
+
+// pagerDutyFromEnv reads PAGERDUTY_ROUTING_KEY, the integration key of an
+// Events API v2 integration, PAGERDUTY_SEVERITY and
+// PAGERDUTY_OUTAGE_THRESHOLD.
+func pagerDutyFromEnv() []NotifierConfig {
+	if os.Getenv("PAGERDUTY_ROUTING_KEY") == "" {
+		return nil
+	}
+	return []NotifierConfig{{Name: "pagerduty", Type: "pagerduty", Settings: envSettings(map[string]string{
+		"routing_key":      "PAGERDUTY_ROUTING_KEY",
+		"severity":         "PAGERDUTY_SEVERITY",
+		"outage_threshold": "PAGERDUTY_OUTAGE_THRESHOLD",
+	})}}
+}
+
+// newPagerDutyIntegration takes routing_key, severity (critical, error,
+// warning or info; default critical) and outage_threshold (default 0,
+// paging as soon as a tunnel is unhealthy).
+func newPagerDutyIntegration(settings map[string]string) (integration, error) {
+	hook := &pagerDutyHook{routingKey: settings["routing_key"], severity: settings["severity"]}
+	if hook.routingKey == "" {
+		return integration{}, fmt.Errorf("routing_key is required")
+	}
+	switch hook.severity {
+	case "":
+		hook.severity = "critical"
+	case "critical", "error", "warning", "info":
+	default:
+		return integration{}, fmt.Errorf("severity: unknown severity %q (available: critical, error, warning, info)", hook.severity)
+	}
+	if value := settings["outage_threshold"]; value != "" {
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 0 {
+			return integration{}, fmt.Errorf("outage_threshold: invalid duration %q", value)
+		}
+		hook.threshold = threshold
+	}
+	return integration{hook: hook}, nil
+}
+
+func (h *pagerDutyHook) Name() string {
+	return "pagerduty"
+}
+
+func (h *pagerDutyHook) Threshold() time.Duration {
+	return h.threshold
+}
+
+// enqueue sends an Events API v2 event for the incident; its ID is the
+// dedup key, which PagerDuty reports back as the incident key.
+func (h *pagerDutyHook) enqueue(ctx context.Context, action string, rec incidentRecord, payload map[string]any) error {
+	event := map[string]any{
+		"routing_key":  h.routingKey,
+		"event_action": action,
+		"dedup_key":    rec.ID,
+	}
+	if payload != nil {
+		event["payload"] = payload
+	}
+	return postJSON(ctx, pagerDutyEventsURL, nil, event)
+}
+
+func (h *pagerDutyHook) Open(ctx context.Context, rec incidentRecord) (string, error) {
+	err := h.enqueue(ctx, "trigger", rec, map[string]any{
+		"summary":   fmt.Sprintf("Tunnel %s outage: %s", rec.Tunnel, statusLabel(rec.Status)),
+		"source":    rec.TunnelID,
+		"severity":  h.severity,
+		"timestamp": rec.Start.UTC().Format(time.RFC3339),
+		"component": rec.Tunnel,
+		"custom_details": map[string]string{
+			"incident_id": rec.ID,
+			"status":      rec.Status,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return pagerDutyAppURL, nil
+}
+
+func (h *pagerDutyHook) Acknowledge(ctx context.Context, rec incidentRecord, link string) error {
+	return h.enqueue(ctx, "acknowledge", rec, nil)
+}
+
+func (h *pagerDutyHook) Resolve(ctx context.Context, rec incidentRecord, link string) error {
+	return h.enqueue(ctx, "resolve", rec, nil)
+}
+
+// loadPagerDutyWebhook reads PAGERDUTY_WEBHOOK_SECRET, the signing secret
+// of a PagerDuty V3 webhook subscription sending incident.acknowledged and
+// incident.resolved to /webhooks/pagerduty. The endpoint is disabled
+// without it.
+func loadPagerDutyWebhook() error {
+	pagerDutyWebhookSecret = os.Getenv("PAGERDUTY_WEBHOOK_SECRET")
+	return nil
+}
+
+// pagerDutyWebhook is the part of a V3 webhook the sync uses.
+type pagerDutyWebhook struct {
+	Event struct {
+		EventType string `json:"event_type"`
+		Agent     *struct {
+			Summary string `json:"summary"`
+		} `json:"agent"`
+		Data struct {
+			IncidentKey string `json:"incident_key"`
+			HTMLURL     string `json:"html_url"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// pagerDutyWebhookHandler serves POST /webhooks/pagerduty: acknowledging or
+// resolving an alert this service triggered acknowledges the incident
+// here too. Other events are accepted and ignored.
+func pagerDutyWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if pagerDutyWebhookSecret == "" {
+		writeProblem(w, r, problemDisabled, "PagerDuty webhooks disabled; set PAGERDUTY_WEBHOOK_SECRET")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxChatRequestSize))
+	if err != nil {
+		writeProblem(w, r, problemInvalidRequest, "")
+		return
+	}
+	if !pagerDutySignatureValid(r.Header.Get("X-PagerDuty-Signature"), body) {
+		writeProblem(w, r, problemUnauthorized, "invalid signature")
+		return
+	}
+	var webhook pagerDutyWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		writeProblem(w, r, problemInvalidRequest, "invalid webhook: "+err.Error())
+		return
+	}
+	event := webhook.Event
+	actor := "PagerDuty"
+	if event.Agent != nil && event.Agent.Summary != "" {
+		actor = event.Agent.Summary
+	}
+	id := event.Data.IncidentKey
+	switch event.EventType {
+	case "incident.acknowledged":
+		if _, ok := acknowledgeRecord(id, actor, "PagerDuty"); ok && event.Data.HTMLURL != "" {
+			setIncidentLink(id, "pagerduty", event.Data.HTMLURL)
+		}
+	case "incident.resolved":
+		resolveRecordInPager(id, "pagerduty", actor, "PagerDuty")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	slog.Info("PagerDuty webhook", "action", event.EventType, "user", actor, "incident_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pagerDutySignatureValid checks X-PagerDuty-Signature, which lists one
+// v1=<HMAC-SHA256> signature per active secret.
+func pagerDutySignatureValid(header string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(pagerDutyWebhookSecret))
+	mac.Write(body)
+	expected := []byte("v1=" + hex.EncodeToString(mac.Sum(nil)))
+	for _, signature := range strings.Split(header, ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(signature)), expected) {
+			return true
+		}
+	}
+	return false
+}
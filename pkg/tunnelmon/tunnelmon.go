@@ -0,0 +1,58 @@
+// Package tunnelmon polls Cloudflare Tunnels and reports their status
+// changes, so other Go programs can embed the monitoring CFTunnels does
+// without running its server:
+//
+//	m := &tunnelmon.Monitor{
+//		Client:   &tunnelmon.Client{Token: os.Getenv("API_TOKEN")},
+//		Tunnels:  []tunnelmon.Ref{{AccountID: account, ID: tunnel}},
+//		Interval: time.Minute,
+//		OnChange: func(c tunnelmon.Change) { log.Printf("%s: %s -> %s", c.Name, c.From, c.To) },
+//	}
+//	err := m.Run(ctx)
+//
+// It is the public face of the client and poller the CFTunnels server
+// itself is built on.
+package tunnelmon
+
+import (
+	"github.com/s3ansh33p/CFTunnels/internal/cloudflare"
+	"github.com/s3ansh33p/CFTunnels/internal/poller"
+)
+
+type (
+	// Tunnel is a tunnel as the Cloudflare API reports it. Status is
+	// healthy, degraded, inactive or down.
+	Tunnel = cloudflare.Tunnel
+	// Connection is one of a tunnel's connections to the Cloudflare edge.
+	Connection = cloudflare.Connection
+	// Client fetches tunnels from the Cloudflare API with an API token
+	// that can read them.
+	Client = cloudflare.Client
+
+	// Monitor polls its tunnels every Interval and calls OnChange when
+	// one's status changes.
+	Monitor = poller.Monitor
+	// Ref names a tunnel to monitor.
+	Ref = poller.Ref
+	// State is what a Monitor last saw of a tunnel.
+	State = poller.State
+	// Change is a tunnel's status changing from one poll to the next.
+	Change = poller.Change
+)
+
+// StatusUnknown is the status of a tunnel not polled successfully yet.
+const StatusUnknown = poller.StatusUnknown
+
+// ErrNoClient is returned by a Monitor without a Client.
+var ErrNoClient = poller.ErrNoClient
+
+// URL is the Cloudflare API endpoint of a tunnel.
+func URL(accountID, tunnelID string) string {
+	return cloudflare.URL(accountID, tunnelID)
+}
+
+// Decode parses a Cloudflare API response body for a tunnel, returning an
+// error if the API reports failure.
+func Decode(body []byte) (Tunnel, error) {
+	return cloudflare.Decode(body)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"regexp"
+)
+
+// privacyPolicy is the privacy section of CONFIG_FILE: which details are
+// masked on the public pages and in API responses to callers without the
+// admin token. Admins always see them.
+type privacyPolicy struct {
+	// MaskTunnelIDs replaces tunnel UUIDs with pseudonyms, stable across
+	// restarts, which the pages and API also accept.
+	MaskTunnelIDs bool `json:"mask_tunnel_ids"`
+	MaskOriginIPs bool `json:"mask_origin_ips"`
+	// MaskColos hides which Cloudflare data centres connectors use.
+	MaskColos bool `json:"mask_colos"`
+	// Logs applies the same masking to the log output.
+	Logs bool `json:"logs"`
+}
+
+// privacy is the policy from CONFIG_FILE; nothing is masked by default.
+var privacy privacyPolicy
+
+const (
+	maskedIP   = "[ip]"
+	maskedColo = "[colo]"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+	// ipPattern matches IPv4 addresses and anything with two colons that
+	// may be an IPv6 one; maskLogMessage checks which are.
+	ipPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|[0-9a-fA-F]*:[0-9a-fA-F]*:[0-9a-fA-F:]*`)
+)
+
+// maskedTunnelID is the pseudonym shown for a tunnel ID.
+func maskedTunnelID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "tunnel-" + hex.EncodeToString(sum[:5])
+}
+
+// publicTunnelID is the tunnel ID as the public pages show it.
+func publicTunnelID(id string) string {
+	if privacy.MaskTunnelIDs {
+		return maskedTunnelID(id)
+	}
+	return id
+}
+
+// visibleTunnelID is the tunnel ID as shown to the caller of r.
+func visibleTunnelID(r *http.Request, id string) string {
+	if adminAuthorized(r) {
+		return id
+	}
+	return publicTunnelID(id)
+}
+
+// showColos reports whether the caller of r may see Cloudflare colos.
+func showColos(r *http.Request) bool {
+	return !privacy.MaskColos || adminAuthorized(r)
+}
+
+// maskTunnelIDs replaces the tunnel IDs in a status response with their
+// pseudonyms, for callers without the admin token.
+func (s *statusResponse) maskTunnelIDs() {
+	for i := range s.Tunnels {
+		s.Tunnels[i].ID = publicTunnelID(s.Tunnels[i].ID)
+	}
+}
+
+// showOriginIPs reports whether the caller of r may see origin IPs in
+// connector URLs, errors and logs. The connections' origin IPs are only
+// shown to admins regardless.
+func showOriginIPs(r *http.Request) bool {
+	return !privacy.MaskOriginIPs || adminAuthorized(r)
+}
+
+// maskLogMessage masks tunnel UUIDs and IP addresses in a log message
+// under the policy.
+func maskLogMessage(message string) string {
+	if !privacy.Logs {
+		return message
+	}
+	if privacy.MaskTunnelIDs {
+		message = uuidPattern.ReplaceAllStringFunc(message, maskedTunnelID)
+	}
+	if privacy.MaskOriginIPs {
+		message = maskIPs(message)
+	}
+	return message
+}
+
+// maskIPs replaces the IP addresses in text.
+func maskIPs(text string) string {
+	return ipPattern.ReplaceAllStringFunc(text, func(match string) string {
+		// Times such as 15:04:05 match too; only mask addresses.
+		if _, err := netip.ParseAddr(match); err == nil {
+			return maskedIP
+		}
+		return match
+	})
+}
+
+// maskLogAttr masks the message and string fields of a log record.
+func maskLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if !privacy.Logs {
+		return a
+	}
+	if err, ok := a.Value.Any().(error); ok && a.Value.Kind() == slog.KindAny {
+		return slog.String(a.Key, maskLogMessage(err.Error()))
+	}
+	if a.Value.Kind() != slog.KindString {
+		return a
+	}
+	if a.Key == "colo" && privacy.MaskColos {
+		return slog.String(a.Key, maskedColo)
+	}
+	return slog.String(a.Key, maskLogMessage(a.Value.String()))
+}
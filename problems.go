@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// problemCode is the stable, machine-readable code of an API error. Clients
+// should branch on it rather than on the detail text, which may change.
+type problemCode string
+
+const (
+	problemUnauthorized        problemCode = "unauthorized"
+	problemForbidden           problemCode = "forbidden"
+	problemCrossOrigin         problemCode = "cross_origin_request"
+	problemDisabled            problemCode = "endpoint_disabled"
+	problemUnknownTunnel       problemCode = "unknown_tunnel"
+	problemUnknownComponent    problemCode = "unknown_component"
+	problemUnknownIncident     problemCode = "unknown_incident"
+	problemUnknownExclusion    problemCode = "unknown_exclusion"
+	problemNotSnoozed          problemCode = "not_snoozed"
+	problemUnknownDeleted      problemCode = "unknown_deleted_object"
+	problemConflict            problemCode = "already_exists"
+	problemInvalidRequest      problemCode = "invalid_request"
+	problemInvalidParameter    problemCode = "invalid_parameter"
+	problemValidation          problemCode = "validation_failed"
+	problemMethodNotAllowed    problemCode = "method_not_allowed"
+	problemIdempotencyPending  problemCode = "idempotency_key_in_use"
+	problemIdempotencyMismatch problemCode = "idempotency_key_reused"
+	problemOverloaded          problemCode = "overloaded"
+	problemCloudflare          problemCode = "cloudflare_error"
+	problemInternal            problemCode = "internal_error"
+)
+
+// problemType is what a code stands for: its HTTP status and title.
+type problemType struct {
+	Code   problemCode `json:"code"`
+	Type   string      `json:"type"`
+	Status int         `json:"status"`
+	Title  string      `json:"title"`
+}
+
+var problemTypes = map[problemCode]problemType{
+	problemUnauthorized:        {Status: http.StatusUnauthorized, Title: "Missing or invalid credentials"},
+	problemForbidden:           {Status: http.StatusForbidden, Title: "Access denied from this address"},
+	problemCrossOrigin:         {Status: http.StatusForbidden, Title: "Cross-origin admin request rejected"},
+	problemDisabled:            {Status: http.StatusNotFound, Title: "Endpoint not enabled"},
+	problemUnknownTunnel:       {Status: http.StatusNotFound, Title: "Unknown tunnel"},
+	problemUnknownComponent:    {Status: http.StatusNotFound, Title: "Unknown component"},
+	problemUnknownIncident:     {Status: http.StatusNotFound, Title: "Unknown incident"},
+	problemUnknownExclusion:    {Status: http.StatusNotFound, Title: "Unknown exclusion"},
+	problemNotSnoozed:          {Status: http.StatusNotFound, Title: "Tunnel not snoozed"},
+	problemUnknownDeleted:      {Status: http.StatusNotFound, Title: "Unknown deleted object"},
+	problemConflict:            {Status: http.StatusConflict, Title: "Object already exists"},
+	problemInvalidRequest:      {Status: http.StatusBadRequest, Title: "Malformed request body"},
+	problemInvalidParameter:    {Status: http.StatusBadRequest, Title: "Invalid query parameter"},
+	problemValidation:          {Status: http.StatusUnprocessableEntity, Title: "Request failed validation"},
+	problemMethodNotAllowed:    {Status: http.StatusMethodNotAllowed, Title: "Method not allowed"},
+	problemIdempotencyPending:  {Status: http.StatusConflict, Title: "Request with this idempotency key in progress"},
+	problemIdempotencyMismatch: {Status: http.StatusUnprocessableEntity, Title: "Idempotency key reused for a different request"},
+	problemOverloaded:          {Status: http.StatusServiceUnavailable, Title: "Server overloaded"},
+	problemCloudflare:          {Status: http.StatusBadGateway, Title: "Cloudflare API error"},
+	problemInternal:            {Status: http.StatusInternalServerError, Title: "Internal error"},
+}
+
+// problemsPath documents the codes; each problem's type is this path with
+// its code as the fragment.
+const problemsPath = "/api/problems"
+
+func typeOf(code problemCode) problemType {
+	t := problemTypes[code]
+	t.Code = code
+	t.Type = problemsPath + "#" + string(code)
+	return t
+}
+
+// problem is an RFC 9457 problem details response, extended with the code.
+type problem struct {
+	problemType
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// writeProblem responds with the problem for code. detail explains this
+// occurrence and is meant for people.
+func writeProblem(w http.ResponseWriter, r *http.Request, code problemCode, detail string) {
+	p := problem{problemType: typeOf(code), Detail: detail, Instance: r.URL.Path}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(p)
+}
+
+// isAPIPath reports whether path is served to programs, which get problem
+// responses, rather than to browsers, which get plain text.
+func isAPIPath(path string) bool {
+	for _, prefix := range []string{"/api/", "/admin/api/", "/webhooks/", "/chatops/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError responds with the problem for code on API paths and with
+// plain text on pages, for middleware that guards both.
+func writeError(w http.ResponseWriter, r *http.Request, code problemCode, detail string) {
+	if isAPIPath(r.URL.Path) {
+		writeProblem(w, r, code, detail)
+		return
+	}
+	text := typeOf(code).Title
+	if detail != "" {
+		text = detail
+	}
+	http.Error(w, text, typeOf(code).Status)
+}
+
+// methodNotAllowed responds 405 listing the allowed methods.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	writeProblem(w, r, problemMethodNotAllowed, fmt.Sprintf("%s is not supported; use %s", r.Method, allow))
+}
+
+// upstreamFailure reports why none of the tunnels in list has a status: none
+// has been polled successfully and the last poll of each failed. Until then
+// the status endpoints answer cloudflare_error instead of an unknown status.
+func upstreamFailure(list []tunnelState) (string, bool) {
+	if len(list) == 0 {
+		return "", false
+	}
+	for _, t := range list {
+		if !t.LastPollAt.IsZero() || lastPollError(t.ID) == "" {
+			return "", false
+		}
+	}
+	if len(list) == 1 {
+		return fmt.Sprintf("tunnel %s has not been polled successfully: %s", list[0].label(), lastPollError(list[0].ID)), true
+	}
+	return "no tunnel has been polled successfully: " + lastPollError(list[0].ID), true
+}
+
+// problemsHandler serves GET /api/problems, the error codes the API uses.
+func problemsHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]problemType, 0, len(problemTypes))
+	for code := range problemTypes {
+		list = append(list, typeOf(code))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, list)
+}
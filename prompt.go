@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// statusSymbols are the one-glyph summaries served by /api/status/short,
+// keyed by format and then by status.
+var statusSymbols = map[string]map[string]string{
+	"emoji": {"healthy": "🟢", "degraded": "🟡", "down": "🔴", "inactive": "⚪", "unknown": "❔"},
+	"char":  {"healthy": "+", "degraded": "~", "down": "!", "inactive": "-", "unknown": "?"},
+}
+
+// overallStatus rolls several tunnel statuses up into the worst of them.
+// Tunnels that have not reported yet only count if nothing else has.
+func overallStatus(statuses []string) string {
+	overall := ""
+	for _, s := range statuses {
+		if s == "" || s == statusUnknown {
+			continue
+		}
+		if overall == "" || statusSeverity(s) > statusSeverity(overall) {
+			overall = s
+		}
+	}
+	if overall == "" {
+		return "unknown"
+	}
+	return overall
+}
+
+// shortStatus renders status in the given format: emoji, char or text.
+func shortStatus(status, format string) (string, bool) {
+	if format == "text" {
+		return status, true
+	}
+	symbols, ok := statusSymbols[format]
+	if !ok {
+		return "", false
+	}
+	if symbol, ok := symbols[status]; ok {
+		return symbol, true
+	}
+	return symbols["unknown"], true
+}
+
+// shortStatusHandler serves /api/status/short, a single-glyph roll-up
+// suitable for shell prompts and tmux status lines. ?tunnel=<id> limits it
+// to one tunnel.
+func shortStatusHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "emoji"
+	}
+
+	var overall string
+	if id := r.URL.Query().Get("tunnel"); id != "" {
+		t, ok := findTunnel(id)
+		if !ok {
+			writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", id))
+			return
+		}
+		overall = overallStatus([]string{t.Status})
+	} else {
+		overall = overallTunnelStatus(snapshotTunnels())
+	}
+
+	summary, ok := shortStatus(overall, format)
+	if !ok {
+		writeProblem(w, r, problemInvalidParameter, "format must be emoji, char or text")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, summary)
+}
+
+// defaultInstanceURL is where subcommands that talk to a running instance
+// find it: CFTUNNELS_URL, or localhost on HTTP_PORT.
+func defaultInstanceURL() string {
+	if url := os.Getenv("CFTUNNELS_URL"); url != "" {
+		return url
+	}
+	port := os.Getenv("HTTP_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://localhost:" + port
+}
+
+// runPrompt implements the prompt subcommand. It asks a running instance
+// for its short status rather than calling the Cloudflare API, so it is
+// cheap enough to run on every prompt. Any failure prints the unknown
+// symbol and still exits zero so a prompt is never broken.
+func runPrompt(args []string) int {
+	flags := flag.NewFlagSet("prompt", flag.ExitOnError)
+	baseURL := flags.String("url", defaultInstanceURL(), "base URL of a running instance")
+	format := flags.String("format", "emoji", "output format: emoji, char or text")
+	timeout := flags.Duration("timeout", 2*time.Second, "request timeout")
+	flags.Parse(args)
+
+	unknown, ok := shortStatus("unknown", *format)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "format must be emoji, char or text")
+		return 2
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	endpoint := strings.TrimRight(*baseURL, "/") + "/api/status/short?format=" + url.QueryEscape(*format)
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		fmt.Println(unknown)
+		return 0
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		fmt.Println(unknown)
+		return 0
+	}
+	fmt.Println(strings.TrimSpace(string(body)))
+	return 0
+}
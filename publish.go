@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// publishTarget receives the static status snapshot.
+type publishTarget interface {
+	Name() string
+	Put(ctx context.Context, name, contentType string, data []byte) error
+}
+
+// r2Publisher uploads through the Cloudflare API, like the R2 state store.
+type r2Publisher struct {
+	store  *cloudflareObjectStore
+	prefix string
+}
+
+type s3Publisher struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// githubPublisher commits the files to a repository branch served by
+// GitHub Pages.
+type githubPublisher struct {
+	githubClient
+	branch string
+	dir    string
+}
+
+// staticStatus is the status.json document published next to the page.
+type staticStatus struct {
+	GeneratedAt time.Time            `json:"generated_at"`
+	Status      string               `json:"status"`
+	Tunnels     []staticTunnelStatus `json:"tunnels"`
+}
+
+type staticTunnelStatus struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Up     bool   `json:"up"`
+	// Since is when the tunnel came up or went down and StatusSince when
+	// its current status began; both are omitted when not known.
+	Since       *time.Time `json:"since,omitempty"`
+	StatusSince *time.Time `json:"status_since,omitempty"`
+	Connections int        `json:"connections"`
+}
+
+var (
+	publishTargets []publishTarget
+	// publishNow is signalled after every poll; snapshots are only
+	// uploaded when the status has changed since the last upload.
+	publishNow = make(chan struct{}, 1)
+)
+
+// loadPublish reads PUBLISH_TARGETS, a comma-separated list of
+// r2:<bucket>[/prefix], s3:<bucket>[/prefix] and
+// github:<owner/repo>[@branch][/dir] targets that receive index.html and
+// status.json whenever the status changes. The copies stay up when this
+// service or the tunnel in front of it does not. GitHub uploads use
+// PUBLISH_GITHUB_TOKEN, or GITHUB_TOKEN, and GITHUB_API_URL.
+func loadPublish() error {
+	for _, spec := range splitList(os.Getenv("PUBLISH_TARGETS")) {
+		kind, target, _ := strings.Cut(spec, ":")
+		if target == "" {
+			return fmt.Errorf("PUBLISH_TARGETS: %q is not kind:target", spec)
+		}
+		switch kind {
+		case "r2":
+			bucket, prefix, _ := strings.Cut(target, "/")
+			publishTargets = append(publishTargets, &r2Publisher{
+				store:  newCloudflareObjectStore(os.Getenv("ACCOUNT_ID"), "r2", bucket),
+				prefix: prefix,
+			})
+		case "s3":
+			cfg, err := awsConfig()
+			if err != nil {
+				return fmt.Errorf("PUBLISH_TARGETS: %s: %w", spec, err)
+			}
+			bucket, prefix, _ := strings.Cut(target, "/")
+			publishTargets = append(publishTargets, &s3Publisher{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: prefix})
+		case "github":
+			// owner/repo, then an optional @branch and /dir.
+			owner, rest, _ := strings.Cut(target, "/")
+			repo, dir, _ := strings.Cut(rest, "/")
+			repo, branch, _ := strings.Cut(repo, "@")
+			token := os.Getenv("PUBLISH_GITHUB_TOKEN")
+			if token == "" {
+				token = os.Getenv("GITHUB_TOKEN")
+			}
+			client, err := newGitHubClient(map[string]string{"repo": owner + "/" + repo, "token": token, "api_url": os.Getenv("GITHUB_API_URL")})
+			if err != nil {
+				return fmt.Errorf("PUBLISH_TARGETS: %s: %w", spec, err)
+			}
+			publishTargets = append(publishTargets, &githubPublisher{githubClient: client, branch: branch, dir: dir})
+		default:
+			return fmt.Errorf("PUBLISH_TARGETS: unknown target %q (available: r2, s3, github)", kind)
+		}
+	}
+	return nil
+}
+
+// publishStatus uploads a fresh snapshot each time the status changes.
+func publishStatus() {
+	var published [sha256.Size]byte
+	for range publishNow {
+		now := time.Now()
+		list := snapshotTunnels()
+		status := staticStatusOf(list, now)
+		// The generation time changes every run; only the status matters.
+		key, _ := json.Marshal(status.Tunnels)
+		sum := sha256.Sum256(key)
+		if sum == published {
+			continue
+		}
+		published = sum
+
+		document, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			slog.Error("Error encoding status snapshot", "error", err)
+			continue
+		}
+		page := []byte(staticStatusPage(list, status.Status, now))
+		for _, target := range publishTargets {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := target.Put(ctx, "index.html", "text/html; charset=utf-8", page)
+			if err == nil {
+				err = target.Put(ctx, "status.json", "application/json", document)
+			}
+			cancel()
+			if err != nil {
+				slog.Error("Error publishing status snapshot", "target", target.Name(), "error", err)
+				// Try again after the next poll.
+				published = [sha256.Size]byte{}
+			}
+		}
+	}
+}
+
+// requestPublish asks the publisher to check for changes without waiting.
+func requestPublish() {
+	select {
+	case publishNow <- struct{}{}:
+	default:
+	}
+}
+
+func staticStatusOf(list []tunnelState, now time.Time) staticStatus {
+	status := staticStatus{GeneratedAt: now, Status: overallTunnelStatus(list)}
+	for _, t := range list {
+		since, up := t.since()
+		row := staticTunnelStatus{
+			ID:          publicTunnelID(t.ID),
+			Name:        t.label(),
+			Status:      statusLabel(t.Status),
+			Up:          up,
+			Connections: t.Connections,
+		}
+		if !since.IsZero() {
+			row.Since = &since
+		}
+		if changed := statusSince(t.ID, t.Status); !changed.IsZero() {
+			row.StatusSince = &changed
+		}
+		status.Tunnels = append(status.Tunnels, row)
+	}
+	return status
+}
+
+// staticStatusPage is the status page as a self-contained file: the theme
+// is inlined and nothing links back to this service.
+func staticStatusPage(list []tunnelState, overall string, now time.Time) string {
+	var rows strings.Builder
+	for _, t := range list {
+		activeString := t.periodLabel()
+		since, _ := t.since()
+		sinceText := statusUnknown
+		if !since.IsZero() {
+			sinceText = since.UTC().Format("2006-01-02 15:04 MST")
+		}
+		fmt.Fprintf(&rows, `<li><span class="tunnel-name">%s</span> %s <span class="tunnel-since">%s since %s</span></li>`,
+			html.EscapeString(t.label()), statusPill(t.Status), activeString, sinceText)
+	}
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta http-equiv="refresh" content="60">
+	<title>Server Status</title>
+	<style>%s</style>
+</head>
+<body class="page-status">
+	<main>
+		<h1>Server Status</h1>
+		%s
+		<ul class="tunnel-list">%s</ul>
+		<p class="tunnel-since">Snapshot taken %s. <a href="status.json">JSON</a></p>
+	</main>
+</body>
+</html>`, themeStylesheet, statusPill(overall), rows.String(), now.UTC().Format(time.RFC1123))
+}
+
+func (p *r2Publisher) Name() string { return p.store.Name() }
+
+func (p *r2Publisher) Put(ctx context.Context, name, contentType string, data []byte) error {
+	return p.store.put(ctx, path.Join(p.prefix, name), contentType, data)
+}
+
+func (p *s3Publisher) Name() string { return "s3:" + p.bucket }
+
+func (p *s3Publisher) Put(ctx context.Context, name, contentType string, data []byte) error {
+	_, err := p.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(p.bucket),
+		Key:          aws.String(path.Join(p.prefix, name)),
+		Body:         bytes.NewReader(data),
+		ContentType:  aws.String(contentType),
+		CacheControl: aws.String("max-age=60"),
+	})
+	return err
+}
+
+func (p *githubPublisher) Name() string { return "github:" + p.repo }
+
+// Put creates or updates the file with the contents API, which needs the
+// current blob SHA to update an existing file.
+func (p *githubPublisher) Put(ctx context.Context, name, contentType string, data []byte) error {
+	file := path.Join(p.dir, name)
+	ref := ""
+	if p.branch != "" {
+		ref = "?ref=" + p.branch
+	}
+	var existing struct {
+		SHA     string `json:"sha"`
+		Content string `json:"content"`
+	}
+	err := doJSON(ctx, "GET", p.url("/contents/%s%s", file, ref), p.headers(), nil, &existing)
+	// sendRequest reports the status first; a missing file is created.
+	if err != nil && !strings.HasPrefix(err.Error(), "HTTP 404") {
+		return err
+	}
+
+	content := base64.StdEncoding.EncodeToString(data)
+	if strings.ReplaceAll(existing.Content, "\n", "") == content {
+		return nil
+	}
+	update := map[string]string{
+		"message": "Update status snapshot",
+		"content": content,
+	}
+	if existing.SHA != "" {
+		update["sha"] = existing.SHA
+	}
+	if p.branch != "" {
+		update["branch"] = p.branch
+	}
+	return doJSON(ctx, "PUT", p.url("/contents/%s", file), p.headers(), update, nil)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// refreshInterval is the page reload interval used when a viewer has not
+// picked one. It defaults to the poll interval.
+var refreshInterval = pollInterval
+
+// refreshOptions are the fixed intervals, in seconds, offered on the page.
+// Zero disables automatic reloads.
+var refreshOptions = []int{30, 60, 300, 900, 0}
+
+// refreshSettings is served by /api/refresh so the page can reload shortly
+// after the server has fresh data instead of on a blind timer.
+type refreshSettings struct {
+	DefaultSeconds int        `json:"default_seconds"`
+	Options        []int      `json:"options"`
+	LastPollAt     *time.Time `json:"last_poll_at,omitempty"`
+	NextPollAt     *time.Time `json:"next_poll_at,omitempty"`
+}
+
+func loadRefreshInterval() error {
+	value := os.Getenv("REFRESH_INTERVAL")
+	if value == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		return fmt.Errorf("REFRESH_INTERVAL: invalid duration %q", value)
+	}
+	refreshInterval = interval
+	return nil
+}
+
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	statusMutex.RLock()
+	settings := refreshSettings{
+		DefaultSeconds: int(refreshInterval.Seconds()),
+		Options:        refreshOptions,
+	}
+	if !lastPollAt.IsZero() {
+		last, next := lastPollAt, lastPollAt.Add(pollInterval)
+		settings.LastPollAt, settings.NextPollAt = &last, &next
+	}
+	statusMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// viewerRefreshSeconds returns the reload interval requested with
+// ?refresh=<seconds>, falling back to refreshInterval. Zero means no reload.
+func viewerRefreshSeconds(r *http.Request) int {
+	if seconds, err := strconv.Atoi(r.URL.Query().Get("refresh")); err == nil && seconds >= 0 {
+		if seconds > 0 && seconds < 10 {
+			seconds = 10
+		}
+		return seconds
+	}
+	return int(refreshInterval.Seconds())
+}
+
+// noscriptRefresh is the reload fallback for browsers without JavaScript,
+// reloading every seconds. It belongs in the document head.
+func noscriptRefresh(seconds int) string {
+	if seconds == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`<noscript><meta http-equiv="refresh" content="%d"></noscript>`, seconds)
+}
+
+// refreshControls renders the pause/play button, interval picker and
+// countdown driven by refreshScript. They start hidden and are only shown
+// once the script runs, since they do nothing without it.
+func refreshControls() string {
+	options := `<option value="auto">Auto</option>`
+	for _, seconds := range refreshOptions {
+		label := "Off"
+		if seconds > 0 {
+			label = formatRefreshOption(seconds)
+		}
+		options += fmt.Sprintf(`<option value="%d">%s</option>`, seconds, label)
+	}
+	return fmt.Sprintf(`<div id="refresh-controls" class="refresh-controls" data-default-refresh="%d" hidden>
+		<button id="refresh-toggle" type="button" aria-pressed="false">Pause</button>
+		<select id="refresh-interval" aria-label="Refresh interval">%s</select>
+		<span id="refresh-countdown"></span>
+	</div>`, int(refreshInterval.Seconds()), options)
+}
+
+func formatRefreshOption(seconds int) string {
+	if seconds%60 == 0 {
+		return fmt.Sprintf("%dm", seconds/60)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// refreshScript reloads the page on the viewer's chosen interval, unless
+// liveScript is receiving updates. A
+// ?refresh=<seconds> query parameter takes precedence over the choice stored
+// in localStorage, which is handy for kiosk URLs. "Auto" reloads shortly
+// after the server's next poll as reported by /api/refresh.
+const refreshScript = `
+(function () {
+	const controls = document.getElementById("refresh-controls");
+	const toggle = document.getElementById("refresh-toggle");
+	const select = document.getElementById("refresh-interval");
+	const countdown = document.getElementById("refresh-countdown");
+	const fallback = Number(controls.dataset.defaultRefresh);
+	const params = new URLSearchParams(location.search);
+
+	controls.hidden = false;
+	let choice = params.get("refresh") || localStorage.getItem("refreshInterval") || "auto";
+	let paused = localStorage.getItem("refreshPaused") === "true";
+	let nextPollIn = null;
+	let remaining = 0;
+
+	if (!Array.from(select.options).some(function (o) { return o.value === choice; })) {
+		const option = document.createElement("option");
+		option.value = choice;
+		option.textContent = choice + "s";
+		select.appendChild(option);
+	}
+	select.value = choice;
+
+	function intervalSeconds() {
+		if (choice === "auto") {
+			return nextPollIn !== null ? Math.max(30, nextPollIn + 5) : fallback;
+		}
+		return Number(choice);
+	}
+
+	function reset() {
+		remaining = intervalSeconds();
+		render();
+	}
+
+	function live() {
+		return document.documentElement.dataset.live === "true";
+	}
+
+	function render() {
+		toggle.textContent = paused ? "Resume" : "Pause";
+		toggle.setAttribute("aria-pressed", String(paused));
+		if (live()) {
+			countdown.textContent = "Live";
+		} else if (choice === "0") {
+			countdown.textContent = "Auto-refresh off";
+		} else if (paused) {
+			countdown.textContent = "Paused";
+		} else {
+			const minutes = Math.floor(remaining / 60);
+			const seconds = String(remaining % 60).padStart(2, "0");
+			countdown.textContent = "Refresh in " + minutes + ":" + seconds;
+		}
+	}
+
+	function tick() {
+		if (live()) {
+			reset();
+			return;
+		}
+		if (paused || choice === "0") {
+			return;
+		}
+		remaining--;
+		if (remaining <= 0) {
+			location.reload();
+			return;
+		}
+		render();
+	}
+
+	toggle.addEventListener("click", function () {
+		paused = !paused;
+		localStorage.setItem("refreshPaused", String(paused));
+		render();
+	});
+
+	select.addEventListener("change", function () {
+		choice = select.value;
+		localStorage.setItem("refreshInterval", choice);
+		reset();
+	});
+
+	fetch("/api/refresh")
+		.then(function (resp) { return resp.json(); })
+		.then(function (settings) {
+			if (!settings.next_poll_at) {
+				return;
+			}
+			nextPollIn = Math.max(0, Math.round((Date.parse(settings.next_poll_at) - Date.now()) / 1000));
+			if (choice === "auto") {
+				reset();
+			}
+		})
+		.catch(function () {});
+
+	reset();
+	setInterval(tick, 1000);
+})();
+`
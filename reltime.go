@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// formatElapsed renders d as "3d 4h 5m 6s", dropping leading zero units.
+// relTimeScript implements the same format so hydrated values don't jump
+// when the script takes over.
+func formatElapsed(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	total := int64(d / time.Second)
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"d", 86400}, {"h", 3600}, {"m", 60}, {"s", 1}}
+
+	var parts []string
+	for _, unit := range units {
+		value := total / unit.size
+		total %= unit.size
+		if value == 0 && len(parts) == 0 && unit.suffix != "s" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d%s", value, unit.suffix))
+	}
+	return strings.Join(parts, " ")
+}
+
+// elapsedSince is formatElapsed from since to now, or "unknown" when since
+// is zero.
+func elapsedSince(since, now time.Time) string {
+	if since.IsZero() {
+		return statusUnknown
+	}
+	return formatElapsed(now.Sub(since))
+}
+
+// relTime renders a <time> element showing the time elapsed since t as of
+// now, or "unknown" for a zero t. The value is correct without JavaScript;
+// relTimeScript keeps it ticking in the browser.
+func relTime(id string, since, now time.Time) string {
+	if since.IsZero() {
+		return statusUnknown
+	}
+	return fmt.Sprintf(`<time id="%s" datetime="%s" data-since="%d">%s</time>`,
+		id, since.UTC().Format(time.RFC3339), since.Unix(), formatElapsed(now.Sub(since)))
+}
+
+// relTimeScript hydrates every element rendered by relTime. It recomputes
+// from the timestamp rather than incrementing a counter, so values stay
+// right after the tab has been in the background.
+const relTimeScript = `
+(function () {
+	const units = [["d", 86400], ["h", 3600], ["m", 60], ["s", 1]];
+
+	function formatElapsed(total) {
+		total = Math.max(0, Math.floor(total));
+		const parts = [];
+		units.forEach(function (unit) {
+			const value = Math.floor(total / unit[1]);
+			total %= unit[1];
+			if (value === 0 && parts.length === 0 && unit[0] !== "s") {
+				return;
+			}
+			parts.push(value + unit[0]);
+		});
+		return parts.join(" ");
+	}
+
+	function update() {
+		const now = Date.now() / 1000;
+		document.querySelectorAll("[data-since]").forEach(function (el) {
+			el.textContent = formatElapsed(now - Number(el.dataset.since));
+		});
+	}
+
+	setInterval(update, 1000);
+})();
+`
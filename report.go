@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// reportDays is the period covered by the printable report.
+const reportDays = 30
+
+type reportDay struct {
+	Date time.Time
+	// Availability has one entry per tunnel, in report order.
+	Availability []string
+}
+
+type reportIncident struct {
+	Tunnel   string
+	Start    time.Time
+	End      time.Time
+	Duration string
+	Status   string
+	Links    map[string]string
+	Tags     []string
+	Notes    []incidentNote
+	// Exclusions overlap the incident and take it out of the
+	// availability figures.
+	Exclusions []availabilityExclusion
+	Audit      []auditEntry
+}
+
+type reportTunnel struct {
+	ID           string
+	Label        string
+	Status       string
+	StatusClass  string
+	ActiveLabel  string
+	Since        time.Time
+	Elapsed      string
+	Availability string
+	// BusinessAvailability counts only the tunnel's business hours; empty
+	// when it has none.
+	BusinessAvailability string
+	BusinessHours        string
+	Budgets              []slaBudget
+}
+
+type reportData struct {
+	HTMLClass      template.HTMLAttr
+	GeneratedAt    time.Time
+	Tunnels        []reportTunnel
+	Days           []reportDay
+	Incidents      []reportIncident
+	ContrastToggle template.HTML
+	Stylesheets    template.HTML
+	ReportDays     int
+	AuditEnabled   bool
+	// Tag limits the incidents to those tagged with it.
+	Tag           string
+	ServiceTokens []serviceTokenRow
+	// BusinessHours and SLA are set when any tunnel has business hours or
+	// SLA targets.
+	BusinessHours bool
+	SLA           bool
+}
+
+func formatAvailability(ratio float64, ok bool) string {
+	if !ok {
+		return "No data"
+	}
+	return fmt.Sprintf("%.3f%%", ratio*100)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+	"date":     func(t time.Time) string { return t.UTC().Format("Mon 02 Jan 2006") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Status Report</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Status Report</h1>
+			<p>Generated {{datetime .GeneratedAt}}</p>
+			<p class="report-actions"><button type="button" onclick="window.print()">Print</button> <a href="/">Back to status page</a></p>
+		</header>
+
+		<section aria-labelledby="current-heading">
+			<h2 id="current-heading">Current status</h2>
+			<table>
+				<thead><tr><th scope="col">Tunnel</th><th scope="col">Status</th><th scope="col">Since</th><th scope="col">{{.ReportDays}}-day availability</th>{{if .BusinessHours}}<th scope="col">Business-hours availability</th>{{end}}{{if .SLA}}<th scope="col">Error budget</th>{{end}}</tr></thead>
+				<tbody>
+				{{range .Tunnels}}<tr>
+					<td>{{.Label}}<br><code>{{.ID}}</code></td>
+					<td><span class="status-pill {{.StatusClass}}">{{.Status}}</span></td>
+					<td>{{.ActiveLabel}}: {{.Elapsed}}{{if not .Since.IsZero}} (since {{datetime .Since}}){{end}}</td>
+					<td>{{.Availability}}</td>
+					{{if $.BusinessHours}}<td>{{if .BusinessHours}}{{.BusinessAvailability}}<br><small>{{.BusinessHours}}</small>{{else}}Not set{{end}}</td>{{end}}
+					{{if $.SLA}}<td>{{range .Budgets}}{{.Target}}: {{.Remaining}}<br>{{else}}Not set{{end}}</td>{{end}}
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+		</section>
+
+		<section aria-labelledby="availability-heading">
+			<h2 id="availability-heading">Daily availability</h2>
+			<table>
+				<caption class="visually-hidden">Daily availability, newest first</caption>
+				<thead><tr><th scope="col">Day (UTC)</th>{{range .Tunnels}}<th scope="col">{{.Label}}</th>{{end}}</tr></thead>
+				<tbody>
+				{{range .Days}}<tr><td>{{date .Date}}</td>{{range .Availability}}<td>{{.}}</td>{{end}}</tr>
+				{{end}}
+				</tbody>
+			</table>
+		</section>
+
+		{{if .ServiceTokens}}
+		<section aria-labelledby="tokens-heading">
+			<h2 id="tokens-heading">Access service tokens</h2>
+			<table>
+				<thead><tr><th scope="col">Token</th><th scope="col">Client ID</th><th scope="col">Expires</th><th scope="col">Remaining</th></tr></thead>
+				<tbody>
+				{{range .ServiceTokens}}<tr class="token-{{.Level}}">
+					<td>{{.Name}}</td>
+					<td><code>{{.ClientID}}</code></td>
+					<td>{{if .ExpiresAt.IsZero}}Never{{else}}{{datetime .ExpiresAt}}{{end}}</td>
+					<td>{{.Remaining}}</td>
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+		</section>
+		{{end}}
+
+		<section class="incidents" aria-labelledby="incidents-heading">
+			<h2 id="incidents-heading">Incidents</h2>
+			{{if .Tag}}<p>Tagged <strong>{{.Tag}}</strong> &middot; <a href="/report">All incidents</a></p>{{end}}
+			{{if .Incidents}}
+			<table>
+				<thead><tr><th scope="col">Tunnel</th><th scope="col">Start</th><th scope="col">End</th><th scope="col">Duration</th><th scope="col">Worst status</th><th scope="col">Tickets</th><th scope="col">Notes</th>{{if $.AuditEnabled}}<th scope="col">Audit log</th>{{end}}</tr></thead>
+				<tbody>
+				{{range .Incidents}}<tr>
+					<td>{{.Tunnel}}</td>
+					<td>{{datetime .Start}}</td>
+					<td>{{if .End.IsZero}}Ongoing{{else}}{{datetime .End}}{{end}}</td>
+					<td>{{.Duration}}</td>
+					<td>{{.Status}}</td>
+					<td>{{range $name, $link := .Links}}<a href="{{$link}}">{{$name}}</a> {{end}}</td>
+					<td>{{range .Tags}}<a href="?tag={{.}}">{{.}}</a> {{end}}{{range .Notes}}<br>{{.Text}}{{end}}{{range .Exclusions}}<br><strong>{{if eq .Kind "false_positive"}}False positive{{else}}Excluded{{end}}:</strong> {{.Reason}}{{end}}</td>
+					{{if $.AuditEnabled}}<td>{{range .Audit}}{{datetime .When}}: {{.Summary}}<br>{{end}}</td>{{end}}
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{else}}
+			<p>No incidents{{if .Tag}} tagged {{.Tag}}{{end}} recorded in the last {{.ReportDays}} days.</p>
+			{{end}}
+		</section>
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// reportHandler serves a print-optimised summary of the current status,
+// availability and incidents of every tunnel over the last reportDays days.
+// ?tag= lists only the incidents carrying that tag.
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	from := now.AddDate(0, 0, -reportDays)
+	high := highContrast(w, r)
+	list := snapshotTunnels()
+
+	data := reportData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		GeneratedAt:    now,
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ReportDays:     reportDays,
+		AuditEnabled:   auditEnabled,
+		Tag:            normalizeTag(r.URL.Query().Get("tag")),
+		ServiceTokens:  serviceTokenRows(now),
+	}
+	for _, t := range list {
+		since, _ := t.since()
+		row := reportTunnel{
+			ID:           visibleTunnelID(r, t.ID),
+			Label:        t.label(),
+			Status:       statusLabel(t.Status),
+			StatusClass:  statusClass(t.Status),
+			ActiveLabel:  t.periodLabel(),
+			Since:        since,
+			Elapsed:      elapsedSince(since, now),
+			Availability: formatAvailability(availability(t.ID, from, now)),
+		}
+		if hours := tunnelBusinessHours(t); hours != nil {
+			row.BusinessHours = hours.String()
+			row.BusinessAvailability = formatAvailability(businessAvailability(t.ID, hours, from, now))
+			data.BusinessHours = true
+		}
+		if row.Budgets = tunnelBudgets(t, now); len(row.Budgets) > 0 {
+			data.SLA = true
+		}
+		data.Tunnels = append(data.Tunnels, row)
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	for day := 0; day < reportDays; day++ {
+		start := today.AddDate(0, 0, -day)
+		end := start.AddDate(0, 0, 1)
+		if end.After(now) {
+			end = now
+		}
+		row := reportDay{Date: start}
+		for _, t := range list {
+			row.Availability = append(row.Availability, formatAvailability(availability(t.ID, start, end)))
+		}
+		data.Days = append(data.Days, row)
+	}
+
+	for _, t := range list {
+		for _, inc := range incidentsBetween(t.ID, from, now) {
+			end := inc.End
+			if end.IsZero() {
+				end = now
+			}
+			tags, notes := incidentAnnotations(t.ID, inc.Start, inc.End)
+			if data.Tag != "" && !slices.Contains(tags, data.Tag) {
+				continue
+			}
+			data.Incidents = append(data.Incidents, reportIncident{
+				Tunnel:     t.label(),
+				Start:      inc.Start,
+				End:        inc.End,
+				Duration:   formatElapsed(end.Sub(inc.Start)),
+				Status:     inc.Status,
+				Links:      incidentLinks(t.ID, inc.Start, inc.End),
+				Tags:       tags,
+				Notes:      notes,
+				Exclusions: tunnelExclusions(t.ID, inc.Start, end),
+				Audit:      auditEntriesAround(t.ID, inc.Start, inc.End),
+			})
+		}
+	}
+	sort.Slice(data.Incidents, func(i, j int) bool { return data.Incidents[i].Start.After(data.Incidents[j].Start) })
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := reportTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering report", "error", err)
+	}
+}
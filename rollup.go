@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultOutageShare is the share of the total weight that has to be
+// down or inactive for the weighted roll-up to report an outage.
+const defaultOutageShare = 0.5
+
+// rollupThreshold is how many tunnels, or what share of their weight, it
+// takes for the threshold rule to move the headline.
+type rollupThreshold struct {
+	count int
+	share float64
+}
+
+var (
+	// rollupRule is worst, where the headline is the worst tunnel's
+	// status; weighted, where it is only an outage when enough of the
+	// weight is; or threshold, where partialAt and majorAt decide.
+	rollupRule  = "worst"
+	outageShare = defaultOutageShare
+	// partialAt is how many affected tunnels make a partial outage
+	// (degraded); majorAt how many tunnels down or inactive make a major
+	// one.
+	partialAt = rollupThreshold{count: 1}
+	majorAt   = rollupThreshold{share: 1}
+)
+
+// loadRollup reads OVERALL_STATUS_RULE, worst (the default), weighted or
+// threshold, and OVERALL_OUTAGE_SHARE, the share of the weight that is down
+// or inactive at which the weighted rule reports an outage rather than
+// degraded. For the threshold rule, OVERALL_PARTIAL_AT is the count (e.g.
+// 2) or share of the weight (e.g. 10%) of tunnels not healthy at which the
+// headline reads degraded rather than healthy (default 1), and
+// OVERALL_MAJOR_AT that of tunnels down or inactive at which it reads as
+// an outage (default 100%). Tunnels set their weight and max_status in the
+// config.
+func loadRollup() error {
+	if rule := os.Getenv("OVERALL_STATUS_RULE"); rule != "" {
+		if rule != "worst" && rule != "weighted" && rule != "threshold" {
+			return fmt.Errorf("OVERALL_STATUS_RULE: unknown rule %q (available: worst, weighted, threshold)", rule)
+		}
+		rollupRule = rule
+	}
+	if value := os.Getenv("OVERALL_OUTAGE_SHARE"); value != "" {
+		share, err := strconv.ParseFloat(value, 64)
+		if err != nil || share <= 0 || share > 1 {
+			return fmt.Errorf("OVERALL_OUTAGE_SHARE: %q is not a number between 0 and 1", value)
+		}
+		outageShare = share
+	}
+	for name, threshold := range map[string]*rollupThreshold{"OVERALL_PARTIAL_AT": &partialAt, "OVERALL_MAJOR_AT": &majorAt} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseRollupThreshold(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*threshold = parsed
+	}
+	return nil
+}
+
+// parseRollupThreshold parses a tunnel count, e.g. 3, or a share of the
+// weight, e.g. 25%.
+func parseRollupThreshold(value string) (rollupThreshold, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		share, err := strconv.ParseFloat(percent, 64)
+		if err != nil || share <= 0 || share > 100 {
+			return rollupThreshold{}, fmt.Errorf("%q is not a percentage between 0 and 100", value)
+		}
+		return rollupThreshold{share: share / 100}, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return rollupThreshold{}, fmt.Errorf("%q is not a tunnel count or a percentage", value)
+	}
+	return rollupThreshold{count: count}, nil
+}
+
+// reached reports whether count tunnels with weight out of total meet the
+// threshold.
+func (t rollupThreshold) reached(count int, weight, total float64) bool {
+	if t.count > 0 {
+		return count >= t.count
+	}
+	return total > 0 && weight/total >= t.share
+}
+
+// validStatus reports whether status is one the API reports.
+func validStatus(status string) bool {
+	switch status {
+	case "healthy", "degraded", "inactive", "down":
+		return true
+	default:
+		return false
+	}
+}
+
+// headlineStatus is the status a tunnel contributes to the overall
+// status: its own, but no worse than its max_status.
+func headlineStatus(t tunnelState) string {
+	if t.MaxStatus != "" && t.Status != statusUnknown && statusSeverity(t.Status) > statusSeverity(t.MaxStatus) {
+		return t.MaxStatus
+	}
+	return t.Status
+}
+
+// tunnelWeight is the tunnel's configured weight, 1 by default.
+func tunnelWeight(t tunnelState) float64 {
+	if t.Weight > 0 {
+		return t.Weight
+	}
+	return 1
+}
+
+// overallTunnelStatus rolls the tunnels up into the page's headline status
+// under rollupRule.
+func overallTunnelStatus(list []tunnelState) string {
+	statuses := make([]string, len(list))
+	for i, t := range list {
+		statuses[i] = headlineStatus(t)
+	}
+	overall := overallStatus(statuses)
+	if rollupRule == "threshold" {
+		return thresholdStatus(list, statuses, overall)
+	}
+	if rollupRule != "weighted" || (overall != "down" && overall != "inactive") {
+		return overall
+	}
+
+	var total, outage float64
+	for i, t := range list {
+		if statuses[i] == "" || statuses[i] == statusUnknown {
+			continue
+		}
+		total += tunnelWeight(t)
+		if statuses[i] == "down" || statuses[i] == "inactive" {
+			outage += tunnelWeight(t)
+		}
+	}
+	if outage/total >= outageShare {
+		return overall
+	}
+	return "degraded"
+}
+
+// thresholdStatus applies partialAt and majorAt to the tunnels' headline
+// statuses, whose worst is overall.
+func thresholdStatus(list []tunnelState, statuses []string, overall string) string {
+	if overall == statusUnknown || overall == "healthy" {
+		return overall
+	}
+	var total, affectedWeight, outageWeight float64
+	var affected, outage int
+	for i, t := range list {
+		if statuses[i] == "" || statuses[i] == statusUnknown {
+			continue
+		}
+		total += tunnelWeight(t)
+		if statuses[i] == "healthy" {
+			continue
+		}
+		affected++
+		affectedWeight += tunnelWeight(t)
+		if statuses[i] == "down" || statuses[i] == "inactive" {
+			outage++
+			outageWeight += tunnelWeight(t)
+		}
+	}
+	switch {
+	case outage > 0 && majorAt.reached(outage, outageWeight, total):
+		return overall
+	case partialAt.reached(affected, affectedWeight, total):
+		return "degraded"
+	default:
+		return "healthy"
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSelfCheckInterval = 5 * time.Minute
+	defaultSelfCheckFailures = 2
+	checkHostAPI             = "https://check-host.net"
+	// checkHostNodes is how many check-host.net locations probe the page;
+	// the page counts as up if any of them reach it.
+	checkHostNodes = 3
+)
+
+var (
+	selfCheckURL      string
+	selfCheckVia      = "check-host"
+	selfCheckInterval = defaultSelfCheckInterval
+	selfCheckFailures = defaultSelfCheckFailures
+	// selfCheckWebhook receives self-check events instead of the regular
+	// notifiers, so the alert does not depend on anything this service
+	// shares with the status page.
+	selfCheckWebhook string
+)
+
+// loadSelfCheck reads SELF_CHECK_URL, the public status page URL to verify
+// from outside; SELF_CHECK_VIA, check-host (probe from check-host.net
+// locations, the default) or direct (from this host, which only proves the
+// public route works); SELF_CHECK_INTERVAL; SELF_CHECK_FAILURES, how many
+// failed checks in a row count as down; and SELF_CHECK_WEBHOOK, where
+// the alerts are POSTed instead of the configured notifiers.
+func loadSelfCheck() error {
+	selfCheckURL = os.Getenv("SELF_CHECK_URL")
+	if selfCheckURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(selfCheckURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("SELF_CHECK_URL: %q is not an http or https URL", selfCheckURL)
+	}
+	if via := os.Getenv("SELF_CHECK_VIA"); via != "" {
+		if via != "check-host" && via != "direct" {
+			return fmt.Errorf("SELF_CHECK_VIA: unknown method %q (available: check-host, direct)", via)
+		}
+		selfCheckVia = via
+	}
+	if value := os.Getenv("SELF_CHECK_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Minute {
+			return fmt.Errorf("SELF_CHECK_INTERVAL: invalid duration %q (minimum 1m)", value)
+		}
+		selfCheckInterval = interval
+	}
+	if value := os.Getenv("SELF_CHECK_FAILURES"); value != "" {
+		failures, err := strconv.Atoi(value)
+		if err != nil || failures < 1 {
+			return fmt.Errorf("SELF_CHECK_FAILURES: invalid number %q", value)
+		}
+		selfCheckFailures = failures
+	}
+	selfCheckWebhook = os.Getenv("SELF_CHECK_WEBHOOK")
+	return nil
+}
+
+// runSelfCheck probes the status page every interval and alerts when it
+// becomes unreachable and again when it recovers.
+func runSelfCheck() {
+	failures := 0
+	down := false
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		var err error
+		if selfCheckVia == "direct" {
+			err = checkDirect(ctx, selfCheckURL)
+		} else {
+			err = checkFromCheckHost(ctx, selfCheckURL)
+		}
+		cancel()
+
+		if err != nil {
+			failures++
+			slog.Error("Self-check failed", "url", selfCheckURL, "failures", failures, "error", err)
+			if failures >= selfCheckFailures && !down {
+				down = true
+				sendSelfCheckEvent(selfCheckEvent(eventStatusPageDown, err))
+			}
+		} else {
+			failures = 0
+			if down {
+				down = false
+				slog.Info("Self-check passed again", "url", selfCheckURL)
+				sendSelfCheckEvent(selfCheckEvent(eventStatusPageUp, nil))
+			}
+		}
+		time.Sleep(selfCheckInterval)
+	}
+}
+
+func selfCheckEvent(typ string, cause error) Event {
+	event := Event{ID: newEventID(), Type: typ, Time: time.Now()}
+	if typ == eventStatusPageDown {
+		event.Title = "Status page is unreachable"
+		event.Message = fmt.Sprintf("The status page at %s failed %d checks in a row: %v. Tunnel alerts may still be delivered, but viewers cannot see the page.",
+			selfCheckURL, selfCheckFailures, cause)
+		return event
+	}
+	event.Title = "Status page is reachable again"
+	event.Message = fmt.Sprintf("The status page at %s is reachable again.", selfCheckURL)
+	return event
+}
+
+func sendSelfCheckEvent(event Event) {
+	if selfCheckWebhook == "" {
+		notify(event)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := postJSON(ctx, selfCheckWebhook, nil, event); err != nil {
+		slog.Error("Error sending event to the self-check webhook", "event_type", event.Type, "error", err)
+	}
+}
+
+// checkDirect fetches the page from this host. Any 2xx, including the 201
+// the page returns while a tunnel is unhealthy, counts as reachable; so
+// does 503, which the page returns before the first poll.
+func checkDirect(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusServiceUnavailable {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkFromCheckHost asks check-host.net to fetch the page from several
+// locations and waits for their verdicts.
+func checkFromCheckHost(ctx context.Context, target string) error {
+	query := url.Values{"host": {target}, "max_nodes": {strconv.Itoa(checkHostNodes)}}
+	var started struct {
+		OK        int    `json:"ok"`
+		RequestID string `json:"request_id"`
+	}
+	if err := doJSON(ctx, "GET", checkHostAPI+"/check-http?"+query.Encode(), nil, nil, &started); err != nil {
+		return fmt.Errorf("starting check: %w", err)
+	}
+	if started.OK != 1 || started.RequestID == "" {
+		return fmt.Errorf("check-host.net did not accept the check")
+	}
+
+	// Each node reports null until done, then [[success, time, message,
+	// code, ip]].
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for check results: %w", ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
+		var results map[string][][]any
+		if err := doJSON(ctx, "GET", checkHostAPI+"/check-result/"+url.PathEscape(started.RequestID), nil, nil, &results); err != nil {
+			return fmt.Errorf("fetching check results: %w", err)
+		}
+		pending := len(results) == 0
+		var failures []string
+		for node, result := range results {
+			if result == nil {
+				pending = true
+				continue
+			}
+			if len(result) > 0 && len(result[0]) > 0 {
+				if ok, _ := result[0][0].(float64); ok == 1 {
+					return nil
+				}
+			}
+			failures = append(failures, node+": "+checkHostMessage(result))
+		}
+		if !pending {
+			sort.Strings(failures)
+			return fmt.Errorf("unreachable from every location (%s)", strings.Join(failures, "; "))
+		}
+	}
+}
+
+func checkHostMessage(result [][]any) string {
+	if len(result) == 0 || len(result[0]) < 3 {
+		return "no result"
+	}
+	if message, ok := result[0][2].(string); ok && message != "" {
+		return message
+	}
+	return "failed"
+}
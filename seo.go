@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	// noindex asks search engines to stay away from every page.
+	noindex bool
+	// tunnelSlugs maps tunnel IDs to the slugs of their names, for
+	// readable /tunnels/ links. Tunnels without a name, or sharing one,
+	// keep their ID.
+	tunnelSlugs   = map[string]string{}
+	tunnelSlugsMu sync.RWMutex
+)
+
+// loadSearchIndexing reads NOINDEX; true sends X-Robots-Tag: noindex on
+// every response and disallows everything in robots.txt, and false lists
+// the public pages in /sitemap.xml. It defaults to true when the public
+// pages are restricted with PUBLIC_ALLOW_CIDRS or PUBLIC_BASIC_AUTH.
+// Sitemap URLs start with PUBLIC_URL, or the request's host without it.
+func loadSearchIndexing() error {
+	_, noindex = accessRules[groupPublic]
+	if value := os.Getenv("NOINDEX"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("NOINDEX: %q is not true or false", value)
+		}
+		noindex = parsed
+	}
+	return nil
+}
+
+// slugify turns a name into lower-case words joined by hyphens, e.g.
+// "EU Web (prod)" into "eu-web-prod".
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+// setTunnelSlugs derives the slugs from the configured names.
+func setTunnelSlugs(cfgs []TunnelConfig) {
+	count := map[string]int{}
+	for _, cfg := range cfgs {
+		count[slugify(cfg.Name)]++
+	}
+	slugs := map[string]string{}
+	for _, cfg := range cfgs {
+		if slug := slugify(cfg.Name); slug != "" && count[slug] == 1 {
+			slugs[cfg.ID] = slug
+		}
+	}
+	tunnelSlugsMu.Lock()
+	tunnelSlugs = slugs
+	tunnelSlugsMu.Unlock()
+}
+
+// tunnelBySlug returns the ID of the tunnel whose slug is slug.
+func tunnelBySlug(slug string) (string, bool) {
+	tunnelSlugsMu.RLock()
+	defer tunnelSlugsMu.RUnlock()
+	for id, s := range tunnelSlugs {
+		if s == slug {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// noindexHeader marks every response as not for search engines when
+// NOINDEX is set, and the admin pages and API always.
+func noindexHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if noindex || routeGroup(r.URL.Path) != groupPublic {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// siteURL is the absolute base URL for links in the sitemap.
+func siteURL(r *http.Request) string {
+	if publicURL != "" {
+		return publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// robotsHandler serves /robots.txt.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if noindex {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /\n")
+		return
+	}
+	fmt.Fprintf(w, "User-agent: *\nDisallow: /admin/\nDisallow: /api/\nDisallow: /events\nDisallow: /ws\n\nSitemap: %s/sitemap.xml\n", siteURL(r))
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapHandler serves /sitemap.xml: the status page, report and
+// incident timeline, each tunnel's page and each incident's permalink.
+// It is not found with NOINDEX.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if noindex {
+		http.NotFound(w, r)
+		return
+	}
+	base := siteURL(r)
+	lastmod := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	var m sitemap
+	for _, path := range []string{"/", "/report", "/incidents"} {
+		m.URLs = append(m.URLs, sitemapURL{Loc: base + path})
+	}
+	for _, t := range snapshotTunnels() {
+		u := sitemapURL{Loc: base + tunnelPath(t.ID)}
+		if changed := statusSince(t.ID, t.Status); !changed.IsZero() {
+			u.LastMod = lastmod(changed)
+		}
+		m.URLs = append(m.URLs, u)
+	}
+	for _, rec := range filteredIncidents("", "") {
+		m.URLs = append(m.URLs, sitemapURL{Loc: base + incidentPath(rec), LastMod: lastmod(incidentLastChange(rec))})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		slog.Error("Error writing sitemap", "error", err)
+	}
+}
+
+// incidentLastChange is when the record's public page last changed.
+func incidentLastChange(rec incidentRecord) time.Time {
+	last := rec.Start
+	if rec.End != nil {
+		last = *rec.End
+	}
+	for _, note := range rec.Notes {
+		if note.Public && note.Time.After(last) {
+			last = note.Time
+		}
+	}
+	return last
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServiceTokenWarnDays = 30
+	serviceTokenCheckInterval   = 6 * time.Hour
+)
+
+// serviceToken is an Access service token and when it stops working.
+type serviceToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ClientID  string    `json:"client_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type serviceTokensResponse struct {
+	Success bool           `json:"success"`
+	Result  []serviceToken `json:"result"`
+}
+
+var (
+	serviceTokenMonitor  bool
+	serviceTokenWarnDays = defaultServiceTokenWarnDays
+	// serviceTokenFilter limits monitoring to tokens with these names or
+	// IDs; empty means every token in the account.
+	serviceTokenFilter map[string]bool
+	serviceTokens      []serviceToken
+	// serviceTokenWarned is the last warning level sent per token and
+	// expiry, so each level is sent once and a renewed token starts over.
+	serviceTokenWarned = map[string]string{}
+	serviceTokensMu    sync.RWMutex
+)
+
+// loadServiceTokens reads SERVICE_TOKEN_MONITOR=true, which checks the
+// expiry of the account's Access service tokens, SERVICE_TOKEN_WARN_DAYS,
+// how far ahead to warn, and SERVICE_TOKENS, an optional comma-separated
+// list of token names or IDs to watch. The API token needs the Access:
+// Service Tokens read permission.
+func loadServiceTokens() error {
+	serviceTokenMonitor = os.Getenv("SERVICE_TOKEN_MONITOR") == "true"
+	if value := os.Getenv("SERVICE_TOKEN_WARN_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			return fmt.Errorf("SERVICE_TOKEN_WARN_DAYS: invalid number %q", value)
+		}
+		serviceTokenWarnDays = days
+	}
+	if names := splitList(os.Getenv("SERVICE_TOKENS")); len(names) > 0 {
+		serviceTokenFilter = map[string]bool{}
+		for _, name := range names {
+			serviceTokenFilter[name] = true
+		}
+	}
+	return nil
+}
+
+// watchServiceTokens checks token expiry now and every
+// serviceTokenCheckInterval.
+func watchServiceTokens() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := checkServiceTokens(ctx, os.Getenv("ACCOUNT_ID"), time.Now()); err != nil {
+			slog.Error("Error checking Access service tokens", "error", err)
+		}
+		cancel()
+		time.Sleep(serviceTokenCheckInterval)
+	}
+}
+
+func fetchServiceTokens(ctx context.Context, accountID string) ([]serviceToken, error) {
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/access/service_tokens", accountID)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiBackground)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var parsed serviceTokensResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing API response: %w", err)
+	}
+	if !parsed.Success {
+		return nil, fmt.Errorf("API response indicates failure: %s", string(body))
+	}
+	return parsed.Result, nil
+}
+
+// checkServiceTokens refreshes the token list and sends a warning for each
+// token newly within the warning period, and again once it has expired.
+func checkServiceTokens(ctx context.Context, accountID string, now time.Time) error {
+	fetched, err := fetchServiceTokens(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	var watched []serviceToken
+	for _, token := range fetched {
+		if serviceTokenFilter == nil || serviceTokenFilter[token.Name] || serviceTokenFilter[token.ID] {
+			watched = append(watched, token)
+		}
+	}
+	// Soonest expiry first; tokens that never expire last.
+	sort.Slice(watched, func(i, j int) bool {
+		a, b := watched[i].ExpiresAt, watched[j].ExpiresAt
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero()
+		}
+		return a.Before(b)
+	})
+
+	var events []Event
+	serviceTokensMu.Lock()
+	serviceTokens = watched
+	for _, token := range watched {
+		level := serviceTokenLevel(token, now)
+		key := token.ID + "@" + token.ExpiresAt.Format(time.RFC3339)
+		if level == "" || serviceTokenWarned[key] == level {
+			continue
+		}
+		serviceTokenWarned[key] = level
+		events = append(events, serviceTokenEvent(token, level, now))
+	}
+	serviceTokensMu.Unlock()
+
+	for _, event := range events {
+		slog.Warn("Access service token expiring", "token", event.TokenName, "expires_at", event.ExpiresAt, "event_type", event.Type)
+		notify(event)
+	}
+	return nil
+}
+
+// serviceTokenLevel is "expired", "expiring" within the warning period,
+// or "" for tokens with time to spare or no expiry.
+func serviceTokenLevel(token serviceToken, now time.Time) string {
+	switch {
+	case token.ExpiresAt.IsZero():
+		return ""
+	case !token.ExpiresAt.After(now):
+		return "expired"
+	case token.ExpiresAt.Sub(now) <= time.Duration(serviceTokenWarnDays)*24*time.Hour:
+		return "expiring"
+	default:
+		return ""
+	}
+}
+
+func serviceTokenEvent(token serviceToken, level string, now time.Time) Event {
+	event := Event{
+		ID:        newEventID(),
+		Type:      eventServiceTokenExpiring,
+		Time:      now,
+		TokenName: token.Name,
+		ExpiresAt: &token.ExpiresAt,
+	}
+	expires := token.ExpiresAt.UTC().Format(time.RFC1123)
+	if level == "expired" {
+		event.Type = eventServiceTokenExpired
+		event.Title = fmt.Sprintf("Access service token %s has expired", token.Name)
+		event.Message = fmt.Sprintf("Access service token %s (client ID %s) expired at %s. Clients using it can no longer reach applications behind Access.",
+			token.Name, token.ClientID, expires)
+		return event
+	}
+	event.Title = fmt.Sprintf("Access service token %s expires in %s", token.Name, daysLeft(token.ExpiresAt.Sub(now)))
+	event.Message = fmt.Sprintf("Access service token %s (client ID %s) expires at %s. Refresh or rotate it before then.",
+		token.Name, token.ClientID, expires)
+	return event
+}
+
+// serviceTokenRow is a watched token as shown on the report.
+type serviceTokenRow struct {
+	Name      string
+	ClientID  string
+	ExpiresAt time.Time
+	Remaining string
+	Level     string
+}
+
+func serviceTokenRows(now time.Time) []serviceTokenRow {
+	serviceTokensMu.RLock()
+	defer serviceTokensMu.RUnlock()
+	var rows []serviceTokenRow
+	for _, token := range serviceTokens {
+		row := serviceTokenRow{
+			Name:      token.Name,
+			ClientID:  token.ClientID,
+			ExpiresAt: token.ExpiresAt,
+			Level:     serviceTokenLevel(token, now),
+		}
+		switch {
+		case token.ExpiresAt.IsZero():
+			row.Remaining = "Never expires"
+		case row.Level == "expired":
+			row.Remaining = "Expired"
+		default:
+			row.Remaining = daysLeft(token.ExpiresAt.Sub(now))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// daysLeft renders a time to expiry in whole days, the precision tokens
+// are renewed at.
+func daysLeft(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	switch days {
+	case 0:
+		return "less than a day"
+	case 1:
+		return "1 day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
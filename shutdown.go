@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout bounds how long shutdown waits for in-flight requests
+// and the current poll, within the 30 seconds Docker and Kubernetes allow
+// before killing the process.
+const shutdownTimeout = 25 * time.Second
+
+var (
+	// shuttingDown is closed when shutdown starts. It ends the /events and
+	// /ws streams, which would otherwise hold http.Server.Shutdown until
+	// its timeout.
+	shuttingDown = make(chan struct{})
+	// pollerDone is closed when pollAPI returns.
+	pollerDone = make(chan struct{})
+)
+
+// shutdown stops the server once SIGINT or SIGTERM has stopped the poller:
+// it stops accepting connections and drains in-flight requests, waits for
+// the poll in progress so its samples are recorded, then flushes history
+// and saves the state to STATE_STORE.
+func shutdown(server *http.Server) {
+	slog.Info("Shutting down; press Ctrl+C again to stop immediately")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	close(shuttingDown)
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Error draining HTTP requests", "error", err)
+	}
+	select {
+	case <-pollerDone:
+	case <-ctx.Done():
+		slog.Warn("Poll still running after the shutdown timeout; its results may be lost")
+	}
+	flushHistory()
+	if stateBackend != nil {
+		slog.Info("Saving state before exit", "backend", stateBackend.Name())
+		saveState()
+	}
+	slog.Info("Server stopped")
+}
+
+// flushHistory finishes history writes before exit. Samples are written
+// as they are recorded, so this waits for any write in progress, then
+// compacts HISTORY_FILE or closes HISTORY_DB, checkpointing its
+// write-ahead log. Samples recorded afterwards are not persisted.
+func flushHistory() {
+	historyWriteMu.Lock()
+	defer historyWriteMu.Unlock()
+	switch {
+	case historyDB != nil:
+		if err := historyDB.Close(); err != nil {
+			slog.Error("Error closing history database", "error", err)
+		}
+		historyDB = nil
+	case historyFile != "" && historyAppended > 0:
+		if err := rewriteHistory(historySnapshot()); err != nil {
+			slog.Error("Error compacting history file", "error", err)
+		}
+		historyFile = ""
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slaBudgetWarning is the fraction of an error budget consumed before a
+// warning is sent.
+const slaBudgetWarning = 0.8
+
+// businessHours is a weekly schedule of covered time, e.g. 8x5 for a
+// contract that only counts Monday to Friday, 08:00 to 16:00.
+type businessHours struct {
+	spec string
+	days [7]bool
+	// start and end are offsets from midnight in loc.
+	start, end time.Duration
+	loc        *time.Location
+}
+
+// slaTarget is an availability objective over a calendar period, e.g.
+// 99.9% per month. Business targets only count business hours.
+type slaTarget struct {
+	spec      string
+	objective float64
+	period    string
+	business  bool
+}
+
+// slaBudget is how much of a target's error budget the current period has
+// used. The budget is the downtime the objective allows over the whole
+// period, so it is known from the first day.
+type slaBudget struct {
+	Target      slaTarget
+	PeriodStart time.Time
+	Budget      time.Duration
+	Used        time.Duration
+}
+
+var (
+	// defaultBusinessHours and defaultSLATargets apply to tunnels without
+	// their own business_hours or sla, including discovered ones.
+	defaultBusinessHours *businessHours
+	defaultSLATargets    []slaTarget
+	// slaAlerted is the last alert level sent per tunnel, target and
+	// period, so each level is sent once per period.
+	slaAlerted   = map[string]string{}
+	slaAlertedMu sync.Mutex
+)
+
+// loadSLA reads BUSINESS_HOURS, the default schedule for the
+// business-hours availability figure, and SLA_TARGETS, the default SLA
+// targets, in the same form as a tunnel's business_hours and sla
+// settings.
+func loadSLA() error {
+	if spec := os.Getenv("BUSINESS_HOURS"); spec != "" {
+		hours, err := parseBusinessHours(spec)
+		if err != nil {
+			return fmt.Errorf("BUSINESS_HOURS: %w", err)
+		}
+		defaultBusinessHours = hours
+	}
+	if spec := os.Getenv("SLA_TARGETS"); spec != "" {
+		targets, err := parseSLATargets(spec, defaultBusinessHours != nil)
+		if err != nil {
+			return fmt.Errorf("SLA_TARGETS: %w", err)
+		}
+		defaultSLATargets = targets
+	}
+	return nil
+}
+
+// parseSLATargets parses a comma-separated list of "<percent>% <period>
+// [business]" targets, where period is daily, weekly or monthly, e.g. "99.9% monthly, 99.5% weekly business". hasHours is
+// whether business hours apply, which business targets need.
+func parseSLATargets(spec string, hasHours bool) ([]slaTarget, error) {
+	var targets []slaTarget
+	for _, item := range splitList(spec) {
+		fields := strings.Fields(item)
+		if len(fields) < 2 || len(fields) > 3 || !strings.HasSuffix(fields[0], "%") {
+			return nil, fmt.Errorf("%q is not \"<percent>%% <period> [business]\"", item)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("%q: objective must be between 0%% and 100%%", item)
+		}
+		target := slaTarget{spec: strings.Join(fields, " "), objective: percent / 100, period: fields[1]}
+		switch target.period {
+		case "daily", "weekly", "monthly":
+		default:
+			return nil, fmt.Errorf("%q: unknown period %q (available: daily, weekly, monthly)", item, target.period)
+		}
+		if len(fields) == 3 {
+			if fields[2] != "business" {
+				return nil, fmt.Errorf("%q: unknown option %q", item, fields[2])
+			}
+			if !hasHours {
+				return nil, fmt.Errorf("%q: business targets need business hours", item)
+			}
+			target.business = true
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// parseBusinessHours parses "<days> <HH:MM>-<HH:MM> [time zone]", where
+// days is a comma-separated list of days or day ranges, e.g.
+// "Mon-Fri 08:00-17:00 Europe/London" or "Mon,Wed,Fri 09:00-12:30". The
+// time zone defaults to UTC.
+func parseBusinessHours(spec string) (*businessHours, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("%q is not \"<days> <HH:MM>-<HH:MM> [time zone]\"", spec)
+	}
+	hours := &businessHours{spec: spec, loc: time.UTC}
+	for _, part := range strings.Split(fields[0], ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := parseWeekday(first)
+		to := from
+		if isRange {
+			to, ok = parseWeekday(last)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%q: unknown day in %q", spec, part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			hours.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	start, end, _ := strings.Cut(fields[1], "-")
+	var err error
+	if hours.start, err = parseTimeOfDay(start); err != nil {
+		return nil, fmt.Errorf("%q: %w", spec, err)
+	}
+	if hours.end, err = parseTimeOfDay(end); err != nil {
+		return nil, fmt.Errorf("%q: %w", spec, err)
+	}
+	if hours.end <= hours.start {
+		return nil, fmt.Errorf("%q: hours must end after they start on the same day", spec)
+	}
+	if len(fields) == 3 {
+		if hours.loc, err = time.LoadLocation(fields[2]); err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+	}
+	return hours, nil
+}
+
+// parseWeekday accepts a day's full name or its first three letters.
+func parseWeekday(name string) (int, bool) {
+	name = strings.ToLower(name)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return int(day), true
+		}
+	}
+	return 0, false
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (h *businessHours) String() string { return h.spec }
+
+// overlap returns how much of [from, to) falls within business hours.
+func (h *businessHours) overlap(from, to time.Time) time.Duration {
+	var total time.Duration
+	local := from.In(h.loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, h.loc)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !h.days[day.Weekday()] {
+			continue
+		}
+		// Built from the wall clock so that the hours hold across DST
+		// changes; time.Date normalises 24:00 to the next midnight.
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, int(h.start/time.Minute), 0, 0, h.loc)
+		end := time.Date(day.Year(), day.Month(), day.Day(), 0, int(h.end/time.Minute), 0, 0, h.loc)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// tunnelBusinessHours returns the tunnel's schedule, or the default one.
+func tunnelBusinessHours(t tunnelState) *businessHours {
+	if t.BusinessHours != nil {
+		return t.BusinessHours
+	}
+	return defaultBusinessHours
+}
+
+// businessAvailability is availability counting only observed time within
+// hours, so downtime outside them does not affect the figure.
+func businessAvailability(tunnelID string, hours *businessHours, from, to time.Time) (ratio float64, ok bool) {
+	var up, observed time.Duration
+	availabilitySpans(tunnelID, from, to, func(status string, start, end time.Time) {
+		covered := hours.overlap(start, end)
+		observed += covered
+		if isAvailable(status) {
+			up += covered
+		}
+	})
+	if observed == 0 {
+		return 0, false
+	}
+	return float64(up) / float64(observed), true
+}
+
+func (t slaTarget) String() string { return t.spec }
+
+// periodBounds returns the calendar period containing now, in loc. Weeks
+// start on Monday.
+func (t slaTarget) periodBounds(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	local := now.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	switch t.period {
+	case "daily":
+		return day, day.AddDate(0, 0, 1)
+	case "weekly":
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	default:
+		start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+// periodLength is the nominal length of the target's period.
+func (t slaTarget) periodLength() time.Duration {
+	switch t.period {
+	case "daily":
+		return 24 * time.Hour
+	case "weekly":
+		return 7 * 24 * time.Hour
+	default:
+		return 30 * 24 * time.Hour
+	}
+}
+
+// tunnelSLATargets returns the tunnel's targets, or the default ones.
+func tunnelSLATargets(t tunnelState) []slaTarget {
+	if t.SLATargets != nil {
+		return t.SLATargets
+	}
+	return defaultSLATargets
+}
+
+// errorBudget measures target's current period for the tunnel. Time
+// without samples is not counted as downtime, nor is excluded downtime.
+func errorBudget(t tunnelState, target slaTarget, now time.Time) slaBudget {
+	hours := tunnelBusinessHours(t)
+	loc := time.UTC
+	if target.business {
+		loc = hours.loc
+	}
+	start, end := target.periodBounds(now, loc)
+	covered := func(from, to time.Time) time.Duration {
+		if target.business {
+			return hours.overlap(from, to)
+		}
+		return to.Sub(from)
+	}
+
+	b := slaBudget{Target: target, PeriodStart: start}
+	b.Budget = time.Duration(float64(covered(start, end)) * (1 - target.objective))
+	availabilitySpans(t.ID, start, now, func(status string, from, to time.Time) {
+		if !isAvailable(status) {
+			b.Used += covered(from, to)
+		}
+	})
+	return b
+}
+
+// Consumed is the fraction of the budget used, above 1 once the target
+// is breached.
+func (b slaBudget) Consumed() float64 {
+	if b.Budget <= 0 {
+		return 0
+	}
+	return float64(b.Used) / float64(b.Budget)
+}
+
+// Remaining describes the budget left, e.g. "62% left (16m 24s)".
+func (b slaBudget) Remaining() string {
+	if b.Used > b.Budget {
+		return fmt.Sprintf("breached (%s over)", formatElapsed((b.Used - b.Budget)))
+	}
+	return fmt.Sprintf("%.0f%% left (%s)", (1-b.Consumed())*100, formatElapsed((b.Budget - b.Used)))
+}
+
+// Breached reports whether the period's downtime exceeds the budget.
+func (b slaBudget) Breached() bool { return b.Used > b.Budget }
+
+// tunnelBudgets measures every SLA target of the tunnel.
+func tunnelBudgets(t tunnelState, now time.Time) []slaBudget {
+	var budgets []slaBudget
+	for _, target := range tunnelSLATargets(t) {
+		budgets = append(budgets, errorBudget(t, target, now))
+	}
+	return budgets
+}
+
+// checkSLAs alerts when a tunnel's target is breached or most of its error
+// budget is gone, once per level and period.
+func checkSLAs(list []tunnelState, now time.Time) {
+	var events []Event
+	slaAlertedMu.Lock()
+	for _, t := range list {
+		for _, b := range tunnelBudgets(t, now) {
+			level := ""
+			switch {
+			case b.Breached():
+				level = eventSLABreached
+			case b.Consumed() >= slaBudgetWarning:
+				level = eventSLABudgetLow
+			}
+			key := t.ID + "|" + b.Target.spec + "@" + b.PeriodStart.Format(time.RFC3339)
+			if level == "" || slaAlerted[key] == level || slaAlerted[key] == eventSLABreached {
+				continue
+			}
+			slaAlerted[key] = level
+			events = append(events, slaEvent(t, b, level, now))
+		}
+	}
+	slaAlertedMu.Unlock()
+
+	for _, event := range events {
+		slog.Warn("SLA at risk", "tunnel_id", event.TunnelID, "sla", event.SLA, "event_type", event.Type, "title", event.Title)
+		notify(event)
+	}
+}
+
+func slaEvent(t tunnelState, b slaBudget, typ string, now time.Time) Event {
+	event := Event{
+		ID:         newEventID(),
+		Type:       typ,
+		Time:       now,
+		TunnelID:   t.ID,
+		TunnelName: t.label(),
+		NewStatus:  t.Status,
+		SLA:        b.Target.spec,
+	}
+	if typ == eventSLABreached {
+		event.Title = fmt.Sprintf("Tunnel %s breached its %s SLA", t.label(), b.Target)
+		event.Message = fmt.Sprintf("Tunnel %s has been down for %s since %s, more than the %s the %s target allows.",
+			t.label(), formatElapsed(b.Used), b.PeriodStart.Format("2006-01-02"), formatElapsed(b.Budget), b.Target)
+		return event
+	}
+	event.Title = fmt.Sprintf("Tunnel %s has used %.0f%% of its %s error budget", t.label(), b.Consumed()*100, b.Target)
+	event.Message = fmt.Sprintf("Tunnel %s has been down for %s since %s; the %s target allows %s, so %s remain this period.",
+		t.label(), formatElapsed(b.Used), b.PeriodStart.Format("2006-01-02"), b.Target, formatElapsed(b.Budget),
+		formatElapsed((b.Budget - b.Used)))
+	return event
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// slackColors are the attachment bar colours per new status.
+var slackColors = map[string]string{
+	"healthy":  "#2eb67d",
+	"degraded": "#ecb22e",
+	"inactive": "#e01e5a",
+	"down":     "#e01e5a",
+}
+
+// slackNotifier posts Block Kit messages to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+	// statuses limits status change events to those entering one of
+	// them; nil sends every change. Other events are always sent.
+	statuses []string
+}
+
+// slackFromEnv reads SLACK_WEBHOOK_URLS, a comma-separated list of
+// incoming webhook URLs, and SLACK_STATUSES.
+func slackFromEnv() []NotifierConfig {
+	return numberedConfigs("slack", "url", os.Getenv("SLACK_WEBHOOK_URLS"), map[string]string{
+		"statuses": os.Getenv("SLACK_STATUSES"),
+	})
+}
+
+// newSlackIntegration takes the webhook url setting and statuses, a
+// comma-separated list of the statuses worth a message, e.g.
+// "down,degraded".
+func newSlackIntegration(settings map[string]string) (integration, error) {
+	if settings["url"] == "" {
+		return integration{}, fmt.Errorf("url is required")
+	}
+	statuses := splitList(settings["statuses"])
+	for _, status := range statuses {
+		if !validStatus(status) {
+			return integration{}, fmt.Errorf("statuses: unknown status %q (available: healthy, degraded, inactive, down)", status)
+		}
+	}
+	return integration{notifier: &slackNotifier{url: settings["url"], statuses: statuses}}, nil
+}
+
+func (n *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, event Event) error {
+	if event.Type == eventStatusChanged && n.statuses != nil && !slices.Contains(n.statuses, event.NewStatus) {
+		return nil
+	}
+
+	blocks := []map[string]any{
+		{"type": "header", "text": map[string]any{"type": "plain_text", "text": event.Title}},
+		{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": event.Message}},
+	}
+	if transition := statusTransition(event); transition != "" {
+		blocks = append(blocks, map[string]any{
+			"type": "section",
+			"fields": []map[string]any{
+				{"type": "mrkdwn", "text": "*Status*\n" + transition},
+				{"type": "mrkdwn", "text": "*Tunnel*\n`" + event.TunnelID + "`"},
+			},
+		})
+	}
+	blocks = append(blocks, map[string]any{
+		"type": "context",
+		"elements": []map[string]any{
+			{"type": "mrkdwn", "text": fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", event.Time.Unix(), event.Time.UTC().Format(time.RFC1123))},
+		},
+	})
+
+	color, ok := slackColors[event.NewStatus]
+	if !ok {
+		color = "#1d9bd1"
+	}
+	payload := map[string]any{
+		"text":        event.Title,
+		"attachments": []map[string]any{{"color": color, "blocks": blocks}},
+	}
+	return postJSON(ctx, n.url, nil, payload)
+}
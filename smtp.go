@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpNotifier emails events, and optionally a daily summary, through an
+// SMTP server.
+type smtpNotifier struct {
+	host     string
+	port     int
+	security string
+	username string
+	password string
+	from     string
+	to       []string
+	// summaryAt is when the daily summary is sent, as an offset from
+	// midnight UTC; negative when there is none.
+	summaryAt time.Duration
+}
+
+// smtpFromEnv reads SMTP_HOST, SMTP_PORT, SMTP_TLS, SMTP_USERNAME,
+// SMTP_PASSWORD, SMTP_FROM, SMTP_TO (comma-separated) and SMTP_SUMMARY_AT.
+func smtpFromEnv() []NotifierConfig {
+	if os.Getenv("SMTP_HOST") == "" {
+		return nil
+	}
+	return []NotifierConfig{{Name: "email", Type: "smtp", Settings: envSettings(map[string]string{
+		"host":       "SMTP_HOST",
+		"port":       "SMTP_PORT",
+		"tls":        "SMTP_TLS",
+		"username":   "SMTP_USERNAME",
+		"password":   "SMTP_PASSWORD",
+		"from":       "SMTP_FROM",
+		"to":         "SMTP_TO",
+		"summary_at": "SMTP_SUMMARY_AT",
+	})}}
+}
+
+// newSMTPIntegration takes host, port, tls (starttls, the default; tls for
+// implicit TLS, usually on port 465; or none), username and password for
+// authentication, from, to (comma-separated) and summary_at, the UTC time
+// of day (HH:MM) a status summary is sent. Mail is sent directly, so with
+// OUTBOUND_PROXY the host must be in OUTBOUND_NO_PROXY.
+func newSMTPIntegration(settings map[string]string) (integration, error) {
+	n := &smtpNotifier{
+		host:      settings["host"],
+		security:  settings["tls"],
+		username:  settings["username"],
+		password:  settings["password"],
+		from:      settings["from"],
+		to:        splitList(settings["to"]),
+		summaryAt: -1,
+	}
+	if n.host == "" || n.from == "" || len(n.to) == 0 {
+		return integration{}, fmt.Errorf("host, from and to are required")
+	}
+	if outboundProxy != nil && !outboundBypassed(n.host) {
+		return integration{}, fmt.Errorf("host: SMTP does not go through OUTBOUND_PROXY; add %s to OUTBOUND_NO_PROXY", n.host)
+	}
+	for _, address := range append([]string{n.from}, n.to...) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return integration{}, fmt.Errorf("invalid address %q: %v", address, err)
+		}
+	}
+	switch n.security {
+	case "":
+		n.security = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return integration{}, fmt.Errorf("tls: must be starttls, tls or none, got %q", n.security)
+	}
+	n.port = 587
+	if n.security == "tls" {
+		n.port = 465
+	}
+	if value := settings["port"]; value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return integration{}, fmt.Errorf("port: invalid port %q", value)
+		}
+		n.port = port
+	}
+	if value := settings["summary_at"]; value != "" {
+		at, err := time.Parse("15:04", value)
+		if err != nil {
+			return integration{}, fmt.Errorf("summary_at: invalid time %q (HH:MM)", value)
+		}
+		n.summaryAt = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	}
+	in := integration{notifier: n}
+	if n.summaryAt >= 0 {
+		in.summary = n
+	}
+	return in, nil
+}
+
+func (n *smtpNotifier) Name() string {
+	return "email " + strings.Join(n.to, ", ")
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, event Event) error {
+	return n.send(ctx, event.Title, event.Message)
+}
+
+func (n *smtpNotifier) SummaryAt() time.Duration { return n.summaryAt }
+
+func (n *smtpNotifier) SendSummary(ctx context.Context, subject, body string) error {
+	return n.send(ctx, subject, body)
+}
+
+// send delivers one plain-text email to every recipient.
+func (n *smtpNotifier) send(ctx context.Context, subject, body string) error {
+	address := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+	tlsConfig := &tls.Config{ServerName: n.host, RootCAs: outboundCAs}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if n.security == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.security == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if n.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+	}
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(subject, body, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (n *smtpNotifier) message(subject, body string, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@cftunnels>\r\n", newEventID())
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
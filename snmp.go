@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSNMPBaseOID sits under NET-SNMP-MIB::netSnmpPlaypen, the
+// experimental subtree, since this project has no enterprise number of its
+// own. CFTUNNELS-MIB in mibs/ describes the objects beneath it.
+const defaultSNMPBaseOID = "1.3.6.1.4.1.8072.9999.9999.7637"
+
+// BER and SNMP tags used by the agent.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	snmpTimeTicks  = 0x43
+
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduGetResponse    = 0xa2
+	pduGetBulkRequest = 0xa5
+
+	snmpNoSuchObject   = 0x80
+	snmpNoSuchInstance = 0x81
+	snmpEndOfMibView   = 0x82
+
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+
+	snmpErrNoSuchName = 2
+	snmpErrGenErr     = 5
+)
+
+// SNMP status codes, matching CftTunnelStatus in the MIB.
+var snmpStatusCodes = map[string]int64{
+	"healthy":  1,
+	"degraded": 2,
+	"inactive": 3,
+	"down":     4,
+}
+
+var (
+	snmpListen    string
+	snmpCommunity = "public"
+	snmpBaseOID   []uint32
+)
+
+// snmpVar is one object instance: its OID and its BER-encoded value.
+type snmpVar struct {
+	oid   []uint32
+	value []byte
+}
+
+// loadSNMP reads SNMP_LISTEN (a UDP address such as ":1161"; the agent is
+// disabled when empty), SNMP_COMMUNITY and SNMP_BASE_OID.
+func loadSNMP() error {
+	snmpListen = os.Getenv("SNMP_LISTEN")
+	if community := os.Getenv("SNMP_COMMUNITY"); community != "" {
+		snmpCommunity = community
+	}
+	base := os.Getenv("SNMP_BASE_OID")
+	if base == "" {
+		base = defaultSNMPBaseOID
+	}
+	oid, err := parseOIDString(base)
+	if err != nil {
+		return fmt.Errorf("SNMP_BASE_OID: %w", err)
+	}
+	snmpBaseOID = oid
+	return nil
+}
+
+func parseOIDString(value string) ([]uint32, error) {
+	var oid []uint32
+	for _, part := range strings.Split(strings.Trim(value, "."), ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", value)
+		}
+		oid = append(oid, uint32(n))
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", value)
+	}
+	return oid, nil
+}
+
+func childOID(base []uint32, sub ...uint32) []uint32 {
+	oid := make([]uint32, 0, len(base)+len(sub))
+	return append(append(oid, base...), sub...)
+}
+
+func compareOIDs(a, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// snmpSnapshot builds the agent's view of the MIB, sorted by OID:
+//
+//	base.1.0        cftTunnelCount
+//	base.2.1.1.i    cftTunnelIndex
+//	base.2.1.2.i    cftTunnelId
+//	base.2.1.3.i    cftTunnelName
+//	base.2.1.4.i    cftTunnelStatus
+//	base.2.1.5.i    cftTunnelStatusText
+//	base.2.1.6.i    cftTunnelUptime (TimeTicks)
+func snmpSnapshot() []snmpVar {
+	type row struct {
+		id, name, status string
+		since            time.Time
+	}
+	var rows []row
+	for _, t := range snapshotTunnels() {
+		rows = append(rows, row{t.ID, t.label(), t.Status, t.ActiveAt})
+	}
+
+	vars := []snmpVar{{childOID(snmpBaseOID, 1, 0), berTLV(berInteger, berInt(int64(len(rows))))}}
+	columns := []func(index int) []byte{
+		func(i int) []byte { return berTLV(berInteger, berInt(int64(i))) },
+		func(i int) []byte { return berTLV(berOctetString, []byte(rows[i-1].id)) },
+		func(i int) []byte { return berTLV(berOctetString, []byte(rows[i-1].name)) },
+		func(i int) []byte { return berTLV(berInteger, berInt(snmpStatusCodes[rows[i-1].status])) },
+		func(i int) []byte { return berTLV(berOctetString, []byte(statusLabel(rows[i-1].status))) },
+		func(i int) []byte {
+			ticks := int64(0)
+			if row := rows[i-1]; row.status == "healthy" && !row.since.IsZero() {
+				ticks = time.Since(row.since).Milliseconds() / 10
+			}
+			return berTLV(snmpTimeTicks, berUint(uint32(ticks)))
+		},
+	}
+	for column, value := range columns {
+		for index := 1; index <= len(rows); index++ {
+			vars = append(vars, snmpVar{childOID(snmpBaseOID, 2, 1, uint32(column+1), uint32(index)), value(index)})
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool { return compareOIDs(vars[i].oid, vars[j].oid) < 0 })
+	return vars
+}
+
+// serveSNMP answers SNMPv1 and v2c Get, GetNext and GetBulk requests.
+// Set requests are not supported and are ignored.
+func serveSNMP() {
+	conn, err := net.ListenPacket("udp", snmpListen)
+	if err != nil {
+		slog.Error("Error starting SNMP agent", "error", err)
+		return
+	}
+	slog.Info("SNMP agent listening", "addr", snmpListen)
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			slog.Error("Error reading SNMP request", "error", err)
+			continue
+		}
+		response, err := handleSNMP(buf[:n])
+		if err != nil {
+			slog.Warn("Dropped SNMP request", "remote_addr", addr.String(), "error", err)
+			continue
+		}
+		if _, err := conn.WriteTo(response, addr); err != nil {
+			slog.Error("Error writing SNMP response", "error", err)
+		}
+	}
+}
+
+func handleSNMP(packet []byte) ([]byte, error) {
+	tag, message, _, err := readTLV(packet)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed message")
+	}
+	tag, versionBytes, message, err := readTLV(message)
+	if err != nil || tag != berInteger {
+		return nil, errors.New("malformed version")
+	}
+	version := parseBERInt(versionBytes)
+	if version != snmpVersion1 && version != snmpVersion2c {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	tag, community, message, err := readTLV(message)
+	if err != nil || tag != berOctetString {
+		return nil, errors.New("malformed community")
+	}
+	if subtle.ConstantTimeCompare(community, []byte(snmpCommunity)) != 1 {
+		return nil, errors.New("wrong community")
+	}
+	pduType, pdu, _, err := readTLV(message)
+	if err != nil {
+		return nil, errors.New("malformed PDU")
+	}
+
+	fields := make([][]byte, 3)
+	for i := range fields {
+		if tag, fields[i], pdu, err = readTLV(pdu); err != nil || tag != berInteger {
+			return nil, errors.New("malformed PDU header")
+		}
+	}
+	requestID := parseBERInt(fields[0])
+	tag, varbindList, _, err := readTLV(pdu)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed varbind list")
+	}
+	var requested [][]uint32
+	for len(varbindList) > 0 {
+		var varbind, oidBytes []byte
+		if tag, varbind, varbindList, err = readTLV(varbindList); err != nil || tag != berSequence {
+			return nil, errors.New("malformed varbind")
+		}
+		if tag, oidBytes, _, err = readTLV(varbind); err != nil || tag != berOID {
+			return nil, errors.New("malformed varbind OID")
+		}
+		requested = append(requested, parseBEROID(oidBytes))
+	}
+
+	vars := snmpSnapshot()
+	var results []snmpVar
+	errorStatus, errorIndex := int64(0), int64(0)
+	switch pduType {
+	case pduGetRequest:
+		for i, oid := range requested {
+			value := snmpGet(vars, oid, version)
+			if value == nil {
+				errorStatus, errorIndex = snmpErrNoSuchName, int64(i+1)
+				value = berTLV(berNull, nil)
+			}
+			results = append(results, snmpVar{oid, value})
+		}
+	case pduGetNextRequest:
+		for i, oid := range requested {
+			next, ok := snmpNext(vars, oid)
+			if !ok {
+				if version == snmpVersion1 {
+					errorStatus, errorIndex = snmpErrNoSuchName, int64(i+1)
+					next = snmpVar{oid, berTLV(berNull, nil)}
+				} else {
+					next = snmpVar{oid, berTLV(snmpEndOfMibView, nil)}
+				}
+			}
+			results = append(results, next)
+		}
+	case pduGetBulkRequest:
+		if version == snmpVersion1 {
+			return nil, errors.New("GetBulk is not valid in SNMPv1")
+		}
+		results = snmpBulk(vars, requested, int(parseBERInt(fields[1])), int(parseBERInt(fields[2])))
+	default:
+		errorStatus = snmpErrGenErr
+		for _, oid := range requested {
+			results = append(results, snmpVar{oid, berTLV(berNull, nil)})
+		}
+	}
+	if errorStatus != 0 {
+		// On error the request's varbinds are returned unchanged.
+		for i, oid := range requested {
+			results[i] = snmpVar{oid, berTLV(berNull, nil)}
+		}
+	}
+
+	var encodedVars []byte
+	for _, v := range results {
+		encodedVars = append(encodedVars, berTLV(berSequence, append(berTLV(berOID, berOIDBytes(v.oid)), v.value...))...)
+	}
+	var body []byte
+	body = append(body, berTLV(berInteger, berInt(requestID))...)
+	body = append(body, berTLV(berInteger, berInt(errorStatus))...)
+	body = append(body, berTLV(berInteger, berInt(errorIndex))...)
+	body = append(body, berTLV(berSequence, encodedVars)...)
+
+	var response []byte
+	response = append(response, berTLV(berInteger, berInt(version))...)
+	response = append(response, berTLV(berOctetString, community)...)
+	response = append(response, berTLV(pduGetResponse, body)...)
+	return berTLV(berSequence, response), nil
+}
+
+// snmpGet returns the value at oid. For SNMPv1 a missing object yields nil;
+// v2c uses the noSuchObject/noSuchInstance exceptions instead.
+func snmpGet(vars []snmpVar, oid []uint32, version int64) []byte {
+	for _, v := range vars {
+		if compareOIDs(v.oid, oid) == 0 {
+			return v.value
+		}
+	}
+	if version == snmpVersion1 {
+		return nil
+	}
+	for _, v := range vars {
+		if len(oid) > 0 && len(v.oid) >= len(oid)-1 && compareOIDs(v.oid[:len(oid)-1], oid[:len(oid)-1]) == 0 {
+			return berTLV(snmpNoSuchInstance, nil)
+		}
+	}
+	return berTLV(snmpNoSuchObject, nil)
+}
+
+func snmpNext(vars []snmpVar, oid []uint32) (snmpVar, bool) {
+	i := sort.Search(len(vars), func(i int) bool { return compareOIDs(vars[i].oid, oid) > 0 })
+	if i == len(vars) {
+		return snmpVar{}, false
+	}
+	return vars[i], true
+}
+
+func snmpBulk(vars []snmpVar, requested [][]uint32, nonRepeaters, maxRepetitions int) []snmpVar {
+	if nonRepeaters < 0 {
+		nonRepeaters = 0
+	}
+	if nonRepeaters > len(requested) {
+		nonRepeaters = len(requested)
+	}
+	if maxRepetitions < 0 {
+		maxRepetitions = 0
+	}
+	const maxBulkResults = 1000
+
+	var results []snmpVar
+	next := func(oid []uint32) snmpVar {
+		if v, ok := snmpNext(vars, oid); ok {
+			return v
+		}
+		return snmpVar{oid, berTLV(snmpEndOfMibView, nil)}
+	}
+	for _, oid := range requested[:nonRepeaters] {
+		results = append(results, next(oid))
+	}
+	current := append([][]uint32(nil), requested[nonRepeaters:]...)
+	for rep := 0; rep < maxRepetitions && len(results) < maxBulkResults; rep++ {
+		done := true
+		for i, oid := range current {
+			v := next(oid)
+			results = append(results, v)
+			current[i] = v.oid
+			if v.value[0] != snmpEndOfMibView {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+	}
+	return results
+}
+
+func readTLV(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated TLV")
+	}
+	tag = b[0]
+	length := int(b[1])
+	offset := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return 0, nil, nil, errors.New("invalid length")
+		}
+		length = 0
+		for _, octet := range b[2 : 2+n] {
+			length = length<<8 | int(octet)
+		}
+		offset += n
+	}
+	if length < 0 || len(b) < offset+length {
+		return 0, nil, nil, errors.New("truncated TLV")
+	}
+	return tag, b[offset : offset+length], b[offset+length:], nil
+}
+
+func berTLV(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	if n := len(content); n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, content...)
+}
+
+func berInt(v int64) []byte {
+	out := []byte{byte(v)}
+	for v > 127 || v < -128 {
+		v >>= 8
+		out = append([]byte{byte(v)}, out...)
+	}
+	return out
+}
+
+func berUint(v uint32) []byte {
+	return berInt(int64(v))
+}
+
+func parseBERInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	v := int64(int8(b[0]))
+	for _, octet := range b[1:] {
+		v = v<<8 | int64(octet)
+	}
+	return v
+}
+
+func berOIDBytes(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	out := []byte{byte(oid[0]*40 + oid[1])}
+	for _, arc := range oid[2:] {
+		chunk := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{byte(arc&0x7f) | 0x80}, chunk...)
+		}
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func parseBEROID(b []byte) []uint32 {
+	if len(b) == 0 {
+		return nil
+	}
+	oid := []uint32{uint32(b[0]) / 40, uint32(b[0]) % 40}
+	var arc uint32
+	for _, octet := range b[1:] {
+		arc = arc<<7 | uint32(octet&0x7f)
+		if octet&0x80 == 0 {
+			oid = append(oid, arc)
+			arc = 0
+		}
+	}
+	return oid
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSnooze bounds how long a tunnel's alerts can be muted, so a
+// forgotten snooze cannot hide an outage for good.
+const maxSnooze = 7 * 24 * time.Hour
+
+// snoozePresets are the durations offered on the admin tunnel page.
+var snoozePresets = []string{"15m", "1h", "8h"}
+
+// tunnelSnooze mutes a tunnel's alerts until Until. The tunnel stays on
+// the status page, marked as snoozed.
+type tunnelSnooze struct {
+	TunnelID string    `json:"tunnel_id"`
+	Until    time.Time `json:"until"`
+	Reason   string    `json:"reason,omitempty"`
+	Author   string    `json:"author,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+var (
+	snoozeFile string
+	snoozes    = map[string]tunnelSnooze{}
+	snoozesMu  sync.Mutex
+)
+
+// loadSnoozes reads SNOOZE_FILE, where snoozes are kept between restarts.
+// Without it they are kept in memory only.
+func loadSnoozes() error {
+	snoozeFile = os.Getenv("SNOOZE_FILE")
+	if snoozeFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(snoozeFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []tunnelSnooze
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range list {
+		snoozes[s.TunnelID] = s
+		if s.Until.After(now) {
+			expireSnoozeAt(s.Until)
+		}
+	}
+	return nil
+}
+
+// expireSnoozeAt drops the cached pages when a snooze ends, so the
+// snoozed badge does not outlive it.
+func expireSnoozeAt(until time.Time) {
+	time.AfterFunc(time.Until(until), invalidatePageCache)
+}
+
+// saveSnoozes writes the snoozes to SNOOZE_FILE. Callers hold snoozesMu.
+func saveSnoozes() {
+	if snoozeFile == "" {
+		return
+	}
+	list := make([]tunnelSnooze, 0, len(snoozes))
+	for _, s := range snoozes {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].TunnelID < list[j].TunnelID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding snoozes", "error", err)
+		monitorFailure("snooze file", err)
+		return
+	}
+	tmp := snoozeFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		slog.Error("Error writing snooze file", "error", err)
+		monitorFailure("snooze file", err)
+		return
+	}
+	err = os.Rename(tmp, snoozeFile)
+	if err != nil {
+		slog.Error("Error writing snooze file", "error", err)
+	}
+	monitorResult("snooze file", err)
+}
+
+// parseSnoozeDuration reads a snooze duration such as 15m or 8h.
+func parseSnoozeDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Minute || d > maxSnooze {
+		return 0, fmt.Errorf("invalid snooze duration %q (1m to 168h)", value)
+	}
+	return d, nil
+}
+
+// snoozeTunnel mutes the tunnel's alerts for d, replacing any snooze it
+// already has, and notes it in the audit log as done through iface.
+func snoozeTunnel(tunnelID string, d time.Duration, author, iface, reason string) tunnelSnooze {
+	now := time.Now()
+	s := tunnelSnooze{TunnelID: tunnelID, Until: now.Add(d), Reason: reason, Author: author, Created: now}
+	snoozesMu.Lock()
+	snoozes[tunnelID] = s
+	saveSnoozes()
+	snoozesMu.Unlock()
+
+	action := fmt.Sprintf("snoozed alerts until %s UTC", s.Until.UTC().Format("2006-01-02 15:04"))
+	if reason != "" {
+		action += ": " + reason
+	}
+	recordAction(tunnelID, author, iface, action)
+	invalidatePageCache()
+	expireSnoozeAt(s.Until)
+	return s
+}
+
+// unsnoozeTunnel turns the tunnel's alerts back on, reporting whether it
+// was snoozed.
+func unsnoozeTunnel(tunnelID, author, iface string) bool {
+	snoozesMu.Lock()
+	s, ok := snoozes[tunnelID]
+	delete(snoozes, tunnelID)
+	if ok {
+		saveSnoozes()
+	}
+	snoozesMu.Unlock()
+	if !ok || !s.Until.After(time.Now()) {
+		return false
+	}
+	recordAction(tunnelID, author, iface, "ended the alert snooze")
+	invalidatePageCache()
+	return true
+}
+
+// snoozedUntil is when the tunnel's snooze ends, or the zero time when its
+// alerts are on.
+func snoozedUntil(tunnelID string, now time.Time) time.Time {
+	snoozesMu.Lock()
+	defer snoozesMu.Unlock()
+	s, ok := snoozes[tunnelID]
+	if !ok || !s.Until.After(now) {
+		return time.Time{}
+	}
+	return s.Until
+}
+
+// activeSnoozes are the snoozes that have not ended, by tunnel.
+func activeSnoozes(now time.Time) []tunnelSnooze {
+	snoozesMu.Lock()
+	defer snoozesMu.Unlock()
+	out := []tunnelSnooze{}
+	for _, s := range snoozes {
+		if s.Until.After(now) {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TunnelID < out[j].TunnelID })
+	return out
+}
+
+// snoozedBadge marks a snoozed tunnel on the status page.
+func snoozedBadge(tunnelID string, now time.Time) string {
+	until := snoozedUntil(tunnelID, now)
+	if until.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(` <span class="tunnel-snoozed">Alerts snoozed until %s</span>`, until.UTC().Format("2006-01-02 15:04 MST"))
+}
+
+// snoozesHandler serves GET /admin/api/snoozes, the snoozes in effect.
+func snoozesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, activeSnoozes(time.Now()))
+}
+
+// snoozeHandler serves /admin/api/tunnels/{id}/snooze. POST snoozes the
+// tunnel for {"duration": "1h", "reason": "..."}; DELETE turns its alerts
+// back on.
+func snoozeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	id := r.PathValue("id")
+	if !knownTunnel(id) {
+		writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", id))
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var request struct {
+			Duration string `json:"duration"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAnnotationSize)).Decode(&request); err != nil {
+			writeProblem(w, r, problemInvalidRequest, "invalid snooze: "+err.Error())
+			return
+		}
+		d, err := parseSnoozeDuration(request.Duration)
+		if err != nil {
+			writeProblem(w, r, problemValidation, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, snoozeTunnel(id, d, adminUser(r), "status page", request.Reason))
+	case http.MethodDelete:
+		if !unsnoozeTunnel(id, adminUser(r), "status page") {
+			writeProblem(w, r, problemNotSnoozed, "")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r, "POST, DELETE")
+	}
+}
+
+// snoozeFormHandler serves POST /admin/tunnels/{id}/snooze, the snooze
+// buttons on the admin tunnel page, and goes back to that page.
+func snoozeFormHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !knownTunnel(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.FormValue("action") == "unsnooze" {
+		unsnoozeTunnel(id, adminUser(r), "status page")
+	} else {
+		d, err := parseSnoozeDuration(r.FormValue("duration"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		snoozeTunnel(id, d, adminUser(r), "status page", r.FormValue("reason"))
+	}
+	http.Redirect(w, r, "/admin/tunnels/"+id, http.StatusSeeOther)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// staleAfter is how long a tunnel may go without a successful poll
+	// before its status is reported as stale; zero means three poll
+	// intervals.
+	staleAfter time.Duration
+	// serverStart stands in for the last successful poll until the first.
+	serverStart = time.Now()
+	// staleTunnels is the set found stale by the last check, so changes
+	// are logged and rerendered once.
+	staleTunnels = map[string]bool{}
+	staleMu      sync.Mutex
+)
+
+// loadStaleAfter reads STALE_AFTER, how long polling may keep failing
+// before the page and API flag the data as stale (default three poll
+// intervals, at least one). Load it after POLL_INTERVAL.
+func loadStaleAfter() error {
+	value := os.Getenv("STALE_AFTER")
+	if value == "" {
+		staleAfter = 3 * pollInterval
+		return nil
+	}
+	after, err := time.ParseDuration(value)
+	if err != nil || after < pollInterval {
+		return fmt.Errorf("STALE_AFTER: invalid duration %q (at least POLL_INTERVAL, %s)", value, pollInterval)
+	}
+	staleAfter = after
+	return nil
+}
+
+// lastUpdated is when the tunnel was last polled successfully, or when the
+// server started if it has not been yet.
+func (t tunnelState) lastUpdated() time.Time {
+	if t.LastPollAt.IsZero() {
+		return serverStart
+	}
+	return t.LastPollAt
+}
+
+// stale reports whether the tunnel's status is older than staleAfter.
+func (t tunnelState) stale(now time.Time) bool {
+	return staleAfter > 0 && now.Sub(t.lastUpdated()) > staleAfter
+}
+
+// lastSuccessfulPoll is the newest successful poll of any tunnel in list,
+// zero if there has been none.
+func lastSuccessfulPoll(list []tunnelState) time.Time {
+	var last time.Time
+	for _, t := range list {
+		if t.LastPollAt.After(last) {
+			last = t.LastPollAt
+		}
+	}
+	return last
+}
+
+// allStale reports whether every tunnel in list is stale: the Cloudflare
+// API has not answered for any of them within staleAfter.
+func allStale(list []tunnelState, now time.Time) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, t := range list {
+		if !t.stale(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkStaleness runs after each poll cycle. A tunnel going stale, or
+// fresh again, is logged and the cached page, which shows it, rerendered.
+func checkStaleness(list []tunnelState, now time.Time) {
+	current := map[string]bool{}
+	for _, t := range list {
+		if t.stale(now) {
+			current[t.ID] = true
+		}
+	}
+	staleMu.Lock()
+	previous := staleTunnels
+	staleTunnels = current
+	staleMu.Unlock()
+
+	changed := len(current) != len(previous)
+	for _, t := range list {
+		switch {
+		case current[t.ID] && !previous[t.ID]:
+			slog.Warn("Tunnel status is stale", "tunnel_id", t.ID, "tunnel", t.label(), "since_last_poll", now.Sub(t.lastUpdated()).Round(time.Second))
+			changed = true
+		case !current[t.ID] && previous[t.ID]:
+			slog.Info("Tunnel is being polled successfully again", "tunnel_id", t.ID, "tunnel", t.label())
+			changed = true
+		}
+	}
+	if changed {
+		invalidatePageCache()
+	}
+}
+
+// staleBanner is the status page warning while every tunnel's data is
+// stale, "" otherwise. The age ticks in the browser, since the page stays
+// cached until polling recovers.
+func staleBanner(list []tunnelState, now time.Time) string {
+	if !allStale(list, now) {
+		return ""
+	}
+	last := lastSuccessfulPoll(list)
+	if last.IsZero() {
+		return fmt.Sprintf(`<p class="stale-warning" role="alert">No data yet: the Cloudflare API has not answered since the server started %s ago.</p>`,
+			relTime("stale-since", serverStart, now))
+	}
+	return fmt.Sprintf(`<p class="stale-warning" role="alert">Data is stale (last updated %s ago): the Cloudflare API cannot be reached, so the statuses below may be out of date.</p>`,
+		relTime("stale-since", last, now))
+}
+
+// staleBadge marks the ith tunnel row when its data is stale.
+func staleBadge(i int, t tunnelState, now time.Time) string {
+	if !t.stale(now) {
+		return ""
+	}
+	return fmt.Sprintf(` <span class="tunnel-stale">Stale: last updated %s ago</span>`, relTime(fmt.Sprintf("stale-%d", i), t.lastUpdated(), now))
+}
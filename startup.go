@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStartupWait     = 30 * time.Second
+	defaultPollConcurrency = 8
+)
+
+var (
+	// firstPoll is closed once every tunnel has been polled once.
+	firstPoll     = make(chan struct{})
+	firstPollOnce sync.Once
+	// startupWait, when positive, holds back serving until the first poll
+	// completes or the wait runs out.
+	startupWait     time.Duration
+	pollConcurrency = defaultPollConcurrency
+)
+
+// loadStartup reads STARTUP_WAIT=true, which delays serving until every
+// tunnel has been polled once, and STARTUP_WAIT_TIMEOUT, the most it waits
+// (default 30s). Either way /readyz reports 503 until then, for readiness
+// probes.
+func loadStartup() error {
+	if os.Getenv("STARTUP_WAIT") != "true" {
+		return nil
+	}
+	startupWait = defaultStartupWait
+	if value := os.Getenv("STARTUP_WAIT_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("STARTUP_WAIT_TIMEOUT: invalid duration %q", value)
+		}
+		startupWait = timeout
+	}
+	return nil
+}
+
+// loadPollInterval reads POLL_INTERVAL, how often the tunnels are polled
+// (default 5m, minimum 30s, or 1s with MOCK). The page refresh and
+// external status page intervals default to it. Load it after MOCK.
+func loadPollInterval() error {
+	value := os.Getenv("POLL_INTERVAL")
+	if value == "" {
+		return nil
+	}
+	minimum := 30 * time.Second
+	if mockMode {
+		minimum = time.Second
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < minimum {
+		return fmt.Errorf("POLL_INTERVAL: invalid duration %q (minimum %s)", value, formatElapsed(minimum))
+	}
+	pollInterval = interval
+	maxSampleSpan = 3 * interval
+	refreshInterval = interval
+	externalInterval = interval
+	return nil
+}
+
+// loadPollConcurrency reads POLL_CONCURRENCY, how many tunnels are polled
+// at once on each cycle (default 8); 1 polls them in turn. The first cycle
+// after startup polls every tunnel at once, as nothing is known yet.
+func loadPollConcurrency() error {
+	value := os.Getenv("POLL_CONCURRENCY")
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("POLL_CONCURRENCY: invalid number %q", value)
+	}
+	pollConcurrency = n
+	return nil
+}
+
+func markFirstPoll() {
+	firstPollOnce.Do(func() { close(firstPoll) })
+}
+
+// waitForFirstPoll blocks until the first poll completes or startupWait
+// runs out.
+func waitForFirstPoll() {
+	select {
+	case <-firstPoll:
+	case <-time.After(startupWait):
+		slog.Warn("First poll not finished; serving anyway", "waited", startupWait)
+	}
+}
+
+// healthHandler serves /healthz, for liveness probes: 200 while the
+// process is serving, whatever the state of the tunnels or the API.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// readyHandler serves /readyz, for readiness probes: 200 once every tunnel
+// has been polled once and at least one poll succeeded, 503 while starting,
+// when no poll has succeeded (e.g. a bad API_TOKEN) and while shutting down.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	select {
+	case <-shuttingDown:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case <-firstPoll:
+	default:
+		http.Error(w, "waiting for the first poll", http.StatusServiceUnavailable)
+		return
+	}
+	if lastSuccessfulPoll(snapshotTunnels()).IsZero() {
+		http.Error(w, "no tunnel has been polled successfully", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+// probePath reports whether path is a health probe, which is served
+// without access control, Cloudflare-only ingress or load shedding: a
+// shed liveness probe would get the container restarted just when it is
+// busiest.
+func probePath(path string) bool {
+	return path == "/healthz" || path == "/readyz"
+}
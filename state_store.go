@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultStateSyncInterval = 5 * time.Minute
+
+// stateStore keeps snapshots of the dashboard's state outside the
+// container, so a deployment without a volume can restart anywhere.
+type stateStore interface {
+	// Name identifies the store in logs, e.g. "kv:<namespace>".
+	Name() string
+	// Load returns the value of key, or nil if it has never been saved.
+	Load(ctx context.Context, key string) ([]byte, error)
+	Save(ctx context.Context, key string, data []byte) error
+}
+
+// cloudflareObjectStore reads and writes values through the Cloudflare
+// API. Workers KV and R2 differ only in where values live.
+type cloudflareObjectStore struct {
+	name string
+	// base is the URL values are stored under; keys are appended.
+	base string
+}
+
+// stateSnapshot is one piece of state saved under key.
+type stateSnapshot struct {
+	key      string
+	snapshot func() ([]byte, error)
+	// restore is only called when the state is still empty after loading
+	// local files, so local state always wins.
+	restore func(data []byte) error
+}
+
+// storedConfig is the saved config together with a hash of the
+// environment config it was derived from.
+type storedConfig struct {
+	EnvHash string `json:"env_hash"`
+	Config  Config `json:"config"`
+}
+
+var (
+	stateBackend      stateStore
+	statePrefix       = "cftunnels/"
+	stateSyncInterval = defaultStateSyncInterval
+	// stateSaved is the hash of each key's last saved value. stateSaveMu
+	// keeps the periodic save and the one at shutdown from overlapping.
+	stateSaved  = map[string]string{}
+	stateSaveMu sync.Mutex
+)
+
+// loadStateStore reads STATE_STORE, kv:<namespace-id> for Workers KV or
+// r2:<bucket> for R2, in STATE_ACCOUNT_ID (default ACCOUNT_ID).
+// STATE_PREFIX is prepended to every key and STATE_SYNC_INTERVAL sets how
+// often changed state is saved. The API token needs Workers KV Storage or
+// Workers R2 Storage edit permission. The saved config includes notifier
+// secrets, so the namespace or bucket should be as restricted as the token.
+func loadStateStore() error {
+	spec := os.Getenv("STATE_STORE")
+	if spec == "" {
+		return nil
+	}
+	account := os.Getenv("STATE_ACCOUNT_ID")
+	if account == "" {
+		account = os.Getenv("ACCOUNT_ID")
+	}
+	kind, target, _ := strings.Cut(spec, ":")
+	if target == "" || (kind != "kv" && kind != "r2") {
+		return fmt.Errorf("STATE_STORE: %q is not kv:<namespace-id> or r2:<bucket>", spec)
+	}
+	stateBackend = newCloudflareObjectStore(account, kind, target)
+
+	if prefix, ok := os.LookupEnv("STATE_PREFIX"); ok {
+		statePrefix = prefix
+	}
+	if value := os.Getenv("STATE_SYNC_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 10*time.Second {
+			return fmt.Errorf("STATE_SYNC_INTERVAL: invalid duration %q (minimum 10s)", value)
+		}
+		stateSyncInterval = interval
+	}
+	return nil
+}
+
+// newCloudflareObjectStore stores values in a Workers KV namespace (kind
+// kv) or an R2 bucket (kind r2) of the account.
+func newCloudflareObjectStore(accountID, kind, target string) *cloudflareObjectStore {
+	base := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s", url.PathEscape(accountID))
+	if kind == "kv" {
+		base += "/storage/kv/namespaces/" + url.PathEscape(target) + "/values/"
+	} else {
+		base += "/r2/buckets/" + url.PathEscape(target) + "/objects/"
+	}
+	return &cloudflareObjectStore{name: kind + ":" + target, base: base}
+}
+
+func (s *cloudflareObjectStore) Name() string { return s.name }
+
+func (s *cloudflareObjectStore) Load(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.base+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiCritical)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("loading %s: HTTP %d: %s", key, resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+func (s *cloudflareObjectStore) Save(ctx context.Context, key string, data []byte) error {
+	return s.put(ctx, key, "application/octet-stream", data)
+}
+
+func (s *cloudflareObjectStore) put(ctx context.Context, key, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.base+url.PathEscape(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := cloudflareDo(req, apiCritical)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("saving %s: HTTP %d: %s", key, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// stateSnapshots lists the state that is saved: samples, incident records,
+// availability exclusions, tunnel configuration versions, the applied
+// config, the deleted objects that can be restored and the page views.
+func stateSnapshots() []stateSnapshot {
+	return []stateSnapshot{
+		{key: "history.jsonl", snapshot: snapshotHistory, restore: restoreHistory},
+		{key: "incidents.json", snapshot: snapshotIncidents, restore: restoreIncidents},
+		{key: "exclusions.json", snapshot: snapshotExclusions, restore: restoreExclusions},
+		{key: "config-versions.json", snapshot: snapshotConfigVersions, restore: restoreConfigVersions},
+		{key: "config.json", snapshot: snapshotConfig, restore: restoreConfig},
+		{key: "deleted.json", snapshot: snapshotDeleted, restore: restoreDeletedObjects},
+		{key: "analytics.json", snapshot: snapshotAnalytics, restore: restoreAnalytics},
+	}
+}
+
+// restoreState loads every snapshot from the store into state that local
+// files left empty. It runs at startup after the environment config has
+// been applied.
+func restoreState() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, s := range stateSnapshots() {
+		data, err := stateBackend.Load(ctx, statePrefix+s.key)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		if err := s.restore(data); err != nil {
+			return fmt.Errorf("restoring %s: %w", s.key, err)
+		}
+		stateSaved[s.key] = hashState(data)
+	}
+	return nil
+}
+
+// syncState saves changed state every stateSyncInterval until shutdown
+// starts; shutdown saves it once more after flushing history.
+func syncState() {
+	ticker := time.NewTicker(stateSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			saveState()
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+func saveState() {
+	stateSaveMu.Lock()
+	defer stateSaveMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var failed error
+	for _, s := range stateSnapshots() {
+		data, err := s.snapshot()
+		if err != nil {
+			slog.Error("Error encoding state", "key", s.key, "error", err)
+			failed = err
+			continue
+		}
+		hash := hashState(data)
+		if stateSaved[s.key] == hash {
+			continue
+		}
+		if err := stateBackend.Save(ctx, statePrefix+s.key, data); err != nil {
+			slog.Error("Error saving state", "backend", stateBackend.Name(), "error", err)
+			failed = err
+			continue
+		}
+		stateSaved[s.key] = hash
+	}
+	monitorResult("state store", failed)
+}
+
+func hashState(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func snapshotHistory() ([]byte, error) {
+	historyMu.RLock()
+	defer historyMu.RUnlock()
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, in := range history {
+		if err := encoder.Encode(in); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// restoreHistory accepts intervals and, from snapshots saved by earlier
+// versions, samples.
+func restoreHistory(data []byte) error {
+	loaded, _, err := readHistory(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	historyMu.Lock()
+	if len(history) > 0 || len(loaded) == 0 {
+		historyMu.Unlock()
+		return nil
+	}
+	setHistory(loaded)
+	historyMu.Unlock()
+	historyWriteMu.Lock()
+	defer historyWriteMu.Unlock()
+	slog.Info("State restored history intervals", "count", len(loaded), "backend", stateBackend.Name())
+	if historyDB != nil {
+		return historyDB.SaveIntervals(loaded)
+	}
+	if historyFile != "" {
+		return rewriteHistory(loaded)
+	}
+	return nil
+}
+
+func snapshotIncidents() ([]byte, error) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	return json.Marshal(incidentRecords)
+}
+
+func restoreIncidents(data []byte) error {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	if len(incidentRecords) > 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &incidentRecords); err != nil {
+		return err
+	}
+	if len(incidentRecords) > 0 {
+		slog.Info("State restored incident records", "count", len(incidentRecords), "backend", stateBackend.Name())
+		saveIncidents()
+	}
+	return nil
+}
+
+func snapshotExclusions() ([]byte, error) {
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+	return json.Marshal(exclusions)
+}
+
+func restoreExclusions(data []byte) error {
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+	if len(exclusions) > 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &exclusions); err != nil {
+		return err
+	}
+	if len(exclusions) > 0 {
+		slog.Info("State restored availability exclusions", "count", len(exclusions), "backend", stateBackend.Name())
+		saveExclusions()
+	}
+	return nil
+}
+
+func snapshotConfigVersions() ([]byte, error) {
+	configVersionsMu.RLock()
+	defer configVersionsMu.RUnlock()
+	return json.Marshal(configVersions)
+}
+
+func restoreConfigVersions(data []byte) error {
+	configVersionsMu.Lock()
+	defer configVersionsMu.Unlock()
+	if len(configVersions) > 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &configVersions); err != nil {
+		return err
+	}
+	saveConfigVersions()
+	return nil
+}
+
+// envConfigHash identifies the environment config, so a saved config is
+// only restored on top of the environment it was made from.
+func envConfigHash() string {
+	data, _ := json.Marshal(configFromEnv())
+	return hashState(data)
+}
+
+func snapshotConfig() ([]byte, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return json.Marshal(storedConfig{EnvHash: envConfigHash(), Config: currentConfig})
+}
+
+// restoreConfig reapplies a config changed at runtime, unless the
+// environment config has changed since, in which case the environment
+// wins.
+func restoreConfig(data []byte) error {
+	var stored storedConfig
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	if stored.EnvHash != envConfigHash() {
+		slog.Info("State config ignored: the environment config changed since it was saved", "backend", stateBackend.Name())
+		return nil
+	}
+	_, err := applyConfig(stored.Config)
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+type statusResponse struct {
+	Status     string     `json:"status"`
+	LastPollAt *time.Time `json:"last_poll_at,omitempty"`
+	// Stale is set when no tunnel has been polled successfully within
+	// STALE_AFTER; LastUpdatedAt is the last successful poll.
+	Stale         bool                 `json:"stale"`
+	LastUpdatedAt *time.Time           `json:"last_updated_at,omitempty"`
+	Tunnels       []tunnelStatusResult `json:"tunnels"`
+}
+
+// tunnelStatusResult is a tunnel's current state as the API reports it.
+// UptimeSeconds is set while the tunnel is up and DowntimeSeconds while it
+// is down, both counted from the connection times Cloudflare reports.
+type tunnelStatusResult struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Status          string     `json:"status"`
+	Up              bool       `json:"up"`
+	ConnsActiveAt   *time.Time `json:"conns_active_at,omitempty"`
+	ConnsInactiveAt *time.Time `json:"conns_inactive_at,omitempty"`
+	UptimeSeconds   *int64     `json:"uptime_seconds,omitempty"`
+	DowntimeSeconds *int64     `json:"downtime_seconds,omitempty"`
+	Uptime          string     `json:"uptime,omitempty"`
+	Connections     int        `json:"connections"`
+	LastPollAt      *time.Time `json:"last_poll_at,omitempty"`
+	StatusSince     *time.Time `json:"status_since,omitempty"`
+	// Availability is the percentage available over each of 24h, 7d, 30d
+	// and 90d, for the windows with data.
+	Availability map[string]float64 `json:"availability,omitempty"`
+	// SnoozedUntil is set while the tunnel's alerts are snoozed.
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+	// Stale is set when the tunnel has not been polled successfully within
+	// STALE_AFTER, so its status may be out of date.
+	Stale bool `json:"stale"`
+	// DNSProblems are the tunnel's broken DNS records, with DNS_CHECK.
+	DNSProblems []dnsProblem `json:"dns_problems,omitempty"`
+}
+
+// optionalTime is nil for the zero time, for omitempty fields.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func tunnelStatusOf(t tunnelState, now time.Time) tunnelStatusResult {
+	since, up := t.since()
+	result := tunnelStatusResult{
+		ID:              t.ID,
+		Name:            t.label(),
+		Status:          t.Status,
+		Up:              up,
+		ConnsActiveAt:   optionalTime(t.ActiveAt),
+		ConnsInactiveAt: optionalTime(t.InactiveAt),
+		Connections:     t.Connections,
+		LastPollAt:      optionalTime(t.LastPollAt),
+		StatusSince:     optionalTime(statusSince(t.ID, t.Status)),
+		SnoozedUntil:    optionalTime(snoozedUntil(t.ID, now)),
+		Stale:           t.stale(now),
+		DNSProblems:     tunnelDNSProblems(t.ID),
+	}
+	for _, w := range windowAvailabilities(t.ID, now) {
+		if w.OK {
+			if result.Availability == nil {
+				result.Availability = map[string]float64{}
+			}
+			result.Availability[w.Label] = math.Round(w.Ratio*100000) / 1000
+		}
+	}
+	if !since.IsZero() {
+		seconds := int64(now.Sub(since).Seconds())
+		if up {
+			result.UptimeSeconds = &seconds
+		} else {
+			result.DowntimeSeconds = &seconds
+		}
+		result.Uptime = elapsedSince(since, now)
+	}
+	return result
+}
+
+// statusAPIHandler serves /api/status: the overall status and every
+// tunnel's status, connection times and uptime as JSON, for dashboards
+// and scripts. ?tunnel=<id> limits it to one tunnel; the tunnels can be
+// filtered, sorted and paged like other lists.
+func statusAPIHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list := snapshotTunnels()
+	if id := r.URL.Query().Get("tunnel"); id != "" {
+		t, ok := findTunnel(id)
+		if !ok {
+			writeProblem(w, r, problemUnknownTunnel, fmt.Sprintf("no tunnel %q", id))
+			return
+		}
+		list = []tunnelState{t}
+	}
+	if detail, failed := upstreamFailure(list); failed {
+		writeProblem(w, r, problemCloudflare, detail)
+		return
+	}
+	response := statusSnapshot(list, now)
+	if !adminAuthorized(r) {
+		response.maskTunnelIDs()
+	}
+	page, ok := pageList(w, r, response.Tunnels, listSpec{key: "id"})
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if page != nil {
+		writeJSON(w, http.StatusOK, page.replace(response, "tunnels"))
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// statusSnapshot is the status of the given tunnels as /api/status serves
+// it.
+func statusSnapshot(list []tunnelState, now time.Time) statusResponse {
+	statusMutex.RLock()
+	response := statusResponse{
+		Status:        overallTunnelStatus(list),
+		LastPollAt:    optionalTime(lastPollAt),
+		Stale:         allStale(list, now),
+		LastUpdatedAt: optionalTime(lastSuccessfulPoll(list)),
+		Tunnels:       []tunnelStatusResult{},
+	}
+	statusMutex.RUnlock()
+	for _, t := range list {
+		response.Tunnels = append(response.Tunnels, tunnelStatusOf(t, now))
+	}
+	return response
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// summarySender is an integration that sends a daily status summary.
+type summarySender interface {
+	Name() string
+	// SummaryAt is the time of day, as an offset from midnight UTC.
+	SummaryAt() time.Duration
+	SendSummary(ctx context.Context, subject, body string) error
+}
+
+// summarySenders is replaced with the notifiers when a config is applied,
+// under notifiersMu.
+var summarySenders []summarySender
+
+// runDailySummaries sends each summary sender its summary once a day at
+// its time.
+func runDailySummaries() {
+	sent := map[string]time.Time{}
+	for {
+		now := time.Now().UTC()
+		midnight := now.Truncate(24 * time.Hour)
+		notifiersMu.RLock()
+		senders := summarySenders
+		notifiersMu.RUnlock()
+		for _, s := range senders {
+			due := midnight.Add(s.SummaryAt())
+			last, seen := sent[s.Name()]
+			if !seen {
+				// Today's summary is not sent late after a restart.
+				sent[s.Name()] = now
+				continue
+			}
+			if now.Before(due) || !last.Before(due) {
+				continue
+			}
+			sent[s.Name()] = now
+			go sendSummary(s, now)
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+func sendSummary(s summarySender, now time.Time) {
+	subject, body := dailySummary(snapshotTunnels(), now)
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := s.SendSummary(ctx, subject, body); err != nil {
+		slog.Error("Error sending daily summary", "notifier", s.Name(), "error", err)
+	}
+}
+
+// dailySummary describes every tunnel's current status, availability and
+// incidents over the last day.
+func dailySummary(list []tunnelState, now time.Time) (subject, body string) {
+	from := now.Add(-24 * time.Hour)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tunnel status at %s\n\n", now.UTC().Format(time.RFC1123))
+	incidents := 0
+	for _, t := range list {
+		since, _ := t.since()
+		fmt.Fprintf(&b, "%s: %s (%s %s)\n", t.label(), statusLabel(t.Status), strings.ToLower(t.periodLabel()), elapsedSince(since, now))
+		fmt.Fprintf(&b, "  Availability, last 24 hours: %s\n", formatAvailability(availability(t.ID, from, now)))
+		for _, inc := range incidentsBetween(t.ID, from, now) {
+			incidents++
+			end := "ongoing"
+			if !inc.End.IsZero() {
+				end = inc.End.UTC().Format("15:04 MST")
+			}
+			fmt.Fprintf(&b, "  %s %s to %s\n", statusLabel(inc.Status), inc.Start.UTC().Format("15:04 MST"), end)
+		}
+	}
+
+	subject = fmt.Sprintf("Daily tunnel summary: %s", statusLabel(overallTunnelStatus(list)))
+	switch {
+	case incidents == 1:
+		subject += ", 1 incident"
+	case incidents > 1:
+		subject += fmt.Sprintf(", %d incidents", incidents)
+	}
+	return subject, b.String()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// messageTemplate overrides an event's title and/or message. Empty fields
+// keep the text from the layer below.
+type messageTemplate struct {
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// templateSet holds templates keyed by event type; "*" applies to every
+// type without its own entry.
+type templateSet map[string]messageTemplate
+
+// languagePack translates the built-in messages. Statuses translates the
+// status words the status function renders.
+type languagePack struct {
+	Templates templateSet       `json:"templates"`
+	Statuses  map[string]string `json:"statuses,omitempty"`
+}
+
+// channelTemplates customises one notifier, named as in the config.
+type channelTemplates struct {
+	Language  string      `json:"language,omitempty"`
+	Templates templateSet `json:"templates,omitempty"`
+}
+
+// messageTemplates is the MESSAGE_TEMPLATES file. Text is resolved per
+// field from the built-in English, then Default, then the channel's
+// language, then the channel's own templates; within each layer an event
+// type's entry wins over "*".
+type messageTemplates struct {
+	Default   templateSet                 `json:"default,omitempty"`
+	Languages map[string]languagePack     `json:"languages,omitempty"`
+	Channels  map[string]channelTemplates `json:"channels,omitempty"`
+}
+
+// templatedNotifier rewrites an event's text for its channel before
+// handing it to the notifier it wraps.
+type templatedNotifier struct {
+	Notifier
+	channel string
+}
+
+// compiledTemplate is one layer's parsed title and message.
+type compiledTemplate struct {
+	title   *template.Template
+	message *template.Template
+}
+
+var (
+	// templateLayers are the parsed templates, keyed by "default",
+	// "language/<code>" or "channel/<name>", then by event type.
+	templateLayers = map[string]map[string]compiledTemplate{}
+	templateConfig messageTemplates
+)
+
+// loadMessageTemplates reads MESSAGE_TEMPLATES, a JSON file of alert
+// templates in Go text/template syntax over the event's fields, e.g.
+// {"languages": {"ja": {"templates": {"status_changed": {"title":
+// "トンネル {{.TunnelName}} は{{status .NewStatus}}です"}}}},
+// "channels": {"slack-apac": {"language": "ja"}}}.
+func loadMessageTemplates() error {
+	file := os.Getenv("MESSAGE_TEMPLATES")
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &templateConfig); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	layers := map[string]templateSet{"default": templateConfig.Default}
+	for code, pack := range templateConfig.Languages {
+		layers["language/"+code] = pack.Templates
+	}
+	for name, channel := range templateConfig.Channels {
+		if _, ok := templateConfig.Languages[channel.Language]; channel.Language != "" && !ok {
+			return fmt.Errorf("%s: channel %q: unknown language %q", file, name, channel.Language)
+		}
+		layers["channel/"+name] = channel.Templates
+	}
+	for layer, set := range layers {
+		compiled := map[string]compiledTemplate{}
+		for eventType, tmpl := range set {
+			var c compiledTemplate
+			name := layer + "/" + eventType
+			funcs := templateFuncs(layerLanguage(layer))
+			if tmpl.Title != "" {
+				if c.title, err = template.New(name + "/title").Funcs(funcs).Parse(tmpl.Title); err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+			}
+			if tmpl.Message != "" {
+				if c.message, err = template.New(name + "/message").Funcs(funcs).Parse(tmpl.Message); err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+			}
+			compiled[eventType] = c
+		}
+		templateLayers[layer] = compiled
+	}
+	return nil
+}
+
+// layerLanguage is the language whose status words a layer renders.
+func layerLanguage(layer string) string {
+	if code, ok := strings.CutPrefix(layer, "language/"); ok {
+		return code
+	}
+	if name, ok := strings.CutPrefix(layer, "channel/"); ok {
+		return templateConfig.Channels[name].Language
+	}
+	return ""
+}
+
+func templateFuncs(language string) template.FuncMap {
+	statuses := templateConfig.Languages[language].Statuses
+	return template.FuncMap{
+		"status": func(status string) string {
+			if translated, ok := statuses[status]; ok {
+				return translated
+			}
+			return statusLabel(status)
+		},
+		"datetime": func(t time.Time) string { return t.UTC().Format(time.RFC1123) },
+		"upper":    strings.ToUpper,
+	}
+}
+
+// withTemplates wraps n so its events use the channel's templates. It
+// returns n unchanged when no templates are configured.
+func withTemplates(channel string, n Notifier) Notifier {
+	if len(templateLayers) == 0 {
+		return n
+	}
+	return &templatedNotifier{Notifier: n, channel: channel}
+}
+
+func (n *templatedNotifier) Notify(ctx context.Context, event Event) error {
+	return n.Notifier.Notify(ctx, localizeEvent(n.channel, event))
+}
+
+// localizeEvent applies the template layers for channel to event.
+func localizeEvent(channel string, event Event) Event {
+	layers := []string{"default"}
+	if language := templateConfig.Channels[channel].Language; language != "" {
+		layers = append(layers, "language/"+language)
+	}
+	layers = append(layers, "channel/"+channel)
+
+	// Templates see the event as it was built, not earlier layers' output.
+	original := event
+	for _, layer := range layers {
+		for _, key := range []string{"*", event.Type} {
+			c, ok := templateLayers[layer][key]
+			if !ok {
+				continue
+			}
+			if text, ok := renderTemplate(c.title, original); ok {
+				event.Title = text
+			}
+			if text, ok := renderTemplate(c.message, original); ok {
+				event.Message = text
+			}
+		}
+	}
+	return event
+}
+
+func renderTemplate(tmpl *template.Template, event Event) (string, bool) {
+	if tmpl == nil {
+		return "", false
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, event); err != nil {
+		slog.Error("Error rendering message template", "template", tmpl.Name(), "error", err)
+		return "", false
+	}
+	return b.String(), true
+}
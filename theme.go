@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// themePack maps CSS custom property names, without the leading "--", to
+// their values. Every pack must define the same set of properties.
+type themePack map[string]string
+
+var themePacks = map[string]themePack{
+	"dark": {
+		"bg": "#121212", "fg": "#ffffff", "muted": "#bbbbbb", "link": "#8ab4f8",
+		"focus": "#ffbf47", "border": "#444444", "status-fg": "#ffffff",
+		"status-healthy": "#008000", "status-degraded": "#c2410c",
+		"status-down": "#b91c1c", "status-inactive": "#2f4f4f",
+	},
+	"light": {
+		"bg": "#f6f8fa", "fg": "#1f2328", "muted": "#59636e", "link": "#0969da",
+		"focus": "#bf8700", "border": "#d1d9e0", "status-fg": "#ffffff",
+		"status-healthy": "#1a7f37", "status-degraded": "#bc4c00",
+		"status-down": "#cf222e", "status-inactive": "#59636e",
+	},
+	"midnight": {
+		"bg": "#0b1021", "fg": "#e6e9f5", "muted": "#a3acc9", "link": "#7aa2f7",
+		"focus": "#e0af68", "border": "#2a3152", "status-fg": "#ffffff",
+		"status-healthy": "#2e7d32", "status-degraded": "#b45309",
+		"status-down": "#b91c1c", "status-inactive": "#3b4261",
+	},
+	"solarized": {
+		"bg": "#002b36", "fg": "#eee8d5", "muted": "#93a1a1", "link": "#6cb6eb",
+		"focus": "#b58900", "border": "#0a4b5a", "status-fg": "#ffffff",
+		"status-healthy": "#36750b", "status-degraded": "#a8410b",
+		"status-down": "#b8231f", "status-inactive": "#44595f",
+	},
+}
+
+// highContrastPack overrides the selected pack when a viewer enables high
+// contrast.
+var highContrastPack = themePack{
+	"bg": "#000000", "fg": "#ffffff", "muted": "#ffffff", "link": "#ffff00",
+	"focus": "#ffff00", "border": "#ffffff", "status-fg": "#000000",
+	"status-healthy": "#7cfc00", "status-degraded": "#ffd000",
+	"status-down": "#ff8080", "status-inactive": "#ffffff",
+	"pill-border": "3px solid #ffffff", "pill-weight": "bold",
+}
+
+// themeLayout holds the properties shared by all packs. Packs may override
+// them.
+var themeLayout = themePack{
+	"font-family": "Arial, sans-serif",
+	"space-xs":    "4px",
+	"space-sm":    "10px",
+	"space-md":    "20px",
+	"space-lg":    "2em",
+	"radius-pill": "25px",
+	"pill-border": "none",
+	"pill-weight": "normal",
+}
+
+var (
+	themeName       = "dark"
+	customCSSURL    string
+	themeStylesheet string
+)
+
+// loadTheme reads THEME, which names one of themePacks, and CUSTOM_CSS_URL,
+// a stylesheet linked after the built-in one so it can override any rule
+// or variable.
+func loadTheme() error {
+	if name := os.Getenv("THEME"); name != "" {
+		if _, ok := themePacks[name]; !ok {
+			return fmt.Errorf("THEME: unknown theme %q (available: %s)", name, strings.Join(themeNames(), ", "))
+		}
+		themeName = name
+	}
+	customCSSURL = os.Getenv("CUSTOM_CSS_URL")
+	themeStylesheet = renderTheme(themePacks[themeName])
+	return nil
+}
+
+func themeNames() []string {
+	names := make([]string, 0, len(themePacks))
+	for name := range themePacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func cssVariables(selector string, pack themePack) string {
+	keys := make([]string, 0, len(pack))
+	for key := range pack {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(selector + " {\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\t--%s: %s;\n", key, pack[key])
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func renderTheme(pack themePack) string {
+	merged := themePack{}
+	for key, value := range themeLayout {
+		merged[key] = value
+	}
+	for key, value := range pack {
+		merged[key] = value
+	}
+	return cssVariables(":root", merged) + cssVariables("html.high-contrast", highContrastPack) + themeRules
+}
+
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write([]byte(themeStylesheet))
+}
+
+// stylesheetLinks renders the <link> tags every page includes in its head.
+func stylesheetLinks() string {
+	links := `<link rel="stylesheet" href="/theme.css">`
+	if customCSSURL != "" {
+		links += fmt.Sprintf(`
+	<link rel="stylesheet" href="%s">`, html.EscapeString(customCSSURL))
+	}
+	return links
+}
+
+// themeRules are the page styles. Colours and spacing come only from the
+// variables above so theme packs and custom stylesheets can restyle pages
+// without touching these rules.
+const themeRules = `
+body {
+	font-family: var(--font-family);
+	margin: 0;
+	background-color: var(--bg);
+	color: var(--fg);
+}
+a { color: var(--link); }
+[hidden] { display: none !important; }
+:focus-visible {
+	outline: 3px solid var(--focus);
+	outline-offset: 2px;
+}
+.visually-hidden {
+	position: absolute;
+	width: 1px;
+	height: 1px;
+	overflow: hidden;
+	clip: rect(0 0 0 0);
+	white-space: nowrap;
+}
+.status-pill {
+	display: inline-block;
+	padding: var(--space-sm) var(--space-md);
+	color: var(--status-fg);
+	border: var(--pill-border);
+	border-radius: var(--radius-pill);
+	font-size: 1.2em;
+	font-weight: var(--pill-weight);
+	text-transform: uppercase;
+}
+.status-healthy { background-color: var(--status-healthy); }
+.status-degraded { background-color: var(--status-degraded); }
+.status-down { background-color: var(--status-down); }
+.status-inactive { background-color: var(--status-inactive); }
+.refresh-controls, .contrast-toggle {
+	display: inline-block;
+	margin-top: var(--space-md);
+	font-size: 0.9em;
+	color: var(--muted);
+}
+.contrast-toggle { color: var(--link); }
+
+.page-status {
+	text-align: center;
+	display: flex;
+	flex-direction: column;
+	justify-content: center;
+	align-items: center;
+	min-height: 100vh;
+	min-height: 100dvh;
+}
+.tunnel-list {
+	list-style: none;
+	padding: 0;
+	margin: var(--space-md) 0 0;
+}
+.tunnel-list li { margin: var(--space-sm) 0; }
+.tunnel-list .status-pill {
+	padding: var(--space-xs) var(--space-sm);
+	font-size: 0.9em;
+}
+.tunnel-name { font-weight: var(--pill-weight); }
+.tunnel-since { color: var(--muted); }
+.tunnel-budget, .tunnel-availability { display: block; color: var(--muted); font-size: 0.9em; }
+.tunnel-snoozed, .tunnel-stale, .tunnel-dns {
+	border: 1px solid var(--muted);
+	border-radius: 1em;
+	padding: 0 var(--space-sm);
+	color: var(--muted);
+	font-size: 0.8em;
+}
+.tunnel-stale { border-color: var(--status-degraded); }
+.tunnel-dns { border-color: var(--status-down); }
+.clock-warning, .stale-warning {
+	border: 2px solid var(--status-degraded);
+	padding: var(--space-sm);
+	font-weight: bold;
+}
+.viewer-hint {
+	border: 2px solid var(--muted);
+	padding: var(--space-sm);
+}
+
+.page-report {
+	max-width: 50em;
+	margin: var(--space-lg) auto;
+	padding: 0 1em;
+}
+.page-report .status-pill {
+	padding: var(--space-xs) var(--space-sm);
+	font-size: 1em;
+}
+.page-report table {
+	width: 100%;
+	border-collapse: collapse;
+	margin-bottom: 1.5em;
+}
+.page-report th, .page-report td {
+	text-align: left;
+	padding: var(--space-xs) 8px;
+	border-bottom: 1px solid var(--border);
+}
+.page-report tr { page-break-inside: avoid; }
+.report-actions button { font-size: 1em; }
+.log-lines code, .connector-command code, .config-diff code { white-space: pre-wrap; word-break: break-all; }
+.connector-command {
+	padding: var(--space-sm);
+	border: 1px solid var(--border);
+}
+.connector-qr { background-color: #ffffff; padding: var(--space-sm); }
+.log-ERR td:nth-child(2) { color: var(--status-down); font-weight: bold; }
+.token-expiring td:nth-child(4) { color: var(--status-degraded); font-weight: bold; }
+.token-expired td:nth-child(4) { color: var(--status-down); font-weight: bold; }
+
+@page { margin: 15mm; }
+@media print {
+	:root, html.high-contrast {
+		--bg: #ffffff;
+		--fg: #000000;
+		--link: #000000;
+		--border: #999999;
+		--status-fg: #000000;
+	}
+	body { font-size: 11pt; }
+	.page-report { max-width: none; margin: 0; }
+	.status-pill {
+		background-color: transparent;
+		border: 1px solid #000000;
+	}
+	a { text-decoration: none; }
+	section.incidents a::after { content: " (" attr(href) ")"; }
+	.report-actions, .contrast-toggle, .refresh-controls { display: none; }
+	section { page-break-inside: avoid; }
+	section.incidents { page-break-before: always; }
+}
+`
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	// serverTLS is the web server's TLS configuration; nil serves plain
+	// HTTP.
+	serverTLS *tls.Config
+	// tlsRedirectPort serves HTTP redirects to HTTPS, and the ACME
+	// HTTP-01 challenge with automatic certificates.
+	tlsRedirectPort string
+	acmeManager     *autocert.Manager
+)
+
+// loadTLS reads TLS_CERT_FILE and TLS_KEY_FILE, a PEM certificate chain and
+// key to serve HTTPS with, reloaded when the files change so renewals need
+// no restart. Alternatively TLS_AUTOCERT_HOSTS, a comma-separated list of
+// host names, gets certificates from Let's Encrypt, cached in
+// TLS_AUTOCERT_CACHE (default autocert-cache) with TLS_AUTOCERT_EMAIL as the
+// contact; HTTP_PORT must then be reachable as 443, or TLS_REDIRECT_PORT as
+// 80. TLS_REDIRECT_PORT also redirects plain HTTP to HTTPS.
+func loadTLS() error {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	hosts := splitList(os.Getenv("TLS_AUTOCERT_HOSTS"))
+	tlsRedirectPort = os.Getenv("TLS_REDIRECT_PORT")
+	switch {
+	case (certFile == "") != (keyFile == ""):
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case certFile != "" && len(hosts) > 0:
+		return fmt.Errorf("set either TLS_CERT_FILE and TLS_KEY_FILE or TLS_AUTOCERT_HOSTS, not both")
+	case certFile != "":
+		keyPair := &reloadingKeyPair{certFile: certFile, keyFile: keyFile}
+		if _, err := keyPair.load(); err != nil {
+			return err
+		}
+		serverTLS = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: keyPair.getCertificate}
+	case len(hosts) > 0:
+		cache := os.Getenv("TLS_AUTOCERT_CACHE")
+		if cache == "" {
+			cache = "autocert-cache"
+		}
+		acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(cache),
+			Email:      os.Getenv("TLS_AUTOCERT_EMAIL"),
+		}
+		serverTLS = acmeManager.TLSConfig()
+		serverTLS.MinVersion = tls.VersionTLS12
+		slog.Info("TLS: automatic certificates", "hosts", strings.Join(hosts, ","), "cache", cache)
+	case tlsRedirectPort != "":
+		return fmt.Errorf("TLS_REDIRECT_PORT needs TLS_CERT_FILE and TLS_KEY_FILE or TLS_AUTOCERT_HOSTS")
+	}
+	return nil
+}
+
+// reloadingKeyPair serves a certificate from files, reading them again
+// when the certificate file's modification time changes.
+type reloadingKeyPair struct {
+	certFile, keyFile string
+	mu                sync.Mutex
+	cert              *tls.Certificate
+	modTime           time.Time
+}
+
+func (k *reloadingKeyPair) load() (*tls.Certificate, error) {
+	info, err := os.Stat(k.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS_CERT_FILE: %w", err)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.cert != nil && info.ModTime().Equal(k.modTime) {
+		return k.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	if k.cert != nil {
+		slog.Info("TLS certificate reloaded", "file", k.certFile)
+	}
+	k.cert, k.modTime = &cert, info.ModTime()
+	return k.cert, nil
+}
+
+// getCertificate keeps serving the previous certificate if a renewal
+// leaves the files unreadable or mismatched for a moment.
+func (k *reloadingKeyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := k.load()
+	if err != nil {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if k.cert != nil {
+			slog.Error("Error reloading TLS certificate, serving the previous one", "error", err)
+			return k.cert, nil
+		}
+	}
+	return cert, err
+}
+
+// serveTLSRedirects serves TLS_REDIRECT_PORT, redirecting every request to
+// HTTPS on HTTP_PORT and answering ACME challenges.
+func serveTLSRedirects(port string) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+		if port != "443" {
+			host += ":" + port
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if acmeManager != nil {
+		handler = acmeManager.HTTPHandler(handler)
+	}
+	server := &http.Server{Addr: ":" + tlsRedirectPort, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("Redirecting HTTP to HTTPS", "addr", ":"+tlsRedirectPort)
+	if err := server.ListenAndServe(); err != nil {
+		slog.Error("Error serving HTTP redirects", "error", err)
+	}
+}
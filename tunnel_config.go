@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConfigWatchInterval = 15 * time.Minute
+	// configVersionsKept is how many versions of each tunnel's remote
+	// configuration are remembered.
+	configVersionsKept = 20
+	// maxDiffLinesInMessage keeps notifications readable; the full diff is
+	// on the tunnel page and in the event's Diff field.
+	maxDiffLinesInMessage = 40
+)
+
+// tunnelConfigVersion is one observed version of a tunnel's remotely
+// managed configuration (ingress rules, origin settings, WARP routing).
+type tunnelConfigVersion struct {
+	Version   int             `json:"version"`
+	Source    string          `json:"source"`
+	CreatedAt time.Time       `json:"created_at"`
+	SeenAt    time.Time       `json:"seen_at"`
+	Config    json.RawMessage `json:"config"`
+	// Diff is against the previous version, empty for the first.
+	Diff string `json:"diff,omitempty"`
+}
+
+type tunnelConfigResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		Version   int             `json:"version"`
+		Source    string          `json:"source"`
+		CreatedAt time.Time       `json:"created_at"`
+		Config    json.RawMessage `json:"config"`
+	} `json:"result"`
+}
+
+var (
+	configWatchInterval time.Duration
+	configVersionsFile  string
+	configVersions      = map[string][]tunnelConfigVersion{}
+	configVersionsMu    sync.RWMutex
+)
+
+// loadConfigWatch reads CONFIG_WATCH_INTERVAL (e.g. 15m) or
+// CONFIG_WATCH=true for the default interval, and CONFIG_VERSIONS_FILE,
+// where versions are kept between restarts so changes made while stopped
+// are still reported.
+func loadConfigWatch() error {
+	if value := os.Getenv("CONFIG_WATCH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Minute {
+			return fmt.Errorf("CONFIG_WATCH_INTERVAL: invalid duration %q (minimum 1m)", value)
+		}
+		configWatchInterval = interval
+	} else if os.Getenv("CONFIG_WATCH") == "true" {
+		configWatchInterval = defaultConfigWatchInterval
+	}
+	if configWatchInterval == 0 {
+		return nil
+	}
+
+	configVersionsFile = os.Getenv("CONFIG_VERSIONS_FILE")
+	if configVersionsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(configVersionsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &configVersions)
+}
+
+func saveConfigVersions() {
+	if configVersionsFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(configVersions, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding config versions", "error", err)
+		return
+	}
+	tmp := configVersionsFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		slog.Error("Error writing config versions file", "error", err)
+		return
+	}
+	if err := os.Rename(tmp, configVersionsFile); err != nil {
+		slog.Error("Error writing config versions file", "error", err)
+	}
+}
+
+// watchTunnelConfigs checks every tunnel's remote configuration on each
+// interval.
+func watchTunnelConfigs() {
+	for {
+		for _, t := range snapshotTunnels() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := checkTunnelConfig(ctx, t); err != nil {
+				slog.Error("Error fetching tunnel configuration", "tunnel_id", t.ID, "error", err)
+			}
+			cancel()
+		}
+		time.Sleep(configWatchInterval)
+	}
+}
+
+func fetchTunnelConfig(ctx context.Context, t tunnelState) (*tunnelConfigResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.url()+"/configurations", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiBackground)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugAPIResponse(req, resp, body)
+	var parsed tunnelConfigResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing API response: %w", err)
+	}
+	if !parsed.Success {
+		return nil, fmt.Errorf("API response indicates failure: %s", string(body))
+	}
+	return &parsed, nil
+}
+
+// checkTunnelConfig records the tunnel's current configuration and sends a
+// config_changed event with a diff when it differs from the last one seen.
+func checkTunnelConfig(ctx context.Context, t tunnelState) error {
+	remote, err := fetchTunnelConfig(ctx, t)
+	if err != nil {
+		return err
+	}
+	pretty := prettyJSON(remote.Result.Config)
+
+	configVersionsMu.Lock()
+	versions := configVersions[t.ID]
+	var previous *tunnelConfigVersion
+	if len(versions) > 0 {
+		previous = &versions[len(versions)-1]
+	}
+	// Only the content matters: a version bump that leaves the rules as
+	// they were is not worth an alert.
+	if previous != nil && bytes.Equal(prettyJSON(previous.Config), pretty) {
+		configVersionsMu.Unlock()
+		return nil
+	}
+	version := tunnelConfigVersion{
+		Version:   remote.Result.Version,
+		Source:    remote.Result.Source,
+		CreatedAt: remote.Result.CreatedAt,
+		SeenAt:    time.Now(),
+		Config:    json.RawMessage(pretty),
+	}
+	if previous != nil {
+		version.Diff = lineDiff(string(prettyJSON(previous.Config)), string(pretty))
+	}
+	versions = append(versions, version)
+	if len(versions) > configVersionsKept {
+		versions = versions[len(versions)-configVersionsKept:]
+	}
+	configVersions[t.ID] = versions
+	saveConfigVersions()
+	configVersionsMu.Unlock()
+
+	if previous != nil {
+		notify(configChangeEvent(t, *previous, version))
+	}
+	return nil
+}
+
+// configChangeEvent describes a change to a tunnel's remote configuration.
+func configChangeEvent(t tunnelState, previous, current tunnelConfigVersion) Event {
+	diff := current.Diff
+	if lines := strings.Split(diff, "\n"); len(lines) > maxDiffLinesInMessage {
+		diff = strings.Join(lines[:maxDiffLinesInMessage], "\n") + fmt.Sprintf("\n... %d more lines", len(lines)-maxDiffLinesInMessage)
+	}
+	return Event{
+		ID:         newEventID(),
+		Type:       eventConfigChanged,
+		Time:       current.SeenAt,
+		TunnelID:   t.ID,
+		TunnelName: t.label(),
+		Title:      fmt.Sprintf("Tunnel %s configuration changed", t.label()),
+		Message: fmt.Sprintf("The configuration of tunnel %s changed from version %d to %d (source: %s).\n\n%s",
+			t.label(), previous.Version, current.Version, current.Source, diff),
+		Diff: current.Diff,
+	}
+}
+
+// tunnelConfigHistory returns the remembered versions, newest first.
+func tunnelConfigHistory(tunnelID string) []tunnelConfigVersion {
+	configVersionsMu.RLock()
+	defer configVersionsMu.RUnlock()
+	versions := configVersions[tunnelID]
+	out := make([]tunnelConfigVersion, len(versions))
+	for i, v := range versions {
+		out[len(versions)-1-i] = v
+	}
+	return out
+}
+
+// prettyJSON indents raw with sorted keys so versions diff line by line.
+func prettyJSON(raw json.RawMessage) []byte {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return raw
+	}
+	pretty, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return raw
+	}
+	return pretty
+}
+
+// lineDiff renders a unified-style diff of two texts, with "-" and "+"
+// prefixes on changed lines and two lines of context around each change.
+func lineDiff(before, after string) string {
+	a, b := strings.Split(before, "\n"), strings.Split(after, "\n")
+	// Longest common subsequence table, from the end.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type diffLine struct {
+		op   byte
+		text string
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+
+	const context = 2
+	keep := make([]bool, len(lines))
+	for k, line := range lines {
+		if line.op != ' ' {
+			for c := max(0, k-context); c <= min(len(lines)-1, k+context); c++ {
+				keep[c] = true
+			}
+		}
+	}
+	var out strings.Builder
+	skipped := false
+	for k, line := range lines {
+		if !keep[k] {
+			skipped = true
+			continue
+		}
+		if skipped && out.Len() > 0 {
+			out.WriteString("  ...\n")
+		}
+		skipped = false
+		out.WriteByte(line.op)
+		out.WriteByte(' ')
+		out.WriteString(line.text)
+		out.WriteByte('\n')
+	}
+	return strings.TrimRight(out.String(), "\n")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/s3ansh33p/CFTunnels/internal/poller"
+)
+
+// tunnelState is a monitored tunnel and what the last poll observed.
+type tunnelState struct {
+	ID        string
+	AccountID string
+	// Name is the configured display name; APIName is the name reported
+	// by Cloudflare.
+	Name        string
+	APIName     string
+	Group       string
+	Status      string
+	ActiveAt    time.Time
+	InactiveAt  time.Time
+	LastPollAt  time.Time
+	Connections int
+	// BusinessHours and SLATargets are the tunnel's own settings, nil to
+	// use the defaults.
+	BusinessHours *businessHours
+	SLATargets    []slaTarget
+	Weight        float64
+	MaxStatus     string
+	// Links are the configured quick links, replaced rather than modified
+	// so snapshots may share them, as are Regions, upper-cased.
+	Links   map[string]string
+	Regions []string
+}
+
+// tunnels is the registry of monitored tunnels, in configured order.
+// Guarded by statusMutex.
+var tunnels []*tunnelState
+
+// pollNow wakes the poller early, for example when tunnels are added.
+var pollNow = make(chan struct{}, 1)
+
+// tunnelRefs lists the registered tunnels for tunnelPoller.
+func tunnelRefs() []poller.Ref {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	refs := make([]poller.Ref, len(tunnels))
+	for i, t := range tunnels {
+		refs[i] = poller.Ref{AccountID: t.AccountID, ID: t.ID}
+	}
+	return refs
+}
+
+func (t *tunnelState) url() string {
+	return tunnelURL(t.AccountID, t.ID)
+}
+
+// label is how the tunnel is shown to people: the configured name, then
+// the name from the API, then the ID, as the privacy policy shows it.
+func (t *tunnelState) label() string {
+	switch {
+	case t.Name != "":
+		return t.Name
+	case t.APIName != "":
+		return t.APIName
+	default:
+		return publicTunnelID(t.ID)
+	}
+}
+
+// statusUnknown is the status of a tunnel that has not been polled
+// successfully yet.
+const statusUnknown = "unknown"
+
+// since returns the time the current up or down period started and
+// whether the tunnel is up. The time is zero when it is not known: before
+// the first poll, or when the API did not report it.
+func (t *tunnelState) since() (time.Time, bool) {
+	if t.Status == statusUnknown {
+		return time.Time{}, false
+	}
+	if t.ActiveAt.IsZero() {
+		return t.InactiveAt, false
+	}
+	return t.ActiveAt, true
+}
+
+// periodLabel names the period since() measures: "Uptime", "Downtime",
+// or "Since" while the status is unknown.
+func (t *tunnelState) periodLabel() string {
+	if t.Status == statusUnknown {
+		return "Since"
+	}
+	if _, up := t.since(); up {
+		return "Uptime"
+	}
+	return "Downtime"
+}
+
+// setTunnels replaces the registry with cfgs, keeping the observed state of
+// tunnels that remain, and triggers a poll if any were added.
+func setTunnels(cfgs []TunnelConfig) {
+	statusMutex.Lock()
+	existing := map[string]*tunnelState{}
+	for _, t := range tunnels {
+		existing[t.ID] = t
+	}
+	added := false
+	next := make([]*tunnelState, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		t, ok := existing[cfg.ID]
+		if !ok || t.AccountID != cfg.AccountID {
+			t = &tunnelState{ID: cfg.ID, AccountID: cfg.AccountID, Status: statusUnknown}
+			added = true
+		}
+		t.Name, t.Group = cfg.Name, cfg.Group
+		t.Weight, t.MaxStatus = cfg.Weight, cfg.MaxStatus
+		t.Links = cfg.Links
+		t.Regions = nil
+		for _, region := range cfg.Regions {
+			t.Regions = append(t.Regions, strings.ToUpper(region))
+		}
+		// Config.validate has already checked the schedule and targets.
+		t.BusinessHours, t.SLATargets = nil, nil
+		if cfg.BusinessHours != "" {
+			t.BusinessHours, _ = parseBusinessHours(cfg.BusinessHours)
+		}
+		if cfg.SLA != "" {
+			t.SLATargets, _ = parseSLATargets(cfg.SLA, true)
+		}
+		next = append(next, t)
+	}
+	tunnels = next
+	statusMutex.Unlock()
+	setTunnelSlugs(cfgs)
+	invalidatePageCache()
+
+	if added {
+		select {
+		case pollNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// snapshotTunnels returns a copy of the registry for rendering.
+func snapshotTunnels() []tunnelState {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	out := make([]tunnelState, len(tunnels))
+	for i, t := range tunnels {
+		out[i] = *t
+	}
+	return out
+}
+
+// findTunnel returns a copy of the tunnel with the given ID, or with it as
+// the pseudonym when the privacy policy masks tunnel IDs.
+func findTunnel(id string) (tunnelState, bool) {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	for _, t := range tunnels {
+		if t.ID == id || (privacy.MaskTunnelIDs && maskedTunnelID(t.ID) == id) {
+			return *t, true
+		}
+	}
+	return tunnelState{}, false
+}
+
+// sortTunnelConfigs orders discovered tunnels by ID so that discovery
+// results are stable.
+func sortTunnelConfigs(list []TunnelConfig) {
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+}
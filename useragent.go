@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3";
+// otherwise the module version from the build info is used.
+var version string
+
+var (
+	// instanceID names this instance to Cloudflare and federating peers.
+	instanceID string
+	// apiUserAgent and apiHeaders are sent on every Cloudflare API call.
+	apiUserAgent string
+	apiHeaders   = http.Header{}
+)
+
+// appVersion is the running version, "dev" for local builds.
+func appVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// loadUserAgent reads INSTANCE_ID, which names this instance in the
+// User-Agent (default the host name); CLOUDFLARE_API_USER_AGENT, which
+// replaces the User-Agent altogether; and CLOUDFLARE_API_HEADERS, a
+// comma-separated list of Name=value headers added to every Cloudflare
+// API call, e.g. for a corporate proxy.
+func loadUserAgent() error {
+	instanceID = os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		instanceID, _ = os.Hostname()
+	}
+	apiUserAgent = "cftunnels/" + appVersion()
+	if instanceID != "" {
+		apiUserAgent += " (instance " + instanceID + ")"
+	}
+	if value := os.Getenv("CLOUDFLARE_API_USER_AGENT"); value != "" {
+		apiUserAgent = value
+	}
+
+	for _, entry := range splitList(os.Getenv("CLOUDFLARE_API_HEADERS")) {
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t:") {
+			return fmt.Errorf("CLOUDFLARE_API_HEADERS: %q is not Name=value", entry)
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Content-Type", "Content-Length", "Host", "User-Agent":
+			return fmt.Errorf("CLOUDFLARE_API_HEADERS: %s cannot be overridden", name)
+		}
+		apiHeaders.Add(name, strings.TrimSpace(value))
+	}
+	return nil
+}
+
+// setAPIHeaders adds the User-Agent and extra headers to a Cloudflare API
+// request. Subcommands that skip loadUserAgent still name the app.
+func setAPIHeaders(req *http.Request) {
+	if apiUserAgent != "" {
+		req.Header.Set("User-Agent", apiUserAgent)
+	} else {
+		req.Header.Set("User-Agent", "cftunnels/"+appVersion())
+	}
+	for name, values := range apiHeaders {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
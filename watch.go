@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 2 * time.Minute
+	// watchBacklog is how many changes are kept for clients catching up.
+	watchBacklog = 1000
+)
+
+// statusChange is a tunnel's status changing, as /api/watch reports it.
+type statusChange struct {
+	TunnelID string    `json:"tunnel_id"`
+	Tunnel   string    `json:"tunnel"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Time     time.Time `json:"time"`
+	seq      uint64
+}
+
+// watchResponse answers /api/watch. Cursor is passed as since on the next
+// request. Reset is set when since is too old, or from before a restart,
+// for changes to have been kept; the client should reload /api/status.
+type watchResponse struct {
+	Cursor  string         `json:"cursor"`
+	Changes []statusChange `json:"changes"`
+	Reset   bool           `json:"reset,omitempty"`
+}
+
+var (
+	// watchEpoch tells cursors from before a restart apart.
+	watchEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	watchMu    sync.Mutex
+	watchSeq   uint64
+	watchLog   []statusChange
+	// watchWake is closed, and replaced, when a change is recorded.
+	watchWake     = make(chan struct{})
+	activeWatches atomic.Int64
+)
+
+// recordStatusChange stores a change in HISTORY_DB, adds it to the
+// backlog and wakes waiting watchers.
+func recordStatusChange(tunnelID, tunnel, from, to string, at time.Time) {
+	if from == "" {
+		from = statusUnknown
+	}
+	recordTransition(tunnelID, from, to, at)
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watchSeq++
+	watchLog = append(watchLog, statusChange{TunnelID: tunnelID, Tunnel: tunnel, From: from, To: to, Time: at, seq: watchSeq})
+	if len(watchLog) > watchBacklog {
+		watchLog = watchLog[len(watchLog)-watchBacklog:]
+	}
+	close(watchWake)
+	watchWake = make(chan struct{})
+}
+
+func watchCursor(seq uint64) string {
+	return watchEpoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// changesSince returns the changes after seq, whether seq is still covered
+// by the backlog, the current cursor and a channel closed on the next
+// change.
+func changesSince(seq uint64) ([]statusChange, bool, string, <-chan struct{}) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	changes := []statusChange{}
+	for _, c := range watchLog {
+		if c.seq > seq {
+			changes = append(changes, c)
+		}
+	}
+	covered := seq <= watchSeq && (seq == watchSeq || len(watchLog) > 0 && watchLog[0].seq <= seq+1)
+	return changes, covered, watchCursor(watchSeq), watchWake
+}
+
+// visibleChanges masks the tunnel IDs of changes for the caller of r.
+func visibleChanges(r *http.Request, changes []statusChange) []statusChange {
+	for i := range changes {
+		changes[i].TunnelID = visibleTunnelID(r, changes[i].TunnelID)
+	}
+	return changes
+}
+
+// watchHandler serves GET /api/watch?since=<cursor>, a long poll for
+// status changes: it answers as soon as a tunnel changes status after the
+// cursor, or with no changes after ?timeout=<seconds> (default 30, at
+// most 120). Without since it answers at once with the current cursor.
+// Waiting requests share MAX_EVENT_STREAMS with /events and /ws.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	timeout := defaultWatchTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxWatchTimeout {
+			writeProblem(w, r, problemInvalidParameter, fmt.Sprintf("timeout must be a number of seconds from 0 to %d", int(maxWatchTimeout.Seconds())))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		_, _, cursor, _ := changesSince(^uint64(0))
+		writeJSON(w, http.StatusOK, watchResponse{Cursor: cursor, Changes: []statusChange{}})
+		return
+	}
+	epoch, number, _ := strings.Cut(since, "-")
+	seq, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || epoch == "" {
+		writeProblem(w, r, problemInvalidParameter, "since must be a cursor returned by /api/watch")
+		return
+	}
+	changes, covered, cursor, wake := changesSince(seq)
+	if epoch != watchEpoch || !covered {
+		writeJSON(w, http.StatusOK, watchResponse{Cursor: cursor, Changes: []statusChange{}, Reset: true})
+		return
+	}
+	if len(changes) > 0 || timeout == 0 {
+		writeJSON(w, http.StatusOK, watchResponse{Cursor: cursor, Changes: visibleChanges(r, changes)})
+		return
+	}
+
+	if activeWatches.Add(1) > int64(maxEventStreams) {
+		activeWatches.Add(-1)
+		w.Header().Set("Retry-After", strconv.Itoa(shedRetryAfter))
+		writeProblem(w, r, problemOverloaded, "too many requests are waiting for changes")
+		return
+	}
+	defer activeWatches.Add(-1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-wake:
+	case <-timer.C:
+	case <-shuttingDown:
+	case <-r.Context().Done():
+		return
+	}
+	changes, _, cursor, _ = changesSince(seq)
+	writeJSON(w, http.StatusOK, watchResponse{Cursor: cursor, Changes: visibleChanges(r, changes)})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// webhookPayload is the body POSTed to generic webhooks.
+type webhookPayload struct {
+	EventID    string    `json:"event_id"`
+	Type       string    `json:"type"`
+	TunnelID   string    `json:"tunnel_id,omitempty"`
+	TunnelName string    `json:"tunnel_name,omitempty"`
+	OldStatus  string    `json:"old_status,omitempty"`
+	NewStatus  string    `json:"new_status,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+}
+
+// webhookNotifier POSTs status changes as JSON to an HTTP endpoint, for
+// operators' own automation. With a secret, the body is signed with
+// HMAC-SHA256 in the X-CFTunnels-Signature header as sha256=<hex>.
+type webhookNotifier struct {
+	url    string
+	secret string
+	// events are the event types sent; status changes by default.
+	events []string
+}
+
+// webhookFromEnv reads WEBHOOK_URLS (comma-separated), WEBHOOK_SECRET and
+// WEBHOOK_EVENTS.
+func webhookFromEnv() []NotifierConfig {
+	return numberedConfigs("webhook", "url", os.Getenv("WEBHOOK_URLS"), map[string]string{
+		"secret": os.Getenv("WEBHOOK_SECRET"),
+		"events": os.Getenv("WEBHOOK_EVENTS"),
+	})
+}
+
+// newWebhookIntegration takes the url, secret and events settings. events
+// is a comma-separated list of event types, or "all"; it defaults to
+// status_changed.
+func newWebhookIntegration(settings map[string]string) (integration, error) {
+	if settings["url"] == "" {
+		return integration{}, fmt.Errorf("url is required")
+	}
+	events := []string{eventStatusChanged}
+	if value := settings["events"]; value == "all" {
+		events = nil
+	} else if value != "" {
+		events = splitList(value)
+	}
+	return integration{notifier: &webhookNotifier{url: settings["url"], secret: settings["secret"], events: events}}, nil
+}
+
+func (n *webhookNotifier) Name() string {
+	return "webhook " + n.url
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, event Event) error {
+	if n.events != nil && !slices.Contains(n.events, event.Type) {
+		return nil
+	}
+	body, err := json.Marshal(webhookPayload{
+		EventID:    event.ID,
+		Type:       event.Type,
+		TunnelID:   event.TunnelID,
+		TunnelName: event.TunnelName,
+		OldStatus:  event.OldStatus,
+		NewStatus:  event.NewStatus,
+		Timestamp:  event.Time,
+		Title:      event.Title,
+		Message:    event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	var headers map[string]string
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		headers = map[string]string{"X-CFTunnels-Signature": "sha256=" + hex.EncodeToString(mac.Sum(nil))}
+	}
+	return postJSON(ctx, n.url, headers, body)
+}
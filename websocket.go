@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// websocketGUID is the RFC 6455 handshake constant.
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// websocketCoalesce batches the updates of one poll into one snapshot.
+	websocketCoalesce = 250 * time.Millisecond
+	// maxWebsocketFrame bounds what a client may send; clients only need
+	// to send pings and closes.
+	maxWebsocketFrame     = 64 << 10
+	websocketWriteTimeout = 10 * time.Second
+)
+
+// WebSocket opcodes.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsGoingAway is the close status sent when the server shuts down.
+const wsGoingAway = 1001
+
+// websocketSnapshot is the one kind of frame /ws sends: the full
+// /api/status response, on connect and after every poll.
+type websocketSnapshot struct {
+	Type string `json:"type"`
+	statusResponse
+}
+
+// websocketConn is the server side of a WebSocket. Writes come from both
+// the stream and the reader answering pings, so they are serialized.
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+}
+
+// websocketHandler serves /ws, a WebSocket stream of status snapshots as
+// JSON text frames for dashboards that want live tunnel state without
+// polling /api/status. It shares MAX_EVENT_STREAMS with /events.
+func websocketHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch, unsubscribe := subscribePageEvents(w)
+	if ch == nil {
+		return
+	}
+	defer unsubscribe()
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ws := &websocketConn{conn: conn, reader: rw.Reader}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readLoop()
+	}()
+	if ws.sendSnapshot() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-closed:
+			return
+		case <-shuttingDown:
+			ws.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, wsGoingAway))
+			return
+		case <-ch:
+			if pending == nil {
+				pending = time.After(websocketCoalesce)
+			}
+		case <-pending:
+			pending = nil
+			if ws.sendSnapshot() != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if ws.writeFrame(wsPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ws *websocketConn) sendSnapshot() error {
+	snapshot := websocketSnapshot{Type: "snapshot", statusResponse: statusSnapshot(snapshotTunnels(), time.Now())}
+	snapshot.maskTunnelIDs()
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(wsText, data)
+}
+
+// writeFrame writes one unfragmented, unmasked frame.
+func (ws *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := ws.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := ws.conn.Write(payload)
+	return err
+}
+
+// readLoop answers pings and closes until the client goes away. Clients
+// answer the heartbeat pings, so one silent for two heartbeats is gone.
+func (ws *websocketConn) readLoop() {
+	for {
+		ws.conn.SetReadDeadline(time.Now().Add(2 * eventHeartbeat))
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			// Echo the status code, if any, to complete the closing handshake.
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			ws.writeFrame(wsClose, payload)
+			return
+		case wsPing:
+			if ws.writeFrame(wsPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads one client frame and unmasks its payload.
+func (ws *websocketConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebsocketFrame || (opcode >= wsClose && length > 125) {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// headerHasToken reports whether a comma-separated header lists token,
+// ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// wecomNotifier posts markdown messages to a WeChat Work (WeCom) group
+// robot webhook.
+type wecomNotifier struct {
+	url string
+}
+
+// wecomResponse is returned with HTTP 200 even on failure, so errcode has
+// to be checked.
+type wecomResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// wecomFromEnv reads WECOM_WEBHOOK_URLS, a comma-separated list of robot
+// webhook URLs.
+func wecomFromEnv() []NotifierConfig {
+	return numberedConfigs("wecom", "url", os.Getenv("WECOM_WEBHOOK_URLS"), nil)
+}
+
+// newWeComIntegration takes the robot webhook url setting.
+func newWeComIntegration(settings map[string]string) (integration, error) {
+	if settings["url"] == "" {
+		return integration{}, fmt.Errorf("url is required")
+	}
+	return integration{notifier: &wecomNotifier{url: settings["url"]}}, nil
+}
+
+func (n *wecomNotifier) Name() string {
+	return "wecom"
+}
+
+func (n *wecomNotifier) Notify(ctx context.Context, event Event) error {
+	content := fmt.Sprintf("**%s**\n> %s", event.Title, event.Message)
+	if transition := statusTransition(event); transition != "" {
+		content += "\n> Status: " + transition
+	}
+	payload := map[string]any{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": content},
+	}
+	var response wecomResponse
+	if err := doJSON(ctx, "POST", n.url, nil, payload, &response); err != nil {
+		return err
+	}
+	if response.ErrCode != 0 {
+		return fmt.Errorf("errcode %d: %s", response.ErrCode, response.ErrMsg)
+	}
+	return nil
+}
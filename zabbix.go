@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zabbixDiscoveryKey is the trapper key of the low-level discovery rule.
+// Item prototypes use cftunnel.status[{#TUNNEL_ID}],
+// cftunnel.status.code[{#TUNNEL_ID}] and cftunnel.uptime[{#TUNNEL_ID}].
+const zabbixDiscoveryKey = "cftunnel.discovery"
+
+// zabbixDiscoveryInterval is how often discovery data is resent, so Zabbix
+// picks up tunnels again after its "keep lost resources" period.
+const zabbixDiscoveryInterval = time.Hour
+
+var (
+	zabbixServer       string
+	zabbixHost         string
+	zabbixDiscoveredAt time.Time
+	zabbixMu           sync.Mutex
+)
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// loadZabbix reads ZABBIX_SERVER (host[:port]) and ZABBIX_HOST, the host
+// name of the trapper items in Zabbix. Pushing is disabled unless both are
+// set.
+func loadZabbix() error {
+	zabbixServer = os.Getenv("ZABBIX_SERVER")
+	zabbixHost = os.Getenv("ZABBIX_HOST")
+	if zabbixServer == "" {
+		return nil
+	}
+	if zabbixHost == "" {
+		return fmt.Errorf("ZABBIX_HOST must be set when ZABBIX_SERVER is")
+	}
+	if _, _, err := net.SplitHostPort(zabbixServer); err != nil {
+		zabbixServer = net.JoinHostPort(zabbixServer, "10051")
+	}
+	return nil
+}
+
+// zabbixDiscovery returns low-level discovery data for the monitored
+// tunnels, with each tunnel ID as id returns it.
+func zabbixDiscovery(id func(string) string) map[string][]map[string]string {
+	data := []map[string]string{}
+	for _, t := range snapshotTunnels() {
+		data = append(data, map[string]string{"{#TUNNEL_ID}": id(t.ID), "{#TUNNEL_NAME}": t.label()})
+	}
+	return map[string][]map[string]string{"data": data}
+}
+
+// zabbixDiscoveryHandler serves discovery JSON for Zabbix HTTP agent items,
+// with tunnel IDs masked for callers without the admin token.
+func zabbixDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(zabbixDiscovery(func(id string) string { return visibleTunnelID(r, id) }))
+}
+
+// pushZabbix sends the latest status to Zabbix trapper items, including
+// discovery data on the first push and periodically after that.
+func pushZabbix(id, tunnelStatus string, since time.Time, at time.Time) {
+	clock := at.Unix()
+	uptime := int64(0)
+	if tunnelStatus == "healthy" && !since.IsZero() {
+		uptime = int64(at.Sub(since).Seconds())
+	}
+
+	items := []zabbixItem{
+		{Host: zabbixHost, Key: fmt.Sprintf("cftunnel.status[%s]", id), Value: statusLabel(tunnelStatus), Clock: clock},
+		{Host: zabbixHost, Key: fmt.Sprintf("cftunnel.status.code[%s]", id), Value: fmt.Sprint(statusSeverity(tunnelStatus)), Clock: clock},
+		{Host: zabbixHost, Key: fmt.Sprintf("cftunnel.uptime[%s]", id), Value: fmt.Sprint(uptime), Clock: clock},
+	}
+
+	zabbixMu.Lock()
+	if at.Sub(zabbixDiscoveredAt) >= zabbixDiscoveryInterval {
+		discovery, _ := json.Marshal(zabbixDiscovery(func(id string) string { return id }))
+		items = append([]zabbixItem{{Host: zabbixHost, Key: zabbixDiscoveryKey, Value: string(discovery), Clock: clock}}, items...)
+		zabbixDiscoveredAt = at
+	}
+	zabbixMu.Unlock()
+
+	if err := zabbixSend(items); err != nil {
+		slog.Error("Error sending to Zabbix", "error", err)
+		zabbixMu.Lock()
+		zabbixDiscoveredAt = time.Time{}
+		zabbixMu.Unlock()
+	}
+}
+
+// zabbixSend delivers items using the Zabbix sender protocol: a "ZBXD\x01"
+// header, the little-endian payload length and a reserved word, followed by
+// the JSON payload.
+func zabbixSend(items []zabbixItem) error {
+	payload, err := json.Marshal(zabbixRequest{Request: "sender data", Data: items})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", zabbixServer, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var packet bytes.Buffer
+	packet.WriteString("ZBXD\x01")
+	binary.Write(&packet, binary.LittleEndian, uint32(len(payload)))
+	binary.Write(&packet, binary.LittleEndian, uint32(0))
+	packet.Write(payload)
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		return err
+	}
+
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("reading response header: %w", err)
+	}
+	if string(header[:4]) != "ZBXD" {
+		return fmt.Errorf("unexpected response header %q", header[:5])
+	}
+	length := binary.LittleEndian.Uint32(header[5:9])
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	var response zabbixResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	if response.Response != "success" || !strings.Contains(response.Info, "failed: 0") {
+		return fmt.Errorf("server rejected items: %s %s", response.Response, response.Info)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fleetStatusMinutes is the window DEX counts devices as connected in.
+const fleetStatusMinutes = 10
+
+// warpConnector is a WARP connector tunnel as listed by the API.
+type warpConnector struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	ConnsActiveAt time.Time `json:"conns_active_at"`
+	Connections   []struct {
+		ColoName string `json:"colo_name"`
+	} `json:"connections"`
+}
+
+// deviceCount is how many devices are connected through one location.
+type deviceCount struct {
+	Location string
+	Devices  int
+}
+
+type warpConnectorsResponse struct {
+	Result []warpConnector `json:"result"`
+}
+
+type fleetStatusResponse struct {
+	Result struct {
+		DeviceStats struct {
+			UniqueDevicesTotal int `json:"uniqueDevicesTotal"`
+			ByColo             []struct {
+				Value              string `json:"value"`
+				UniqueDevicesTotal int    `json:"uniqueDevicesTotal"`
+			} `json:"byColo"`
+		} `json:"deviceStats"`
+	} `json:"result"`
+}
+
+var (
+	zeroTrustEnabled bool
+	// zeroTrust is the last overview fetched, guarded by zeroTrustMu.
+	zeroTrust struct {
+		FetchedAt    time.Time
+		Error        string
+		Connectors   []warpConnector
+		DevicesTotal int
+		Devices      []deviceCount
+	}
+	zeroTrustMu sync.RWMutex
+)
+
+// loadZeroTrust reads ZERO_TRUST_OVERVIEW=true, which adds a page showing
+// the account's WARP connectors and devices connected per location. The
+// API token needs the Cloudflare Tunnel and Zero Trust read permissions,
+// plus DEX read for device counts.
+func loadZeroTrust() error {
+	zeroTrustEnabled = os.Getenv("ZERO_TRUST_OVERVIEW") == "true"
+	return nil
+}
+
+// pollZeroTrust refreshes the overview on each poll interval.
+func pollZeroTrust() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		refreshZeroTrust(ctx, os.Getenv("ACCOUNT_ID"))
+		cancel()
+		time.Sleep(pollInterval)
+	}
+}
+
+func refreshZeroTrust(ctx context.Context, accountID string) {
+	base := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s", accountID)
+	var errs []string
+
+	var connectors warpConnectorsResponse
+	if err := getCloudflare(ctx, base+"/warp_connector?is_deleted=false", &connectors); err != nil {
+		slog.Error("Error fetching WARP connectors", "error", err)
+		errs = append(errs, "WARP connectors: "+err.Error())
+	}
+	sort.Slice(connectors.Result, func(i, j int) bool { return connectors.Result[i].Name < connectors.Result[j].Name })
+
+	var fleet fleetStatusResponse
+	if err := getCloudflare(ctx, fmt.Sprintf("%s/dex/fleet-status/live?since_minutes=%d", base, fleetStatusMinutes), &fleet); err != nil {
+		slog.Error("Error fetching device fleet status", "error", err)
+		errs = append(errs, "devices: "+err.Error())
+	}
+	var devices []deviceCount
+	for _, colo := range fleet.Result.DeviceStats.ByColo {
+		devices = append(devices, deviceCount{Location: colo.Value, Devices: colo.UniqueDevicesTotal})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Devices != devices[j].Devices {
+			return devices[i].Devices > devices[j].Devices
+		}
+		return devices[i].Location < devices[j].Location
+	})
+
+	zeroTrustMu.Lock()
+	defer zeroTrustMu.Unlock()
+	zeroTrust.FetchedAt = time.Now()
+	zeroTrust.Error = ""
+	if len(errs) > 0 {
+		zeroTrust.Error = strings.Join(errs, "; ")
+	}
+	zeroTrust.Connectors = connectors.Result
+	zeroTrust.DevicesTotal = fleet.Result.DeviceStats.UniqueDevicesTotal
+	zeroTrust.Devices = devices
+}
+
+// getCloudflare GETs a Cloudflare API URL and decodes the response into
+// out, failing if the API reports failure.
+func getCloudflare(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := cloudflareDo(req, apiBackground)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		Success bool `json:"success"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("parsing API response: %w", err)
+	}
+	if !status.Success {
+		return fmt.Errorf("API response indicates failure (HTTP %d)", resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+type zeroTrustConnectorRow struct {
+	Name        string
+	ID          string
+	Status      string
+	StatusClass string
+	Locations   string
+	Connections int
+}
+
+type zeroTrustData struct {
+	HTMLClass      template.HTMLAttr
+	Stylesheets    template.HTML
+	ContrastToggle template.HTML
+	FetchedAt      time.Time
+	Error          string
+	Connectors     []zeroTrustConnectorRow
+	DevicesTotal   int
+	Devices        []deviceCount
+	Minutes        int
+}
+
+var zeroTrustTemplate = template.Must(template.New("zerotrust").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en"{{.HTMLClass}}>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Zero Trust - Tunnel Status</title>
+	{{.Stylesheets}}
+</head>
+<body class="page-report">
+	<main>
+		<header>
+			<h1>Zero Trust</h1>
+			<p>Updated {{datetime .FetchedAt}} &middot; <a href="/">Back to status page</a></p>
+			{{if .Error}}<p role="alert">Some data could not be fetched: {{.Error}}</p>{{end}}
+		</header>
+
+		<section aria-labelledby="warp-heading">
+			<h2 id="warp-heading">WARP connectors</h2>
+			{{if .Connectors}}
+			<table>
+				<thead><tr><th scope="col">Connector</th><th scope="col">Status</th><th scope="col">Connections</th><th scope="col">Locations</th></tr></thead>
+				<tbody>
+				{{range .Connectors}}<tr>
+					<td>{{.Name}}<br><code>{{.ID}}</code></td>
+					<td><span class="status-pill {{.StatusClass}}">{{.Status}}</span></td>
+					<td>{{.Connections}}</td>
+					<td>{{.Locations}}</td>
+				</tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{else}}
+			<p>No WARP connectors in this account.</p>
+			{{end}}
+		</section>
+
+		<section aria-labelledby="devices-heading">
+			<h2 id="devices-heading">Connected devices</h2>
+			<p>{{.DevicesTotal}} devices connected in the last {{.Minutes}} minutes.</p>
+			{{if .Devices}}
+			<table>
+				<thead><tr><th scope="col">Location</th><th scope="col">Devices</th></tr></thead>
+				<tbody>
+				{{range .Devices}}<tr><td>{{.Location}}</td><td>{{.Devices}}</td></tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{end}}
+		</section>
+		{{.ContrastToggle}}
+	</main>
+</body>
+</html>`))
+
+// zeroTrustHandler serves /zero-trust, the WARP connector and device view
+// that complements the cfd_tunnel status page.
+func zeroTrustHandler(w http.ResponseWriter, r *http.Request) {
+	if !zeroTrustEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	high := highContrast(w, r)
+	data := zeroTrustData{
+		HTMLClass:      template.HTMLAttr(htmlClass(high)),
+		Stylesheets:    template.HTML(stylesheetLinks()),
+		ContrastToggle: template.HTML(contrastToggle(high)),
+		Minutes:        fleetStatusMinutes,
+	}
+
+	zeroTrustMu.RLock()
+	data.FetchedAt = zeroTrust.FetchedAt
+	data.Error = zeroTrust.Error
+	data.DevicesTotal = zeroTrust.DevicesTotal
+	data.Devices = zeroTrust.Devices
+	for _, c := range zeroTrust.Connectors {
+		seen := map[string]bool{}
+		var locations []string
+		for _, conn := range c.Connections {
+			if conn.ColoName != "" && !seen[conn.ColoName] {
+				seen[conn.ColoName] = true
+				locations = append(locations, conn.ColoName)
+			}
+		}
+		sort.Strings(locations)
+		if len(locations) == 0 {
+			locations = []string{"None"}
+		}
+		data.Connectors = append(data.Connectors, zeroTrustConnectorRow{
+			Name:        c.Name,
+			ID:          c.ID,
+			Status:      statusLabel(c.Status),
+			StatusClass: statusClass(c.Status),
+			Locations:   strings.Join(locations, ", "),
+			Connections: len(c.Connections),
+		})
+	}
+	zeroTrustMu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := zeroTrustTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering Zero Trust page", "error", err)
+	}
+}
+
+// zeroTrustLink is the status page link to /zero-trust, when enabled.
+func zeroTrustLink() string {
+	if !zeroTrustEnabled {
+		return ""
+	}
+	return ` &middot; <a href="/zero-trust">Zero Trust</a>`
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// zoomNotifier posts to a Zoom Team Chat channel through the Incoming
+// Webhook app, using its "full" message format.
+type zoomNotifier struct {
+	url   string
+	token string
+}
+
+// zoomFromEnv reads ZOOM_WEBHOOK_URL and ZOOM_VERIFICATION_TOKEN, both
+// shown by the Incoming Webhook app when it is connected to a channel.
+func zoomFromEnv() []NotifierConfig {
+	if os.Getenv("ZOOM_WEBHOOK_URL") == "" {
+		return nil
+	}
+	return []NotifierConfig{{Name: "zoom", Type: "zoom", Settings: envSettings(map[string]string{
+		"url":   "ZOOM_WEBHOOK_URL",
+		"token": "ZOOM_VERIFICATION_TOKEN",
+	})}}
+}
+
+// newZoomIntegration takes the webhook url and verification token
+// settings.
+func newZoomIntegration(settings map[string]string) (integration, error) {
+	if settings["url"] == "" || settings["token"] == "" {
+		return integration{}, fmt.Errorf("url and token are required")
+	}
+	parsed, err := url.Parse(settings["url"])
+	if err != nil {
+		return integration{}, fmt.Errorf("url: %w", err)
+	}
+	query := parsed.Query()
+	query.Set("format", "full")
+	parsed.RawQuery = query.Encode()
+	return integration{notifier: &zoomNotifier{url: parsed.String(), token: settings["token"]}}, nil
+}
+
+func (n *zoomNotifier) Name() string {
+	return "zoom team chat"
+}
+
+func (n *zoomNotifier) Notify(ctx context.Context, event Event) error {
+	head := map[string]any{"text": event.Title, "style": map[string]any{"bold": true}}
+	if transition := statusTransition(event); transition != "" {
+		head["sub_head"] = map[string]any{"text": transition}
+	}
+	payload := map[string]any{
+		"head": head,
+		"body": []map[string]any{{"type": "message", "text": event.Message}},
+	}
+	return postJSON(ctx, n.url, map[string]string{"Authorization": n.token}, payload)
+}